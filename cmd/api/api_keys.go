@@ -0,0 +1,103 @@
+package main
+
+import (
+	"appdrop/internal/data"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// listApiKeysHandler handles GET /api-keys
+func (b *backend) listApiKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := b.models.ApiKeys.GetAll(r.Context())
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+		return
+	}
+	if err = b.writeJson(w, http.StatusOK, envelope{"api_keys": keys}, nil); err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// createApiKeyHandler handles POST /api-keys
+//
+// The response is the only time the key is shown. store_ids restricts the key
+// to those stores, and expires_in, a duration such as "720h", makes it
+// expire.
+func (b *backend) createApiKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name      string      `json:"name"`
+		StoreIds  []uuid.UUID `json:"store_ids"`
+		ExpiresIn string      `json:"expires_in"`
+	}
+	if err := b.readJson(w, r, &input); err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	if strings.TrimSpace(input.Name) == "" {
+		b.validationErrorResponse(w, r, "api key name is required")
+		return
+	}
+	var expiresAt *time.Time
+	if input.ExpiresIn != "" {
+		d, err := time.ParseDuration(input.ExpiresIn)
+		if err != nil || d <= 0 {
+			b.failedValidationResponse(w, r, map[string]string{"expires_in": "must be a positive duration, e.g. 720h"})
+			return
+		}
+		at := time.Now().Add(d).UTC().Truncate(time.Second)
+		expiresAt = &at
+	}
+	for i, id := range input.StoreIds {
+		if _, err := b.models.Stores.Get(r.Context(), id); err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				b.failedValidationResponse(w, r, map[string]string{fmt.Sprintf("store_ids[%d]", i): "must be an existing store"})
+			default:
+				b.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+	key, err := data.GenerateApiKey(input.Name, input.StoreIds, expiresAt)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+		return
+	}
+	if err = b.models.ApiKeys.Insert(r.Context(), key); err != nil {
+		b.serverErrorResponse(w, r, err)
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api-keys/%s", key.Id))
+
+	if err = b.writeJson(w, http.StatusCreated, envelope{"api_key": key}, headers); err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// revokeApiKeyHandler handles DELETE /api-keys/:key_id
+func (b *backend) revokeApiKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := b.readIdParam(r, "key_id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	if err = b.models.ApiKeys.Revoke(r.Context(), id); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = b.writeJson(w, http.StatusOK, envelope{"message": "api key successfully revoked"}, nil)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"appdrop/internal/data"
+	"appdrop/internal/validator"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// audit records through m, and so in the same unit of work as the change,
+// that the request r made a change to the entity of store storeId. before and
+// after are the entity as it was and as it is now; nil stands for none.
+func (b *backend) audit(r *http.Request, m data.Models, storeId uuid.UUID, entityType string, entityId uuid.UUID, action string, before, after any) error {
+	entry := &data.AuditEntry{
+		StoreId:    storeId,
+		Actor:      b.actor(r),
+		RequestId:  b.contextGetRequestId(r),
+		EntityType: entityType,
+		EntityId:   entityId,
+		Action:     action,
+	}
+	var err error
+	if entry.Before, err = auditJSON(before); err != nil {
+		return err
+	}
+	if entry.After, err = auditJSON(after); err != nil {
+		return err
+	}
+	return m.Audit.Record(r.Context(), entry)
+}
+
+// auditJSON returns the JSON of v, or nil if v is nil or encodes as null.
+func auditJSON(v any) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil || string(raw) == "null" {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// widgetOrder is what the audit log records of the order of the widgets of a
+// page: their ids, by position.
+func widgetOrder(widgets []*data.Widget) envelope {
+	ids := make([]uuid.UUID, len(widgets))
+	for i, widget := range widgets {
+		ids[i] = widget.Id
+	}
+	return envelope{"widget_ids": ids}
+}
+
+// listAuditHandler handles GET /stores/:store_id/audit?entity=&actor=&since=&until=&cursor=&limit=
+//
+// entity is an entity type or the id of an entity. since and until are
+// RFC 3339 times. Entries come newest first, limit at a time; next_cursor,
+// when there are more, is the cursor of the next page. The trail outlives the
+// store, so it can still be read once the store is deleted or purged.
+func (b *backend) listAuditHandler(w http.ResponseWriter, r *http.Request) {
+	storeId, err := b.readIdParam(r, "store_id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	qs := r.URL.Query()
+	v := validator.New()
+
+	filter := data.AuditFilter{
+		Actor: qs.Get("actor"),
+		Since: b.readTime(qs, "since", v),
+		Until: b.readTime(qs, "until", v),
+		Limit: b.readInt(qs, "limit", 50, v),
+	}
+	if entity := qs.Get("entity"); entity != "" {
+		if id, err := uuid.Parse(entity); err == nil {
+			filter.EntityId = &id
+		} else {
+			v.Check(validator.PermittedValue(entity, data.AuditEntities...), "entity", "must be an entity type or id")
+			filter.EntityType = entity
+		}
+	}
+	if cursor := qs.Get("cursor"); cursor != "" {
+		filter.Cursor, err = strconv.ParseInt(cursor, 10, 64)
+		v.Check(err == nil && filter.Cursor > 0, "cursor", "must be a cursor returned by a previous page")
+	}
+	v.Check(filter.Limit >= 1 && filter.Limit <= 100, "limit", "must be between 1 and 100")
+	if filter.Since != nil && filter.Until != nil {
+		v.Check(filter.Since.Before(*filter.Until), "until", "must be after since")
+	}
+	if !v.Valid() {
+		b.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// One entry more than asked for tells whether there is a next page.
+	limit := filter.Limit
+	filter.Limit++
+
+	entries, err := b.models.Audit.GetForStore(r.Context(), storeId, filter)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+		return
+	}
+	env := envelope{"entries": entries}
+	if len(entries) > limit {
+		entries = entries[:limit]
+		env["entries"] = entries
+		env["next_cursor"] = strconv.FormatInt(entries[limit-1].Id, 10)
+	}
+	if err = b.writeJson(w, http.StatusOK, env, nil); err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"appdrop/internal/bundle"
+	"appdrop/internal/data"
+	"appdrop/internal/validator"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// exportStoreHandler handles GET /stores/:store_id/export
+//
+// The response is the bundle itself rather than an envelope, so it can be
+// saved and imported again as it is.
+func (b *backend) exportStoreHandler(w http.ResponseWriter, r *http.Request) {
+	storeId, err := b.readIdParam(r, "store_id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	bdl, err := bundle.Export(r.Context(), b.models, storeId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", bdl.Store.Slug+".json"))
+
+	err = b.writeJson(w, http.StatusOK, bdl, headers)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// importStoreHandler handles POST /stores/import?dry_run=
+//
+// The store with the bundle's slug is created, or replaced so that it matches
+// the bundle. With dry_run=true only the plan is returned.
+func (b *backend) importStoreHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	dryRun := b.readBool(r.URL.Query(), "dry_run", v)
+
+	if !v.Valid() {
+		b.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	bdl, ok := b.readBundle(w, r)
+	if !ok {
+		return
+	}
+	var plan *bundle.Plan
+
+	err := b.models.InTx(r.Context(), func(m data.Models) error {
+		var err error
+		if plan, err = bundle.Import(r.Context(), m, bdl, b.conf.routes.reservedPrefixes, dryRun != nil && *dryRun); err != nil {
+			return err
+		}
+		return b.auditPlan(r, m, plan, "import", dryRun != nil && *dryRun)
+	})
+	if err != nil {
+		b.bundleErrorResponse(w, r, err)
+		return
+	}
+	response := envelope{"plan": plan, "dry_run": dryRun != nil && *dryRun}
+	if dryRun == nil || !*dryRun {
+		response["store"] = plan.Store()
+	}
+	err = b.writeJson(w, http.StatusOK, response, nil)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// applyStoreHandler handles POST /stores/:store_id/apply?dry_run=&plan_hash=
+//
+// The store is brought to what the bundle in the body describes, keeping
+// track of pages and widgets that were renamed, re-routed or reordered. With
+// dry_run=true only the plan and its hash are returned. Passing that hash as
+// plan_hash applies the plan only if it is still the same.
+func (b *backend) applyStoreHandler(w http.ResponseWriter, r *http.Request) {
+	storeId, err := b.readIdParam(r, "store_id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	qs := r.URL.Query()
+	v := validator.New()
+	dryRun := b.readBool(qs, "dry_run", v)
+	hash := qs.Get("plan_hash")
+
+	if !v.Valid() {
+		b.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	bdl, ok := b.readBundle(w, r)
+	if !ok {
+		return
+	}
+	var plan *bundle.Plan
+
+	err = b.models.InTx(r.Context(), func(m data.Models) error {
+		var err error
+		if plan, err = bundle.ApplyToStore(r.Context(), m, storeId, bdl, b.conf.routes.reservedPrefixes, hash, dryRun != nil && *dryRun); err != nil {
+			return err
+		}
+		return b.auditPlan(r, m, plan, "apply", dryRun != nil && *dryRun)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		case errors.Is(err, bundle.ErrPlanChanged):
+			b.planChangedResponse(w, r)
+		default:
+			b.bundleErrorResponse(w, r, err)
+		}
+		return
+	}
+	response := envelope{"plan": plan, "plan_hash": plan.Hash(), "dry_run": dryRun != nil && *dryRun}
+	if dryRun == nil || !*dryRun {
+		response["store"] = plan.Store()
+	}
+	err = b.writeJson(w, http.StatusOK, response, nil)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// auditPlan records an applied plan in the audit log as a single change to
+// its store, with the changes it made as the after state.
+func (b *backend) auditPlan(r *http.Request, m data.Models, plan *bundle.Plan, action string, dryRun bool) error {
+	if dryRun || len(plan.Changes) == 0 {
+		return nil
+	}
+	store := plan.Store()
+	return b.audit(r, m, store.Id, data.AuditStore, store.Id, action, nil, envelope{"plan": plan})
+}
+
+// readBundle decodes the bundle in the body of r, which is YAML when the
+// Content-Type says so and JSON otherwise. It answers the request itself and
+// returns false when the body is not a bundle.
+func (b *backend) readBundle(w http.ResponseWriter, r *http.Request) (*bundle.Bundle, bool) {
+	var bdl *bundle.Bundle
+	var err error
+
+	if isYAML(r.Header.Get("Content-Type")) {
+		r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+
+		var raw []byte
+		if raw, err = io.ReadAll(r.Body); err != nil {
+			b.badRequestResponse(w, r, b.decodeJsonError(err))
+			return nil, false
+		}
+		bdl, err = bundle.DecodeYAML(raw)
+	} else {
+		var raw json.RawMessage
+		if err = b.readJson(w, r, &raw); err != nil {
+			b.badRequestResponse(w, r, err)
+			return nil, false
+		}
+		bdl, err = bundle.Decode(raw)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, bundle.ErrUnsupportedVersion):
+			b.failedValidationResponse(w, r, map[string]string{"schema_version": err.Error()})
+		default:
+			b.badRequestResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return bdl, true
+}
+
+// isYAML reports whether contentType is one of the media types used for YAML.
+func isYAML(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch mediaType {
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		return true
+	}
+	return false
+}
+
+// bundleErrorResponse answers a bundle that could not be planned or applied.
+func (b *backend) bundleErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	var vErr *bundle.ValidationError
+	switch {
+	case errors.As(err, &vErr):
+		b.failedValidationResponse(w, r, vErr.Fields)
+	case errors.Is(err, data.ErrDeleteHomePage):
+		b.failedValidationResponse(w, r, map[string]string{"pages": "must keep a home page for the store"})
+	case errors.Is(err, data.ErrDuplicateRoute):
+		b.failedValidationResponse(w, r, map[string]string{"pages": "a page takes a route another page still holds, apply the change in two steps"})
+	case errors.Is(err, data.ErrEditConflict):
+		b.editConflictResponse(w, r)
+	case errors.Is(err, data.ErrHomeConflict):
+		b.homeConflictResponse(w, r)
+	default:
+		b.serverErrorResponse(w, r, err)
+	}
+}
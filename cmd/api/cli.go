@@ -0,0 +1,271 @@
+package main
+
+import (
+	"appdrop/internal/bundle"
+	"appdrop/internal/data"
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// runApply implements "api apply [flags] FILE". The store with the slug of the
+// bundle in FILE is brought to what the bundle describes, the same way as
+// POST /stores/:store_id/apply. The plan is printed first and applied once
+// confirmed, and only if it has not changed in the meantime.
+func runApply(args []string, stdin io.Reader, stdout io.Writer) error {
+	var cfg config
+	var dryRun, autoApprove bool
+	var timeout time.Duration
+
+	fs := flag.NewFlagSet("apply", flag.ContinueOnError)
+	fs.SetOutput(stdout)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: api apply [flags] FILE.{json,yaml}")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("APP_DROP_DSN"), "PostgreSQL DSN")
+	reservedPrefixesFlag(fs, &cfg.routes.reservedPrefixes)
+	fs.BoolVar(&dryRun, "dry-run", false, "Print the plan without applying it")
+	fs.BoolVar(&autoApprove, "auto-approve", false, "Apply the plan without asking for confirmation")
+	fs.DurationVar(&timeout, "timeout", 30*time.Second, "Deadline for computing and applying the plan")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("apply takes exactly one bundle file")
+	}
+	bdl, err := readBundleFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	cfg.db.maxOpenConns, cfg.db.maxIdleConns = 1, 1
+	db, err := openDb(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	models := data.NewModels(db)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	store, err := models.Stores.GetBySlug(ctx, bdl.Store.Slug)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return fmt.Errorf("there is no store with the slug %q", bdl.Store.Slug)
+		}
+		return err
+	}
+	plan, err := bundle.ApplyToStore(ctx, models, store.Id, bdl, cfg.routes.reservedPrefixes, "", true)
+	if err != nil {
+		return planError(err)
+	}
+	printPlan(stdout, plan)
+
+	if len(plan.Changes) == 0 || dryRun {
+		return nil
+	}
+	if !autoApprove {
+		fmt.Fprint(stdout, "\nApply these changes? Only 'yes' will be accepted: ")
+		answer, _ := bufio.NewReader(stdin).ReadString('\n')
+		if strings.TrimSpace(answer) != "yes" {
+			fmt.Fprintln(stdout, "Apply cancelled.")
+			return nil
+		}
+	}
+	if err = cliApply(ctx, models, store.Id, bdl, cfg.routes.reservedPrefixes, plan.Hash()); err != nil {
+		return planError(err)
+	}
+	fmt.Fprintf(stdout, "Applied %d changes to %s.\n", len(plan.Changes), store.Slug)
+	return nil
+}
+
+// cliApply applies bdl to the store storeId, as long as its plan still hashes
+// to hash, and records the plan in the audit log in the same unit of work, the
+// way POST /stores/:store_id/apply does. The actor is the system user who ran
+// the command.
+func cliApply(ctx context.Context, models data.Models, storeId uuid.UUID, bdl *bundle.Bundle, reserved []string, hash string) error {
+	return models.InTx(ctx, func(m data.Models) error {
+		plan, err := bundle.ApplyToStore(ctx, m, storeId, bdl, reserved, hash, false)
+		if err != nil {
+			return err
+		}
+		after, err := auditJSON(envelope{"plan": plan})
+		if err != nil {
+			return err
+		}
+		return m.Audit.Record(ctx, &data.AuditEntry{
+			StoreId:    storeId,
+			Actor:      cliActor(),
+			EntityType: data.AuditStore,
+			EntityId:   storeId,
+			Action:     "apply",
+			After:      after,
+		})
+	})
+}
+
+// cliActor names the system user running a command in the audit log.
+func cliActor() string {
+	if u, err := user.Current(); err == nil {
+		return "cli:" + u.Username
+	}
+	return "cli"
+}
+
+// runCreateKey implements "api create-key [flags] NAME". It creates an API key
+// straight in the database and prints it, which is how the first key, the one
+// that creates the others through the API, comes about.
+func runCreateKey(args []string, _ io.Reader, stdout io.Writer) error {
+	var cfg config
+	var storeIds []uuid.UUID
+	var expiresIn time.Duration
+
+	fs := flag.NewFlagSet("create-key", flag.ContinueOnError)
+	fs.SetOutput(stdout)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: api create-key [flags] NAME")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("APP_DROP_DSN"), "PostgreSQL DSN")
+	fs.Func("stores", "Store ids the key is restricted to (comma separated, default all stores)", func(val string) error {
+		for _, s := range strings.Split(val, ",") {
+			id, err := uuid.Parse(strings.TrimSpace(s))
+			if err != nil {
+				return err
+			}
+			storeIds = append(storeIds, id)
+		}
+		return nil
+	})
+	fs.DurationVar(&expiresIn, "expires-in", 0, "How long the key is valid (default forever)")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || strings.TrimSpace(fs.Arg(0)) == "" {
+		fs.Usage()
+		return errors.New("create-key takes exactly one key name")
+	}
+	var expiresAt *time.Time
+	if expiresIn > 0 {
+		at := time.Now().Add(expiresIn).UTC().Truncate(time.Second)
+		expiresAt = &at
+	}
+	key, err := data.GenerateApiKey(fs.Arg(0), storeIds, expiresAt)
+	if err != nil {
+		return err
+	}
+	cfg.db.maxOpenConns, cfg.db.maxIdleConns = 1, 1
+	db, err := openDb(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err = data.NewModels(db).ApiKeys.Insert(ctx, key); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Created API key %q (%s). Store it now, it is not shown again:\n%s\n", key.Name, key.Prefix, key.Plaintext)
+	return nil
+}
+
+// readBundleFile decodes the bundle in the file at path, as YAML if its
+// extension says so and as JSON otherwise.
+func readBundleFile(path string) (*bundle.Bundle, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return bundle.DecodeYAML(raw)
+	default:
+		return bundle.Decode(raw)
+	}
+}
+
+// planError spells out the problems of an invalid bundle, one per line.
+func planError(err error) error {
+	var vErr *bundle.ValidationError
+	if !errors.As(err, &vErr) {
+		return err
+	}
+	var sb strings.Builder
+	sb.WriteString(err.Error())
+	for _, key := range slices.Sorted(maps.Keys(vErr.Fields)) {
+		fmt.Fprintf(&sb, "\n  %s: %s", key, vErr.Fields[key])
+	}
+	return errors.New(sb.String())
+}
+
+// printPlan writes the changes of plan one per line, the way a reviewer reads
+// them: + creates, - deletes, ~ updates, with old and new values where the
+// change has them.
+func printPlan(w io.Writer, plan *bundle.Plan) {
+	if len(plan.Changes) == 0 {
+		fmt.Fprintln(w, "No changes. The store already matches the bundle.")
+		return
+	}
+	for _, c := range plan.Changes {
+		sign := "~"
+		switch c.Action {
+		case bundle.Create:
+			sign = "+"
+		case bundle.Delete:
+			sign = "-"
+		}
+		line := fmt.Sprintf("%s %s %s %s", sign, c.Action, c.Kind, c.Key)
+		if c.Position != nil {
+			line += fmt.Sprintf(" #%d", *c.Position)
+		}
+		if len(c.Fields) > 0 {
+			line += " (" + strings.Join(c.Fields, ", ") + ")"
+		}
+		switch {
+		case c.Action == bundle.Reorder:
+			line += fmt.Sprintf(": %s", formatPositions(c.From))
+		case c.From != nil || c.To != nil:
+			line += fmt.Sprintf(": %v -> %v", c.From, c.To)
+		}
+		fmt.Fprintln(w, line)
+	}
+	fmt.Fprintf(w, "\nPlan: %d to create, %d to update, %d to delete.\n",
+		plan.Summary.Create, plan.Summary.Update, plan.Summary.Delete)
+}
+
+// formatPositions shows the old positions of a reordered page in their new
+// order, with + for the widgets that are added.
+func formatPositions(from any) string {
+	positions, ok := from.([]*int)
+	if !ok {
+		return fmt.Sprint(from)
+	}
+	parts := make([]string, len(positions))
+	for i, position := range positions {
+		if position == nil {
+			parts[i] = "+"
+			continue
+		}
+		parts[i] = fmt.Sprintf("#%d", *position)
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"appdrop/internal/data"
+	"appdrop/internal/oauth"
+	"context"
+	"net/http"
+)
+
+type contextKey string
+
+const (
+	requestIdContextKey = contextKey("requestId")
+	apiKeyContextKey    = contextKey("apiKey")
+	userContextKey      = contextKey("user")
+	clientContextKey    = contextKey("client")
+)
+
+// contextSetRequestId returns a copy of r carrying the request id.
+func (b *backend) contextSetRequestId(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIdContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestId returns the request id set by the requestId middleware,
+// or an empty string for a request that did not go through it.
+func (b *backend) contextGetRequestId(r *http.Request) string {
+	id, _ := r.Context().Value(requestIdContextKey).(string)
+	return id
+}
+
+// contextSetApiKey returns a copy of r carrying the API key it was
+// authenticated with.
+func (b *backend) contextSetApiKey(r *http.Request, key *data.ApiKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// contextGetApiKey returns the API key the request was authenticated with, or
+// nil for an anonymous request.
+func (b *backend) contextGetApiKey(r *http.Request) *data.ApiKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.ApiKey)
+	return key
+}
+
+// contextSetUser returns a copy of r carrying the user whose session it was
+// authenticated with.
+func (b *backend) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	return r.WithContext(ctx)
+}
+
+// contextGetUser returns the user whose session the request was authenticated
+// with, or nil for a request made with an API key or anonymously.
+func (b *backend) contextGetUser(r *http.Request) *data.User {
+	user, _ := r.Context().Value(userContextKey).(*data.User)
+	return user
+}
+
+// contextSetClient returns a copy of r carrying the claims of the access token
+// it was authenticated with.
+func (b *backend) contextSetClient(r *http.Request, claims *oauth.Claims) *http.Request {
+	ctx := context.WithValue(r.Context(), clientContextKey, claims)
+	return r.WithContext(ctx)
+}
+
+// contextGetClient returns the claims of the access token the request was
+// authenticated with, or nil for a request made otherwise.
+func (b *backend) contextGetClient(r *http.Request) *oauth.Claims {
+	claims, _ := r.Context().Value(clientContextKey).(*oauth.Claims)
+	return claims
+}
+
+// actor names who makes the request, as recorded in the audit log: the API
+// key it was authenticated with, by prefix, the user by email, the client of
+// its access token by client id, or "anonymous".
+func (b *backend) actor(r *http.Request) string {
+	if key := b.contextGetApiKey(r); key != nil {
+		return "api_key:" + key.Prefix
+	}
+	if user := b.contextGetUser(r); user != nil {
+		return "user:" + user.Email
+	}
+	if claims := b.contextGetClient(r); claims != nil {
+		return "client:" + claims.Subject
+	}
+	return "anonymous"
+}
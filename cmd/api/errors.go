@@ -3,19 +3,32 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"strings"
 )
 
 // ErrorResponse represents the API error format specified in requirements
 type ErrorResponse struct {
 	Error struct {
-		Code    string `json:"code"`
-		Message string `json:"message"`
+		Code    string            `json:"code"`
+		Message string            `json:"message"`
+		Fields  map[string]string `json:"fields,omitempty"`
+
+		Operations []operationError `json:"operations,omitempty"`
 	} `json:"error"`
 }
 
+// operationError reports why one operation of a batch failed. Index is its
+// place in the request.
+type operationError struct {
+	Index   int               `json:"index"`
+	Op      string            `json:"op"`
+	Message string            `json:"message,omitempty"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
 // logError logs the error with details
 func (b *backend) logError(r *http.Request, err error) {
-	b.logger.Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+	b.logger.Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI(), "request_id", b.contextGetRequestId(r))
 }
 
 // errorResponse sends a JSON error response with the specified code and message
@@ -24,6 +37,11 @@ func (b *backend) errorResponse(w http.ResponseWriter, r *http.Request, status i
 	resp.Error.Code = code
 	resp.Error.Message = message
 
+	b.writeErrorResponse(w, r, status, resp)
+}
+
+// writeErrorResponse writes resp, falling back to a bare 500 if that fails
+func (b *backend) writeErrorResponse(w http.ResponseWriter, r *http.Request, status int, resp ErrorResponse) {
 	err := b.writeJson(w, status, resp, nil)
 	if err != nil {
 		b.logError(r, err)
@@ -59,10 +77,116 @@ func (b *backend) conflictResponse(w http.ResponseWriter, r *http.Request, messa
 	b.errorResponse(w, r, http.StatusConflict, "CONFLICT", message)
 }
 
-// failedValidationResponse sends a 422 Unprocessable Entity response with validation errors
+// editConflictResponse sends a 409 Conflict response when the resource was
+// changed by someone else between reading and writing it
+func (b *backend) editConflictResponse(w http.ResponseWriter, r *http.Request) {
+	message := "unable to update the record due to an edit conflict, please fetch it and try again"
+	b.errorResponse(w, r, http.StatusConflict, "EDIT_CONFLICT", message)
+}
+
+// homeConflictResponse sends a 409 Conflict response when another page was
+// made the home page of the store at the same time
+func (b *backend) homeConflictResponse(w http.ResponseWriter, r *http.Request) {
+	message := "another page was made the home page at the same time, please try again"
+	b.errorResponse(w, r, http.StatusConflict, "CONFLICT", message)
+}
+
+// preconditionFailedResponse sends a 412 Precondition Failed response when the
+// If-Match header does not match the current version of the resource
+func (b *backend) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the resource has changed since it was fetched, please fetch it and try again"
+	b.errorResponse(w, r, http.StatusPreconditionFailed, "EDIT_CONFLICT", message)
+}
+
+// planChangedResponse sends a 412 Precondition Failed response when a plan
+// that was reviewed no longer matches what applying it would do
+func (b *backend) planChangedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the plan has changed since it was computed, please review the new plan and try again"
+	b.errorResponse(w, r, http.StatusPreconditionFailed, "PLAN_CHANGED", message)
+}
+
+// failedValidationResponse sends a 422 Unprocessable Entity response with the
+// validation errors listed per field
 func (b *backend) failedValidationResponse(w http.ResponseWriter, r *http.Request, errs map[string]string) {
-	message := fmt.Sprintf("validation failed: %v", errs)
-	b.errorResponse(w, r, http.StatusUnprocessableEntity, "VALIDATION_ERROR", message)
+	resp := ErrorResponse{}
+	resp.Error.Code = "VALIDATION_ERROR"
+	resp.Error.Message = "validation failed"
+	resp.Error.Fields = errs
+
+	b.writeErrorResponse(w, r, http.StatusUnprocessableEntity, resp)
+}
+
+// failedBatchResponse sends the per-operation error report of a batch that was
+// not applied. code and status describe the first failure, which decides how
+// the batch as a whole is answered.
+func (b *backend) failedBatchResponse(w http.ResponseWriter, r *http.Request, status int, code string, errs []operationError) {
+	resp := ErrorResponse{}
+	resp.Error.Code = code
+	resp.Error.Message = "the batch was not applied, see the operations for details"
+	resp.Error.Operations = errs
+
+	b.writeErrorResponse(w, r, status, resp)
+}
+
+// invalidAuthenticationTokenResponse sends a 401 Unauthorized response when
+// the credentials of the request are wrong, expired or revoked
+func (b *backend) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	message := "invalid or missing authentication token"
+	b.errorResponse(w, r, http.StatusUnauthorized, "INVALID_TOKEN", message)
+}
+
+// invalidCredentialsResponse sends a 401 Unauthorized response when a login
+// names an unknown email or the wrong password
+func (b *backend) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid authentication credentials"
+	b.errorResponse(w, r, http.StatusUnauthorized, "INVALID_CREDENTIALS", message)
+}
+
+// authenticationRequiredResponse sends a 401 Unauthorized response when the
+// request comes without credentials
+func (b *backend) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	message := "you must be authenticated to access this resource"
+	b.errorResponse(w, r, http.StatusUnauthorized, "UNAUTHORIZED", message)
+}
+
+// notPermittedResponse sends a 403 Forbidden response when the credentials of
+// the request do not give access to the resource
+func (b *backend) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your credentials do not give access to this resource"
+	b.errorResponse(w, r, http.StatusForbidden, "FORBIDDEN", message)
+}
+
+// insufficientScopeResponse sends a 403 Forbidden response when the access
+// token of the request lacks one of the scopes the route needs
+func (b *backend) insufficientScopeResponse(w http.ResponseWriter, r *http.Request, scopes []string) {
+	if len(scopes) == 0 {
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+		b.errorResponse(w, r, http.StatusForbidden, "INSUFFICIENT_SCOPE", "access tokens cannot access this resource")
+		return
+	}
+	scope := strings.Join(scopes, " ")
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, scope))
+	message := fmt.Sprintf("the access token needs the %s scope to access this resource", scope)
+	if len(scopes) > 1 {
+		message = fmt.Sprintf("the access token needs the %s scopes to access this resource", strings.Join(scopes, ", "))
+	}
+	b.errorResponse(w, r, http.StatusForbidden, "INSUFFICIENT_SCOPE", message)
+}
+
+// oauthErrorResponse sends the error of a token request in the format of
+// RFC 6749, which OAuth clients expect instead of the usual one
+func (b *backend) oauthErrorResponse(w http.ResponseWriter, r *http.Request, status int, code, description string) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	headers := http.Header{"Cache-Control": {"no-store"}, "Pragma": {"no-cache"}}
+	err := b.writeJson(w, status, envelope{"error": code, "error_description": description}, headers)
+	if err != nil {
+		b.logger.Error(err.Error(), "request_id", b.contextGetRequestId(r))
+		w.WriteHeader(http.StatusInternalServerError)
+	}
 }
 
 func (b *backend) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
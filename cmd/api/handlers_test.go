@@ -0,0 +1,1421 @@
+package main
+
+import (
+	"appdrop/internal/bundle"
+	"appdrop/internal/data"
+	"appdrop/internal/mailer"
+	"appdrop/internal/oauth"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// newTestBackend returns the routes of a backend on empty in-memory models.
+// Requests are made with a key that can access every store unless they carry
+// an Authorization header of their own.
+func newTestBackend(t *testing.T) http.Handler {
+	t.Helper()
+
+	return withTestKey(t, newTestApp(t))
+}
+
+// withTestKey returns the routes of b, authenticating requests without an
+// Authorization header with a key that can access every store.
+func withTestKey(t *testing.T, b *backend) http.Handler {
+	t.Helper()
+
+	key, err := data.GenerateApiKey("tests", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = b.models.ApiKeys.Insert(context.Background(), key); err != nil {
+		t.Fatal(err)
+	}
+	h := b.routes()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+key.Plaintext)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// newTestApp returns a backend on empty in-memory models.
+func newTestApp(t *testing.T) *backend {
+	t.Helper()
+
+	models := data.NewMemoryModels()
+	if err := models.WidgetTypes.EnsureBuiltins(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	b := &backend{
+		logger: logger,
+		models: models,
+		mailer: mailer.NewLog(logger),
+	}
+	b.conf.db.queryTimeout = time.Second
+	b.conf.routes.reservedPrefixes = []string{"/api", "/public"}
+	b.conf.sessions.ttl = time.Hour
+	b.conf.invitations.ttl = time.Hour
+	b.conf.oauth.tokenTTL = time.Minute
+
+	tokens, err := oauth.GenerateKeySet(oauth.AlgEdDSA, "appdrop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.tokens = tokens
+	return b
+}
+
+type testResponse struct {
+	status int
+	header http.Header
+	body   map[string]any
+}
+
+// do sends a request to h and decodes the JSON response body.
+func do(t *testing.T, h http.Handler, method, path string, body any, headers ...string) testResponse {
+	t.Helper()
+
+	var r io.Reader
+	if raw, ok := body.([]byte); ok {
+		r = bytes.NewReader(raw)
+	} else if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = bytes.NewReader(b)
+	}
+	req := httptest.NewRequest(method, path, r)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	res := testResponse{status: rr.Code, header: rr.Header()}
+	if rr.Body.Len() > 0 {
+		if err := json.Unmarshal(rr.Body.Bytes(), &res.body); err != nil {
+			t.Fatalf("%s %s: decoding response: %v", method, path, err)
+		}
+	}
+	return res
+}
+
+// field walks the decoded body along keys, e.g. field(res.body, "page", "id").
+func field(v any, keys ...string) any {
+	for _, k := range keys {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = m[k]
+	}
+	return v
+}
+
+func expectStatus(t *testing.T, res testResponse, want int) {
+	t.Helper()
+
+	if res.status != want {
+		t.Fatalf("expected status %d, got %d: %v", want, res.status, res.body)
+	}
+}
+
+func createStore(t *testing.T, h http.Handler, slug string) string {
+	t.Helper()
+
+	res := do(t, h, http.MethodPost, "/stores", map[string]any{"name": "Store " + slug, "slug": slug})
+	expectStatus(t, res, http.StatusCreated)
+	return field(res.body, "store", "id").(string)
+}
+
+// createApiKey creates a key restricted to the given stores and returns it
+// with its prefix.
+func createApiKey(t *testing.T, h http.Handler, name string, storeIds ...string) (string, string) {
+	t.Helper()
+
+	res := do(t, h, http.MethodPost, "/api-keys", map[string]any{"name": name, "store_ids": storeIds})
+	expectStatus(t, res, http.StatusCreated)
+	return field(res.body, "api_key", "key").(string), field(res.body, "api_key", "prefix").(string)
+}
+
+// login registers a user with the given email, logs them in and returns the
+// Authorization header of their session with their id.
+func login(t *testing.T, h http.Handler, email string) ([]string, string) {
+	t.Helper()
+
+	credentials := map[string]any{"email": email, "password": "correct horse"}
+	res := do(t, h, http.MethodPost, "/users", map[string]any{"name": email, "email": email, "password": "correct horse"})
+	expectStatus(t, res, http.StatusCreated)
+	res = do(t, h, http.MethodPost, "/sessions", credentials)
+	expectStatus(t, res, http.StatusCreated)
+	return []string{"Authorization", "Bearer " + field(res.body, "session", "token").(string)}, field(res.body, "user", "id").(string)
+}
+
+func createPage(t *testing.T, h http.Handler, storeId, route string, home bool) string {
+	t.Helper()
+
+	res := do(t, h, http.MethodPost, "/stores/"+storeId+"/pages",
+		map[string]any{"name": "Page " + route, "route": route, "is_home": home})
+	expectStatus(t, res, http.StatusCreated)
+	return field(res.body, "page", "id").(string)
+}
+
+func TestStoreHandlers(t *testing.T) {
+	h := newTestBackend(t)
+
+	res := do(t, h, http.MethodPost, "/stores", map[string]any{"name": "Acme", "slug": "acme"})
+	expectStatus(t, res, http.StatusCreated)
+	if etag := res.header.Get("ETag"); etag != `"1"` {
+		t.Fatalf(`expected ETag "1", got %s`, etag)
+	}
+	id := field(res.body, "store", "id").(string)
+
+	res = do(t, h, http.MethodPost, "/stores", map[string]any{"name": "Acme again", "slug": "acme"})
+	expectStatus(t, res, http.StatusConflict)
+
+	res = do(t, h, http.MethodPut, "/stores/"+id, map[string]any{"name": "Acme Inc"}, "If-Match", `"1"`)
+	expectStatus(t, res, http.StatusOK)
+	if etag := res.header.Get("ETag"); etag != `"2"` {
+		t.Fatalf(`expected ETag "2", got %s`, etag)
+	}
+	res = do(t, h, http.MethodPut, "/stores/"+id, map[string]any{"name": "Stale"}, "If-Match", `"1"`)
+	expectStatus(t, res, http.StatusPreconditionFailed)
+	if code := field(res.body, "error", "code"); code != "EDIT_CONFLICT" {
+		t.Fatalf("expected EDIT_CONFLICT, got %v", code)
+	}
+	res = do(t, h, http.MethodGet, "/stores/"+id, nil)
+	expectStatus(t, res, http.StatusOK)
+	if name := field(res.body, "store", "name"); name != "Acme Inc" {
+		t.Fatalf("expected the first update to stick, got name %v", name)
+	}
+}
+
+func TestPageHandlers(t *testing.T) {
+	h := newTestBackend(t)
+	storeId := createStore(t, h, "acme")
+
+	home := createPage(t, h, storeId, "/", true)
+	product := createPage(t, h, storeId, "product/:id", false)
+
+	tests := []struct {
+		name   string
+		body   map[string]any
+		status int
+	}{
+		{"duplicate route", map[string]any{"name": "Again", "route": "/product/:id/"}, http.StatusConflict},
+		{"ambiguous route", map[string]any{"name": "Sku", "route": "/product/:sku"}, http.StatusConflict},
+		{"reserved prefix", map[string]any{"name": "Api", "route": "/api/orders"}, http.StatusBadRequest},
+		{"invalid route", map[string]any{"name": "Sale", "route": "/sale now"}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := do(t, h, http.MethodPost, "/stores/"+storeId+"/pages", tt.body)
+			expectStatus(t, res, tt.status)
+		})
+	}
+
+	t.Run("single home page", func(t *testing.T) {
+		res := do(t, h, http.MethodPut, "/stores/"+storeId+"/pages/"+product, map[string]any{"is_home": true})
+		expectStatus(t, res, http.StatusOK)
+
+		res = do(t, h, http.MethodGet, "/stores/"+storeId+"/pages/"+home, nil)
+		expectStatus(t, res, http.StatusOK)
+		if isHome := field(res.body, "page", "is_home"); isHome != false {
+			t.Fatalf("expected the old home page to be unset, got is_home %v", isHome)
+		}
+		res = do(t, h, http.MethodDelete, "/stores/"+storeId+"/pages/"+product, nil)
+		expectStatus(t, res, http.StatusConflict)
+	})
+
+	t.Run("cascade delete", func(t *testing.T) {
+		res := do(t, h, http.MethodDelete, "/stores/"+storeId, nil)
+		expectStatus(t, res, http.StatusOK)
+
+		res = do(t, h, http.MethodGet, "/stores/"+storeId+"/pages/"+home, nil)
+		expectStatus(t, res, http.StatusNotFound)
+	})
+}
+
+func TestWidgetHandlers(t *testing.T) {
+	h := newTestBackend(t)
+	storeId := createStore(t, h, "acme")
+	pageId := createPage(t, h, storeId, "/", true)
+	widgetsPath := "/stores/" + storeId + "/pages/" + pageId + "/widgets"
+
+	res := do(t, h, http.MethodPost, widgetsPath, map[string]any{"type": "text", "config": map[string]any{}})
+	expectStatus(t, res, http.StatusUnprocessableEntity)
+	if msg := field(res.body, "error", "fields", "config.content"); msg != "is required" {
+		t.Fatalf("expected config.content to be required, got %v", msg)
+	}
+
+	var ids []string
+	for _, content := range []string{"first", "second", "third"} {
+		res := do(t, h, http.MethodPost, widgetsPath, map[string]any{"type": "text", "config": map[string]any{"content": content}})
+		expectStatus(t, res, http.StatusCreated)
+		ids = append(ids, field(res.body, "widget", "id").(string))
+	}
+
+	res = do(t, h, http.MethodPost, widgetsPath+"/reorder", map[string]any{"widget_ids": []string{ids[2], ids[0], ids[1]}})
+	expectStatus(t, res, http.StatusOK)
+
+	res = do(t, h, http.MethodGet, "/stores/"+storeId+"/pages/"+pageId, nil)
+	expectStatus(t, res, http.StatusOK)
+	widgets := field(res.body, "page", "widgets").([]any)
+	for i, want := range []string{"third", "first", "second"} {
+		if got := field(widgets[i], "config", "content"); got != want {
+			t.Fatalf("widget %d: expected %q, got %v", i, want, got)
+		}
+	}
+
+	otherPage := createPage(t, h, storeId, "/other", false)
+	res = do(t, h, http.MethodPost, "/stores/"+storeId+"/pages/"+otherPage+"/widgets/reorder",
+		map[string]any{"widget_ids": []string{ids[0]}})
+	expectStatus(t, res, http.StatusBadRequest)
+
+	res = do(t, h, http.MethodPut, "/stores/"+storeId+"/widgets/"+ids[0],
+		map[string]any{"config": map[string]any{"content": "edited"}}, "If-Match", `"1"`)
+	expectStatus(t, res, http.StatusPreconditionFailed)
+
+	res = do(t, h, http.MethodDelete, "/stores/"+storeId+"/widgets/"+ids[0], nil)
+	expectStatus(t, res, http.StatusOK)
+}
+
+func TestRestorePageVersion(t *testing.T) {
+	h := newTestBackend(t)
+	storeId := createStore(t, h, "acme")
+	homeId := createPage(t, h, storeId, "/", true)
+	aboutId := createPage(t, h, storeId, "/about", false)
+	aboutPath := "/stores/" + storeId + "/pages/" + aboutId
+	restore := func(version int) testResponse {
+		return do(t, h, http.MethodPost, fmt.Sprintf("%s/versions/%d/restore", aboutPath, version), nil)
+	}
+
+	res := do(t, h, http.MethodPost, aboutPath+"/widgets", map[string]any{"type": "text", "config": map[string]any{"content": "hi"}})
+	expectStatus(t, res, http.StatusCreated)
+	widgetId := field(res.body, "widget", "id").(string)
+	expectStatus(t, do(t, h, http.MethodDelete, "/stores/"+storeId+"/widgets/"+widgetId, nil), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodPut, aboutPath, map[string]any{"route": "/info"}), http.StatusOK)
+
+	// Version 2 had the widget at /about; restoring it is saved as version 5.
+	res = restore(2)
+	expectStatus(t, res, http.StatusOK)
+	if v := field(res.body, "version", "version"); v != float64(5) {
+		t.Fatalf("expected the restore to be recorded as version 5, got %v", v)
+	}
+	res = do(t, h, http.MethodGet, aboutPath, nil)
+	expectStatus(t, res, http.StatusOK)
+	widgets, _ := field(res.body, "page", "widgets").([]any)
+	if field(res.body, "page", "route") != "/about" || len(widgets) != 1 || field(widgets[0], "id") != widgetId {
+		t.Fatalf("expected the route and widget of version 2 back, got %v", res.body)
+	}
+	expectStatus(t, restore(99), http.StatusNotFound)
+
+	// Version 6 is home; by the time it is restored another page is.
+	expectStatus(t, do(t, h, http.MethodPut, aboutPath, map[string]any{"is_home": true}), http.StatusOK)
+	res = do(t, h, http.MethodPut, "/stores/"+storeId+"/pages/"+homeId, map[string]any{"is_home": true})
+	expectStatus(t, res, http.StatusOK)
+	homeETag := res.header.Get("ETag")
+	res = restore(6)
+	expectStatus(t, res, http.StatusOK)
+	if field(res.body, "version", "is_home") != true {
+		t.Fatal("expected the restored page to be home")
+	}
+	res = do(t, h, http.MethodGet, "/stores/"+storeId+"/pages/"+homeId, nil)
+	expectStatus(t, res, http.StatusOK)
+	if field(res.body, "page", "is_home") != false {
+		t.Fatal("expected the previous home page to give it up")
+	}
+	if etag := res.header.Get("ETag"); etag == homeETag {
+		t.Fatalf("expected the previous home page to move past its ETag %s", etag)
+	}
+
+	// /about has since gone to another page.
+	expectStatus(t, do(t, h, http.MethodPut, aboutPath, map[string]any{"route": "/info"}), http.StatusOK)
+	createPage(t, h, storeId, "/about", false)
+	expectStatus(t, restore(2), http.StatusConflict)
+
+	// So has a route that matches the same paths as one of its versions.
+	expectStatus(t, do(t, h, http.MethodPut, aboutPath, map[string]any{"route": "/product/:id"}), http.StatusOK)
+	res = do(t, h, http.MethodGet, aboutPath+"/versions", nil)
+	expectStatus(t, res, http.StatusOK)
+	productVersion := int(field(field(res.body, "versions").([]any)[0], "version").(float64))
+	expectStatus(t, do(t, h, http.MethodPut, aboutPath, map[string]any{"route": "/info"}), http.StatusOK)
+	skuId := createPage(t, h, storeId, "/product/:sku", false)
+	res = restore(productVersion)
+	expectStatus(t, res, http.StatusConflict)
+	if message, _ := field(res.body, "error", "message").(string); !strings.Contains(message, skuId) {
+		t.Fatalf("expected the conflict to name page %s, got %q", skuId, message)
+	}
+
+	// A version cannot bring back a widget whose type was deleted since.
+	res = do(t, h, http.MethodPost, "/stores/"+storeId+"/widget-types", map[string]any{
+		"name": "countdown", "label": "Countdown",
+		"schema": map[string]any{"fields": map[string]any{"ends_at": map[string]any{"type": "string"}}},
+	})
+	expectStatus(t, res, http.StatusCreated)
+	typeId := field(res.body, "widget_type", "id").(string)
+	res = do(t, h, http.MethodPost, aboutPath+"/widgets", map[string]any{"type": "countdown", "config": map[string]any{"ends_at": "soon"}})
+	expectStatus(t, res, http.StatusCreated)
+	countdownId := field(res.body, "widget", "id").(string)
+
+	res = do(t, h, http.MethodGet, aboutPath+"/versions", nil)
+	expectStatus(t, res, http.StatusOK)
+	withCountdown := int(field(field(res.body, "versions").([]any)[0], "version").(float64))
+	expectStatus(t, do(t, h, http.MethodDelete, "/stores/"+storeId+"/widgets/"+countdownId, nil), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodDelete, "/stores/"+storeId+"/widget-types/"+typeId, nil), http.StatusOK)
+	expectStatus(t, restore(withCountdown), http.StatusConflict)
+}
+
+func TestPublicHandlers(t *testing.T) {
+	h := newTestBackend(t)
+	storeId := createStore(t, h, "acme")
+	pageId := createPage(t, h, storeId, "/", true)
+	productId := createPage(t, h, storeId, "/product/:id", false)
+
+	res := do(t, h, http.MethodGet, "/public/acme/resolve?path=/product/42", nil)
+	expectStatus(t, res, http.StatusNotFound)
+
+	for _, id := range []string{pageId, productId} {
+		res = do(t, h, http.MethodPost, "/stores/"+storeId+"/pages/"+id+"/publish", nil)
+		expectStatus(t, res, http.StatusCreated)
+	}
+
+	res = do(t, h, http.MethodGet, "/public/acme/manifest", nil)
+	expectStatus(t, res, http.StatusOK)
+	if home := field(res.body, "manifest", "home", "page_id"); home != pageId {
+		t.Fatalf("expected home page %s, got %v", pageId, home)
+	}
+	// A stale key left in the app does not lock it out of public content.
+	res = do(t, h, http.MethodGet, "/public/acme/manifest", nil, "Authorization", "Bearer adk_revoked")
+	expectStatus(t, res, http.StatusOK)
+
+	res = do(t, h, http.MethodGet, "/public/acme/resolve?path=/product/42", nil)
+	expectStatus(t, res, http.StatusOK)
+	if id := field(res.body, "params", "id"); id != "42" {
+		t.Fatalf("expected param id 42, got %v", id)
+	}
+
+	etag := res.header.Get("ETag")
+	res = do(t, h, http.MethodGet, "/public/acme/resolve?path=/product/42", nil, "If-None-Match", etag)
+	expectStatus(t, res, http.StatusNotModified)
+}
+
+func TestCreateWidgetPlacement(t *testing.T) {
+	h := newTestBackend(t)
+	storeId := createStore(t, h, "acme")
+	pageId := createPage(t, h, storeId, "/", true)
+	widgetsPath := "/stores/" + storeId + "/pages/" + pageId + "/widgets"
+
+	add := func(content string, placement map[string]any) (testResponse, string) {
+		body := map[string]any{"type": "text", "config": map[string]any{"content": content}}
+		for k, v := range placement {
+			body[k] = v
+		}
+		res := do(t, h, http.MethodPost, widgetsPath, body)
+		id, _ := field(res.body, "widget", "id").(string)
+		return res, id
+	}
+	contents := func() []string {
+		res := do(t, h, http.MethodGet, "/stores/"+storeId+"/pages/"+pageId, nil)
+		expectStatus(t, res, http.StatusOK)
+		var got []string
+		for i, w := range field(res.body, "page", "widgets").([]any) {
+			if pos := field(w, "position"); pos != float64(i) {
+				t.Fatalf("expected dense positions, widget %d is at %v", i, pos)
+			}
+			got = append(got, field(w, "config", "content").(string))
+		}
+		return got
+	}
+
+	_, a := add("a", nil)
+	_, c := add("c", nil)
+	res, _ := add("b", map[string]any{"before": c})
+	expectStatus(t, res, http.StatusCreated)
+	res, _ = add("d", map[string]any{"after": c})
+	expectStatus(t, res, http.StatusCreated)
+	res, _ = add("first", map[string]any{"position": 0})
+	expectStatus(t, res, http.StatusCreated)
+	res, _ = add("last", map[string]any{"position": 99})
+	expectStatus(t, res, http.StatusCreated)
+
+	want := []string{"first", "a", "b", "c", "d", "last"}
+	if got := contents(); !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	res, _ = add("x", map[string]any{"position": 1, "before": c})
+	expectStatus(t, res, http.StatusUnprocessableEntity)
+	res, _ = add("x", map[string]any{"after": uuid.NewString()})
+	expectStatus(t, res, http.StatusUnprocessableEntity)
+
+	res = do(t, h, http.MethodDelete, "/stores/"+storeId+"/widgets/"+a, nil)
+	expectStatus(t, res, http.StatusOK)
+
+	want = []string{"first", "b", "c", "d", "last"}
+	if got := contents(); !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestMoveWidget(t *testing.T) {
+	h := newTestBackend(t)
+	storeId := createStore(t, h, "acme")
+	homeId := createPage(t, h, storeId, "/", true)
+	saleId := createPage(t, h, storeId, "/sale", false)
+	otherId := createPage(t, h, createStore(t, h, "other"), "/", true)
+
+	add := func(pageId, content string) string {
+		body := map[string]any{"type": "text", "config": map[string]any{"content": content}}
+		res := do(t, h, http.MethodPost, "/stores/"+storeId+"/pages/"+pageId+"/widgets", body)
+		expectStatus(t, res, http.StatusCreated)
+		return field(res.body, "widget", "id").(string)
+	}
+	contents := func(pageId string) []string {
+		res := do(t, h, http.MethodGet, "/stores/"+storeId+"/pages/"+pageId, nil)
+		expectStatus(t, res, http.StatusOK)
+		got := []string{}
+		for i, w := range field(res.body, "page", "widgets").([]any) {
+			if pos := field(w, "position"); pos != float64(i) {
+				t.Fatalf("expected dense positions, widget %d is at %v", i, pos)
+			}
+			got = append(got, field(w, "config", "content").(string))
+		}
+		return got
+	}
+	move := func(id string, body map[string]any) testResponse {
+		return do(t, h, http.MethodPost, "/stores/"+storeId+"/widgets/"+id+"/move", body)
+	}
+
+	a := add(homeId, "a")
+	b := add(homeId, "b")
+	add(homeId, "c")
+	x := add(saleId, "x")
+
+	res := move(b, map[string]any{"page_id": saleId, "before": x})
+	expectStatus(t, res, http.StatusOK)
+	if got := field(res.body, "widget", "page_id"); got != saleId {
+		t.Fatalf("expected widget on %s, got %v", saleId, got)
+	}
+	if got, want := contents(homeId), []string{"a", "c"}; !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if got, want := contents(saleId), []string{"b", "x"}; !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	res = move(a, map[string]any{"page_id": homeId})
+	expectStatus(t, res, http.StatusOK)
+	if got, want := contents(homeId), []string{"c", "a"}; !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	tests := []struct {
+		name string
+		body map[string]any
+		want int
+	}{
+		{"page of another store", map[string]any{"page_id": otherId}, http.StatusUnprocessableEntity},
+		{"unknown page", map[string]any{"page_id": uuid.NewString()}, http.StatusUnprocessableEntity},
+		{"missing page", map[string]any{}, http.StatusUnprocessableEntity},
+		{"anchor on another page", map[string]any{"page_id": saleId, "after": a}, http.StatusUnprocessableEntity},
+		{"anchor is the widget", map[string]any{"page_id": homeId, "before": a}, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectStatus(t, move(a, tt.body), tt.want)
+		})
+	}
+
+	res = do(t, h, http.MethodGet, "/stores/"+storeId+"/pages/"+saleId+"/versions", nil)
+	expectStatus(t, res, http.StatusOK)
+	if got := len(field(res.body, "versions").([]any)); got != 3 {
+		t.Fatalf("expected 3 versions of the target page, got %d", got)
+	}
+}
+
+func TestReorderWidgets(t *testing.T) {
+	h := newTestBackend(t)
+	storeId := createStore(t, h, "acme")
+	pageId := createPage(t, h, storeId, "/", true)
+	widgetsPath := "/stores/" + storeId + "/pages/" + pageId + "/widgets"
+
+	var ids []string
+	for _, content := range []string{"a", "b", "c", "d"} {
+		body := map[string]any{"type": "text", "config": map[string]any{"content": content}}
+		res := do(t, h, http.MethodPost, widgetsPath, body)
+		expectStatus(t, res, http.StatusCreated)
+		ids = append(ids, field(res.body, "widget", "id").(string))
+	}
+	reorder := func(body map[string]any) (testResponse, []string) {
+		res := do(t, h, http.MethodPost, widgetsPath+"/reorder", body)
+		var got []string
+		if widgets, ok := field(res.body, "widgets").([]any); ok {
+			for i, w := range widgets {
+				if pos := field(w, "position"); pos != float64(i) {
+					t.Fatalf("expected dense positions, widget %d is at %v", i, pos)
+				}
+				got = append(got, field(w, "config", "content").(string))
+			}
+		}
+		return res, got
+	}
+
+	tests := []struct {
+		name   string
+		body   map[string]any
+		status int
+		want   []string
+	}{
+		{"partial list in strict mode", map[string]any{"widget_ids": []string{ids[1], ids[0]}}, http.StatusBadRequest, nil},
+		{"duplicate ids", map[string]any{"widget_ids": []string{ids[0], ids[0], ids[1], ids[2]}}, http.StatusBadRequest, nil},
+		{"nothing to do", map[string]any{}, http.StatusBadRequest, nil},
+		{"partial", map[string]any{"widget_ids": []string{ids[3], ids[0]}, "partial": true}, http.StatusOK,
+			[]string{"d", "b", "c", "a"}},
+		{"move before", map[string]any{"operations": []map[string]any{{"widget_id": ids[0], "before": ids[1]}}}, http.StatusOK,
+			[]string{"d", "a", "b", "c"}},
+		{"move to index", map[string]any{"operations": []map[string]any{{"widget_id": ids[3], "position": 3}}}, http.StatusOK,
+			[]string{"a", "b", "c", "d"}},
+		{"bad operation", map[string]any{"operations": []map[string]any{{"widget_id": ids[3], "position": -1}}}, http.StatusUnprocessableEntity, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, got := reorder(tt.body)
+			expectStatus(t, res, tt.status)
+			if tt.want != nil && !slices.Equal(got, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestBatchWidgets(t *testing.T) {
+	h := newTestBackend(t)
+	storeId := createStore(t, h, "acme")
+	pageId := createPage(t, h, storeId, "/", true)
+	pagePath := "/stores/" + storeId + "/pages/" + pageId
+	text := func(content string) map[string]any { return map[string]any{"content": content} }
+
+	res := do(t, h, http.MethodPost, pagePath+"/widgets", map[string]any{"type": "text", "config": text("old")})
+	expectStatus(t, res, http.StatusCreated)
+	old := field(res.body, "widget", "id").(string)
+
+	batch := func(ops ...map[string]any) testResponse {
+		return do(t, h, http.MethodPost, pagePath+"/widgets/batch", map[string]any{"operations": ops})
+	}
+	contents := func(res testResponse) []string {
+		var got []string
+		for _, w := range field(res.body, "widgets").([]any) {
+			got = append(got, field(w, "config", "content").(string))
+		}
+		return got
+	}
+
+	res = batch(
+		map[string]any{"op": "create", "temp_id": "intro", "type": "text", "config": text("intro")},
+		map[string]any{"op": "create", "temp_id": "outro", "type": "text", "config": text("outro")},
+		map[string]any{"op": "create", "type": "text", "config": text("middle"), "after": "intro"},
+		map[string]any{"op": "update", "widget_id": "outro", "config": text("bye")},
+		map[string]any{"op": "move", "widget_id": "intro", "position": 0},
+		map[string]any{"op": "delete", "widget_id": old},
+	)
+	expectStatus(t, res, http.StatusOK)
+	if got, want := contents(res), []string{"intro", "middle", "bye"}; !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if field(res.body, "created", "intro") == nil || field(res.body, "created", "outro") == nil {
+		t.Fatalf("expected the temp ids to be mapped, got %v", field(res.body, "created"))
+	}
+
+	t.Run("static errors are all reported", func(t *testing.T) {
+		res := batch(
+			map[string]any{"op": "rename"},
+			map[string]any{"op": "create", "type": "text", "config": text("ok")},
+			map[string]any{"op": "delete"},
+		)
+		expectStatus(t, res, http.StatusUnprocessableEntity)
+		ops := field(res.body, "error", "operations").([]any)
+		if len(ops) != 2 || field(ops[0], "index") != float64(0) || field(ops[1], "index") != float64(2) {
+			t.Fatalf("expected operations 0 and 2 to be reported, got %v", ops)
+		}
+	})
+
+	t.Run("a failing operation rolls the batch back", func(t *testing.T) {
+		res := batch(
+			map[string]any{"op": "create", "type": "text", "config": text("lost")},
+			map[string]any{"op": "create", "type": "banner", "config": map[string]any{}},
+		)
+		expectStatus(t, res, http.StatusUnprocessableEntity)
+		ops := field(res.body, "error", "operations").([]any)
+		if len(ops) != 1 || field(ops[0], "index") != float64(1) {
+			t.Fatalf("expected operation 1 to be reported, got %v", ops)
+		}
+		res = do(t, h, http.MethodGet, pagePath, nil)
+		if got := len(field(res.body, "page", "widgets").([]any)); got != 3 {
+			t.Fatalf("expected 3 widgets after the rollback, got %d", got)
+		}
+	})
+
+	t.Run("stale version", func(t *testing.T) {
+		res := do(t, h, http.MethodGet, pagePath, nil)
+		id := field(field(res.body, "page", "widgets").([]any)[0], "id")
+		res = batch(map[string]any{"op": "delete", "widget_id": id, "version": 99})
+		expectStatus(t, res, http.StatusConflict)
+	})
+}
+
+func TestDuplicatePage(t *testing.T) {
+	h := newTestBackend(t)
+	storeId := createStore(t, h, "acme")
+	otherId := createStore(t, h, "other")
+	homeId := createPage(t, h, storeId, "/", true)
+	homePath := "/stores/" + storeId + "/pages/" + homeId
+
+	for _, content := range []string{"a", "b"} {
+		body := map[string]any{"type": "text", "config": map[string]any{"content": content}}
+		expectStatus(t, do(t, h, http.MethodPost, homePath+"/widgets", body), http.StatusCreated)
+	}
+
+	res := do(t, h, http.MethodPost, homePath+"/duplicate", map[string]any{"name": "Winter", "route": "/winter"})
+	expectStatus(t, res, http.StatusCreated)
+	if field(res.body, "page", "is_home") != false {
+		t.Fatal("expected the copy not to be the home page")
+	}
+	copyId := field(res.body, "page", "id").(string)
+	res = do(t, h, http.MethodGet, "/stores/"+storeId+"/pages/"+copyId, nil)
+	expectStatus(t, res, http.StatusOK)
+	original := do(t, h, http.MethodGet, homePath, nil)
+	widgets, originals := field(res.body, "page", "widgets").([]any), field(original.body, "page", "widgets").([]any)
+	if len(widgets) != 2 || len(originals) != 2 {
+		t.Fatalf("expected 2 widgets on both pages, got %d and %d", len(widgets), len(originals))
+	}
+	for i := range widgets {
+		if field(widgets[i], "id") == field(originals[i], "id") {
+			t.Errorf("widget %d: expected a new id", i)
+		}
+		if got, want := field(widgets[i], "config", "content"), field(originals[i], "config", "content"); got != want {
+			t.Errorf("widget %d: expected content %v, got %v", i, want, got)
+		}
+	}
+
+	res = do(t, h, http.MethodPost, homePath+"/duplicate", map[string]any{"name": "Again", "route": "/winter"})
+	expectStatus(t, res, http.StatusConflict)
+
+	res = do(t, h, http.MethodPost, homePath+"/duplicate", map[string]any{"name": "Home", "route": "/", "store_id": otherId})
+	expectStatus(t, res, http.StatusCreated)
+	if want := "/stores/" + otherId + "/pages/" + field(res.body, "page", "id").(string); res.header.Get("Location") != want {
+		t.Fatalf("expected Location %s, got %s", want, res.header.Get("Location"))
+	}
+	if field(res.body, "page", "store_id") != otherId {
+		t.Fatalf("expected the copy in store %s, got %v", otherId, field(res.body, "page", "store_id"))
+	}
+
+	res = do(t, h, http.MethodPost, "/stores/"+storeId+"/widget-types", map[string]any{
+		"name": "countdown", "label": "Countdown",
+		"schema": map[string]any{"fields": map[string]any{"ends_at": map[string]any{"type": "string"}}},
+	})
+	expectStatus(t, res, http.StatusCreated)
+	body := map[string]any{"type": "countdown", "config": map[string]any{"ends_at": "tomorrow"}}
+	expectStatus(t, do(t, h, http.MethodPost, homePath+"/widgets", body), http.StatusCreated)
+
+	res = do(t, h, http.MethodPost, homePath+"/duplicate", map[string]any{"name": "Sale", "route": "/sale", "store_id": otherId})
+	expectStatus(t, res, http.StatusUnprocessableEntity)
+
+	// A countdown of the other store needs a number where this one has text.
+	res = do(t, h, http.MethodPost, "/stores/"+otherId+"/widget-types", map[string]any{
+		"name": "countdown", "label": "Countdown",
+		"schema": map[string]any{"fields": map[string]any{"ends_at": map[string]any{"type": "number", "required": true}}},
+	})
+	expectStatus(t, res, http.StatusCreated)
+	res = do(t, h, http.MethodPost, homePath+"/duplicate", map[string]any{"name": "Sale", "route": "/sale", "store_id": otherId})
+	expectStatus(t, res, http.StatusUnprocessableEntity)
+	fields, _ := field(res.body, "error", "fields").(map[string]any)
+	if len(fields) == 0 {
+		t.Fatalf("expected the invalid copies to be reported, got %v", res.body)
+	}
+	res = do(t, h, http.MethodGet, "/stores/"+otherId+"/pages", nil)
+	expectStatus(t, res, http.StatusOK)
+	if pages := field(res.body, "pages").([]any); len(pages) != 1 {
+		t.Fatalf("expected the invalid copy to be rolled back, got %d pages", len(pages))
+	}
+}
+
+func TestCloneStore(t *testing.T) {
+	h := newTestBackend(t)
+
+	res := do(t, h, http.MethodPost, "/stores", map[string]any{"name": "Starter", "slug": "starter", "is_template": true})
+	expectStatus(t, res, http.StatusCreated)
+	starterId := field(res.body, "store", "id").(string)
+	createStore(t, h, "acme")
+
+	res = do(t, h, http.MethodPost, "/stores/"+starterId+"/widget-types", map[string]any{
+		"name": "countdown", "label": "Countdown",
+		"schema": map[string]any{"fields": map[string]any{"ends_at": map[string]any{"type": "string"}}},
+	})
+	expectStatus(t, res, http.StatusCreated)
+	homeId := createPage(t, h, starterId, "/", true)
+	createPage(t, h, starterId, "/about", false)
+	body := map[string]any{"type": "countdown", "config": map[string]any{"ends_at": "soon"}}
+	expectStatus(t, do(t, h, http.MethodPost, "/stores/"+starterId+"/pages/"+homeId+"/widgets", body), http.StatusCreated)
+
+	res = do(t, h, http.MethodGet, "/stores?template=true", nil)
+	expectStatus(t, res, http.StatusOK)
+	if stores := field(res.body, "stores").([]any); len(stores) != 1 || field(stores[0], "id") != starterId {
+		t.Fatalf("expected only the starter store, got %v", stores)
+	}
+	expectStatus(t, do(t, h, http.MethodGet, "/stores?template=maybe", nil), http.StatusUnprocessableEntity)
+
+	res = do(t, h, http.MethodPost, "/stores/"+starterId+"/clone", map[string]any{"name": "Bob's", "slug": "bobs"})
+	expectStatus(t, res, http.StatusCreated)
+	if field(res.body, "store", "is_template") != false {
+		t.Fatal("expected the clone not to be a template")
+	}
+	cloneId := field(res.body, "store", "id").(string)
+
+	res = do(t, h, http.MethodGet, "/stores/"+cloneId+"/pages", nil)
+	expectStatus(t, res, http.StatusOK)
+	pages := field(res.body, "pages").([]any)
+	if len(pages) != 2 {
+		t.Fatalf("expected 2 pages, got %d", len(pages))
+	}
+	var home any
+	for _, p := range pages {
+		if field(p, "id") == homeId {
+			t.Fatal("expected the pages to get new ids")
+		}
+		if field(p, "is_home") == true {
+			home = field(p, "id")
+		}
+	}
+	if home == nil {
+		t.Fatal("expected the clone to keep a home page")
+	}
+	res = do(t, h, http.MethodGet, "/stores/"+cloneId+"/pages/"+home.(string), nil)
+	expectStatus(t, res, http.StatusOK)
+	if widgets := field(res.body, "page", "widgets").([]any); len(widgets) != 1 || field(widgets[0], "type") != "countdown" {
+		t.Fatalf("expected the countdown widget to be cloned, got %v", widgets)
+	}
+
+	res = do(t, h, http.MethodPost, "/stores/"+starterId+"/clone", map[string]any{"name": "Dup", "slug": "acme"})
+	expectStatus(t, res, http.StatusConflict)
+	res = do(t, h, http.MethodPost, "/stores/"+uuid.NewString()+"/clone", map[string]any{"name": "Nope", "slug": "nope"})
+	expectStatus(t, res, http.StatusNotFound)
+}
+
+func TestExportImportStore(t *testing.T) {
+	h := newTestBackend(t)
+	storeId := createStore(t, h, "acme")
+	pageId := createPage(t, h, storeId, "/", true)
+	body := map[string]any{"type": "text", "config": map[string]any{"content": "hi"}}
+	expectStatus(t, do(t, h, http.MethodPost, "/stores/"+storeId+"/pages/"+pageId+"/widgets", body), http.StatusCreated)
+
+	res := do(t, h, http.MethodGet, "/stores/"+storeId+"/export", nil)
+	expectStatus(t, res, http.StatusOK)
+	if v := field(res.body, "schema_version"); v != float64(1) {
+		t.Fatalf("expected schema_version 1, got %v", v)
+	}
+	exported := res.body
+	exported["store"] = map[string]any{"slug": "acme-staging", "name": "Acme staging"}
+
+	res = do(t, h, http.MethodPost, "/stores/import?dry_run=true", exported)
+	expectStatus(t, res, http.StatusOK)
+	if got := field(res.body, "plan", "summary", "create"); got != float64(3) {
+		t.Fatalf("expected 3 creates, got %v", got)
+	}
+
+	res = do(t, h, http.MethodPost, "/stores/import", exported)
+	expectStatus(t, res, http.StatusOK)
+	newId := field(res.body, "store", "id").(string)
+
+	res = do(t, h, http.MethodGet, "/stores/"+newId+"/export", nil)
+	expectStatus(t, res, http.StatusOK)
+	if got := field(res.body, "pages").([]any); len(got) != 1 || len(field(got[0], "widgets").([]any)) != 1 {
+		t.Fatalf("expected the imported page and widget, got %v", got)
+	}
+
+	exported["schema_version"] = 2
+	expectStatus(t, do(t, h, http.MethodPost, "/stores/import", exported), http.StatusUnprocessableEntity)
+	expectStatus(t, do(t, h, http.MethodPost, "/stores/"+uuid.NewString(), exported), http.StatusNotFound)
+	expectStatus(t, do(t, h, http.MethodGet, "/stores/"+uuid.NewString()+"/export", nil), http.StatusNotFound)
+}
+
+func TestApplyStore(t *testing.T) {
+	h := newTestBackend(t)
+	storeId := createStore(t, h, "acme")
+	pageId := createPage(t, h, storeId, "/", true)
+	for _, content := range []string{"one", "two"} {
+		body := map[string]any{"type": "text", "config": map[string]any{"content": content}}
+		expectStatus(t, do(t, h, http.MethodPost, "/stores/"+storeId+"/pages/"+pageId+"/widgets", body), http.StatusCreated)
+	}
+	res := do(t, h, http.MethodGet, "/stores/"+storeId, nil)
+	expectStatus(t, res, http.StatusOK)
+	name := field(res.body, "store", "name").(string)
+
+	doc := []byte(`
+schema_version: 1
+store: {slug: acme, name: "` + name + `"}
+pages:
+  - route: /
+    name: Start
+    is_home: true
+    widgets:
+      - {type: text, config: {content: two}}
+      - {type: text, config: {content: one}}
+`)
+	yaml := []string{"Content-Type", "application/yaml"}
+
+	res = do(t, h, http.MethodPost, "/stores/"+storeId+"/apply?dry_run=true", doc, yaml...)
+	expectStatus(t, res, http.StatusOK)
+	changes := field(res.body, "plan", "changes").([]any)
+	if len(changes) != 2 || field(changes[0], "action") != "rename" || field(changes[1], "action") != "reorder" {
+		t.Fatalf("expected a rename and a reorder, got %v", changes)
+	}
+	hash := field(res.body, "plan_hash").(string)
+
+	res = do(t, h, http.MethodPost, "/stores/"+storeId+"/apply?plan_hash=stale", doc, yaml...)
+	expectStatus(t, res, http.StatusPreconditionFailed)
+
+	res = do(t, h, http.MethodPost, "/stores/"+storeId+"/apply?plan_hash="+hash, doc, yaml...)
+	expectStatus(t, res, http.StatusOK)
+
+	res = do(t, h, http.MethodGet, "/stores/"+storeId+"/export", nil)
+	expectStatus(t, res, http.StatusOK)
+	page := field(res.body, "pages").([]any)[0]
+	if got := field(field(page, "widgets").([]any)[0], "config", "content"); got != "two" {
+		t.Fatalf("expected the widgets to be reordered, got %v first", got)
+	}
+
+	res = do(t, h, http.MethodPost, "/stores/"+storeId+"/apply?dry_run=true", doc, yaml...)
+	expectStatus(t, res, http.StatusOK)
+	if got := field(res.body, "plan", "changes").([]any); len(got) != 0 {
+		t.Fatalf("expected no changes once applied, got %v", got)
+	}
+	expectStatus(t, do(t, h, http.MethodPost, "/stores/"+storeId+"/apply", []byte("pages: [\n"), yaml...), http.StatusBadRequest)
+	reserved := []byte(strings.Replace(string(doc), "route: /", "route: /public/start", 1))
+	res = do(t, h, http.MethodPost, "/stores/"+storeId+"/apply", reserved, yaml...)
+	expectStatus(t, res, http.StatusUnprocessableEntity)
+	if _, ok := field(res.body, "error", "fields").(map[string]any)["pages[0].route"]; !ok {
+		t.Fatalf("expected the reserved route to be rejected, got %v", res.body)
+	}
+	expectStatus(t, do(t, h, http.MethodPost, "/stores/"+uuid.NewString()+"/apply", doc, yaml...), http.StatusNotFound)
+}
+
+func TestCliApply(t *testing.T) {
+	ctx := context.Background()
+	models := data.NewMemoryModels()
+	if err := models.WidgetTypes.EnsureBuiltins(ctx); err != nil {
+		t.Fatal(err)
+	}
+	store := &data.Store{Id: uuid.New(), Name: "Acme", Slug: "acme"}
+	if err := models.Stores.Insert(ctx, store); err != nil {
+		t.Fatal(err)
+	}
+	bdl := &bundle.Bundle{
+		SchemaVersion: bundle.SchemaVersion,
+		Store:         bundle.Store{Slug: "acme", Name: "Acme"},
+		Pages:         []bundle.Page{{Route: "/", Name: "Home", IsHome: true}},
+	}
+	plan, err := bundle.ApplyToStore(ctx, models, store.Id, bdl, nil, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cliApply(ctx, models, store.Id, bdl, nil, plan.Hash()); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := models.Audit.GetForStore(ctx, store.Id, data.AuditFilter{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Action != "apply" || !strings.HasPrefix(entries[0].Actor, "cli") {
+		t.Fatalf("expected the apply to be audited with the cli as the actor, got %+v", entries)
+	}
+}
+
+func TestTrashRestore(t *testing.T) {
+	h := newTestBackend(t)
+	storeId := createStore(t, h, "acme")
+	createPage(t, h, storeId, "/", true)
+	pageId := createPage(t, h, storeId, "/sale", false)
+	storePath := "/stores/" + storeId
+
+	res := do(t, h, http.MethodPost, storePath+"/pages/"+pageId+"/widgets",
+		map[string]any{"type": "text", "config": map[string]any{"content": "hello"}})
+	expectStatus(t, res, http.StatusCreated)
+	widgetId := field(res.body, "widget", "id").(string)
+
+	res = do(t, h, http.MethodDelete, storePath+"/widgets/"+widgetId, nil)
+	expectStatus(t, res, http.StatusOK)
+	res = do(t, h, http.MethodGet, storePath+"/trash", nil)
+	expectStatus(t, res, http.StatusOK)
+	if widgets := field(res.body, "trash", "widgets").([]any); len(widgets) != 1 {
+		t.Fatalf("expected the widget in the trash, got %v", res.body)
+	}
+	res = do(t, h, http.MethodPost, storePath+"/widgets/"+widgetId+"/restore", nil)
+	expectStatus(t, res, http.StatusOK)
+
+	res = do(t, h, http.MethodDelete, storePath+"/pages/"+pageId, nil)
+	expectStatus(t, res, http.StatusOK)
+	res = do(t, h, http.MethodGet, storePath+"/pages/"+pageId, nil)
+	expectStatus(t, res, http.StatusNotFound)
+
+	createPage(t, h, storeId, "/sale", false)
+	res = do(t, h, http.MethodPost, storePath+"/pages/"+pageId+"/restore", nil)
+	expectStatus(t, res, http.StatusConflict)
+	res = do(t, h, http.MethodPost, storePath+"/pages/"+pageId+"/restore", map[string]any{"route": "/old-sale"})
+	expectStatus(t, res, http.StatusOK)
+	if route := field(res.body, "page", "route"); route != "/old-sale" {
+		t.Fatalf("expected the page restored under /old-sale, got %v", route)
+	}
+	if widgets := field(res.body, "page", "widgets").([]any); len(widgets) != 1 {
+		t.Fatalf("expected the page restored with its widget, got %v", widgets)
+	}
+	res = do(t, h, http.MethodGet, storePath+"/pages/"+pageId+"/versions", nil)
+	expectStatus(t, res, http.StatusOK)
+
+	res = do(t, h, http.MethodDelete, storePath, nil)
+	expectStatus(t, res, http.StatusOK)
+	res = do(t, h, http.MethodGet, storePath+"/trash", nil)
+	expectStatus(t, res, http.StatusNotFound)
+	res = do(t, h, http.MethodGet, "/stores?deleted=true", nil)
+	expectStatus(t, res, http.StatusOK)
+	if stores := field(res.body, "stores").([]any); len(stores) != 1 {
+		t.Fatalf("expected the store in the trash, got %v", stores)
+	}
+
+	createStore(t, h, "acme")
+	res = do(t, h, http.MethodPost, storePath+"/restore", nil)
+	expectStatus(t, res, http.StatusConflict)
+	res = do(t, h, http.MethodPost, storePath+"/restore", map[string]any{"slug": "acme-old"})
+	expectStatus(t, res, http.StatusOK)
+	res = do(t, h, http.MethodGet, storePath+"/pages", nil)
+	expectStatus(t, res, http.StatusOK)
+	if pages := field(res.body, "pages").([]any); len(pages) != 3 {
+		t.Fatalf("expected the store restored with its 3 pages, got %d", len(pages))
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	h := newTestBackend(t)
+	storeId := createStore(t, h, "acme")
+	pageId := createPage(t, h, storeId, "/", true)
+	storePath := "/stores/" + storeId
+
+	alice, alicePrefix := createApiKey(t, h, "alice", storeId)
+	bob, bobPrefix := createApiKey(t, h, "bob", storeId)
+
+	res := do(t, h, http.MethodPost, storePath+"/pages/"+pageId+"/widgets",
+		map[string]any{"type": "banner", "config": map[string]any{"image_url": "https://example.com/sale.png"}},
+		"Authorization", "Bearer "+alice)
+	expectStatus(t, res, http.StatusCreated)
+	widgetId := field(res.body, "widget", "id").(string)
+
+	res = do(t, h, http.MethodDelete, storePath+"/widgets/"+widgetId, nil,
+		"Authorization", "Bearer "+bob, "X-Request-Id", "req-42")
+	expectStatus(t, res, http.StatusOK)
+	if got := res.header.Get("X-Request-Id"); got != "req-42" {
+		t.Fatalf("expected the request id to be echoed, got %q", got)
+	}
+
+	// A failed change leaves no entry behind.
+	res = do(t, h, http.MethodPost, "/stores", map[string]any{"name": "Acme again", "slug": "acme"})
+	expectStatus(t, res, http.StatusConflict)
+
+	res = do(t, h, http.MethodGet, storePath+"/audit?entity="+widgetId, nil)
+	expectStatus(t, res, http.StatusOK)
+	entries := field(res.body, "entries").([]any)
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries for the widget, got %v", entries)
+	}
+	deleted := entries[0]
+	if field(deleted, "action") != "delete" || field(deleted, "request_id") != "req-42" {
+		t.Fatalf("expected bob's delete first, got %v", deleted)
+	}
+	if field(deleted, "before", "type") != "banner" || field(deleted, "after") != nil {
+		t.Fatalf("expected the deleted banner as the before state, got %v", deleted)
+	}
+
+	res = do(t, h, http.MethodGet, storePath+"/audit?actor=api_key:"+alicePrefix, nil)
+	expectStatus(t, res, http.StatusOK)
+	if entries := field(res.body, "entries").([]any); len(entries) != 1 || field(entries[0], "action") != "create" {
+		t.Fatalf("expected alice's create only, got %v", entries)
+	}
+
+	// store create, page create, widget create and delete, one at a time
+	var actions []any
+	path := storePath + "/audit?limit=1"
+	for range 5 {
+		res = do(t, h, http.MethodGet, path, nil)
+		expectStatus(t, res, http.StatusOK)
+		entries := field(res.body, "entries").([]any)
+		for _, entry := range entries {
+			actions = append(actions, field(entry, "entity_type").(string)+":"+field(entry, "action").(string))
+		}
+		cursor, ok := field(res.body, "next_cursor").(string)
+		if !ok {
+			break
+		}
+		path = storePath + "/audit?limit=1&cursor=" + cursor
+	}
+	want := []any{"widget:delete", "widget:create", "page:create", "store:create"}
+	if !reflect.DeepEqual(actions, want) {
+		t.Fatalf("expected %v, got %v", want, actions)
+	}
+
+	res = do(t, h, http.MethodGet, storePath+"/audit?entity=banner&since=yesterday", nil)
+	expectStatus(t, res, http.StatusUnprocessableEntity)
+	if field(res.body, "error", "fields", "entity") == nil || field(res.body, "error", "fields", "since") == nil {
+		t.Fatalf("expected entity and since to be rejected, got %v", res.body)
+	}
+
+	// The trail can still be read, down to who deleted the store, once it is
+	// gone.
+	expectStatus(t, do(t, h, http.MethodDelete, storePath, nil, "Authorization", "Bearer "+bob), http.StatusOK)
+	res = do(t, h, http.MethodGet, storePath+"/audit?entity=store", nil, "Authorization", "Bearer "+alice)
+	expectStatus(t, res, http.StatusOK)
+	entries = field(res.body, "entries").([]any)
+	if len(entries) != 2 || field(entries[0], "action") != "delete" || field(entries[0], "actor") != "api_key:"+bobPrefix {
+		t.Fatalf("expected bob's delete of the store first, got %v", entries)
+	}
+}
+
+func TestApiKeys(t *testing.T) {
+	h := newTestBackend(t)
+	acme := createStore(t, h, "acme")
+	other := createStore(t, h, "other")
+
+	anonymous := newTestApp(t).routes()
+	res := do(t, anonymous, http.MethodGet, "/stores", nil)
+	expectStatus(t, res, http.StatusUnauthorized)
+	res = do(t, anonymous, http.MethodGet, "/public/acme/manifest", nil)
+	if res.status == http.StatusUnauthorized {
+		t.Fatal("expected the public routes to stay open")
+	}
+	res = do(t, h, http.MethodGet, "/stores", nil, "Authorization", "Bearer adk_nope_nope")
+	expectStatus(t, res, http.StatusUnauthorized)
+
+	key, _ := createApiKey(t, h, "acme only", acme)
+	auth := []string{"Authorization", "Bearer " + key}
+
+	res = do(t, h, http.MethodGet, "/stores/"+acme, nil, auth...)
+	expectStatus(t, res, http.StatusOK)
+	res = do(t, h, http.MethodGet, "/stores/"+other, nil, auth...)
+	expectStatus(t, res, http.StatusForbidden)
+	res = do(t, h, http.MethodGet, "/stores", nil, auth...)
+	expectStatus(t, res, http.StatusOK)
+	if stores := field(res.body, "stores").([]any); len(stores) != 1 || field(stores[0], "id") != acme {
+		t.Fatalf("expected to see acme only, got %v", stores)
+	}
+	res = do(t, h, http.MethodPost, "/stores", map[string]any{"name": "New", "slug": "new"}, auth...)
+	expectStatus(t, res, http.StatusForbidden)
+	res = do(t, h, http.MethodGet, "/api-keys", nil, auth...)
+	expectStatus(t, res, http.StatusForbidden)
+
+	res = do(t, h, http.MethodGet, "/api-keys", nil)
+	expectStatus(t, res, http.StatusOK)
+	keys := field(res.body, "api_keys").([]any)
+	if len(keys) != 2 || field(keys[0], "key") != nil {
+		t.Fatalf("expected 2 keys without their secrets, got %v", keys)
+	}
+	res = do(t, h, http.MethodDelete, "/api-keys/"+field(keys[0], "id").(string), nil)
+	expectStatus(t, res, http.StatusOK)
+	res = do(t, h, http.MethodGet, "/stores/"+acme, nil, auth...)
+	expectStatus(t, res, http.StatusUnauthorized)
+
+	res = do(t, h, http.MethodPost, "/api-keys", map[string]any{"name": "brief", "expires_in": "-1h"})
+	expectStatus(t, res, http.StatusUnprocessableEntity)
+}
+
+func TestUserSessions(t *testing.T) {
+	h := newTestBackend(t)
+	auth, _ := login(t, h, "ada@example.com")
+
+	res := do(t, h, http.MethodPost, "/users", map[string]any{"name": "Ada", "email": "ADA@example.com ", "password": "another one"})
+	expectStatus(t, res, http.StatusUnprocessableEntity)
+	res = do(t, h, http.MethodPost, "/users", map[string]any{"name": "Bob", "email": "bob", "password": "short"})
+	expectStatus(t, res, http.StatusUnprocessableEntity)
+	if errs := field(res.body, "error", "fields").(map[string]any); len(errs) != 2 {
+		t.Fatalf("expected the email and password to be rejected, got %v", errs)
+	}
+	res = do(t, h, http.MethodPost, "/sessions", map[string]any{"email": "ada@example.com", "password": "wrong horse"})
+	expectStatus(t, res, http.StatusUnauthorized)
+
+	res = do(t, h, http.MethodGet, "/stores", nil, auth...)
+	expectStatus(t, res, http.StatusOK)
+	if stores, _ := field(res.body, "stores").([]any); len(stores) != 0 {
+		t.Fatalf("expected a new user to see no store, got %v", stores)
+	}
+	res = do(t, h, http.MethodDelete, "/sessions", nil, auth...)
+	expectStatus(t, res, http.StatusOK)
+	res = do(t, h, http.MethodGet, "/stores", nil, auth...)
+	expectStatus(t, res, http.StatusUnauthorized)
+}
+
+func TestStoreMembers(t *testing.T) {
+	h := newTestBackend(t)
+	owner, ownerId := login(t, h, "owner@example.com")
+	editor, editorId := login(t, h, "editor@example.com")
+	viewer, _ := login(t, h, "viewer@example.com")
+	outsider, _ := login(t, h, "outsider@example.com")
+
+	res := do(t, h, http.MethodPost, "/stores", map[string]any{"name": "Acme", "slug": "acme"}, owner...)
+	expectStatus(t, res, http.StatusCreated)
+	acme := field(res.body, "store", "id").(string)
+	page := createPage(t, h, acme, "/", true)
+
+	for email, role := range map[string]string{"editor@example.com": "editor", "viewer@example.com": "viewer"} {
+		res = do(t, h, http.MethodPost, "/stores/"+acme+"/members", map[string]any{"email": email, "role": role}, owner...)
+		expectStatus(t, res, http.StatusCreated)
+	}
+	res = do(t, h, http.MethodPost, "/stores/"+acme+"/members", map[string]any{"email": "nobody@example.com", "role": "viewer"}, owner...)
+	expectStatus(t, res, http.StatusUnprocessableEntity)
+	res = do(t, h, http.MethodGet, "/stores/"+acme+"/members", nil, viewer...)
+	expectStatus(t, res, http.StatusOK)
+	if members := field(res.body, "members").([]any); len(members) != 3 || field(members[1], "role") != "owner" {
+		t.Fatalf("expected the editor, owner and viewer, by email, got %v", members)
+	}
+
+	res = do(t, h, http.MethodPost, "/stores/"+acme+"/pages/"+page+"/widgets",
+		map[string]any{"type": "text", "config": map[string]any{"content": "hi"}}, editor...)
+	expectStatus(t, res, http.StatusCreated)
+	reorder := map[string]any{"widget_ids": []any{field(res.body, "widget", "id")}}
+	res = do(t, h, http.MethodGet, "/stores/"+acme+"/pages/"+page, nil, viewer...)
+	expectStatus(t, res, http.StatusOK)
+	res = do(t, h, http.MethodPost, "/stores/"+acme+"/pages/"+page+"/widgets/reorder", reorder, viewer...)
+	expectStatus(t, res, http.StatusForbidden)
+	res = do(t, h, http.MethodPost, "/stores/"+acme+"/pages/"+page+"/widgets/reorder", reorder, editor...)
+	expectStatus(t, res, http.StatusOK)
+	res = do(t, h, http.MethodGet, "/stores/"+acme, nil, outsider...)
+	expectStatus(t, res, http.StatusForbidden)
+
+	res = do(t, h, http.MethodPut, "/stores/"+acme+"/members/"+editorId, map[string]any{"role": "owner"}, editor...)
+	expectStatus(t, res, http.StatusForbidden)
+	res = do(t, h, http.MethodDelete, "/stores/"+acme, nil, editor...)
+	expectStatus(t, res, http.StatusForbidden)
+	res = do(t, h, http.MethodPut, "/stores/"+acme+"/members/"+ownerId, map[string]any{"role": "editor"}, owner...)
+	expectStatus(t, res, http.StatusConflict)
+	res = do(t, h, http.MethodPut, "/stores/"+acme+"/members/"+editorId, map[string]any{"role": "owner"}, owner...)
+	expectStatus(t, res, http.StatusOK)
+	res = do(t, h, http.MethodDelete, "/stores/"+acme+"/members/"+ownerId, nil, editor...)
+	expectStatus(t, res, http.StatusOK)
+
+	res = do(t, h, http.MethodGet, "/stores/"+acme, nil, owner...)
+	expectStatus(t, res, http.StatusForbidden)
+	res = do(t, h, http.MethodGet, "/stores/"+acme+"/audit?entity=member", nil)
+	expectStatus(t, res, http.StatusOK)
+	if entries := field(res.body, "entries").([]any); len(entries) != 5 || field(entries[0], "actor") != "user:editor@example.com" {
+		t.Fatalf("expected 5 member entries, the last by the editor, got %v", entries)
+	}
+	res = do(t, h, http.MethodDelete, "/stores/"+acme, nil, editor...)
+	expectStatus(t, res, http.StatusOK)
+}
+
+// testMailer keeps the messages it is asked to send.
+type testMailer struct {
+	mu   sync.Mutex
+	sent []mailer.Message
+}
+
+func (m *testMailer) Send(_ context.Context, msg mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func TestInvitations(t *testing.T) {
+	b := newTestApp(t)
+	mail := &testMailer{}
+	b.mailer = mail
+	h := withTestKey(t, b)
+
+	owner, _ := login(t, h, "owner@example.com")
+	invitee, _ := login(t, h, "invitee@example.com")
+	other, _ := login(t, h, "other@example.com")
+
+	res := do(t, h, http.MethodPost, "/stores", map[string]any{"name": "Acme", "slug": "acme"}, owner...)
+	expectStatus(t, res, http.StatusCreated)
+	acme := field(res.body, "store", "id").(string)
+	invitations := "/stores/" + acme + "/invitations"
+
+	res = do(t, h, http.MethodPost, invitations, map[string]any{"email": "owner@example.com", "role": "editor"}, owner...)
+	expectStatus(t, res, http.StatusConflict)
+	res = do(t, h, http.MethodPost, invitations, map[string]any{"email": "nope", "role": "admin"}, owner...)
+	expectStatus(t, res, http.StatusUnprocessableEntity)
+
+	invite := func(email string) string {
+		t.Helper()
+		res := do(t, h, http.MethodPost, invitations, map[string]any{"email": email, "role": "editor"}, owner...)
+		expectStatus(t, res, http.StatusCreated)
+		if _, ok := field(res.body, "invitation").(map[string]any)["token"]; ok {
+			t.Fatal("expected the token to be left out of the response")
+		}
+		b.wg.Wait()
+		mail.mu.Lock()
+		defer mail.mu.Unlock()
+		msg := mail.sent[len(mail.sent)-1]
+		token := regexp.MustCompile(`adi_[a-z0-9]+`).FindString(msg.Body)
+		if msg.To != normalizeEmail(email) || token == "" {
+			t.Fatalf("expected a token mailed to %s, got %+v", email, msg)
+		}
+		return token
+	}
+	token := invite("Invitee@Example.com")
+
+	res = do(t, h, http.MethodPost, "/invitations/"+token+"/accept", nil, other...)
+	expectStatus(t, res, http.StatusForbidden)
+	res = do(t, h, http.MethodPost, "/invitations/"+token+"/accept", nil, invitee...)
+	expectStatus(t, res, http.StatusCreated)
+	if role := field(res.body, "member", "role"); role != "editor" {
+		t.Fatalf("expected to join as editor, got %v", role)
+	}
+	res = do(t, h, http.MethodPost, "/invitations/"+token+"/accept", nil, invitee...)
+	expectStatus(t, res, http.StatusNotFound)
+	res = do(t, h, http.MethodGet, "/stores/"+acme+"/pages", nil, invitee...)
+	expectStatus(t, res, http.StatusOK)
+	res = do(t, h, http.MethodGet, invitations, nil, invitee...)
+	expectStatus(t, res, http.StatusForbidden)
+
+	token = invite("other@example.com")
+	res = do(t, h, http.MethodGet, invitations, nil, owner...)
+	expectStatus(t, res, http.StatusOK)
+	list := field(res.body, "invitations").([]any)
+	if len(list) != 2 || field(list[1], "accepted_at") == nil || field(list[0], "accepted_at") != nil {
+		t.Fatalf("expected a pending and an accepted invitation, newest first, got %v", list)
+	}
+	res = do(t, h, http.MethodDelete, invitations+"/"+field(list[0], "id").(string), nil, owner...)
+	expectStatus(t, res, http.StatusOK)
+	res = do(t, h, http.MethodDelete, invitations+"/"+field(list[1], "id").(string), nil, owner...)
+	expectStatus(t, res, http.StatusNotFound)
+	res = do(t, h, http.MethodPost, "/invitations/"+token+"/accept", nil, other...)
+	expectStatus(t, res, http.StatusNotFound)
+}
+
+func TestOAuthTokens(t *testing.T) {
+	h := newTestBackend(t)
+	storeId := createStore(t, h, "acme")
+	otherId := createStore(t, h, "other")
+
+	res := do(t, h, http.MethodPost, "/oauth/clients", map[string]any{"name": "ci", "scopes": []string{"stores:admin"}})
+	expectStatus(t, res, http.StatusUnprocessableEntity)
+
+	res = do(t, h, http.MethodPost, "/oauth/clients", map[string]any{
+		"name":      "ci",
+		"scopes":    []string{oauth.ScopeStoresRead, oauth.ScopePagesWrite},
+		"store_ids": []string{storeId},
+	})
+	expectStatus(t, res, http.StatusCreated)
+	id := field(res.body, "oauth_client", "id").(string)
+	clientId := field(res.body, "oauth_client", "client_id").(string)
+	secret := field(res.body, "oauth_client", "client_secret").(string)
+
+	form := "application/x-www-form-urlencoded"
+	token := func(body string, headers ...string) testResponse {
+		return do(t, h, http.MethodPost, "/oauth/token", []byte(body), append([]string{"Content-Type", form}, headers...)...)
+	}
+	res = token("grant_type=client_credentials&client_id=" + clientId + "&client_secret=wrong")
+	expectStatus(t, res, http.StatusUnauthorized)
+	if code := field(res.body, "error"); code != "invalid_client" {
+		t.Fatalf("expected invalid_client, got %v", code)
+	}
+	res = token("grant_type=password&client_id=" + clientId + "&client_secret=" + secret)
+	expectStatus(t, res, http.StatusBadRequest)
+	if code := field(res.body, "error"); code != "unsupported_grant_type" {
+		t.Fatalf("expected unsupported_grant_type, got %v", code)
+	}
+	res = token("grant_type=client_credentials&scope=widgets:write&client_id=" + clientId + "&client_secret=" + secret)
+	expectStatus(t, res, http.StatusBadRequest)
+	if code := field(res.body, "error"); code != "invalid_scope" {
+		t.Fatalf("expected invalid_scope, got %v", code)
+	}
+
+	basic := base64.StdEncoding.EncodeToString([]byte(clientId + ":" + secret))
+	res = token("grant_type=client_credentials", "Authorization", "Basic "+basic)
+	expectStatus(t, res, http.StatusOK)
+	if scope := field(res.body, "scope"); scope != "pages:write stores:read" {
+		t.Fatalf("expected every scope of the client, got %v", scope)
+	}
+	if cache := res.header.Get("Cache-Control"); cache != "no-store" {
+		t.Fatalf("expected the token not to be cached, got %q", cache)
+	}
+	auth := []string{"Authorization", "Bearer " + field(res.body, "access_token").(string)}
+
+	res = do(t, h, http.MethodGet, "/stores/"+storeId, nil, auth...)
+	expectStatus(t, res, http.StatusOK)
+	res = do(t, h, http.MethodPost, "/stores/"+storeId+"/pages", map[string]any{"name": "Home", "route": "/", "is_home": true}, auth...)
+	expectStatus(t, res, http.StatusCreated)
+	pageId := field(res.body, "page", "id").(string)
+
+	// The token lacks the widget and store write scopes.
+	res = do(t, h, http.MethodPost, "/stores/"+storeId+"/pages/"+pageId+"/widgets", map[string]any{"type": "text"}, auth...)
+	expectStatus(t, res, http.StatusForbidden)
+	if code := field(res.body, "error", "code"); code != "INSUFFICIENT_SCOPE" {
+		t.Fatalf("expected INSUFFICIENT_SCOPE, got %v", code)
+	}
+	res = do(t, h, http.MethodPut, "/stores/"+storeId, map[string]any{"name": "Renamed"}, auth...)
+	expectStatus(t, res, http.StatusForbidden)
+	// Nor can it reach another store, or the routes of keys.
+	res = do(t, h, http.MethodGet, "/stores/"+otherId, nil, auth...)
+	expectStatus(t, res, http.StatusForbidden)
+	res = do(t, h, http.MethodGet, "/api-keys", nil, auth...)
+	expectStatus(t, res, http.StatusForbidden)
+
+	// Applying a bundle needs the page and widget write scopes too.
+	res = do(t, h, http.MethodPost, "/oauth/clients", map[string]any{"name": "deploy", "scopes": []string{oauth.ScopeStoresWrite}})
+	expectStatus(t, res, http.StatusCreated)
+	deployId := field(res.body, "oauth_client", "client_id").(string)
+	res = token("grant_type=client_credentials&client_id=" + deployId + "&client_secret=" + field(res.body, "oauth_client", "client_secret").(string))
+	expectStatus(t, res, http.StatusOK)
+	deploy := []string{"Authorization", "Bearer " + field(res.body, "access_token").(string)}
+	res = do(t, h, http.MethodPost, "/stores/"+storeId+"/apply", map[string]any{}, deploy...)
+	expectStatus(t, res, http.StatusForbidden)
+	if challenge := res.header.Get("WWW-Authenticate"); !strings.Contains(challenge, `scope="stores:write pages:write widgets:write"`) {
+		t.Fatalf("expected the challenge to name every scope apply needs, got %q", challenge)
+	}
+
+	res = do(t, h, http.MethodGet, "/stores", nil, auth...)
+	expectStatus(t, res, http.StatusOK)
+	if stores := res.body["stores"].([]any); len(stores) != 1 {
+		t.Fatalf("expected only the store of the client, got %d", len(stores))
+	}
+	res = do(t, h, http.MethodGet, "/stores/"+storeId+"/audit", nil, auth...)
+	expectStatus(t, res, http.StatusOK)
+	if actor := field(res.body["entries"].([]any)[0], "actor"); actor != "client:"+clientId {
+		t.Fatalf("expected the client as the actor, got %v", actor)
+	}
+
+	res = do(t, h, http.MethodGet, "/.well-known/jwks.json", nil)
+	expectStatus(t, res, http.StatusOK)
+	if keys := res.body["keys"].([]any); len(keys) != 1 || field(keys[0], "crv") != "Ed25519" {
+		t.Fatalf("expected the public signing key, got %v", keys)
+	}
+
+	res = do(t, h, http.MethodDelete, "/oauth/clients/"+id, nil)
+	expectStatus(t, res, http.StatusOK)
+	res = token("grant_type=client_credentials", "Authorization", "Basic "+basic)
+	expectStatus(t, res, http.StatusUnauthorized)
+	// Tokens already issued last until they expire.
+	res = do(t, h, http.MethodGet, "/stores/"+storeId, nil, auth...)
+	expectStatus(t, res, http.StatusOK)
+
+	res = do(t, h, http.MethodGet, "/stores/"+storeId, nil, "Authorization", "Bearer a.b.c")
+	expectStatus(t, res, http.StatusUnauthorized)
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"appdrop/internal/validator"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
@@ -25,6 +29,83 @@ func (b *backend) readIdParam(r *http.Request, name string) (uuid.UUID, error) {
 	return uid, nil
 }
 
+// readIntParam reads and parses a positive integer from URL parameters
+func (b *backend) readIntParam(r *http.Request, name string) (int, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	n, err := strconv.Atoi(params.ByName(name))
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid %s parameter", name)
+	}
+	return n, nil
+}
+
+// readInt returns the integer query string value for key, or defaultValue if
+// the key is absent. Values that do not parse are recorded in v.
+func (b *backend) readInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		v.AddError(key, "must be an integer value")
+		return defaultValue
+	}
+	return n
+}
+
+// readBool returns the boolean query parameter key, or nil if it is missing.
+func (b *backend) readBool(qs url.Values, key string, v *validator.Validator) *bool {
+	s := qs.Get(key)
+	if s == "" {
+		return nil
+	}
+	ok, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return nil
+	}
+	return &ok
+}
+
+// readTime returns the RFC 3339 time query parameter key, or nil if it is
+// missing. Values that do not parse are recorded in v.
+func (b *backend) readTime(qs url.Values, key string, v *validator.Validator) *time.Time {
+	s := qs.Get(key)
+	if s == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 time, e.g. 2006-01-02T15:04:05Z")
+		return nil
+	}
+	return &t
+}
+
+// versionETag returns the ETag of a resource at the given version
+func versionETag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+// ifMatch reports whether the If-Match header of the request allows writing
+// to a resource at the given version. A request without the header always
+// matches. Weak tags never do, as If-Match uses strong comparison.
+func ifMatch(r *http.Request, version int) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+	etag := versionETag(version)
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
 // writeJson writes JSON response with headers
 func (b *backend) writeJson(w http.ResponseWriter, status int, data any, headers http.Header) error {
 	jsonB, err := json.MarshalIndent(data, "", "\t")
@@ -19,7 +19,7 @@ func TestBackend_readIdParam(t *testing.T) {
 		req := httptestRequestWithParams(t, httprouter.Params{
 			{Key: "id", Value: want.String()}, // arbitrary bytes are allowed in a Go string
 		})
-		got, err := b.readIdParam(req)
+		got, err := b.readIdParam(req, "id")
 		if err != nil {
 			t.Fatalf("expected no error, got %v", err)
 		}
@@ -31,7 +31,7 @@ func TestBackend_readIdParam(t *testing.T) {
 		req := httptestRequestWithParams(t, httprouter.Params{
 			{Key: "id", Value: "not-16-bytes"},
 		})
-		got, err := b.readIdParam(req)
+		got, err := b.readIdParam(req, "id")
 		if err == nil {
 			t.Fatalf("expected error, got nil (uuid=%v)", got)
 		}
@@ -43,7 +43,7 @@ func TestBackend_readIdParam(t *testing.T) {
 		req := httptestRequestWithParams(t, httprouter.Params{
 			{Key: "id", Value: string(make([]byte, 16))}, // 16 zero bytes => uuid.Nil
 		})
-		got, err := b.readIdParam(req)
+		got, err := b.readIdParam(req, "id")
 		if err == nil {
 			t.Fatalf("expected error, got nil (uuid=%v)", got)
 		}
@@ -53,6 +53,33 @@ func TestBackend_readIdParam(t *testing.T) {
 	})
 }
 
+func TestIfMatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   bool
+	}{
+		{"no header", "", true},
+		{"any", "*", true},
+		{"current version", `"3"`, true},
+		{"list containing current version", `"2", "3"`, true},
+		{"stale version", `"2"`, false},
+		{"weak tag", `W/"3"`, false},
+		{"unquoted", "3", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, "https://example.com", nil)
+			if tt.header != "" {
+				req.Header.Set("If-Match", tt.header)
+			}
+			if got := ifMatch(req, 3); got != tt.want {
+				t.Fatalf("ifMatch(%q, 3) = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
 func httptestRequestWithParams(t *testing.T, ps httprouter.Params) *http.Request {
 	t.Helper()
 
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"appdrop/internal/data"
+	"appdrop/internal/mailer"
+	"appdrop/internal/validator"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// errWrongInvitee is returned when a user accepts an invitation made out to
+// another email.
+var errWrongInvitee = errors.New("invitation is for another email")
+
+// listInvitationsHandler handles GET /stores/:store_id/invitations
+func (b *backend) listInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	storeId, err := b.readIdParam(r, "store_id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	if _, err = b.models.Stores.Get(r.Context(), storeId); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	invitations, err := b.models.Invitations.GetAllForStore(r.Context(), storeId)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+		return
+	}
+	if err = b.writeJson(w, http.StatusOK, envelope{"invitations": invitations}, nil); err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// createInvitationHandler handles POST /stores/:store_id/invitations
+//
+// The token of the invitation is only ever sent to its email, which the user
+// who accepts it must be logged in with.
+func (b *backend) createInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	storeId, err := b.readIdParam(r, "store_id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	var input struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+	if err = b.readJson(w, r, &input); err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	input.Email = normalizeEmail(input.Email)
+
+	v := validator.New()
+	v.Check(validator.Matches(input.Email, validator.EmailRX), "email", "must be a valid email address")
+	v.Check(validator.PermittedValue(input.Role, data.Roles...), "role", "must be one of owner, editor or viewer")
+
+	if !v.Valid() {
+		b.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	store, err := b.models.Stores.Get(r.Context(), storeId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if user, err := b.models.Users.GetByEmail(r.Context(), input.Email); err == nil {
+		if _, err = b.models.Members.Get(r.Context(), storeId, user.Id); err == nil {
+			b.conflictResponse(w, r, data.ErrDuplicateMember.Error())
+			return
+		} else if !errors.Is(err, data.ErrRecordNotFound) {
+			b.serverErrorResponse(w, r, err)
+			return
+		}
+	} else if !errors.Is(err, data.ErrRecordNotFound) {
+		b.serverErrorResponse(w, r, err)
+		return
+	}
+	inv, err := data.GenerateInvitation(storeId, input.Email, input.Role, b.actor(r), b.conf.invitations.ttl)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+		return
+	}
+	err = b.models.InTx(r.Context(), func(m data.Models) error {
+		if err := m.Invitations.Insert(r.Context(), inv); err != nil {
+			return err
+		}
+		return b.audit(r, m, storeId, data.AuditInvitation, inv.Id, "create", nil, inv)
+	})
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+		return
+	}
+	msg := invitationMessage(store, inv)
+	b.background(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if err := b.mailer.Send(ctx, msg); err != nil {
+			b.logger.Error("sending invitation", "invitation_id", inv.Id, "err", err)
+		}
+	})
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/stores/%s/invitations/%s", storeId, inv.Id))
+
+	if err = b.writeJson(w, http.StatusCreated, envelope{"invitation": inv}, headers); err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// revokeInvitationHandler handles DELETE /stores/:store_id/invitations/:invitation_id
+//
+// Only pending invitations can be revoked.
+func (b *backend) revokeInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	storeId, err := b.readIdParam(r, "store_id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	id, err := b.readIdParam(r, "invitation_id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	err = b.models.InTx(r.Context(), func(m data.Models) error {
+		if err := m.Invitations.Revoke(r.Context(), storeId, id); err != nil {
+			return err
+		}
+		return b.audit(r, m, storeId, data.AuditInvitation, id, "revoke", nil, nil)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = b.writeJson(w, http.StatusOK, envelope{"message": "invitation successfully revoked"}, nil)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// acceptInvitationHandler handles POST /invitations/:token/accept
+//
+// The user the request is made by becomes a member of the store with the role
+// of the invitation, which cannot be accepted again.
+func (b *backend) acceptInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	user := b.contextGetUser(r)
+	if user == nil {
+		b.notPermittedResponse(w, r)
+		return
+	}
+	token := httprouter.ParamsFromContext(r.Context()).ByName("token")
+	var member *data.Member
+
+	err := b.models.InTx(r.Context(), func(m data.Models) error {
+		inv, err := m.Invitations.GetPending(r.Context(), token)
+		if err != nil {
+			return err
+		}
+		if inv.Email != user.Email {
+			return errWrongInvitee
+		}
+		if _, err = m.Stores.Get(r.Context(), inv.StoreId); err != nil {
+			return err
+		}
+		if err = m.Invitations.Accept(r.Context(), inv.Id, user.Id); err != nil {
+			return err
+		}
+		member = &data.Member{StoreId: inv.StoreId, UserId: user.Id, Name: user.Name, Email: user.Email, Role: inv.Role}
+		if err = m.Members.Insert(r.Context(), member); err != nil {
+			return err
+		}
+		return b.audit(r, m, inv.StoreId, data.AuditMember, user.Id, "create", nil, member)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		case errors.Is(err, errWrongInvitee):
+			b.notPermittedResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateMember):
+			b.conflictResponse(w, r, err.Error())
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/stores/%s/members/%s", member.StoreId, member.UserId))
+
+	if err = b.writeJson(w, http.StatusCreated, envelope{"member": member}, headers); err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// invitationMessage returns the email that carries the token of inv, an
+// invitation to store.
+func invitationMessage(store *data.Store, inv *data.Invitation) mailer.Message {
+	return mailer.Message{
+		To:      inv.Email,
+		Subject: fmt.Sprintf("You are invited to %s", store.Name),
+		Body: fmt.Sprintf(`You have been invited to join the store %s as %s.
+
+Log in with %s, registering first if you have no account, and accept the
+invitation with:
+
+    POST /invitations/%s/accept
+
+The invitation can be accepted once and expires on %s.
+`, store.Name, inv.Role, inv.Email, inv.Token, inv.ExpiresAt.Format(time.RFC1123)),
+	}
+}
@@ -2,12 +2,17 @@ package main
 
 import (
 	"appdrop/internal/data"
+	"appdrop/internal/mailer"
+	"appdrop/internal/oauth"
 	"context"
 	"database/sql"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"log/slog"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -22,20 +27,54 @@ func init() {
 }
 
 func main() {
-	// todo: use flags for configuration later
-	cfg := config{
-		port: 8080, db: struct {
-			dsn          string
-			maxOpenConns int
-			maxIdleTime  time.Duration
-			maxIdleConns int
-		}{
-			dsn:          os.Getenv("APP_DROP_DSN"),
-			maxOpenConns: 25,
-			maxIdleTime:  90 * time.Second,
-			maxIdleConns: 25,
-		},
+	if len(os.Args) > 1 {
+		var run func(args []string, stdin io.Reader, stdout io.Writer) error
+		switch os.Args[1] {
+		case "apply":
+			run = runApply
+		case "create-key":
+			run = runCreateKey
+		}
+		if run != nil {
+			if err := run(os.Args[2:], os.Stdin, os.Stdout); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
 	}
+	var cfg config
+
+	flag.IntVar(&cfg.port, "port", 8080, "API server port")
+
+	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("APP_DROP_DSN"), "PostgreSQL DSN")
+	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
+	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
+	flag.DurationVar(&cfg.db.maxIdleTime, "db-max-idle-time", 90*time.Second, "PostgreSQL max connection idle time")
+	flag.DurationVar(&cfg.db.queryTimeout, "db-query-timeout", 3*time.Second, "PostgreSQL deadline for the queries of a single request")
+
+	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
+		cfg.cors.allowedOrigins = strings.Fields(val)
+		return nil
+	})
+	reservedPrefixesFlag(flag.CommandLine, &cfg.routes.reservedPrefixes)
+	flag.DurationVar(&cfg.trash.retention, "trash-retention", 30*24*time.Hour, "How long deleted stores, pages and widgets can be restored")
+	flag.DurationVar(&cfg.trash.purgeInterval, "trash-purge-interval", time.Hour, "How often the trash is purged of what is past retention")
+	flag.DurationVar(&cfg.sessions.ttl, "session-ttl", 24*time.Hour, "How long a login session lasts")
+	flag.DurationVar(&cfg.invitations.ttl, "invitation-ttl", 7*24*time.Hour, "How long a store invitation can be accepted")
+
+	flag.StringVar(&cfg.smtp.host, "smtp-host", os.Getenv("APP_DROP_SMTP_HOST"), "SMTP host, emails are only logged without one")
+	flag.IntVar(&cfg.smtp.port, "smtp-port", 587, "SMTP port")
+	flag.StringVar(&cfg.smtp.username, "smtp-username", os.Getenv("APP_DROP_SMTP_USERNAME"), "SMTP username")
+	flag.StringVar(&cfg.smtp.password, "smtp-password", os.Getenv("APP_DROP_SMTP_PASSWORD"), "SMTP password")
+	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "AppDrop <no-reply@appdrop.local>", "SMTP sender")
+
+	flag.StringVar(&cfg.oauth.alg, "oauth-alg", oauth.AlgEdDSA, "Signing algorithm of access tokens, HS256 or EdDSA")
+	flag.StringVar(&cfg.oauth.issuer, "oauth-issuer", "appdrop", "Issuer of access tokens")
+	flag.StringVar(&cfg.oauth.keys, "oauth-keys", os.Getenv("APP_DROP_OAUTH_KEYS"), "Access token signing keys as comma separated kid:base64 pairs, the first one signs")
+	flag.DurationVar(&cfg.oauth.tokenTTL, "oauth-token-ttl", 15*time.Minute, "How long an access token lasts")
+	flag.Parse()
+
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
 	db, err := openDb(cfg)
@@ -50,10 +89,33 @@ func main() {
 	}()
 	logger.Info("database connection established")
 
+	models := data.NewModels(db)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.db.queryTimeout)
+	err = models.WidgetTypes.EnsureBuiltins(ctx)
+	cancel()
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
 	b := &backend{
 		logger: logger,
 		conf:   cfg,
-		models: data.NewModels(db),
+		models: models,
+		mailer: mailer.NewLog(logger),
+	}
+	if cfg.smtp.host != "" {
+		b.mailer = mailer.NewSMTP(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender)
+	}
+	if cfg.oauth.keys != "" {
+		b.tokens, err = oauth.ParseKeySet(cfg.oauth.alg, cfg.oauth.issuer, cfg.oauth.keys)
+	} else {
+		logger.Warn("no oauth signing keys, access tokens will not outlive the process")
+		b.tokens, err = oauth.GenerateKeySet(cfg.oauth.alg, cfg.oauth.issuer)
+	}
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
 	}
 	if err = b.serve(); err != nil {
 		logger.Error(err.Error())
@@ -79,3 +141,19 @@ func openDb(cfg config) (*sql.DB, error) {
 	}
 	return db, nil
 }
+
+// reservedPrefixesFlag defines the -reserved-route-prefixes flag on fs, which
+// sets prefixes. The server and the apply command share it, so that neither
+// lets pages take routes the other refuses.
+func reservedPrefixesFlag(fs *flag.FlagSet, prefixes *[]string) {
+	*prefixes = []string{"/api", "/public"}
+	fs.Func("reserved-route-prefixes", "Route prefixes pages may not use (comma separated, default \"/api,/public\")", func(val string) error {
+		*prefixes = nil
+		for _, prefix := range strings.Split(val, ",") {
+			if prefix = strings.TrimSpace(prefix); prefix != "" {
+				*prefixes = append(*prefixes, prefix)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"appdrop/internal/data"
+	"appdrop/internal/validator"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// permission is what a route needs on the store it is about. Each one
+// includes the ones before it.
+type permission int
+
+const (
+	// permNone only needs the request to be authenticated, for the routes
+	// that are not about a single store.
+	permNone permission = iota
+	// permRead lets a store and its content be read.
+	permRead
+	// permWrite lets the content of a store be changed.
+	permWrite
+	// permOwn lets a store be deleted and its members be managed.
+	permOwn
+)
+
+// rolePermissions is the permission each member role grants.
+var rolePermissions = map[string]permission{
+	data.RoleViewer: permRead,
+	data.RoleEditor: permWrite,
+	data.RoleOwner:  permOwn,
+}
+
+// storePermission returns the permission the request r has on the store
+// storeId. An API key or an access token that can access the store has every
+// permission on it, within the scopes of the token; a user has the one of
+// their role, if they are a member.
+func (b *backend) storePermission(r *http.Request, storeId uuid.UUID) (permission, error) {
+	if key := b.contextGetApiKey(r); key != nil {
+		if key.CanAccess(storeId) {
+			return permOwn, nil
+		}
+		return permNone, nil
+	}
+	if claims := b.contextGetClient(r); claims != nil {
+		if claims.CanAccess(storeId) {
+			return permOwn, nil
+		}
+		return permNone, nil
+	}
+	user := b.contextGetUser(r)
+	if user == nil {
+		return permNone, nil
+	}
+	member, err := b.models.Members.Get(r.Context(), storeId, user.Id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return permNone, nil
+		}
+		return permNone, err
+	}
+	return rolePermissions[member.Role], nil
+}
+
+// visibleStores returns whether the request r can read a store, by id, for the
+// listings that span stores.
+func (b *backend) visibleStores(r *http.Request) (func(uuid.UUID) bool, error) {
+	if key := b.contextGetApiKey(r); key != nil {
+		return key.CanAccess, nil
+	}
+	if claims := b.contextGetClient(r); claims != nil {
+		return claims.CanAccess, nil
+	}
+	user := b.contextGetUser(r)
+	if user == nil {
+		return func(uuid.UUID) bool { return false }, nil
+	}
+	roles, err := b.models.Members.GetRolesForUser(r.Context(), user.Id)
+	if err != nil {
+		return nil, err
+	}
+	return func(id uuid.UUID) bool { return rolePermissions[roles[id]] >= permRead }, nil
+}
+
+// canCreateStores reports whether the request r can create stores: users can,
+// and become their owner, and so can API keys and access tokens that access
+// every store.
+func (b *backend) canCreateStores(r *http.Request) bool {
+	if key := b.contextGetApiKey(r); key != nil {
+		return key.Unrestricted()
+	}
+	if claims := b.contextGetClient(r); claims != nil {
+		return claims.Unrestricted()
+	}
+	return b.contextGetUser(r) != nil
+}
+
+// addCreator makes the user who makes the request r, if there is one, the
+// owner of the store storeId they just created, through m.
+func (b *backend) addCreator(r *http.Request, m data.Models, storeId uuid.UUID) error {
+	user := b.contextGetUser(r)
+	if user == nil {
+		return nil
+	}
+	member := &data.Member{StoreId: storeId, UserId: user.Id, Role: data.RoleOwner}
+	if err := m.Members.Insert(r.Context(), member); err != nil {
+		return err
+	}
+	member.Name, member.Email = user.Name, user.Email
+	return b.audit(r, m, storeId, data.AuditMember, user.Id, "create", nil, member)
+}
+
+// listMembersHandler handles GET /stores/:store_id/members
+func (b *backend) listMembersHandler(w http.ResponseWriter, r *http.Request) {
+	storeId, err := b.readIdParam(r, "store_id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	if _, err = b.models.Stores.Get(r.Context(), storeId); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	members, err := b.models.Members.GetAllForStore(r.Context(), storeId)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+		return
+	}
+	if err = b.writeJson(w, http.StatusOK, envelope{"members": members}, nil); err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// addMemberHandler handles POST /stores/:store_id/members
+//
+// The user is named by the email they registered with.
+func (b *backend) addMemberHandler(w http.ResponseWriter, r *http.Request) {
+	storeId, err := b.readIdParam(r, "store_id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	var input struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+	if err = b.readJson(w, r, &input); err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(validator.PermittedValue(input.Role, data.Roles...), "role", "must be one of owner, editor or viewer")
+
+	var user *data.User
+	if input.Email = normalizeEmail(input.Email); input.Email == "" {
+		v.AddError("email", "must be provided")
+	} else if user, err = b.models.Users.GetByEmail(r.Context(), input.Email); err != nil {
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			b.serverErrorResponse(w, r, err)
+			return
+		}
+		v.AddError("email", "must be the email of a registered user")
+	}
+	if !v.Valid() {
+		b.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	member := &data.Member{StoreId: storeId, UserId: user.Id, Name: user.Name, Email: user.Email, Role: input.Role}
+
+	err = b.models.InTx(r.Context(), func(m data.Models) error {
+		if _, err := m.Stores.Get(r.Context(), storeId); err != nil {
+			return err
+		}
+		if err := m.Members.Insert(r.Context(), member); err != nil {
+			return err
+		}
+		return b.audit(r, m, storeId, data.AuditMember, user.Id, "create", nil, member)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateMember):
+			b.conflictResponse(w, r, err.Error())
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/stores/%s/members/%s", storeId, user.Id))
+
+	if err = b.writeJson(w, http.StatusCreated, envelope{"member": member}, headers); err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateMemberHandler handles PUT /stores/:store_id/members/:user_id
+//
+// The last owner of a store cannot be given another role.
+func (b *backend) updateMemberHandler(w http.ResponseWriter, r *http.Request) {
+	storeId, err := b.readIdParam(r, "store_id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	userId, err := b.readIdParam(r, "user_id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	var input struct {
+		Role string `json:"role"`
+	}
+	if err = b.readJson(w, r, &input); err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if v.Check(validator.PermittedValue(input.Role, data.Roles...), "role", "must be one of owner, editor or viewer"); !v.Valid() {
+		b.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	var member *data.Member
+
+	err = b.models.InTx(r.Context(), func(m data.Models) error {
+		before, err := m.Members.Get(r.Context(), storeId, userId)
+		if err != nil {
+			return err
+		}
+		if err = m.Members.UpdateRole(r.Context(), storeId, userId, input.Role); err != nil {
+			return err
+		}
+		if member, err = m.Members.Get(r.Context(), storeId, userId); err != nil {
+			return err
+		}
+		return b.audit(r, m, storeId, data.AuditMember, userId, "update", before, member)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrLastOwner):
+			b.conflictResponse(w, r, err.Error())
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if err = b.writeJson(w, http.StatusOK, envelope{"member": member}, nil); err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// removeMemberHandler handles DELETE /stores/:store_id/members/:user_id
+//
+// The last owner of a store cannot be removed.
+func (b *backend) removeMemberHandler(w http.ResponseWriter, r *http.Request) {
+	storeId, err := b.readIdParam(r, "store_id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	userId, err := b.readIdParam(r, "user_id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	err = b.models.InTx(r.Context(), func(m data.Models) error {
+		before, err := m.Members.Get(r.Context(), storeId, userId)
+		if err != nil {
+			return err
+		}
+		if err = m.Members.Delete(r.Context(), storeId, userId); err != nil {
+			return err
+		}
+		return b.audit(r, m, storeId, data.AuditMember, userId, "delete", before, nil)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrLastOwner):
+			b.conflictResponse(w, r, err.Error())
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if err = b.writeJson(w, http.StatusOK, envelope{"message": "member successfully removed"}, nil); err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// normalizeEmail returns email as it is stored: trimmed and lower case.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -1,11 +1,23 @@
 package main
 
 import (
+	"appdrop/internal/data"
+	"appdrop/internal/oauth"
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"regexp"
+	"strings"
 	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
 )
 
+// requestIdRX matches the request ids taken over from the X-Request-Id header.
+var requestIdRX = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
 // recoverPanic recovers from panics and sends a 500 response.
 func (b *backend) recoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -19,6 +31,153 @@ func (b *backend) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
+// queryTimeout gives each request a deadline for its database work. Handlers
+// pass r.Context() to the models, so the deadline, as well as cancellation when
+// the client goes away, reaches every query the request makes.
+func (b *backend) queryTimeout(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), b.conf.db.queryTimeout)
+		defer cancel()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestId gives each request an id, the one in its X-Request-Id header if
+// that is sensible and a fresh one otherwise, and sends it back in the same
+// header. The id ties log lines and audit entries to the request.
+func (b *backend) requestId(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-Id")
+		if !requestIdRX.MatchString(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set("X-Request-Id", id)
+
+		next.ServeHTTP(w, b.contextSetRequestId(r, id))
+	})
+}
+
+// authenticate sets the API key, the user or the client of a request that
+// carries an API key, a session token or an access token in a bearer
+// Authorization header. Requests without one go on anonymously and are turned
+// away by the routes that need credentials; requests with a key, session or
+// access token that is unknown, revoked, expired or otherwise invalid are
+// turned away here.
+func (b *backend) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+
+		header := r.Header.Get("Authorization")
+		// Basic credentials are those of an OAuth client, which only the
+		// token endpoint reads. The public routes are open to anyone, so
+		// whatever credentials a request to them carries go unchecked.
+		if header == "" || strings.HasPrefix(header, "Basic ") || publicPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			b.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+		if oauth.IsToken(token) {
+			claims, err := b.tokens.Verify(token)
+			if err != nil {
+				b.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+			next.ServeHTTP(w, b.contextSetClient(r, claims))
+			return
+		}
+		if data.IsSessionToken(token) {
+			user, err := b.models.Sessions.GetUser(r.Context(), token)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					b.invalidAuthenticationTokenResponse(w, r)
+				default:
+					b.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+			next.ServeHTTP(w, b.contextSetUser(r, user))
+			return
+		}
+		prefix, ok := data.ApiKeyPrefix(token)
+		if !ok {
+			b.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+		key, err := b.models.ApiKeys.GetByPrefix(r.Context(), prefix)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				b.invalidAuthenticationTokenResponse(w, r)
+			default:
+				b.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		if !key.Matches(token) || key.Expired(time.Now()) {
+			b.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, b.contextSetApiKey(r, key))
+	})
+}
+
+// publicPath reports whether path is one of the routes that need no
+// credentials: the health check and the published content of the mobile app.
+func publicPath(path string) bool {
+	return path == "/healthcheck" || strings.HasPrefix(path, "/public/")
+}
+
+// requirePermission lets through authenticated requests that have the
+// permission perm on the store named by the :store_id parameter of the route,
+// if it has one. Requests made with an access token also need it to carry
+// every one of scopes; a route without any is closed to them.
+func (b *backend) requirePermission(perm permission, scopes []string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := b.contextGetClient(r)
+		if b.contextGetApiKey(r) == nil && b.contextGetUser(r) == nil && claims == nil {
+			b.authenticationRequiredResponse(w, r)
+			return
+		}
+		if claims != nil && !claims.HasScopes(scopes) {
+			b.insufficientScopeResponse(w, r, scopes)
+			return
+		}
+		param := httprouter.ParamsFromContext(r.Context()).ByName("store_id")
+		// A malformed id is left to the handler, which answers 400.
+		if storeId, err := uuid.Parse(param); err == nil {
+			granted, err := b.storePermission(r, storeId)
+			if err != nil {
+				b.serverErrorResponse(w, r, err)
+				return
+			}
+			if granted < perm {
+				b.notPermittedResponse(w, r)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// requireFullAccess lets through requests made with an API key that can
+// access every store, which the routes that import stores or manage keys
+// need.
+func (b *backend) requireFullAccess(next http.HandlerFunc) http.HandlerFunc {
+	return b.requirePermission(permNone, nil, func(w http.ResponseWriter, r *http.Request) {
+		if key := b.contextGetApiKey(r); key == nil || !key.Unrestricted() {
+			b.notPermittedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // logRequest logs HTTP request details.
 func (b *backend) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -30,6 +189,7 @@ func (b *backend) logRequest(next http.Handler) http.Handler {
 			"method", r.Method,
 			"uri", r.URL.RequestURI(),
 			"duration", time.Since(start).String(),
+			"request_id", b.contextGetRequestId(r),
 		)
 	})
 }
@@ -57,11 +217,12 @@ func (b *backend) enableCors(next http.Handler) http.Handler {
 			return
 		}
 		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, X-Request-Id")
 
 		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
 			w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
 			w.Header().Set("Access-Control-Max-Age", "60")
-			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Match, X-Request-Id")
 			w.WriteHeader(http.StatusOK)
 			return
 		}
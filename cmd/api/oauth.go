@@ -0,0 +1,178 @@
+package main
+
+import (
+	"appdrop/internal/data"
+	"appdrop/internal/oauth"
+	"appdrop/internal/validator"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// tokenHandler handles POST /oauth/token
+//
+// It implements the client credentials grant of RFC 6749: the client
+// authenticates with its id and secret, in a Basic Authorization header or the
+// form body, and gets an access token for the scopes it asks for, or all of its
+// own if it asks for none.
+func (b *backend) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<16)
+	if err := r.ParseForm(); err != nil {
+		b.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "the body must be form encoded")
+		return
+	}
+	switch grant := r.PostForm.Get("grant_type"); grant {
+	case "client_credentials":
+	case "":
+		b.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "grant_type must be provided")
+		return
+	default:
+		b.oauthErrorResponse(w, r, http.StatusBadRequest, "unsupported_grant_type", "only the client_credentials grant is supported")
+		return
+	}
+	clientId, secret, ok := r.BasicAuth()
+	if !ok {
+		clientId, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	client, err := b.models.OAuthClients.GetByClientId(r.Context(), clientId)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		b.serverErrorResponse(w, r, err)
+		return
+	}
+	if client == nil || !client.Matches(secret) {
+		b.oauthErrorResponse(w, r, http.StatusUnauthorized, "invalid_client", "invalid client id or secret")
+		return
+	}
+	scopes := strings.Fields(r.PostForm.Get("scope"))
+	for _, scope := range scopes {
+		if !slices.Contains(client.Scopes, scope) {
+			b.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_scope", fmt.Sprintf("the client was not granted the %s scope", scope))
+			return
+		}
+	}
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+	scope := strings.Join(scopes, " ")
+	ttl := b.conf.oauth.tokenTTL
+
+	token, err := b.tokens.Issue(client.ClientId, scope, client.StoreIds, ttl)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+		return
+	}
+	headers := http.Header{"Cache-Control": {"no-store"}, "Pragma": {"no-cache"}}
+	resp := envelope{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(ttl.Seconds()),
+		"scope":        scope,
+	}
+	if err = b.writeJson(w, http.StatusOK, resp, headers); err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// jwksHandler handles GET /.well-known/jwks.json
+//
+// It publishes the keys that verify access tokens, by the kid their header
+// names, so that other services can verify them too.
+func (b *backend) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	if err := b.writeJson(w, http.StatusOK, envelope{"keys": b.tokens.JWKS()}, nil); err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// listOAuthClientsHandler handles GET /oauth/clients
+func (b *backend) listOAuthClientsHandler(w http.ResponseWriter, r *http.Request) {
+	clients, err := b.models.OAuthClients.GetAll(r.Context())
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+		return
+	}
+	if err = b.writeJson(w, http.StatusOK, envelope{"oauth_clients": clients}, nil); err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// createOAuthClientHandler handles POST /oauth/clients
+//
+// The response is the only time the client secret is shown. scopes are the
+// most a token of the client can be granted, and store_ids restricts its
+// tokens to those stores.
+func (b *backend) createOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name     string      `json:"name"`
+		Scopes   []string    `json:"scopes"`
+		StoreIds []uuid.UUID `json:"store_ids"`
+	}
+	if err := b.readJson(w, r, &input); err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(strings.TrimSpace(input.Name) != "", "name", "must be provided")
+	v.Check(len(input.Scopes) > 0, "scopes", "must grant at least one scope")
+	for i, scope := range input.Scopes {
+		v.Check(validator.PermittedValue(scope, oauth.Scopes...), fmt.Sprintf("scopes[%d]", i), "must be one of "+strings.Join(oauth.Scopes, ", "))
+	}
+	if !v.Valid() {
+		b.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	for i, id := range input.StoreIds {
+		if _, err := b.models.Stores.Get(r.Context(), id); err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				b.failedValidationResponse(w, r, map[string]string{fmt.Sprintf("store_ids[%d]", i): "must be an existing store"})
+			default:
+				b.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+	client, err := data.GenerateOAuthClient(input.Name, slices.Compact(slices.Sorted(slices.Values(input.Scopes))), input.StoreIds)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+		return
+	}
+	if err = b.models.OAuthClients.Insert(r.Context(), client); err != nil {
+		b.serverErrorResponse(w, r, err)
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/oauth/clients/%s", client.Id))
+
+	if err = b.writeJson(w, http.StatusCreated, envelope{"oauth_client": client}, headers); err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// revokeOAuthClientHandler handles DELETE /oauth/clients/:id
+//
+// The client cannot get new tokens; those it already has last until they
+// expire, which the short token TTL keeps brief.
+func (b *backend) revokeOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := b.readIdParam(r, "id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	if err = b.models.OAuthClients.Revoke(r.Context(), id); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = b.writeJson(w, http.StatusOK, envelope{"message": "oauth client successfully revoked"}, nil)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
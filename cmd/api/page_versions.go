@@ -0,0 +1,232 @@
+package main
+
+import (
+	"appdrop/internal/data"
+	"appdrop/internal/validator"
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// savePage runs fn and snapshots the resulting state of the page as a new
+// version in the same transaction, so a save is never left without its entry
+// in the page history and vice versa.
+func (b *backend) savePage(ctx context.Context, pageId uuid.UUID, fn func(m data.Models) error) error {
+	return b.savePages(ctx, []uuid.UUID{pageId}, fn)
+}
+
+// savePages is savePage for a change that touches several pages; each of them
+// gets a new version.
+func (b *backend) savePages(ctx context.Context, pageIds []uuid.UUID, fn func(m data.Models) error) error {
+	return b.models.InTx(ctx, func(m data.Models) error {
+		if err := fn(m); err != nil {
+			return err
+		}
+		for _, pageId := range pageIds {
+			if _, err := m.PageVersions.Record(ctx, pageId); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// listPageVersionsHandler handles GET /stores/:store_id/pages/:page_id/versions
+func (b *backend) listPageVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	page, ok := b.readStorePage(w, r)
+	if !ok {
+		return
+	}
+	versions, err := b.models.PageVersions.GetAllForPage(r.Context(), page.Id)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+		return
+	}
+	err = b.writeJson(w, http.StatusOK, envelope{"versions": versions}, nil)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// showPageVersionHandler handles GET /stores/:store_id/pages/:page_id/versions/:version
+func (b *backend) showPageVersionHandler(w http.ResponseWriter, r *http.Request) {
+	page, ok := b.readStorePage(w, r)
+	if !ok {
+		return
+	}
+	n, err := b.readIntParam(r, "version")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	version, err := b.models.PageVersions.Get(r.Context(), page.Id, n)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = b.writeJson(w, http.StatusOK, envelope{"version": version}, nil)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// restorePageVersionHandler handles POST /stores/:store_id/pages/:page_id/versions/:version/restore
+func (b *backend) restorePageVersionHandler(w http.ResponseWriter, r *http.Request) {
+	page, ok := b.readStorePage(w, r)
+	if !ok {
+		return
+	}
+	n, err := b.readIntParam(r, "version")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	// Other pages may have taken a route since the version was recorded that
+	// conflicts with its route.
+	version, err := b.models.PageVersions.Get(r.Context(), page.Id, n)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if _, ok = b.checkPageRoute(w, r, page, version.Route); !ok {
+		return
+	}
+	var restored *data.PageVersion
+
+	err = b.models.InTx(r.Context(), func(m data.Models) error {
+		if page.Widgets, err = m.Widgets.GetForPage(r.Context(), page.Id); err != nil {
+			return err
+		}
+		if restored, err = m.PageVersions.Restore(r.Context(), page.Id, n); err != nil {
+			return err
+		}
+		return b.audit(r, m, page.StoreId, data.AuditPage, page.Id, "restore_version", page, restored)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateRoute):
+			b.conflictResponse(w, r, "the route of this version is now used by another page")
+		case errors.Is(err, data.ErrHomeConflict):
+			b.homeConflictResponse(w, r)
+		case errors.Is(err, data.ErrUnknownWidgetType):
+			b.conflictResponse(w, r, "the version uses widget types that are no longer registered for this store")
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = b.writeJson(w, http.StatusOK, envelope{"version": restored}, nil)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// diffPageVersionsHandler handles GET /stores/:store_id/pages/:page_id/diff?from=&to=
+//
+// to defaults to the latest version and from to the one before it; from=0
+// compares against an empty page.
+func (b *backend) diffPageVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	page, ok := b.readStorePage(w, r)
+	if !ok {
+		return
+	}
+	qs := r.URL.Query()
+	v := validator.New()
+
+	to := b.readInt(qs, "to", 0, v)
+	if to == 0 && v.Valid() {
+		versions, err := b.models.PageVersions.GetAllForPage(r.Context(), page.Id)
+		if err != nil {
+			b.serverErrorResponse(w, r, err)
+			return
+		}
+		if len(versions) == 0 {
+			b.notFoundResponse(w, r)
+			return
+		}
+		to = versions[0].Version
+	}
+	from := b.readInt(qs, "from", to-1, v)
+
+	v.Check(to >= 1, "to", "must be a version number")
+	v.Check(from >= 0, "from", "must be a version number or 0")
+	v.Check(from < to, "from", "must be lower than to")
+	if !v.Valid() {
+		b.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	toVersion, err := b.models.PageVersions.Get(r.Context(), page.Id, to)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	var fromVersion *data.PageVersion
+	if from > 0 {
+		fromVersion, err = b.models.PageVersions.Get(r.Context(), page.Id, from)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				b.notFoundResponse(w, r)
+			default:
+				b.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+	diff := data.DiffPageVersions(fromVersion, toVersion)
+
+	err = b.writeJson(w, http.StatusOK, envelope{"diff": diff}, nil)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// readStorePage loads the page named by the URL and checks that it belongs to
+// the store. It writes the error response itself and reports whether the
+// handler should continue.
+func (b *backend) readStorePage(w http.ResponseWriter, r *http.Request) (*data.Page, bool) {
+	storeId, err := b.readIdParam(r, "store_id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return nil, false
+	}
+	pageId, err := b.readIdParam(r, "page_id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return nil, false
+	}
+	page, err := b.models.Pages.Get(r.Context(), pageId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	if page.StoreId != storeId {
+		b.notFoundResponse(w, r)
+		return nil, false
+	}
+	return page, true
+}
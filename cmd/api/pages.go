@@ -2,6 +2,8 @@ package main
 
 import (
 	"appdrop/internal/data"
+	"appdrop/internal/routing"
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -19,7 +21,7 @@ func (b *backend) createPageHandler(w http.ResponseWriter, r *http.Request) {
 		b.badRequestResponse(w, r, err)
 		return
 	}
-	if _, err = b.models.Stores.Get(storeId); err != nil {
+	if _, err = b.models.Stores.Get(r.Context(), storeId); err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			b.notFoundResponse(w, r)
@@ -50,19 +52,34 @@ func (b *backend) createPageHandler(w http.ResponseWriter, r *http.Request) {
 	page := &data.Page{
 		Id: uuid.New(), StoreId: storeId,
 		IsHome: input.IsHome,
-		Name:   input.Name, Route: input.Route,
+		Name:   input.Name,
 	}
-	err = b.models.Pages.Insert(page)
+	route, ok := b.checkPageRoute(w, r, page, input.Route)
+	if !ok {
+		return
+	}
+	page.Route = route
+
+	err = b.savePage(r.Context(), page.Id, func(m data.Models) error {
+		if err := m.Pages.Insert(r.Context(), page); err != nil {
+			return err
+		}
+		return b.audit(r, m, page.StoreId, data.AuditPage, page.Id, "create", nil, page)
+	})
 	if err != nil {
-		if strings.Contains(err.Error(), "page route already exists") {
+		switch {
+		case errors.Is(err, data.ErrDuplicateRoute):
 			b.conflictResponse(w, r, "page route already exists")
-		} else {
+		case errors.Is(err, data.ErrHomeConflict):
+			b.homeConflictResponse(w, r)
+		default:
 			b.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 	headers := make(http.Header)
-	headers.Set("Location", fmt.Sprintf("stores/%s/pages/%s", storeId, page.Id))
+	headers.Set("Location", fmt.Sprintf("/stores/%s/pages/%s", storeId, page.Id))
+	headers.Set("ETag", versionETag(page.Version))
 
 	err = b.writeJson(w, http.StatusCreated, envelope{"page": page}, headers)
 	if err != nil {
@@ -77,7 +94,7 @@ func (b *backend) listPagesHandler(w http.ResponseWriter, r *http.Request) {
 		b.badRequestResponse(w, r, err)
 		return
 	}
-	pages, err := b.models.Pages.GetAllForStore(id)
+	pages, err := b.models.Pages.GetAllForStore(r.Context(), id)
 	if err != nil {
 		b.serverErrorResponse(w, r, err)
 		return
@@ -100,7 +117,7 @@ func (b *backend) showPageHandler(w http.ResponseWriter, r *http.Request) {
 		b.badRequestResponse(w, r, err)
 		return
 	}
-	page, err := b.models.Pages.Get(pageId)
+	page, err := b.models.Pages.Get(r.Context(), pageId)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -114,7 +131,10 @@ func (b *backend) showPageHandler(w http.ResponseWriter, r *http.Request) {
 		b.notFoundResponse(w, r)
 		return
 	}
-	err = b.writeJson(w, http.StatusOK, envelope{"page": page}, nil)
+	headers := make(http.Header)
+	headers.Set("ETag", versionETag(page.Version))
+
+	err = b.writeJson(w, http.StatusOK, envelope{"page": page}, headers)
 	if err != nil {
 		b.serverErrorResponse(w, r, err)
 	}
@@ -132,7 +152,7 @@ func (b *backend) updatePageHandler(w http.ResponseWriter, r *http.Request) {
 		b.badRequestResponse(w, r, err)
 		return
 	}
-	page, err := b.models.Pages.Get(pageId)
+	page, err := b.models.Pages.Get(r.Context(), pageId)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -146,6 +166,10 @@ func (b *backend) updatePageHandler(w http.ResponseWriter, r *http.Request) {
 		b.notFoundResponse(w, r)
 		return
 	}
+	if !ifMatch(r, page.Version) {
+		b.preconditionFailedResponse(w, r)
+		return
+	}
 	var input struct {
 		Name   *string `json:"name"`
 		Route  *string `json:"route"`
@@ -155,6 +179,8 @@ func (b *backend) updatePageHandler(w http.ResponseWriter, r *http.Request) {
 		b.badRequestResponse(w, r, err)
 		return
 	}
+	before := *page
+
 	// Update fields if provided
 	if input.Name != nil {
 		if strings.TrimSpace(*input.Name) == "" {
@@ -168,28 +194,169 @@ func (b *backend) updatePageHandler(w http.ResponseWriter, r *http.Request) {
 			b.validationErrorResponse(w, r, "page route cannot be empty")
 			return
 		}
-		page.Route = *input.Route
+		route, ok := b.checkPageRoute(w, r, page, *input.Route)
+		if !ok {
+			return
+		}
+		page.Route = route
 	}
 	if input.IsHome != nil {
 		page.IsHome = *input.IsHome
 	}
-	if err = b.models.Pages.Update(page); err != nil {
+	err = b.savePage(r.Context(), page.Id, func(m data.Models) error {
+		if err := m.Pages.Update(r.Context(), page); err != nil {
+			return err
+		}
+		return b.audit(r, m, page.StoreId, data.AuditPage, page.Id, "update", before, page)
+	})
+	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			b.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateRoute):
+			b.conflictResponse(w, r, "page route already exists for this app")
+		case errors.Is(err, data.ErrHomeConflict):
+			b.homeConflictResponse(w, r)
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("ETag", versionETag(page.Version))
+
+	err = b.writeJson(w, http.StatusOK, envelope{"page": page}, headers)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// duplicatePageHandler handles POST /stores/:store_id/pages/:page_id/duplicate
+//
+// The copy gets the given name and route and copies of all widgets; it is
+// never the home page. store_id puts the copy into another store.
+func (b *backend) duplicatePageHandler(w http.ResponseWriter, r *http.Request) {
+	source, ok := b.readStorePage(w, r)
+	if !ok {
+		return
+	}
+	var input struct {
+		Name    string     `json:"name"`
+		Route   string     `json:"route"`
+		StoreId *uuid.UUID `json:"store_id"`
+	}
+	err := b.readJson(w, r, &input)
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	if strings.TrimSpace(input.Name) == "" {
+		b.validationErrorResponse(w, r, "page name is required and cannot be empty")
+		return
+	}
+	if strings.TrimSpace(input.Route) == "" {
+		b.validationErrorResponse(w, r, "page route is required and cannot be empty")
+		return
+	}
+	page := &data.Page{
+		Id: uuid.New(), StoreId: source.StoreId,
+		Name: input.Name,
+	}
+	if input.StoreId != nil && *input.StoreId != source.StoreId {
+		granted, err := b.storePermission(r, *input.StoreId)
+		if err != nil {
+			b.serverErrorResponse(w, r, err)
+			return
+		}
+		if granted < permWrite {
+			b.notPermittedResponse(w, r)
+			return
+		}
+		if _, err = b.models.Stores.Get(r.Context(), *input.StoreId); err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				b.failedValidationResponse(w, r, map[string]string{"store_id": "must be an existing store"})
+			default:
+				b.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		page.StoreId = *input.StoreId
+	}
+	route, ok := b.checkPageRoute(w, r, page, input.Route)
+	if !ok {
+		return
+	}
+	page.Route = route
+
+	var invalid map[string]string
+
+	err = b.savePage(r.Context(), page.Id, func(m data.Models) error {
+		if err := m.Pages.Duplicate(r.Context(), source.Id, page); err != nil {
+			return err
+		}
+		// Another store may define a type of the same name differently. The
+		// copies are checked against it before the transaction commits, so
+		// that they are exactly the widgets that would be written.
+		if page.StoreId != source.StoreId {
+			if invalid, err = b.invalidCopies(r.Context(), m, page); err != nil {
+				return err
+			}
+			if invalid != nil {
+				return errInvalidCopies
+			}
+		}
+		return b.audit(r, m, page.StoreId, data.AuditPage, page.Id, "duplicate", nil, page)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidCopies):
+			b.failedValidationResponse(w, r, invalid)
 		case errors.Is(err, data.ErrRecordNotFound):
 			b.notFoundResponse(w, r)
-		case strings.Contains(err.Error(), "page route already exists"):
-			b.conflictResponse(w, r, "page route already exists for this b")
+		case errors.Is(err, data.ErrDuplicateRoute):
+			b.conflictResponse(w, r, "page route already exists")
+		case errors.Is(err, data.ErrUnknownWidgetType):
+			b.failedValidationResponse(w, r, map[string]string{"store_id": "the page uses widget types that are not registered for this store"})
 		default:
 			b.serverErrorResponse(w, r, err)
 		}
 		return
 	}
-	err = b.writeJson(w, http.StatusOK, envelope{"page": page}, nil)
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/stores/%s/pages/%s", page.StoreId, page.Id))
+	headers.Set("ETag", versionETag(page.Version))
+
+	err = b.writeJson(w, http.StatusCreated, envelope{"page": page}, headers)
 	if err != nil {
 		b.serverErrorResponse(w, r, err)
 	}
 }
 
+// errInvalidCopies rolls back a duplicate whose widgets do not fit the target
+// store.
+var errInvalidCopies = errors.New("the copied widgets are invalid in the target store")
+
+// invalidCopies validates the widgets of page against the registry of its
+// store through m and returns what is wrong with them, keyed by their place
+// on the page, or nil if nothing is.
+func (b *backend) invalidCopies(ctx context.Context, m data.Models, page *data.Page) (map[string]string, error) {
+	var invalid map[string]string
+	for i, widget := range page.Widgets {
+		v, err := validateWidgetWith(ctx, m.WidgetTypes, page.StoreId, widget)
+		if err != nil {
+			return nil, err
+		}
+		for key, message := range v.Errors {
+			if invalid == nil {
+				invalid = make(map[string]string)
+			}
+			invalid[fmt.Sprintf("widgets[%d].%s", i, key)] = message
+		}
+	}
+	return invalid, nil
+}
+
 // deletePageHandler handles DELETE /pages/:id
 func (b *backend) deletePageHandler(w http.ResponseWriter, r *http.Request) {
 	storeId, err := b.readIdParam(r, "store_id")
@@ -202,7 +369,7 @@ func (b *backend) deletePageHandler(w http.ResponseWriter, r *http.Request) {
 		b.badRequestResponse(w, r, err)
 		return
 	}
-	page, err := b.models.Pages.Get(pageId)
+	page, err := b.models.Pages.Get(r.Context(), pageId)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -216,12 +383,23 @@ func (b *backend) deletePageHandler(w http.ResponseWriter, r *http.Request) {
 		b.notFoundResponse(w, r)
 		return
 	}
-	err = b.models.Pages.Delete(pageId)
+	if !ifMatch(r, page.Version) {
+		b.preconditionFailedResponse(w, r)
+		return
+	}
+	err = b.models.InTx(r.Context(), func(m data.Models) error {
+		if err := m.Pages.Delete(r.Context(), pageId, page.Version); err != nil {
+			return err
+		}
+		return b.audit(r, m, page.StoreId, data.AuditPage, page.Id, "delete", page, nil)
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			b.notFoundResponse(w, r)
-		case strings.Contains(err.Error(), "cannot delete home page"):
+		case errors.Is(err, data.ErrEditConflict):
+			b.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDeleteHomePage):
 			b.conflictResponse(w, r, "cannot delete home page")
 		default:
 			b.serverErrorResponse(w, r, err)
@@ -233,3 +411,41 @@ func (b *backend) deletePageHandler(w http.ResponseWriter, r *http.Request) {
 		b.serverErrorResponse(w, r, err)
 	}
 }
+
+// checkPageRoute parses and normalizes a route for the page, rejecting
+// reserved prefixes and patterns that are ambiguous with the route of another
+// page in the same store. It writes the error response itself and returns the
+// normalized route with whether the handler should continue.
+func (b *backend) checkPageRoute(w http.ResponseWriter, r *http.Request, page *data.Page, raw string) (string, bool) {
+	pattern, err := routing.Parse(raw)
+	if err != nil {
+		b.validationErrorResponse(w, r, fmt.Sprintf("page route %s", err))
+		return "", false
+	}
+	for _, prefix := range b.conf.routes.reservedPrefixes {
+		if pattern.HasPrefix(prefix) {
+			b.validationErrorResponse(w, r, fmt.Sprintf("page route must not start with the reserved prefix %s", prefix))
+			return "", false
+		}
+	}
+	pages, err := b.models.Pages.GetAllForStore(r.Context(), page.StoreId)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+		return "", false
+	}
+	for _, other := range pages {
+		if other.Id == page.Id {
+			continue
+		}
+		otherPattern, err := routing.Parse(other.Route)
+		if err != nil {
+			continue
+		}
+		if pattern.Conflicts(otherPattern) {
+			b.conflictResponse(w, r, fmt.Sprintf("page route %s conflicts with %s of page %q (%s)",
+				pattern, other.Route, other.Name, other.Id))
+			return "", false
+		}
+	}
+	return pattern.String(), true
+}
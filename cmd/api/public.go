@@ -0,0 +1,187 @@
+package main
+
+import (
+	"appdrop/internal/data"
+	"appdrop/internal/routing"
+	"appdrop/internal/validator"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// appManifest is everything the mobile app needs on launch. It is built from
+// published snapshots only, never from the working copy.
+type appManifest struct {
+	Store *data.Store         `json:"store"`
+	Home  *data.Publication   `json:"home"`
+	Pages []*data.Publication `json:"pages"`
+}
+
+// showManifestHandler handles GET /public/:slug/manifest
+func (b *backend) showManifestHandler(w http.ResponseWriter, r *http.Request) {
+	store, pubs, ok := b.readPublishedStore(w, r)
+	if !ok {
+		return
+	}
+	manifest := appManifest{Store: store, Pages: pubs}
+
+	// Should two published snapshots both claim to be home, the most recently
+	// published one wins.
+	for _, pub := range pubs {
+		if pub.IsHome && (manifest.Home == nil || pub.PublishedAt.After(manifest.Home.PublishedAt)) {
+			manifest.Home = pub
+		}
+	}
+	if err := b.writeCachedJson(w, r, envelope{"manifest": manifest}); err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// resolveRouteHandler handles GET /public/:slug/resolve?path=
+func (b *backend) resolveRouteHandler(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+
+	v := validator.New()
+	v.Check(strings.HasPrefix(path, "/"), "path", "must be an app path starting with /")
+	if !v.Valid() {
+		b.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	_, pubs, ok := b.readPublishedStore(w, r)
+	if !ok {
+		return
+	}
+	var match *data.Publication
+	var matchPattern routing.Pattern
+	var params map[string]string
+
+	for _, pub := range pubs {
+		pattern, err := routing.Parse(pub.Route)
+		if err != nil {
+			// Routes published before patterns were validated may not parse.
+			continue
+		}
+		p, ok := pattern.Match(path)
+		if !ok {
+			continue
+		}
+		if match == nil || pattern.MoreSpecific(matchPattern) {
+			match, matchPattern, params = pub, pattern, p
+		}
+	}
+	if match == nil {
+		b.notFoundResponse(w, r)
+		return
+	}
+	err := b.writeCachedJson(w, r, envelope{"page": match, "params": params})
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// readPublishedStore loads the store named by the slug in the URL and the live
+// publication of each of its pages. With ?app_version= it also drops widgets
+// whose type the given app version does not support. It writes the error
+// response itself and reports whether the handler should continue.
+func (b *backend) readPublishedStore(w http.ResponseWriter, r *http.Request) (*data.Store, []*data.Publication, bool) {
+	appVersion := r.URL.Query().Get("app_version")
+
+	v := validator.New()
+	if appVersion != "" {
+		v.Check(data.ValidAppVersion(appVersion), "app_version", "must be a version such as 2.4.1")
+	}
+	if !v.Valid() {
+		b.failedValidationResponse(w, r, v.Errors)
+		return nil, nil, false
+	}
+	slug := httprouter.ParamsFromContext(r.Context()).ByName("slug")
+
+	store, err := b.models.Stores.GetBySlug(r.Context(), slug)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return nil, nil, false
+	}
+	pubs, err := b.models.Publications.GetAllForStore(r.Context(), store.Id)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+		return nil, nil, false
+	}
+	if pubs == nil {
+		pubs = []*data.Publication{}
+	}
+	if appVersion != "" {
+		types, err := b.models.WidgetTypes.GetAllForStore(r.Context(), store.Id)
+		if err != nil {
+			b.serverErrorResponse(w, r, err)
+			return nil, nil, false
+		}
+		filterWidgetsForApp(pubs, types, appVersion)
+	}
+	return store, pubs, true
+}
+
+// filterWidgetsForApp removes the widgets whose type is unknown or outside the
+// supported app versions of its registry entry.
+func filterWidgetsForApp(pubs []*data.Publication, types []*data.WidgetType, appVersion string) {
+	registry := make(map[string]*data.WidgetType, len(types))
+	for _, wt := range types {
+		// Store defined types come last and shadow built-ins of the same name.
+		registry[wt.Name] = wt
+	}
+	for _, pub := range pubs {
+		kept := pub.Widgets[:0]
+		for _, widget := range pub.Widgets {
+			wt, ok := registry[widget.Type]
+			if !ok {
+				continue
+			}
+			if wt.MinAppVersion != "" && data.CompareAppVersions(appVersion, wt.MinAppVersion) < 0 {
+				continue
+			}
+			if wt.MaxAppVersion != "" && data.CompareAppVersions(appVersion, wt.MaxAppVersion) > 0 {
+				continue
+			}
+			kept = append(kept, widget)
+		}
+		pub.Widgets = kept
+	}
+}
+
+// writeCachedJson writes a compact JSON response with an ETag derived from the
+// body and answers a matching If-None-Match with 304 Not Modified. The public
+// endpoints are hit on every app launch, so clients are allowed to cache the
+// response briefly.
+func (b *backend) writeCachedJson(w http.ResponseWriter, r *http.Request, data any) error {
+	jsonB, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(jsonB)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=60")
+
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		if strings.TrimSpace(candidate) == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(append(jsonB, '\n')); err != nil {
+		b.logger.Info("Failed writing response", "err", err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"appdrop/internal/data"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// publishPageHandler handles POST /stores/:store_id/pages/:page_id/publish
+func (b *backend) publishPageHandler(w http.ResponseWriter, r *http.Request) {
+	storeId, err := b.readIdParam(r, "store_id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	pageId, err := b.readIdParam(r, "page_id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	page, err := b.models.Pages.Get(r.Context(), pageId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if page.StoreId != storeId {
+		b.notFoundResponse(w, r)
+		return
+	}
+	var pub *data.Publication
+
+	err = b.models.InTx(r.Context(), func(m data.Models) error {
+		var err error
+		if pub, err = m.Publications.Publish(r.Context(), pageId); err != nil {
+			return err
+		}
+		return b.audit(r, m, storeId, data.AuditPage, pageId, "publish", nil, pub)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/stores/%s/pages/%s/published", storeId, pageId))
+
+	err = b.writeJson(w, http.StatusCreated, envelope{"publication": pub}, headers)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// showPublishedPageHandler handles GET /stores/:store_id/pages/:page_id/published
+func (b *backend) showPublishedPageHandler(w http.ResponseWriter, r *http.Request) {
+	storeId, err := b.readIdParam(r, "store_id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	pageId, err := b.readIdParam(r, "page_id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	pub, err := b.models.Publications.GetLatest(r.Context(), pageId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if pub.StoreId != storeId {
+		b.notFoundResponse(w, r)
+		return
+	}
+	err = b.writeJson(w, http.StatusOK, envelope{"publication": pub}, nil)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"appdrop/internal/oauth"
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
@@ -14,27 +15,128 @@ func (b *backend) routes() http.Handler {
 
 	router.HandlerFunc(http.MethodGet, "/healthcheck", b.healthcheckHandler)
 
+	// Registering and logging in are open to anyone.
+	router.HandlerFunc(http.MethodPost, "/users", b.registerUserHandler)
+	router.HandlerFunc(http.MethodPost, "/sessions", b.createSessionHandler)
+
+	// Machine clients trade their credentials for access tokens, which the
+	// published keys verify.
+	router.HandlerFunc(http.MethodPost, "/oauth/token", b.tokenHandler)
+	router.HandlerFunc(http.MethodGet, "/.well-known/jwks.json", b.jwksHandler)
+
+	// Everything else but the health check and the public routes needs an API
+	// key, a session or an access token, with the permission perm on the store
+	// of the route: an API key or a token that can access the store has them
+	// all, a user those of their role in it. A token also needs scope, and
+	// cannot be used where it is empty.
+	handle := func(method, path string, perm permission, scope string, h http.HandlerFunc) {
+		var scopes []string
+		if scope != "" {
+			scopes = []string{scope}
+		}
+		router.HandlerFunc(method, path, b.requirePermission(perm, scopes, h))
+	}
+	// Applying a bundle writes the pages and widgets of the store as well, so
+	// a token needs all three write scopes.
+	applyScopes := []string{oauth.ScopeStoresWrite, oauth.ScopePagesWrite, oauth.ScopeWidgetsWrite}
+	// Importing stores and managing keys and clients needs a key that can
+	// access them all.
+	handleFull := func(method, path string, h http.HandlerFunc) {
+		router.HandlerFunc(method, path, b.requireFullAccess(h))
+	}
+
 	// Store routes
-	router.HandlerFunc(http.MethodGet, "/stores", b.listStoresHandler)
-	router.HandlerFunc(http.MethodPost, "/stores", b.createStoreHandler)
-	router.HandlerFunc(http.MethodGet, "/stores/:store_id", b.showStoreHandler)
-	router.HandlerFunc(http.MethodPut, "/stores/:store_id", b.updateStoreHandler)
-	router.HandlerFunc(http.MethodDelete, "/stores/:store_id", b.deleteStoreHandler)
+	handle(http.MethodGet, "/stores", permNone, oauth.ScopeStoresRead, b.listStoresHandler)
+	handle(http.MethodPost, "/stores", permNone, oauth.ScopeStoresWrite, b.createStoreHandler)
+	handle(http.MethodGet, "/stores/:store_id", permRead, oauth.ScopeStoresRead, b.showStoreHandler)
+	handle(http.MethodPut, "/stores/:store_id", permWrite, oauth.ScopeStoresWrite, b.updateStoreHandler)
+	handle(http.MethodDelete, "/stores/:store_id", permOwn, oauth.ScopeStoresWrite, b.deleteStoreHandler)
+	handle(http.MethodPost, "/stores/:store_id/clone", permRead, oauth.ScopeStoresWrite, b.cloneStoreHandler)
+	handle(http.MethodGet, "/stores/:store_id/export", permRead, oauth.ScopeStoresRead, b.exportStoreHandler)
+	router.HandlerFunc(http.MethodPost, "/stores/:store_id/apply", b.requirePermission(permWrite, applyScopes, b.applyStoreHandler))
+	handle(http.MethodPost, "/stores/:store_id/restore", permOwn, oauth.ScopeStoresWrite, b.restoreStoreHandler)
+	// httprouter cannot put a static segment next to :store_id, so POST
+	// /stores/import is served by the only POST route of that shape.
+	importStore := b.requireFullAccess(b.importStoreHandler)
+	router.HandlerFunc(http.MethodPost, "/stores/:store_id", func(w http.ResponseWriter, r *http.Request) {
+		if httprouter.ParamsFromContext(r.Context()).ByName("store_id") != "import" {
+			b.notFoundResponse(w, r)
+			return
+		}
+		importStore(w, r)
+	})
 
 	// Page routes — nested under store
-	router.HandlerFunc(http.MethodGet, "/stores/:store_id/pages", b.listPagesHandler)
-	router.HandlerFunc(http.MethodPost, "/stores/:store_id/pages", b.createPageHandler)
-	router.HandlerFunc(http.MethodGet, "/stores/:store_id/pages/:page_id", b.showPageHandler)
-	router.HandlerFunc(http.MethodPut, "/stores/:store_id/pages/:page_id", b.updatePageHandler)
-	router.HandlerFunc(http.MethodDelete, "/stores/:store_id/pages/:page_id", b.deletePageHandler)
+	handle(http.MethodGet, "/stores/:store_id/pages", permRead, oauth.ScopePagesRead, b.listPagesHandler)
+	handle(http.MethodPost, "/stores/:store_id/pages", permWrite, oauth.ScopePagesWrite, b.createPageHandler)
+	handle(http.MethodGet, "/stores/:store_id/pages/:page_id", permRead, oauth.ScopePagesRead, b.showPageHandler)
+	handle(http.MethodPut, "/stores/:store_id/pages/:page_id", permWrite, oauth.ScopePagesWrite, b.updatePageHandler)
+	handle(http.MethodDelete, "/stores/:store_id/pages/:page_id", permWrite, oauth.ScopePagesWrite, b.deletePageHandler)
+	handle(http.MethodPost, "/stores/:store_id/pages/:page_id/duplicate", permWrite, oauth.ScopePagesWrite, b.duplicatePageHandler)
+	handle(http.MethodPost, "/stores/:store_id/pages/:page_id/restore", permWrite, oauth.ScopePagesWrite, b.restorePageHandler)
+
+	// Publishing — edits above only touch the working copy until the page is published
+	handle(http.MethodPost, "/stores/:store_id/pages/:page_id/publish", permWrite, oauth.ScopePagesWrite, b.publishPageHandler)
+	handle(http.MethodGet, "/stores/:store_id/pages/:page_id/published", permRead, oauth.ScopePagesRead, b.showPublishedPageHandler)
+
+	// Page history — every save is recorded as a numbered version
+	handle(http.MethodGet, "/stores/:store_id/pages/:page_id/versions", permRead, oauth.ScopePagesRead, b.listPageVersionsHandler)
+	handle(http.MethodGet, "/stores/:store_id/pages/:page_id/versions/:version", permRead, oauth.ScopePagesRead, b.showPageVersionHandler)
+	handle(http.MethodPost, "/stores/:store_id/pages/:page_id/versions/:version/restore", permWrite, oauth.ScopePagesWrite, b.restorePageVersionHandler)
+	handle(http.MethodGet, "/stores/:store_id/pages/:page_id/diff", permRead, oauth.ScopePagesRead, b.diffPageVersionsHandler)
 
 	// Widget routes — nested under store, page_id only where semantically required
-	router.HandlerFunc(http.MethodPost, "/stores/:store_id/pages/:page_id/widgets", b.createWidgetHandler)
-	router.HandlerFunc(http.MethodPost, "/stores/:store_id/pages/:page_id/widgets/reorder", b.reorderWidgetsHandler)
-	router.HandlerFunc(http.MethodPut, "/stores/:store_id/widgets/:id", b.updateWidgetHandler)
-	router.HandlerFunc(http.MethodDelete, "/stores/:store_id/widgets/:id", b.deleteWidgetHandler)
+	handle(http.MethodPost, "/stores/:store_id/pages/:page_id/widgets", permWrite, oauth.ScopeWidgetsWrite, b.createWidgetHandler)
+	handle(http.MethodPost, "/stores/:store_id/pages/:page_id/widgets/reorder", permWrite, oauth.ScopeWidgetsWrite, b.reorderWidgetsHandler)
+	handle(http.MethodPost, "/stores/:store_id/pages/:page_id/widgets/batch", permWrite, oauth.ScopeWidgetsWrite, b.batchWidgetsHandler)
+	handle(http.MethodPut, "/stores/:store_id/widgets/:id", permWrite, oauth.ScopeWidgetsWrite, b.updateWidgetHandler)
+	handle(http.MethodDelete, "/stores/:store_id/widgets/:id", permWrite, oauth.ScopeWidgetsWrite, b.deleteWidgetHandler)
+	handle(http.MethodPost, "/stores/:store_id/widgets/:id/move", permWrite, oauth.ScopeWidgetsWrite, b.moveWidgetHandler)
+	handle(http.MethodPost, "/stores/:store_id/widgets/:id/restore", permWrite, oauth.ScopeWidgetsWrite, b.restoreWidgetHandler)
+
+	// Store members — each user's role in the store
+	handle(http.MethodGet, "/stores/:store_id/members", permRead, oauth.ScopeMembersRead, b.listMembersHandler)
+	handle(http.MethodPost, "/stores/:store_id/members", permOwn, oauth.ScopeMembersWrite, b.addMemberHandler)
+	handle(http.MethodPut, "/stores/:store_id/members/:user_id", permOwn, oauth.ScopeMembersWrite, b.updateMemberHandler)
+	handle(http.MethodDelete, "/stores/:store_id/members/:user_id", permOwn, oauth.ScopeMembersWrite, b.removeMemberHandler)
+
+	// Invitations — the token goes to the invited email, which accepts it once
+	handle(http.MethodGet, "/stores/:store_id/invitations", permOwn, oauth.ScopeMembersRead, b.listInvitationsHandler)
+	handle(http.MethodPost, "/stores/:store_id/invitations", permOwn, oauth.ScopeMembersWrite, b.createInvitationHandler)
+	handle(http.MethodDelete, "/stores/:store_id/invitations/:invitation_id", permOwn, oauth.ScopeMembersWrite, b.revokeInvitationHandler)
+	handle(http.MethodPost, "/invitations/:token/accept", permNone, "", b.acceptInvitationHandler)
+
+	// Trash — deletes are soft until the trash is purged past its retention
+	handle(http.MethodGet, "/stores/:store_id/trash", permRead, oauth.ScopeStoresRead, b.showTrashHandler)
+
+	// Audit log — every change made through the routes above, newest first
+	handle(http.MethodGet, "/stores/:store_id/audit", permRead, oauth.ScopeStoresRead, b.listAuditHandler)
+
+	// Widget type registry — built-in types plus the store's own
+	handle(http.MethodGet, "/stores/:store_id/widget-types", permRead, oauth.ScopeWidgetsRead, b.listWidgetTypesHandler)
+	handle(http.MethodPost, "/stores/:store_id/widget-types", permWrite, oauth.ScopeWidgetsWrite, b.createWidgetTypeHandler)
+	handle(http.MethodGet, "/stores/:store_id/widget-types/:type_id", permRead, oauth.ScopeWidgetsRead, b.showWidgetTypeHandler)
+	handle(http.MethodPut, "/stores/:store_id/widget-types/:type_id", permWrite, oauth.ScopeWidgetsWrite, b.updateWidgetTypeHandler)
+	handle(http.MethodDelete, "/stores/:store_id/widget-types/:type_id", permWrite, oauth.ScopeWidgetsWrite, b.deleteWidgetTypeHandler)
+
+	// Sessions — logging out ends the session the request is made with
+	handle(http.MethodDelete, "/sessions", permNone, "", b.deleteSessionHandler)
+
+	// API keys — the key itself is only ever shown in the response to its creation
+	handleFull(http.MethodGet, "/api-keys", b.listApiKeysHandler)
+	handleFull(http.MethodPost, "/api-keys", b.createApiKeyHandler)
+	handleFull(http.MethodDelete, "/api-keys/:key_id", b.revokeApiKeyHandler)
+
+	// OAuth clients — like API keys, the secret is only shown once
+	handleFull(http.MethodGet, "/oauth/clients", b.listOAuthClientsHandler)
+	handleFull(http.MethodPost, "/oauth/clients", b.createOAuthClientHandler)
+	handleFull(http.MethodDelete, "/oauth/clients/:id", b.revokeOAuthClientHandler)
+
+	// Public, read-only routes used by the mobile app — published content only
+	router.HandlerFunc(http.MethodGet, "/public/:slug/manifest", b.showManifestHandler)
+	router.HandlerFunc(http.MethodGet, "/public/:slug/resolve", b.resolveRouteHandler)
 
-	return b.recoverPanic(b.enableCors(b.logRequest(router)))
+	return b.recoverPanic(b.requestId(b.enableCors(b.logRequest(b.queryTimeout(b.authenticate(router))))))
 }
 
 func (b *backend) healthcheckHandler(w http.ResponseWriter, _ *http.Request) {
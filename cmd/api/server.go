@@ -2,10 +2,13 @@ package main
 
 import (
 	"appdrop/internal/data"
+	"appdrop/internal/mailer"
+	"appdrop/internal/oauth"
 	"context"
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -21,21 +24,56 @@ type config struct {
 		maxOpenConns int
 		maxIdleTime  time.Duration
 		maxIdleConns int
+		queryTimeout time.Duration
 	}
 	cors struct {
 		allowedOrigins []string
 	}
+	routes struct {
+		reservedPrefixes []string
+	}
+	trash struct {
+		retention     time.Duration
+		purgeInterval time.Duration
+	}
+	sessions struct {
+		ttl time.Duration
+	}
+	invitations struct {
+		ttl time.Duration
+	}
+	smtp struct {
+		host     string
+		port     int
+		username string
+		password string
+		sender   string
+	}
+	oauth struct {
+		alg      string
+		issuer   string
+		keys     string
+		tokenTTL time.Duration
+	}
 }
 
 type backend struct {
 	logger *slog.Logger
 	conf   config
 	models data.Models
+	mailer mailer.Mailer
+	tokens *oauth.KeySet
 	wg     sync.WaitGroup
 }
 
 func (b *backend) serve() error {
+	// Every request context derives from baseCtx, so cancelling it aborts the
+	// queries of requests still running when the shutdown grace period ends.
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
+
 	srv := &http.Server{
+		BaseContext:       func(net.Listener) context.Context { return baseCtx },
 		ErrorLog:          slog.NewLogLogger(b.logger.Handler(), slog.LevelError),
 		Addr:              fmt.Sprintf(":%d", b.conf.port),
 		Handler:           b.routes(),
@@ -45,6 +83,12 @@ func (b *backend) serve() error {
 	}
 	shutdownErr := make(chan error, 1)
 
+	// Background tasks stop once the server has drained its requests.
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	b.background(func() { b.purgeTrash(bgCtx, b.conf.trash.purgeInterval) })
+
 	go func() {
 		quit := make(chan os.Signal, 1)
 		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -55,9 +99,11 @@ func (b *backend) serve() error {
 		defer cancel()
 
 		if err := srv.Shutdown(ctx); err != nil {
+			cancelBase()
 			shutdownErr <- err
 		}
 		b.logger.Info("completing background tasks", "addr", srv.Addr)
+		stopBackground()
 		b.wg.Wait()
 		shutdownErr <- nil
 	}()
@@ -75,3 +121,18 @@ func (b *backend) serve() error {
 	b.logger.Info("server stopped", "addr", srv.Addr)
 	return nil
 }
+
+// background runs fn in its own goroutine, which serve waits for before it
+// returns, and recovers from its panics.
+func (b *backend) background(fn func()) {
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		defer func() {
+			if err := recover(); err != nil {
+				b.logger.Error(fmt.Sprintf("%v", err))
+			}
+		}()
+		fn()
+	}()
+}
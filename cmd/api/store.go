@@ -2,20 +2,47 @@ package main
 
 import (
 	"appdrop/internal/data"
+	"appdrop/internal/validator"
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/google/uuid"
 )
 
 func (b *backend) listStoresHandler(w http.ResponseWriter, r *http.Request) {
-	stores, err := b.models.Stores.GetAll()
+	v := validator.New()
+	qs := r.URL.Query()
+	filter := data.StoreFilter{Template: b.readBool(qs, "template", v)}
+	deleted := b.readBool(qs, "deleted", v)
+
+	if !v.Valid() {
+		b.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	var stores []*data.Store
+	var err error
+	if deleted != nil && *deleted {
+		// The trash is listed as is, it takes no other filter.
+		stores, err = b.models.Trash.GetStores(r.Context())
+	} else {
+		stores, err = b.models.Stores.GetAll(r.Context(), filter)
+	}
 	if err != nil {
 		b.serverErrorResponse(w, r, err)
 		return
 	}
+	// A key restricted to some stores, or a user, only gets to see those they
+	// can read.
+	visible, err := b.visibleStores(r)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+		return
+	}
+	stores = slices.DeleteFunc(stores, func(s *data.Store) bool { return !visible(s.Id) })
+
 	if err = b.writeJson(w, http.StatusOK, envelope{"stores": stores}, nil); err != nil {
 		b.serverErrorResponse(w, r, err)
 	}
@@ -27,7 +54,7 @@ func (b *backend) showStoreHandler(w http.ResponseWriter, r *http.Request) {
 		b.badRequestResponse(w, r, err)
 		return
 	}
-	store, err := b.models.Stores.Get(id)
+	store, err := b.models.Stores.Get(r.Context(), id)
 	if err != nil {
 		if errors.Is(err, data.ErrRecordNotFound) {
 			b.notFoundResponse(w, r)
@@ -36,15 +63,26 @@ func (b *backend) showStoreHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	if err = b.writeJson(w, http.StatusOK, envelope{"store": store}, nil); err != nil {
+	headers := make(http.Header)
+	headers.Set("ETag", versionETag(store.Version))
+
+	if err = b.writeJson(w, http.StatusOK, envelope{"store": store}, headers); err != nil {
 		b.serverErrorResponse(w, r, err)
 	}
 }
 
+// createStoreHandler handles POST /stores
+//
+// A user who creates a store becomes its owner.
 func (b *backend) createStoreHandler(w http.ResponseWriter, r *http.Request) {
+	if !b.canCreateStores(r) {
+		b.notPermittedResponse(w, r)
+		return
+	}
 	var input struct {
-		Name string `json:"name"`
-		Slug string `json:"slug"`
+		Name       string `json:"name"`
+		Slug       string `json:"slug"`
+		IsTemplate bool   `json:"is_template"`
 	}
 	if err := b.readJson(w, r, &input); err != nil {
 		b.badRequestResponse(w, r, err)
@@ -60,12 +98,22 @@ func (b *backend) createStoreHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	store := &data.Store{
-		Id:   uuid.New(),
-		Name: input.Name,
-		Slug: input.Slug,
+		Id:         uuid.New(),
+		Name:       input.Name,
+		Slug:       input.Slug,
+		IsTemplate: input.IsTemplate,
 	}
-	if err := b.models.Stores.Insert(store); err != nil {
-		if strings.Contains(err.Error(), "slug already exists") {
+	err := b.models.InTx(r.Context(), func(m data.Models) error {
+		if err := m.Stores.Insert(r.Context(), store); err != nil {
+			return err
+		}
+		if err := b.audit(r, m, store.Id, data.AuditStore, store.Id, "create", nil, store); err != nil {
+			return err
+		}
+		return b.addCreator(r, m, store.Id)
+	})
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicateSlug) {
 			b.conflictResponse(w, r, err.Error())
 		} else {
 			b.serverErrorResponse(w, r, err)
@@ -75,8 +123,9 @@ func (b *backend) createStoreHandler(w http.ResponseWriter, r *http.Request) {
 
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/stores/%s", store.Id))
+	headers.Set("ETag", versionETag(store.Version))
 
-	if err := b.writeJson(w, http.StatusCreated, envelope{"store": store}, headers); err != nil {
+	if err = b.writeJson(w, http.StatusCreated, envelope{"store": store}, headers); err != nil {
 		b.serverErrorResponse(w, r, err)
 	}
 }
@@ -87,7 +136,7 @@ func (b *backend) updateStoreHandler(w http.ResponseWriter, r *http.Request) {
 		b.badRequestResponse(w, r, err)
 		return
 	}
-	store, err := b.models.Stores.Get(id)
+	store, err := b.models.Stores.Get(r.Context(), id)
 	if err != nil {
 		if errors.Is(err, data.ErrRecordNotFound) {
 			b.notFoundResponse(w, r)
@@ -96,14 +145,21 @@ func (b *backend) updateStoreHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	if !ifMatch(r, store.Version) {
+		b.preconditionFailedResponse(w, r)
+		return
+	}
 	var input struct {
-		Name *string `json:"name"`
-		Slug *string `json:"slug"`
+		Name       *string `json:"name"`
+		Slug       *string `json:"slug"`
+		IsTemplate *bool   `json:"is_template"`
 	}
 	if err = b.readJson(w, r, &input); err != nil {
 		b.badRequestResponse(w, r, err)
 		return
 	}
+	before := *store
+
 	if input.Name != nil {
 		if strings.TrimSpace(*input.Name) == "" {
 			b.validationErrorResponse(w, r, "store name cannot be empty")
@@ -118,19 +174,105 @@ func (b *backend) updateStoreHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		store.Slug = *input.Slug
 	}
+	if input.IsTemplate != nil {
+		store.IsTemplate = *input.IsTemplate
+	}
+
+	err = b.models.InTx(r.Context(), func(m data.Models) error {
+		if err := m.Stores.Update(r.Context(), store); err != nil {
+			return err
+		}
+		return b.audit(r, m, store.Id, data.AuditStore, store.Id, "update", before, store)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			b.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateSlug):
+			b.conflictResponse(w, r, err.Error())
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("ETag", versionETag(store.Version))
+
+	if err = b.writeJson(w, http.StatusOK, envelope{"store": store}, headers); err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// cloneStoreHandler handles POST /stores/:store_id/clone
+//
+// The new store gets the given name and slug and copies of the store's widget
+// types, pages and widgets. Each copied page starts its own history. A user
+// who clones a store becomes the owner of the copy.
+func (b *backend) cloneStoreHandler(w http.ResponseWriter, r *http.Request) {
+	if !b.canCreateStores(r) {
+		b.notPermittedResponse(w, r)
+		return
+	}
+	sourceId, err := b.readIdParam(r, "store_id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	var input struct {
+		Name string `json:"name"`
+		Slug string `json:"slug"`
+	}
+	if err = b.readJson(w, r, &input); err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	if strings.TrimSpace(input.Name) == "" {
+		b.validationErrorResponse(w, r, "store name is required")
+		return
+	}
+	if strings.TrimSpace(input.Slug) == "" {
+		b.validationErrorResponse(w, r, "store slug is required")
+		return
+	}
+	store := &data.Store{
+		Id:   uuid.New(),
+		Name: input.Name,
+		Slug: input.Slug,
+	}
+	var pages []*data.Page
 
-	if err = b.models.Stores.Update(store); err != nil {
+	err = b.models.InTx(r.Context(), func(m data.Models) error {
+		var err error
+		if pages, err = m.Stores.Clone(r.Context(), sourceId, store); err != nil {
+			return err
+		}
+		for _, page := range pages {
+			if _, err = m.PageVersions.Record(r.Context(), page.Id); err != nil {
+				return err
+			}
+		}
+		if err = b.audit(r, m, store.Id, data.AuditStore, store.Id, "clone", nil, store); err != nil {
+			return err
+		}
+		return b.addCreator(r, m, store.Id)
+	})
+	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			b.notFoundResponse(w, r)
-		case strings.Contains(err.Error(), "slug already exists"):
+		case errors.Is(err, data.ErrDuplicateSlug):
 			b.conflictResponse(w, r, err.Error())
 		default:
 			b.serverErrorResponse(w, r, err)
 		}
 		return
 	}
-	if err = b.writeJson(w, http.StatusOK, envelope{"store": store}, nil); err != nil {
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/stores/%s", store.Id))
+	headers.Set("ETag", versionETag(store.Version))
+
+	err = b.writeJson(w, http.StatusCreated, envelope{"store": store, "pages": pages}, headers)
+	if err != nil {
 		b.serverErrorResponse(w, r, err)
 	}
 }
@@ -141,10 +283,31 @@ func (b *backend) deleteStoreHandler(w http.ResponseWriter, r *http.Request) {
 		b.badRequestResponse(w, r, err)
 		return
 	}
-	if err = b.models.Stores.Delete(id); err != nil {
+	store, err := b.models.Stores.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			b.notFoundResponse(w, r)
+		} else {
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !ifMatch(r, store.Version) {
+		b.preconditionFailedResponse(w, r)
+		return
+	}
+	err = b.models.InTx(r.Context(), func(m data.Models) error {
+		if err := m.Stores.Delete(r.Context(), id, store.Version); err != nil {
+			return err
+		}
+		return b.audit(r, m, store.Id, data.AuditStore, store.Id, "delete", store, nil)
+	})
+	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			b.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			b.editConflictResponse(w, r)
 		default:
 			b.serverErrorResponse(w, r, err)
 		}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"appdrop/internal/data"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// showTrashHandler handles GET /stores/:store_id/trash
+func (b *backend) showTrashHandler(w http.ResponseWriter, r *http.Request) {
+	storeId, err := b.readIdParam(r, "store_id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	trash, err := b.models.Trash.GetForStore(r.Context(), storeId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = b.writeJson(w, http.StatusOK, envelope{"trash": trash, "retention": b.conf.trash.retention.String()}, nil)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// restoreStoreHandler handles POST /stores/:store_id/restore
+//
+// The store comes back with its slug, or with the one in the body if another
+// store has taken it since.
+func (b *backend) restoreStoreHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := b.readIdParam(r, "store_id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	var input struct {
+		Slug *string `json:"slug"`
+	}
+	if r.ContentLength != 0 {
+		if err = b.readJson(w, r, &input); err != nil {
+			b.badRequestResponse(w, r, err)
+			return
+		}
+	}
+	if input.Slug != nil && strings.TrimSpace(*input.Slug) == "" {
+		b.validationErrorResponse(w, r, "store slug must not be empty")
+		return
+	}
+	slug := ""
+	if input.Slug != nil {
+		slug = *input.Slug
+	}
+	var store *data.Store
+
+	err = b.models.InTx(r.Context(), func(m data.Models) error {
+		if store, err = m.Trash.RestoreStore(r.Context(), id, slug); err != nil {
+			return err
+		}
+		return b.audit(r, m, store.Id, data.AuditStore, store.Id, "restore", nil, store)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateSlug):
+			b.conflictResponse(w, r, "store slug is taken by another store, restore it with a new slug")
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("ETag", versionETag(store.Version))
+
+	if err = b.writeJson(w, http.StatusOK, envelope{"store": store}, headers); err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// restorePageHandler handles POST /stores/:store_id/pages/:page_id/restore
+//
+// The page comes back with its route, or with the one in the body if it now
+// conflicts with another page. It is never restored as the home page.
+func (b *backend) restorePageHandler(w http.ResponseWriter, r *http.Request) {
+	storeId, err := b.readIdParam(r, "store_id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	pageId, err := b.readIdParam(r, "page_id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	var input struct {
+		Route *string `json:"route"`
+	}
+	if r.ContentLength != 0 {
+		if err = b.readJson(w, r, &input); err != nil {
+			b.badRequestResponse(w, r, err)
+			return
+		}
+	}
+	page, err := b.models.Trash.GetPage(r.Context(), pageId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if page.StoreId != storeId {
+		b.notFoundResponse(w, r)
+		return
+	}
+	raw := page.Route
+	if input.Route != nil {
+		raw = *input.Route
+	}
+	route, ok := b.checkPageRoute(w, r, page, raw)
+	if !ok {
+		return
+	}
+	before := *page
+
+	err = b.savePage(r.Context(), pageId, func(m data.Models) error {
+		if page, err = m.Trash.RestorePage(r.Context(), pageId, route); err != nil {
+			return err
+		}
+		return b.audit(r, m, page.StoreId, data.AuditPage, page.Id, "restore", before, page)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateRoute):
+			b.conflictResponse(w, r, fmt.Sprintf("page route %s is taken by another page, restore it with a new route", route))
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("ETag", versionETag(page.Version))
+
+	if err = b.writeJson(w, http.StatusOK, envelope{"page": page}, headers); err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// restoreWidgetHandler handles POST /stores/:store_id/widgets/:id/restore
+//
+// Only widgets deleted on their own are restored this way; the widgets of a
+// deleted page come back with the page.
+func (b *backend) restoreWidgetHandler(w http.ResponseWriter, r *http.Request) {
+	storeId, err := b.readIdParam(r, "store_id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	widgetId, err := b.readIdParam(r, "id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	widget, err := b.models.Trash.GetWidget(r.Context(), widgetId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// Verify the widget's page belongs to this store
+	page, err := b.models.Pages.Get(r.Context(), widget.PageId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if page.StoreId != storeId {
+		b.notFoundResponse(w, r)
+		return
+	}
+	before := *widget
+
+	err = b.savePage(r.Context(), widget.PageId, func(m data.Models) error {
+		if widget, err = m.Trash.RestoreWidget(r.Context(), widgetId); err != nil {
+			return err
+		}
+		return b.audit(r, m, storeId, data.AuditWidget, widget.Id, "restore", before, widget)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("ETag", versionETag(widget.Version))
+
+	if err = b.writeJson(w, http.StatusOK, envelope{"widget": widget}, headers); err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// purgeTrash deletes for good, every interval until ctx is done, what has been
+// in the trash for longer than the retention period, and sessions that have
+// expired along with it.
+func (b *backend) purgeTrash(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		qctx, cancel := context.WithTimeout(ctx, b.conf.db.queryTimeout)
+		purged, err := b.models.Trash.Purge(qctx, time.Now().Add(-b.conf.trash.retention))
+		cancel()
+		if err != nil {
+			b.logger.Error("purging trash", "err", err)
+			continue
+		}
+		if purged > 0 {
+			b.logger.Info("purged trash", "count", purged)
+		}
+		qctx, cancel = context.WithTimeout(ctx, b.conf.db.queryTimeout)
+		expired, err := b.models.Sessions.DeleteExpired(qctx, time.Now())
+		cancel()
+		if err != nil {
+			b.logger.Error("deleting expired sessions", "err", err)
+			continue
+		}
+		if expired > 0 {
+			b.logger.Info("deleted expired sessions", "count", expired)
+		}
+	}
+}
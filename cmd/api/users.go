@@ -0,0 +1,138 @@
+package main
+
+import (
+	"appdrop/internal/data"
+	"appdrop/internal/validator"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// registerUserHandler handles POST /users
+//
+// A new user is a member of no store until an owner adds them, or they create
+// one.
+func (b *backend) registerUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name     string `json:"name"`
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := b.readJson(w, r, &input); err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	user := &data.User{
+		Name:  strings.TrimSpace(input.Name),
+		Email: normalizeEmail(input.Email),
+	}
+	v := validator.New()
+	v.Check(user.Name != "", "name", "must be provided")
+	v.Check(validator.Matches(user.Email, validator.EmailRX), "email", "must be a valid email address")
+	// bcrypt only hashes the first 72 bytes.
+	v.Check(len(input.Password) >= 8, "password", "must be at least 8 bytes long")
+	v.Check(len(input.Password) <= 72, "password", "must not be more than 72 bytes long")
+
+	if !v.Valid() {
+		b.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if err := user.Password.Set(input.Password); err != nil {
+		b.serverErrorResponse(w, r, err)
+		return
+	}
+	if err := b.models.Users.Insert(r.Context(), user); err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			b.failedValidationResponse(w, r, map[string]string{"email": "a user with this email address already exists"})
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if err := b.writeJson(w, http.StatusCreated, envelope{"user": user}, nil); err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// createSessionHandler handles POST /sessions
+//
+// Logging in returns a session token, which authenticates the requests of the
+// user as a bearer token until it expires or the user logs out.
+func (b *backend) createSessionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := b.readJson(w, r, &input); err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(strings.TrimSpace(input.Email) != "", "email", "must be provided")
+	v.Check(input.Password != "", "password", "must be provided")
+
+	if !v.Valid() {
+		b.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	user, err := b.models.Users.GetByEmail(r.Context(), normalizeEmail(input.Email))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			if err = data.MatchNoPassword(input.Password); err != nil {
+				b.serverErrorResponse(w, r, err)
+				return
+			}
+			b.invalidCredentialsResponse(w, r)
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+		return
+	}
+	if !match {
+		b.invalidCredentialsResponse(w, r)
+		return
+	}
+	session, err := data.GenerateSession(user.Id, b.conf.sessions.ttl)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+		return
+	}
+	if err = b.models.Sessions.Insert(r.Context(), session); err != nil {
+		b.serverErrorResponse(w, r, err)
+		return
+	}
+	if err = b.writeJson(w, http.StatusCreated, envelope{"session": session, "user": user}, nil); err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteSessionHandler handles DELETE /sessions
+//
+// Logging out ends the session the request is authenticated with.
+func (b *backend) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if b.contextGetUser(r) == nil {
+		b.badRequestResponse(w, r, errors.New("only a session token can be logged out"))
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	if err := b.models.Sessions.Delete(r.Context(), token); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.invalidAuthenticationTokenResponse(w, r)
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if err := b.writeJson(w, http.StatusOK, envelope{"message": "session successfully ended"}, nil); err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"appdrop/internal/data"
+	"appdrop/internal/validator"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// maxBatchOperations caps the size of a widget batch.
+const maxBatchOperations = 200
+
+// batchOperation is one entry of a widget batch. Widgets are referred to by
+// id, or by the temp_id a create earlier in the same batch gave them.
+type batchOperation struct {
+	Op       string          `json:"op"`
+	TempId   string          `json:"temp_id"`
+	WidgetId string          `json:"widget_id"`
+	Version  *int            `json:"version"`
+	Type     *string         `json:"type"`
+	Config   *map[string]any `json:"config"`
+	Position *int            `json:"position"`
+	Before   *string         `json:"before"`
+	After    *string         `json:"after"`
+}
+
+// batchError is returned from inside the batch transaction when an operation
+// fails; it rolls the batch back and carries the report for the client.
+type batchError struct {
+	status int
+	code   string
+	opErr  operationError
+}
+
+func (e *batchError) Error() string {
+	return "batch operation failed: " + e.opErr.Message
+}
+
+// batchRefs maps the temp ids of the widgets created so far to their ids.
+type batchRefs map[string]uuid.UUID
+
+// resolve turns a widget reference into an id.
+func (refs batchRefs) resolve(ref string) (uuid.UUID, bool) {
+	if id, ok := refs[ref]; ok {
+		return id, true
+	}
+	id, err := uuid.Parse(ref)
+	return id, err == nil
+}
+
+// placement resolves the before and after references of op.
+func (refs batchRefs) placement(op batchOperation) (data.Placement, map[string]string) {
+	at := data.Placement{Position: op.Position}
+	errs := make(map[string]string)
+
+	for key, ref := range map[string]*string{"before": op.Before, "after": op.After} {
+		if ref == nil {
+			continue
+		}
+		id, ok := refs.resolve(*ref)
+		if !ok {
+			errs[key] = "must be a widget id or the temp_id of an earlier create"
+			continue
+		}
+		if key == "before" {
+			at.Before = &id
+		} else {
+			at.After = &id
+		}
+	}
+	return at, errs
+}
+
+// validateBatch runs the checks that do not depend on the state of the page,
+// so every malformed operation is reported at once.
+func validateBatch(ops []batchOperation) []operationError {
+	var errs []operationError
+	tempIds := make(map[string]bool)
+
+	for i, op := range ops {
+		v := validator.New()
+		v.Check(validator.PermittedValue(op.Op, "create", "update", "delete", "move"), "op",
+			"must be one of create, update, delete or move")
+
+		switch op.Op {
+		case "create":
+			v.Check(op.WidgetId == "", "widget_id", "must not be given for a create")
+			v.Check(op.Type != nil, "type", "must be provided")
+			if op.TempId != "" {
+				v.Check(!tempIds[op.TempId], "temp_id", "is already used by an earlier create")
+				tempIds[op.TempId] = true
+			}
+		case "update", "delete", "move":
+			v.Check(op.WidgetId != "", "widget_id", "must be provided")
+			v.Check(op.TempId == "", "temp_id", "is only allowed on a create")
+		}
+		if op.Op == "delete" || op.Op == "move" {
+			v.Check(op.Type == nil, "type", "is only allowed on a create or update")
+			v.Check(op.Config == nil, "config", "is only allowed on a create or update")
+		}
+		if op.Op == "create" || op.Op == "move" {
+			// The anchors are resolved later; only which of them is set
+			// matters here.
+			at := data.Placement{Position: op.Position}
+			if op.Before != nil {
+				at.Before = &uuid.Nil
+			}
+			if op.After != nil {
+				at.After = &uuid.Nil
+			}
+			validatePlacement(v, at)
+		} else {
+			v.Check(op.Position == nil && op.Before == nil && op.After == nil, "position",
+				"is only allowed on a create or move")
+		}
+		if !v.Valid() {
+			errs = append(errs, operationError{Index: i, Op: op.Op, Message: "invalid operation", Fields: v.Errors})
+		}
+	}
+	return errs
+}
+
+// batchWidgetsHandler handles POST /stores/:store_id/pages/:page_id/widgets/batch
+//
+// The operations are applied in order in one transaction and recorded as a
+// single new version of the page. If any of them fails nothing is applied and
+// the response reports the failing operations.
+func (b *backend) batchWidgetsHandler(w http.ResponseWriter, r *http.Request) {
+	page, ok := b.readStorePage(w, r)
+	if !ok {
+		return
+	}
+	var input struct {
+		Operations []batchOperation `json:"operations"`
+	}
+	err := b.readJson(w, r, &input)
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	switch {
+	case len(input.Operations) == 0:
+		b.validationErrorResponse(w, r, "operations array cannot be empty")
+		return
+	case len(input.Operations) > maxBatchOperations:
+		b.validationErrorResponse(w, r, fmt.Sprintf("a batch can hold at most %d operations", maxBatchOperations))
+		return
+	}
+	if errs := validateBatch(input.Operations); len(errs) > 0 {
+		b.failedBatchResponse(w, r, http.StatusUnprocessableEntity, "VALIDATION_ERROR", errs)
+		return
+	}
+	refs := make(batchRefs)
+	var widgets []*data.Widget
+
+	err = b.savePage(r.Context(), page.Id, func(m data.Models) error {
+		before, err := m.Widgets.GetForPage(r.Context(), page.Id)
+		if err != nil {
+			return err
+		}
+		for i, op := range input.Operations {
+			if err := applyBatchOperation(r.Context(), m, page, refs, op); err != nil {
+				var bErr *batchError
+				if errors.As(err, &bErr) {
+					bErr.opErr.Index, bErr.opErr.Op = i, op.Op
+				}
+				return err
+			}
+		}
+		if widgets, err = m.Widgets.GetForPage(r.Context(), page.Id); err != nil {
+			return err
+		}
+		return b.audit(r, m, page.StoreId, data.AuditPage, page.Id, "batch",
+			envelope{"widgets": before}, envelope{"widgets": widgets})
+	})
+	if err != nil {
+		var bErr *batchError
+		switch {
+		case errors.As(err, &bErr):
+			b.failedBatchResponse(w, r, bErr.status, bErr.code, []operationError{bErr.opErr})
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if widgets == nil {
+		widgets = []*data.Widget{}
+	}
+	err = b.writeJson(w, http.StatusOK, envelope{"widgets": widgets, "created": refs}, nil)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// applyBatchOperation applies op to the page through m.
+func applyBatchOperation(ctx context.Context, m data.Models, page *data.Page, refs batchRefs, op batchOperation) error {
+	invalid := func(fields map[string]string) error {
+		return &batchError{
+			status: http.StatusUnprocessableEntity, code: "VALIDATION_ERROR",
+			opErr: operationError{Message: "invalid operation", Fields: fields},
+		}
+	}
+	conflict := func() error {
+		return &batchError{
+			status: http.StatusConflict, code: "EDIT_CONFLICT",
+			opErr: operationError{Message: "the widget has changed since it was fetched"},
+		}
+	}
+	at, errs := refs.placement(op)
+	if len(errs) > 0 {
+		return invalid(errs)
+	}
+	if op.Op == "create" {
+		widget := &data.Widget{Id: uuid.New(), PageId: page.Id, Type: *op.Type}
+		if op.Config != nil {
+			widget.Config = *op.Config
+		}
+		v, err := validateWidgetWith(ctx, m.WidgetTypes, page.StoreId, widget)
+		if err != nil {
+			return err
+		}
+		if !v.Valid() {
+			return invalid(v.Errors)
+		}
+		err = m.Widgets.InsertAt(ctx, widget, at)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrForeignWidgets):
+				return invalid(map[string]string{placementKey(at): "must be a widget on this page"})
+			case errors.Is(err, data.ErrUnknownWidgetType):
+				return invalid(map[string]string{"type": err.Error()})
+			default:
+				return err
+			}
+		}
+		if op.TempId != "" {
+			refs[op.TempId] = widget.Id
+		}
+		return nil
+	}
+	// update, delete and move work on a widget that must be on this page.
+	id, ok := refs.resolve(op.WidgetId)
+	if !ok {
+		return invalid(map[string]string{"widget_id": "must be a widget id or the temp_id of an earlier create"})
+	}
+	widget, err := m.Widgets.Get(ctx, id)
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		return invalid(map[string]string{"widget_id": "must be a widget on this page"})
+	case err != nil:
+		return err
+	case widget.PageId != page.Id:
+		return invalid(map[string]string{"widget_id": "must be a widget on this page"})
+	case op.Version != nil && *op.Version != widget.Version:
+		return conflict()
+	}
+	switch op.Op {
+	case "update":
+		if op.Type != nil {
+			widget.Type = *op.Type
+		}
+		if op.Config != nil {
+			widget.Config = *op.Config
+		}
+		v, err := validateWidgetWith(ctx, m.WidgetTypes, page.StoreId, widget)
+		if err != nil {
+			return err
+		}
+		if !v.Valid() {
+			return invalid(v.Errors)
+		}
+		err = m.Widgets.Update(ctx, widget)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			return conflict()
+		case errors.Is(err, data.ErrUnknownWidgetType):
+			return invalid(map[string]string{"type": err.Error()})
+		}
+		return err
+	case "delete":
+		if err = m.Widgets.Delete(ctx, widget.Id, widget.Version); errors.Is(err, data.ErrEditConflict) {
+			return conflict()
+		}
+		return err
+	default:
+		err = m.Widgets.Move(ctx, widget, page.Id, at)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			return conflict()
+		case errors.Is(err, data.ErrForeignWidgets):
+			return invalid(map[string]string{placementKey(at): "must be another widget on this page"})
+		}
+		return err
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"appdrop/internal/data"
+	"appdrop/internal/validator"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// listWidgetTypesHandler handles GET /stores/:store_id/widget-types
+func (b *backend) listWidgetTypesHandler(w http.ResponseWriter, r *http.Request) {
+	storeId, err := b.readIdParam(r, "store_id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	if _, err = b.models.Stores.Get(r.Context(), storeId); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	types, err := b.models.WidgetTypes.GetAllForStore(r.Context(), storeId)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+		return
+	}
+	err = b.writeJson(w, http.StatusOK, envelope{"widget_types": types}, nil)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// createWidgetTypeHandler handles POST /stores/:store_id/widget-types
+func (b *backend) createWidgetTypeHandler(w http.ResponseWriter, r *http.Request) {
+	storeId, err := b.readIdParam(r, "store_id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	if _, err = b.models.Stores.Get(r.Context(), storeId); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	var input struct {
+		Name          string            `json:"name"`
+		Label         string            `json:"label"`
+		Schema        data.ConfigSchema `json:"schema"`
+		MinAppVersion string            `json:"min_app_version"`
+		MaxAppVersion string            `json:"max_app_version"`
+	}
+	if err = b.readJson(w, r, &input); err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	wt := &data.WidgetType{
+		Id: uuid.New(), StoreId: &storeId,
+		Name: input.Name, Label: input.Label,
+		Schema:        input.Schema,
+		MinAppVersion: input.MinAppVersion,
+		MaxAppVersion: input.MaxAppVersion,
+	}
+	v := validator.New()
+	if data.ValidateWidgetType(v, wt); !v.Valid() {
+		b.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = b.models.InTx(r.Context(), func(m data.Models) error {
+		if err := m.WidgetTypes.Insert(r.Context(), wt); err != nil {
+			return err
+		}
+		return b.audit(r, m, storeId, data.AuditWidgetType, wt.Id, "create", nil, wt)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateWidgetType):
+			b.conflictResponse(w, r, err.Error())
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/stores/%s/widget-types/%s", storeId, wt.Id))
+
+	err = b.writeJson(w, http.StatusCreated, envelope{"widget_type": wt}, headers)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// showWidgetTypeHandler handles GET /stores/:store_id/widget-types/:type_id
+func (b *backend) showWidgetTypeHandler(w http.ResponseWriter, r *http.Request) {
+	wt, ok := b.readWidgetType(w, r)
+	if !ok {
+		return
+	}
+	err := b.writeJson(w, http.StatusOK, envelope{"widget_type": wt}, nil)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateWidgetTypeHandler handles PUT /stores/:store_id/widget-types/:type_id
+func (b *backend) updateWidgetTypeHandler(w http.ResponseWriter, r *http.Request) {
+	wt, ok := b.readWidgetType(w, r)
+	if !ok {
+		return
+	}
+	if wt.BuiltIn() {
+		b.conflictResponse(w, r, "built-in widget types cannot be modified")
+		return
+	}
+	var input struct {
+		Label         *string            `json:"label"`
+		Schema        *data.ConfigSchema `json:"schema"`
+		MinAppVersion *string            `json:"min_app_version"`
+		MaxAppVersion *string            `json:"max_app_version"`
+	}
+	if err := b.readJson(w, r, &input); err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	before := *wt
+
+	if input.Label != nil {
+		wt.Label = *input.Label
+	}
+	if input.Schema != nil {
+		wt.Schema = *input.Schema
+	}
+	if input.MinAppVersion != nil {
+		wt.MinAppVersion = *input.MinAppVersion
+	}
+	if input.MaxAppVersion != nil {
+		wt.MaxAppVersion = *input.MaxAppVersion
+	}
+	v := validator.New()
+	if data.ValidateWidgetType(v, wt); !v.Valid() {
+		b.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err := b.models.InTx(r.Context(), func(m data.Models) error {
+		if err := m.WidgetTypes.Update(r.Context(), wt); err != nil {
+			return err
+		}
+		return b.audit(r, m, *wt.StoreId, data.AuditWidgetType, wt.Id, "update", before, wt)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = b.writeJson(w, http.StatusOK, envelope{"widget_type": wt}, nil)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteWidgetTypeHandler handles DELETE /stores/:store_id/widget-types/:type_id
+func (b *backend) deleteWidgetTypeHandler(w http.ResponseWriter, r *http.Request) {
+	wt, ok := b.readWidgetType(w, r)
+	if !ok {
+		return
+	}
+	if wt.BuiltIn() {
+		b.conflictResponse(w, r, "built-in widget types cannot be deleted")
+		return
+	}
+	err := b.models.InTx(r.Context(), func(m data.Models) error {
+		if err := m.WidgetTypes.Delete(r.Context(), wt.Id); err != nil {
+			return err
+		}
+		return b.audit(r, m, *wt.StoreId, data.AuditWidgetType, wt.Id, "delete", wt, nil)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrWidgetTypeInUse):
+			b.conflictResponse(w, r, err.Error())
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = b.writeJson(w, http.StatusOK, envelope{"message": "widget type successfully deleted"}, nil)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// readWidgetType loads the widget type named by the URL and checks that it is
+// visible to the store. It writes the error response itself and reports
+// whether the handler should continue.
+func (b *backend) readWidgetType(w http.ResponseWriter, r *http.Request) (*data.WidgetType, bool) {
+	storeId, err := b.readIdParam(r, "store_id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return nil, false
+	}
+	typeId, err := b.readIdParam(r, "type_id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return nil, false
+	}
+	wt, err := b.models.WidgetTypes.Get(r.Context(), typeId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	if !wt.BuiltIn() && *wt.StoreId != storeId {
+		b.notFoundResponse(w, r)
+		return nil, false
+	}
+	return wt, true
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"appdrop/internal/data"
+	"appdrop/internal/validator"
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -9,15 +11,48 @@ import (
 	"github.com/google/uuid"
 )
 
-// todo: multiple places to use transactions (later)
+// validateWidget checks the widget against the widget type registry of the
+// store. The returned validator holds the per-field errors, if any.
+func (b *backend) validateWidget(ctx context.Context, storeId uuid.UUID, widget *data.Widget) (*validator.Validator, error) {
+	return validateWidgetWith(ctx, b.models.WidgetTypes, storeId, widget)
+}
+
+// validateWidgetWith is validateWidget against the given registry, which lets
+// a unit of work check widgets against the types it sees.
+func validateWidgetWith(ctx context.Context, types data.WidgetTypeRepository, storeId uuid.UUID, widget *data.Widget) (*validator.Validator, error) {
+	wt, err := types.Lookup(ctx, storeId, widget.Type)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		return nil, err
+	}
+	v := validator.New()
+	data.ValidateWidget(v, widget, wt)
+	return v, nil
+}
+
+// validatePlacement checks that at most one way of placing a widget is used.
+func validatePlacement(v *validator.Validator, at data.Placement) {
+	set := 0
+	for _, given := range []bool{at.Position != nil, at.Before != nil, at.After != nil} {
+		if given {
+			set++
+		}
+	}
+	v.Check(set <= 1, placementKey(at), "only one of position, before and after may be given")
+	if at.Position != nil {
+		v.Check(*at.Position >= 0, "position", "must not be negative")
+	}
+}
 
-// Allowed widget types as per requirements
-var allowedWidgetTypes = map[string]bool{
-	"banner":       true,
-	"product_grid": true,
-	"text":         true,
-	"image":        true,
-	"spacer":       true,
+// placementKey names the input field a placement came from.
+func placementKey(at data.Placement) string {
+	switch {
+	case at.Before != nil:
+		return "before"
+	case at.After != nil:
+		return "after"
+	default:
+		return "position"
+	}
 }
 
 // createWidgetHandler handles POST /pages/:id/widgets
@@ -33,7 +68,7 @@ func (b *backend) createWidgetHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Verify page exists
-	page, err := b.models.Pages.Get(pageId)
+	page, err := b.models.Pages.Get(r.Context(), pageId)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -47,32 +82,59 @@ func (b *backend) createWidgetHandler(w http.ResponseWriter, r *http.Request) {
 		b.notFoundResponse(w, r)
 		return
 	}
+	// The widget is appended unless one of position, before or after says
+	// otherwise; the widgets from there on move down by one.
 	var input struct {
-		Type   string         `json:"type"`
-		Config map[string]any `json:"config"`
+		Type     string         `json:"type"`
+		Config   map[string]any `json:"config"`
+		Position *int           `json:"position"`
+		Before   *uuid.UUID     `json:"before"`
+		After    *uuid.UUID     `json:"after"`
 	}
 	err = b.readJson(w, r, &input)
 	if err != nil {
 		b.badRequestResponse(w, r, err)
 		return
 	}
-	// Validate widget type
-	if !allowedWidgetTypes[input.Type] {
-		b.validationErrorResponse(w, r, "widget type must be one of: banner, product_grid, text, image, spacer")
-		return
-	}
 	widget := &data.Widget{
 		Id: uuid.New(), PageId: pageId,
 		Type:   input.Type,
 		Config: input.Config,
 	}
-	err = b.models.Widgets.Insert(widget)
+	v, err := b.validateWidget(r.Context(), storeId, widget)
 	if err != nil {
 		b.serverErrorResponse(w, r, err)
 		return
 	}
+	at := data.Placement{Position: input.Position, Before: input.Before, After: input.After}
+	validatePlacement(v, at)
+
+	if !v.Valid() {
+		b.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = b.savePage(r.Context(), pageId, func(m data.Models) error {
+		if err := m.Widgets.InsertAt(r.Context(), widget, at); err != nil {
+			return err
+		}
+		return b.audit(r, m, storeId, data.AuditWidget, widget.Id, "create", nil, widget)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrForeignWidgets):
+			b.failedValidationResponse(w, r, map[string]string{placementKey(at): "must be a widget on this page"})
+		case errors.Is(err, data.ErrUnknownWidgetType):
+			b.failedValidationResponse(w, r, map[string]string{"type": err.Error()})
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/stores/%s/widgets/%s", storeId, widget.Id))
+	headers.Set("ETag", versionETag(widget.Version))
 
 	err = b.writeJson(w, http.StatusCreated, envelope{"widget": widget}, headers)
 	if err != nil {
@@ -87,13 +149,23 @@ func (b *backend) updateWidgetHandler(w http.ResponseWriter, r *http.Request) {
 		b.badRequestResponse(w, r, err)
 		return
 	}
-	pageId, err := b.readIdParam(r, "page_id")
+	widgetId, err := b.readIdParam(r, "id")
 	if err != nil {
 		b.badRequestResponse(w, r, err)
 		return
 	}
-	// Verify page exists
-	page, err := b.models.Pages.Get(pageId)
+	widget, err := b.models.Widgets.Get(r.Context(), widgetId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// Verify the widget's page belongs to this store
+	page, err := b.models.Pages.Get(r.Context(), widget.PageId)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -107,9 +179,8 @@ func (b *backend) updateWidgetHandler(w http.ResponseWriter, r *http.Request) {
 		b.notFoundResponse(w, r)
 		return
 	}
-	widgetId, err := b.readIdParam(r, "id")
-	if err != nil {
-		b.badRequestResponse(w, r, err)
+	if !ifMatch(r, widget.Version) {
+		b.preconditionFailedResponse(w, r)
 		return
 	}
 	var input struct {
@@ -121,7 +192,64 @@ func (b *backend) updateWidgetHandler(w http.ResponseWriter, r *http.Request) {
 		b.badRequestResponse(w, r, err)
 		return
 	}
-	widget, err := b.models.Widgets.Get(widgetId)
+	before := *widget
+
+	if input.Type != nil {
+		widget.Type = *input.Type
+	}
+	if input.Config != nil {
+		widget.Config = *input.Config
+	}
+	// The whole resulting config is checked, so changing the type without a
+	// matching config is rejected as well.
+	v, err := b.validateWidget(r.Context(), storeId, widget)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		b.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = b.savePage(r.Context(), widget.PageId, func(m data.Models) error {
+		if err := m.Widgets.Update(r.Context(), widget); err != nil {
+			return err
+		}
+		return b.audit(r, m, storeId, data.AuditWidget, widget.Id, "update", before, widget)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			b.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrUnknownWidgetType):
+			b.failedValidationResponse(w, r, map[string]string{"type": err.Error()})
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("ETag", versionETag(widget.Version))
+
+	err = b.writeJson(w, http.StatusOK, envelope{"widget": widget}, headers)
+	if err != nil {
+		b.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteWidgetHandler handles DELETE /widgets/:id
+func (b *backend) deleteWidgetHandler(w http.ResponseWriter, r *http.Request) {
+	storeId, err := b.readIdParam(r, "store_id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	widgetId, err := b.readIdParam(r, "id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	widget, err := b.models.Widgets.Get(r.Context(), widgetId)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -129,44 +257,66 @@ func (b *backend) updateWidgetHandler(w http.ResponseWriter, r *http.Request) {
 		default:
 			b.serverErrorResponse(w, r, err)
 		}
+		return
 	}
-	if input.Type != nil {
-		if !allowedWidgetTypes[*input.Type] {
-			b.validationErrorResponse(
-				w, r,
-				"widget type must be one of: banner, product_grid, text, image, spacer",
-			)
-			return
+	// Verify the widget's page belongs to this store
+	page, err := b.models.Pages.Get(r.Context(), widget.PageId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		default:
+			b.serverErrorResponse(w, r, err)
 		}
-		widget.Type = *input.Type
+		return
 	}
-	if input.Config != nil {
-		widget.Config = *input.Config
+	if page.StoreId != storeId {
+		b.notFoundResponse(w, r)
+		return
+	}
+	if !ifMatch(r, widget.Version) {
+		b.preconditionFailedResponse(w, r)
+		return
 	}
-	err = b.models.Widgets.Update(widget)
+	err = b.savePage(r.Context(), widget.PageId, func(m data.Models) error {
+		if err := m.Widgets.Delete(r.Context(), widgetId, widget.Version); err != nil {
+			return err
+		}
+		return b.audit(r, m, storeId, data.AuditWidget, widget.Id, "delete", widget, nil)
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			b.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			b.editConflictResponse(w, r)
 		default:
 			b.serverErrorResponse(w, r, err)
 		}
 		return
 	}
-	err = b.writeJson(w, http.StatusOK, envelope{"widget": widget}, nil)
+	err = b.writeJson(w, http.StatusOK, envelope{"message": "widget successfully deleted"}, nil)
 	if err != nil {
 		b.serverErrorResponse(w, r, err)
 	}
 }
 
-// deleteWidgetHandler handles DELETE /widgets/:id
-func (b *backend) deleteWidgetHandler(w http.ResponseWriter, r *http.Request) {
+// moveWidgetHandler handles POST /stores/:store_id/widgets/:id/move
+//
+// The widget goes to page_id, which must be a page of the same store, at the
+// end or where position, before or after says. Both pages get a new version.
+func (b *backend) moveWidgetHandler(w http.ResponseWriter, r *http.Request) {
+	storeId, err := b.readIdParam(r, "store_id")
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
 	widgetId, err := b.readIdParam(r, "id")
 	if err != nil {
 		b.badRequestResponse(w, r, err)
 		return
 	}
-	err = b.models.Widgets.Delete(widgetId)
+	widget, err := b.models.Widgets.Get(r.Context(), widgetId)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -176,7 +326,75 @@ func (b *backend) deleteWidgetHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	err = b.writeJson(w, http.StatusOK, envelope{"message": "widget successfully deleted"}, nil)
+	// Verify the widget's page belongs to this store
+	page, err := b.models.Pages.Get(r.Context(), widget.PageId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			b.notFoundResponse(w, r)
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if page.StoreId != storeId {
+		b.notFoundResponse(w, r)
+		return
+	}
+	if !ifMatch(r, widget.Version) {
+		b.preconditionFailedResponse(w, r)
+		return
+	}
+	var input struct {
+		PageId   uuid.UUID  `json:"page_id"`
+		Position *int       `json:"position"`
+		Before   *uuid.UUID `json:"before"`
+		After    *uuid.UUID `json:"after"`
+	}
+	err = b.readJson(w, r, &input)
+	if err != nil {
+		b.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(input.PageId != uuid.Nil, "page_id", "must be provided")
+
+	at := data.Placement{Position: input.Position, Before: input.Before, After: input.After}
+	validatePlacement(v, at)
+
+	if !v.Valid() {
+		b.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	pageIds := []uuid.UUID{widget.PageId}
+	if input.PageId != widget.PageId {
+		pageIds = append(pageIds, input.PageId)
+	}
+	before := *widget
+
+	err = b.savePages(r.Context(), pageIds, func(m data.Models) error {
+		if err := m.Widgets.Move(r.Context(), widget, input.PageId, at); err != nil {
+			return err
+		}
+		return b.audit(r, m, storeId, data.AuditWidget, widget.Id, "move", before, widget)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound), errors.Is(err, data.ErrForeignPage):
+			b.failedValidationResponse(w, r, map[string]string{"page_id": "must be a page of this store"})
+		case errors.Is(err, data.ErrForeignWidgets):
+			b.failedValidationResponse(w, r, map[string]string{placementKey(at): "must be another widget on the target page"})
+		case errors.Is(err, data.ErrEditConflict):
+			b.editConflictResponse(w, r)
+		default:
+			b.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("ETag", versionETag(widget.Version))
+
+	err = b.writeJson(w, http.StatusOK, envelope{"widget": widget}, headers)
 	if err != nil {
 		b.serverErrorResponse(w, r, err)
 	}
@@ -195,7 +413,7 @@ func (b *backend) reorderWidgetsHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	// Verify page exists
-	page, err := b.models.Pages.Get(pageId)
+	page, err := b.models.Pages.Get(r.Context(), pageId)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -209,28 +427,73 @@ func (b *backend) reorderWidgetsHandler(w http.ResponseWriter, r *http.Request)
 		b.notFoundResponse(w, r)
 		return
 	}
+	// Either widget_ids with the new order, all of the page's widgets unless
+	// partial is set, or a list of operations that each move one widget.
 	var input struct {
-		WidgetIds []uuid.UUID `json:"widget_ids"`
+		WidgetIds  []uuid.UUID `json:"widget_ids"`
+		Partial    bool        `json:"partial"`
+		Operations []struct {
+			WidgetId uuid.UUID  `json:"widget_id"`
+			Position *int       `json:"position"`
+			Before   *uuid.UUID `json:"before"`
+			After    *uuid.UUID `json:"after"`
+		} `json:"operations"`
 	}
 	err = b.readJson(w, r, &input)
 	if err != nil {
 		b.badRequestResponse(w, r, err)
 		return
 	}
-	if len(input.WidgetIds) == 0 {
-		b.validationErrorResponse(w, r, "widget_ids array cannot be empty")
+	if len(input.WidgetIds) == 0 && len(input.Operations) == 0 {
+		b.validationErrorResponse(w, r, "either widget_ids or operations must be given")
 		return
 	}
-	err = b.models.Widgets.Reorder(pageId, input.WidgetIds)
+	if len(input.WidgetIds) > 0 && len(input.Operations) > 0 {
+		b.validationErrorResponse(w, r, "widget_ids and operations cannot be combined")
+		return
+	}
+	order := data.Ordering{WidgetIds: input.WidgetIds, Partial: input.Partial}
+	v := validator.New()
+
+	for i, op := range input.Operations {
+		at := data.Placement{Position: op.Position, Before: op.Before, After: op.After}
+		key := fmt.Sprintf("operations[%d]", i)
+
+		ov := validator.New()
+		validatePlacement(ov, at)
+		v.Check(op.WidgetId != uuid.Nil, key+".widget_id", "must be provided")
+		for field, message := range ov.Errors {
+			v.AddError(key+"."+field, message)
+		}
+		order.Moves = append(order.Moves, data.Relocation{WidgetId: op.WidgetId, At: at})
+	}
+	if !v.Valid() {
+		b.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	var widgets []*data.Widget
+	err = b.savePage(r.Context(), pageId, func(m data.Models) error {
+		before, err := m.Widgets.GetForPage(r.Context(), pageId)
+		if err != nil {
+			return err
+		}
+		if widgets, err = m.Widgets.Reorder(r.Context(), pageId, order); err != nil {
+			return err
+		}
+		return b.audit(r, m, storeId, data.AuditPage, pageId, "reorder", widgetOrder(before), widgetOrder(widgets))
+	})
 	if err != nil {
-		if err.Error() == "some widgets do not belong to this page" {
+		switch {
+		case errors.Is(err, data.ErrForeignWidgets),
+			errors.Is(err, data.ErrDuplicateIds),
+			errors.Is(err, data.ErrPartialOrder):
 			b.validationErrorResponse(w, r, err.Error())
-		} else {
+		default:
 			b.serverErrorResponse(w, r, err)
 		}
 		return
 	}
-	err = b.writeJson(w, http.StatusOK, envelope{"message": "widgets successfully reordered"}, nil)
+	err = b.writeJson(w, http.StatusOK, envelope{"widgets": widgets}, nil)
 	if err != nil {
 		b.serverErrorResponse(w, r, err)
 	}
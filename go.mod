@@ -9,3 +9,9 @@ require (
 )
 
 require github.com/google/uuid v1.6.0
+
+require gopkg.in/yaml.v3 v3.0.1
+
+require golang.org/x/crypto v0.43.0
+
+require github.com/golang-jwt/jwt/v5 v5.3.1
@@ -0,0 +1,310 @@
+package bundle
+
+import (
+	"appdrop/internal/data"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// ErrPlanChanged is returned by ApplyToStore when the plan worked out against
+// the current state is not the one that was reviewed.
+var ErrPlanChanged = errors.New("plan has changed since it was computed")
+
+// Hash returns a digest of the changes of the plan and of the bundle they
+// come from, which holds the values they set. Two plans with the same hash
+// make the same changes, so a plan can be reviewed first and applied later
+// only if it is still the same.
+func (p *Plan) Hash() string {
+	h := sha256.New()
+	// Both only hold values that came out of JSON in the first place.
+	if err := json.NewEncoder(h).Encode(p.Changes); err != nil {
+		panic(err)
+	}
+	if err := json.NewEncoder(h).Encode(p.bundle); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ApplyToStore works out the plan that makes the store storeId match b and,
+// unless dryRun is set, applies it, all in one unit of work. Pages may not take
+// routes that start with one of reserved. When hash is not empty the plan must
+// hash to it, or ErrPlanChanged is returned and nothing is written.
+func ApplyToStore(ctx context.Context, m data.Models, storeId uuid.UUID, b *Bundle, reserved []string, hash string, dryRun bool) (*Plan, error) {
+	var plan *Plan
+
+	err := m.InTx(ctx, func(m data.Models) error {
+		var err error
+		if plan, err = DiffStore(ctx, m, storeId, b, reserved); err != nil {
+			return err
+		}
+		if hash != "" && plan.Hash() != hash {
+			return ErrPlanChanged
+		}
+		if dryRun {
+			return nil
+		}
+		return plan.Apply(ctx, m)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// DiffStore validates b and works out the plan that makes the existing store
+// storeId match it. The slug of b must be the store's, so a file meant for
+// one store cannot be applied to another by mistake.
+//
+// Unlike Diff, which matches pages by route and widgets by position, DiffStore
+// keeps track of pages and widgets that moved: a page whose route is gone is
+// matched by name and re-routed, and widgets are matched by content first and
+// by type second, then put in order. Only what cannot be matched is created
+// or deleted. As with Diff, pages may not take routes that start with one of
+// reserved.
+func DiffStore(ctx context.Context, m data.Models, storeId uuid.UUID, b *Bundle, reserved []string) (*Plan, error) {
+	if err := validate(ctx, m, b, reserved); err != nil {
+		return nil, err
+	}
+	current, err := m.Stores.Get(ctx, storeId)
+	if err != nil {
+		return nil, err
+	}
+	if b.Store.Slug != current.Slug {
+		return nil, &ValidationError{Fields: map[string]string{
+			"store.slug": fmt.Sprintf("must be %q, the slug of the store", current.Slug),
+		}}
+	}
+	p := &Plan{Changes: []Change{}, bundle: b, deleted: make(map[uuid.UUID]bool)}
+	p.diffStore(current, b.Store)
+
+	types, err := m.WidgetTypes.GetAllForStore(ctx, current.Id)
+	if err != nil {
+		return nil, err
+	}
+	pages, err := m.Pages.GetAllForStore(ctx, current.Id)
+	if err != nil {
+		return nil, err
+	}
+	for _, page := range pages {
+		if page.Widgets, err = m.Widgets.GetForPage(ctx, page.Id); err != nil {
+			return nil, err
+		}
+	}
+	deleteTypes := p.diffWidgetTypes(types, b.WidgetTypes)
+	p.convergePages(pages, b.Pages)
+
+	for _, s := range deleteTypes {
+		s()
+	}
+	return p, nil
+}
+
+// convergePages plans the changes to the pages. Pages are matched by route,
+// then the pages left over by name. Deletes go first so that their routes are
+// free for the pages that take them over, except for the current home page,
+// which can only go once another page is home.
+func (p *Plan) convergePages(current []*data.Page, want []Page) {
+	p.pages = current
+	byRoute := make(map[string]*data.Page)
+	for _, page := range current {
+		byRoute[page.Route] = page
+	}
+	matches := make([]*data.Page, len(want))
+	for i, bp := range want {
+		if page, ok := byRoute[bp.Route]; ok {
+			matches[i] = page
+			delete(byRoute, bp.Route)
+		}
+	}
+	for i, bp := range want {
+		if matches[i] != nil {
+			continue
+		}
+		// current is sorted, so the first page by route wins a shared name.
+		for _, route := range sortedKeys(byRoute) {
+			if page := byRoute[route]; page.Name == bp.Name {
+				matches[i] = page
+				delete(byRoute, route)
+				break
+			}
+		}
+	}
+	var home *data.Page
+	for _, route := range sortedKeys(byRoute) {
+		page := byRoute[route]
+		if page.IsHome {
+			home = page
+			continue
+		}
+		p.deletePage(page)
+	}
+	for i, bp := range want {
+		page := matches[i]
+		if page == nil {
+			page := &data.Page{
+				Id: uuid.New(), StoreId: p.store.Id,
+				Name: bp.Name, Route: bp.Route,
+				IsHome: bp.IsHome,
+			}
+			p.add(Change{Action: Create, Kind: KindPage, Key: bp.Route}, func(ctx context.Context, m data.Models) error {
+				if err := m.Pages.Insert(ctx, page); err != nil {
+					return err
+				}
+				p.madeHome(page)
+				return nil
+			})
+			p.pages = append(p.pages, page)
+			p.touch(page.Id)
+			p.diffWidgets(page, nil, bp)
+			continue
+		}
+		p.updatePage(page, bp)
+		p.convergeWidgets(page, bp)
+	}
+	if home != nil {
+		p.deletePage(home)
+	}
+}
+
+func (p *Plan) deletePage(page *data.Page) {
+	p.add(Change{Action: Delete, Kind: KindPage, Key: page.Route}, func(ctx context.Context, m data.Models) error {
+		return m.Pages.Delete(ctx, page.Id, page.Version)
+	})
+	p.deleted[page.Id] = true
+}
+
+// updatePage plans the update of page to bp as one step, listed as a reroute,
+// a rename and an update of the rest, whichever apply.
+func (p *Plan) updatePage(page *data.Page, bp Page) {
+	var changes []Change
+	if page.Route != bp.Route {
+		changes = append(changes, Change{Action: Reroute, Kind: KindPage, Key: page.Route, From: page.Route, To: bp.Route})
+	}
+	if page.Name != bp.Name {
+		changes = append(changes, Change{Action: Rename, Kind: KindPage, Key: bp.Route, From: page.Name, To: bp.Name})
+	}
+	if page.IsHome != bp.IsHome {
+		changes = append(changes, Change{Action: Update, Kind: KindPage, Key: bp.Route, Fields: []string{"is_home"}})
+	}
+	for i, c := range changes {
+		var s step
+		if i == 0 {
+			s = func(ctx context.Context, m data.Models) error {
+				page.Name, page.Route, page.IsHome = bp.Name, bp.Route, bp.IsHome
+				if err := m.Pages.Update(ctx, page); err != nil {
+					return err
+				}
+				p.madeHome(page)
+				return nil
+			}
+		}
+		p.add(c, s)
+	}
+	if len(changes) > 0 {
+		p.touch(page.Id)
+	}
+}
+
+// convergeWidgets plans the changes to the widgets of page. A wanted widget
+// is matched to an identical one on the page if there is one, or else to one
+// of the same type, which is updated. Unmatched widgets are added or removed,
+// and the page is reordered if the widgets that remain end up out of order.
+func (p *Plan) convergeWidgets(page *data.Page, want Page) {
+	current := page.Widgets
+	used := make([]bool, len(current))
+	matches := make([]*data.Widget, len(want.Widgets))
+
+	match := func(same func(w *data.Widget, bw Widget) bool) {
+		for i, bw := range want.Widgets {
+			if matches[i] != nil {
+				continue
+			}
+			for j, w := range current {
+				if !used[j] && same(w, bw) {
+					matches[i], used[j] = w, true
+					break
+				}
+			}
+		}
+	}
+	match(func(w *data.Widget, bw Widget) bool { return w.Type == bw.Type && sameJSON(w.Config, bw.Config) })
+	match(func(w *data.Widget, bw Widget) bool { return w.Type == bw.Type })
+
+	changed := false
+	order := make([]uuid.UUID, len(want.Widgets))
+	from := make([]*int, len(want.Widgets))
+
+	for i, bw := range want.Widgets {
+		position := i
+		widget := matches[i]
+		if widget == nil {
+			widget := &data.Widget{Id: uuid.New(), PageId: page.Id, Type: bw.Type, Config: bw.Config}
+			p.add(Change{Action: Create, Kind: KindWidget, Key: want.Route, Position: &position}, func(ctx context.Context, m data.Models) error {
+				return m.Widgets.Insert(ctx, widget)
+			})
+			order[i] = widget.Id
+			changed = true
+			continue
+		}
+		order[i] = widget.Id
+		was := widget.Position
+		from[i] = &was
+
+		if sameJSON(widget.Config, bw.Config) {
+			continue
+		}
+		p.add(Change{Action: Update, Kind: KindWidget, Key: want.Route, Position: &position, Fields: []string{"config"}}, func(ctx context.Context, m data.Models) error {
+			widget.Config = bw.Config
+			return m.Widgets.Update(ctx, widget)
+		})
+		changed = true
+	}
+	for j := len(current) - 1; j >= 0; j-- {
+		if used[j] {
+			continue
+		}
+		position := current[j].Position
+		widget := current[j]
+		p.add(Change{Action: Delete, Kind: KindWidget, Key: want.Route, Position: &position}, func(ctx context.Context, m data.Models) error {
+			return m.Widgets.Delete(ctx, widget.Id, widget.Version)
+		})
+		changed = true
+	}
+	if !inOrder(from) {
+		// From lists, for each place on the page, where its widget was
+		// before; added widgets have none.
+		p.add(Change{Action: Reorder, Kind: KindWidget, Key: want.Route, From: from}, func(ctx context.Context, m data.Models) error {
+			_, err := m.Widgets.Reorder(ctx, page.Id, data.Ordering{WidgetIds: order})
+			return err
+		})
+		changed = true
+	}
+	if changed {
+		p.touch(page.Id)
+	}
+}
+
+// inOrder reports whether the widgets that were already on the page keep
+// their relative order and come before any added widget, which is where
+// adding them puts them.
+func inOrder(from []*int) bool {
+	last, added := -1, false
+	for _, position := range from {
+		if position == nil {
+			added = true
+			continue
+		}
+		if added || *position < last {
+			return false
+		}
+		last = *position
+	}
+	return true
+}
@@ -0,0 +1,129 @@
+// Package bundle moves store content in and out of the database as a portable
+// document. A bundle describes a store, its own widget types and its pages
+// with their widgets. It uses natural keys instead of ids: the store slug, the
+// widget type name, the page route and the position of a widget on its page,
+// which is its place in the page's widget list. That makes a bundle stable
+// across environments and readable in a diff.
+//
+// Bundles are JSON documents; DecodeYAML reads the same document written as
+// YAML. Every bundle carries the schema version it was written with. Decode
+// brings older documents up to the current version, so exports keep importing
+// after the format changes.
+package bundle
+
+import (
+	"appdrop/internal/data"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SchemaVersion is the version of the bundle format written by Export.
+const SchemaVersion = 1
+
+// ErrUnsupportedVersion is returned by Decode for a document written by a
+// newer version of the format.
+var ErrUnsupportedVersion = errors.New("unsupported bundle schema version")
+
+// Bundle is the portable description of a store.
+type Bundle struct {
+	SchemaVersion int          `json:"schema_version"`
+	Store         Store        `json:"store"`
+	WidgetTypes   []WidgetType `json:"widget_types"`
+	Pages         []Page       `json:"pages"`
+}
+
+// Store holds the fields of a store that travel with it.
+type Store struct {
+	Slug       string `json:"slug"`
+	Name       string `json:"name"`
+	IsTemplate bool   `json:"is_template,omitempty"`
+}
+
+// WidgetType is a widget type the store defines itself. Built-in types are
+// available everywhere and are not part of a bundle.
+type WidgetType struct {
+	Name          string            `json:"name"`
+	Label         string            `json:"label"`
+	Schema        data.ConfigSchema `json:"schema"`
+	MinAppVersion string            `json:"min_app_version,omitempty"`
+	MaxAppVersion string            `json:"max_app_version,omitempty"`
+}
+
+// Page is a page and its widgets in order.
+type Page struct {
+	Route   string   `json:"route"`
+	Name    string   `json:"name"`
+	IsHome  bool     `json:"is_home,omitempty"`
+	Widgets []Widget `json:"widgets"`
+}
+
+// Widget is a widget of a page. Its position is its index in Page.Widgets.
+type Widget struct {
+	Type   string         `json:"type"`
+	Config map[string]any `json:"config,omitempty"`
+}
+
+// upgrades turns a document of schema version n into one of version n+1. A
+// change to the format bumps SchemaVersion and adds the step from the old
+// version here.
+var upgrades = map[int]func(doc map[string]any) error{}
+
+// Decode parses a bundle document of any supported schema version and returns
+// it in the current format. Unknown fields are rejected, so a typo in a hand
+// written bundle does not go unnoticed.
+func Decode(raw []byte) (*Bundle, error) {
+	var doc map[string]any
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("bundle is not a JSON object: %w", err)
+	}
+	version, ok := doc["schema_version"].(float64)
+	switch {
+	case !ok || version != float64(int(version)) || version < 1:
+		return nil, errors.New("bundle must have a schema_version")
+	case int(version) > SchemaVersion:
+		return nil, fmt.Errorf("%w %d, the newest known is %d", ErrUnsupportedVersion, int(version), SchemaVersion)
+	}
+	for n := int(version); n < SchemaVersion; n++ {
+		upgrade, ok := upgrades[n]
+		if !ok {
+			return nil, fmt.Errorf("%w %d", ErrUnsupportedVersion, n)
+		}
+		if err := upgrade(doc); err != nil {
+			return nil, fmt.Errorf("upgrading bundle from schema version %d: %w", n, err)
+		}
+		doc["schema_version"] = n + 1
+	}
+	if int(version) < SchemaVersion {
+		var err error
+		if raw, err = json.Marshal(doc); err != nil {
+			return nil, err
+		}
+	}
+	var b Bundle
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&b); err != nil {
+		return nil, fmt.Errorf("bundle is malformed: %w", err)
+	}
+	return &b, nil
+}
+
+// DecodeYAML is Decode for a bundle written as YAML, which is easier to keep
+// by hand. The document is read as plain YAML values and decoded as the JSON
+// they stand for, so both forms follow the same rules.
+func DecodeYAML(raw []byte) (*Bundle, error) {
+	var doc any
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("bundle is not valid YAML: %w", err)
+	}
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("bundle is not a JSON compatible YAML document: %w", err)
+	}
+	return Decode(raw)
+}
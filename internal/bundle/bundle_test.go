@@ -0,0 +1,284 @@
+package bundle
+
+import (
+	"appdrop/internal/data"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func testBundle() *Bundle {
+	return &Bundle{
+		SchemaVersion: SchemaVersion,
+		Store:         Store{Slug: "acme", Name: "Acme"},
+		WidgetTypes: []WidgetType{{
+			Name: "countdown", Label: "Countdown",
+			Schema: data.ConfigSchema{Fields: map[string]data.FieldSchema{"ends_at": {Type: data.FieldString, Required: true}}},
+		}},
+		Pages: []Page{
+			{Route: "/", Name: "Home", IsHome: true, Widgets: []Widget{
+				{Type: "text", Config: map[string]any{"content": "hello"}},
+				{Type: "countdown", Config: map[string]any{"ends_at": "friday"}},
+			}},
+			{Route: "/about", Name: "About", Widgets: []Widget{
+				{Type: "text", Config: map[string]any{"content": "about us"}},
+			}},
+		},
+	}
+}
+
+func newModels(t *testing.T) data.Models {
+	t.Helper()
+
+	m := data.NewMemoryModels()
+	if err := m.WidgetTypes.EnsureBuiltins(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestImportExport(t *testing.T) {
+	ctx := context.Background()
+	m := newModels(t)
+
+	plan, err := Import(ctx, m, testBundle(), nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Summary{Create: 7}); plan.Summary != want {
+		t.Fatalf("expected %+v, got %+v", want, plan.Summary)
+	}
+	if _, err = m.Stores.GetBySlug(ctx, "acme"); !errors.Is(err, data.ErrRecordNotFound) {
+		t.Fatalf("expected a dry run to write nothing, got %v", err)
+	}
+
+	plan, err = Import(ctx, m, testBundle(), nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported, err := Export(ctx, m, plan.Store().Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, exported, testBundle())
+
+	plan, err = Import(ctx, m, testBundle(), nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Changes) != 0 {
+		t.Fatalf("expected importing the same bundle again to change nothing, got %+v", plan.Changes)
+	}
+}
+
+func TestImportReplaces(t *testing.T) {
+	ctx := context.Background()
+	m := newModels(t)
+
+	if _, err := Import(ctx, m, testBundle(), nil, false); err != nil {
+		t.Fatal(err)
+	}
+	b := testBundle()
+	b.WidgetTypes = []WidgetType{}
+	b.Pages = []Page{
+		{Route: "/about", Name: "About us", IsHome: true, Widgets: []Widget{
+			{Type: "text", Config: map[string]any{"content": "about us"}},
+			{Type: "spacer"},
+		}},
+	}
+	plan, err := Import(ctx, m, b, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Change{
+		{Action: Update, Kind: KindPage, Key: "/about", Fields: []string{"name", "is_home"}},
+		{Action: Create, Kind: KindWidget, Key: "/about", Position: ptr(1)},
+		{Action: Delete, Kind: KindPage, Key: "/"},
+		{Action: Delete, Kind: KindWidgetType, Key: "countdown"},
+	}
+	if !reflect.DeepEqual(plan.Changes, want) {
+		t.Fatalf("expected changes %+v, got %+v", want, plan.Changes)
+	}
+	exported, err := Export(ctx, m, plan.Store().Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, exported, b)
+}
+
+func TestImportValidation(t *testing.T) {
+	b := testBundle()
+	b.WidgetTypes = nil
+	b.Pages[1].IsHome = true
+	b.Pages[1].Route = "/"
+	b.Pages = append(b.Pages, Page{Route: "/public/sale", Name: "Sale"})
+	reserved := fmt.Sprintf("pages[%d].route", len(b.Pages)-1)
+
+	_, err := Import(context.Background(), newModels(t), b, []string{"/api", "/public"}, true)
+	var vErr *ValidationError
+	if !errors.As(err, &vErr) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+	for _, key := range []string{"pages", "pages[1].route", "pages[0].widgets[1].type", reserved} {
+		if _, ok := vErr.Fields[key]; !ok {
+			t.Errorf("expected an error for %s, got %v", key, vErr.Fields)
+		}
+	}
+}
+
+func TestApplyToStore(t *testing.T) {
+	ctx := context.Background()
+	m := newModels(t)
+
+	imported, err := Import(ctx, m, testBundle(), nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := imported.Store()
+	pages, err := m.Pages.GetAllForStore(ctx, store.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	home := pages[slices.IndexFunc(pages, func(p *data.Page) bool { return p.IsHome })]
+	before, err := m.Widgets.GetForPage(ctx, home.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := testBundle()
+	b.Pages[0].Name = "Start"
+	b.Pages[0].Widgets[0], b.Pages[0].Widgets[1] = b.Pages[0].Widgets[1], b.Pages[0].Widgets[0]
+	b.Pages[1].Route = "/about-us"
+	b.Pages[1].Widgets[0].Config = map[string]any{"content": "all about us"}
+	b.Pages = append(b.Pages, Page{Route: "/sale", Name: "Sale", Widgets: []Widget{{Type: "spacer"}}})
+
+	plan, err := ApplyToStore(ctx, m, store.Id, b, nil, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Change{
+		{Action: Rename, Kind: KindPage, Key: "/", From: "Home", To: "Start"},
+		{Action: Reorder, Kind: KindWidget, Key: "/", From: []*int{ptr(1), ptr(0)}},
+		{Action: Reroute, Kind: KindPage, Key: "/about", From: "/about", To: "/about-us"},
+		{Action: Update, Kind: KindWidget, Key: "/about-us", Position: ptr(0), Fields: []string{"config"}},
+		{Action: Create, Kind: KindPage, Key: "/sale"},
+		{Action: Create, Kind: KindWidget, Key: "/sale", Position: ptr(0)},
+	}
+	if !reflect.DeepEqual(plan.Changes, want) {
+		t.Fatalf("expected changes %+v, got %+v", want, plan.Changes)
+	}
+
+	if _, err = ApplyToStore(ctx, m, store.Id, b, nil, "stale", false); !errors.Is(err, ErrPlanChanged) {
+		t.Fatalf("expected %v, got %v", ErrPlanChanged, err)
+	}
+	if _, err = ApplyToStore(ctx, m, store.Id, b, nil, plan.Hash(), false); err != nil {
+		t.Fatal(err)
+	}
+	exported, err := Export(ctx, m, store.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, exported, b)
+
+	after, err := m.Widgets.GetForPage(ctx, home.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after[0].Id != before[1].Id || after[1].Id != before[0].Id {
+		t.Fatal("expected the reordered widgets to keep their ids")
+	}
+
+	plan, err = ApplyToStore(ctx, m, store.Id, b, nil, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Changes) != 0 {
+		t.Fatalf("expected applying the same bundle again to change nothing, got %+v", plan.Changes)
+	}
+
+	b.Store.Slug = "other"
+	_, err = ApplyToStore(ctx, m, store.Id, b, nil, "", true)
+	var vErr *ValidationError
+	if !errors.As(err, &vErr) || vErr.Fields["store.slug"] == "" {
+		t.Fatalf("expected a validation error for store.slug, got %v", err)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	raw, err := json.Marshal(testBundle())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Decode(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, b, testBundle())
+
+	b, err = DecodeYAML([]byte(`
+schema_version: 1
+store: {slug: acme, name: Acme}
+widget_types:
+  - name: countdown
+    label: Countdown
+    schema:
+      fields:
+        ends_at: {type: string, required: true}
+pages:
+  - route: /
+    name: Home
+    is_home: true
+    widgets:
+      - type: text
+        config: {content: hello}
+      - type: countdown
+        config: {ends_at: friday}
+  - route: /about
+    name: About
+    widgets:
+      - type: text
+        config: {content: about us}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, b, testBundle())
+
+	tests := []struct {
+		name    string
+		raw     string
+		wantErr error
+	}{
+		{"newer version", `{"schema_version": 99}`, ErrUnsupportedVersion},
+		{"no version", `{"store": {"slug": "acme"}}`, nil},
+		{"unknown field", `{"schema_version": 1, "store": {"slug": "acme", "colour": "red"}}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decode([]byte(tt.raw))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// roundTrip fails the test unless got and want encode to the same JSON.
+func roundTrip(t *testing.T, got, want *Bundle) {
+	t.Helper()
+
+	if !sameJSON(got, want) {
+		g, _ := json.Marshal(got)
+		w, _ := json.Marshal(want)
+		t.Fatalf("expected\n%s\ngot\n%s", w, g)
+	}
+}
+
+func ptr(n int) *int { return &n }
@@ -0,0 +1,68 @@
+package bundle
+
+import (
+	"appdrop/internal/data"
+	"cmp"
+	"context"
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+// Export describes the store storeId as a bundle. Pages are sorted by route
+// and widget types by name, so exporting the same content twice gives the
+// same document.
+func Export(ctx context.Context, m data.Models, storeId uuid.UUID) (*Bundle, error) {
+	store, err := m.Stores.Get(ctx, storeId)
+	if err != nil {
+		return nil, err
+	}
+	b := &Bundle{
+		SchemaVersion: SchemaVersion,
+		Store:         Store{Slug: store.Slug, Name: store.Name, IsTemplate: store.IsTemplate},
+		WidgetTypes:   []WidgetType{},
+		Pages:         []Page{},
+	}
+	types, err := m.WidgetTypes.GetAllForStore(ctx, storeId)
+	if err != nil {
+		return nil, err
+	}
+	for _, wt := range types {
+		if wt.StoreId == nil {
+			continue
+		}
+		b.WidgetTypes = append(b.WidgetTypes, exportWidgetType(wt))
+	}
+	pages, err := m.Pages.GetAllForStore(ctx, storeId)
+	if err != nil {
+		return nil, err
+	}
+	for _, page := range pages {
+		widgets, err := m.Widgets.GetForPage(ctx, page.Id)
+		if err != nil {
+			return nil, err
+		}
+		b.Pages = append(b.Pages, exportPage(page, widgets))
+	}
+	slices.SortFunc(b.WidgetTypes, func(a, b WidgetType) int { return cmp.Compare(a.Name, b.Name) })
+	slices.SortFunc(b.Pages, func(a, b Page) int { return cmp.Compare(a.Route, b.Route) })
+	return b, nil
+}
+
+func exportWidgetType(wt *data.WidgetType) WidgetType {
+	return WidgetType{
+		Name: wt.Name, Label: wt.Label,
+		Schema:        wt.Schema,
+		MinAppVersion: wt.MinAppVersion,
+		MaxAppVersion: wt.MaxAppVersion,
+	}
+}
+
+// exportPage describes page with widgets, which are ordered by position.
+func exportPage(page *data.Page, widgets []*data.Widget) Page {
+	p := Page{Route: page.Route, Name: page.Name, IsHome: page.IsHome, Widgets: []Widget{}}
+	for _, w := range widgets {
+		p.Widgets = append(p.Widgets, Widget{Type: w.Type, Config: w.Config})
+	}
+	return p
+}
@@ -0,0 +1,147 @@
+package bundle
+
+import (
+	"appdrop/internal/data"
+	"appdrop/internal/routing"
+	"appdrop/internal/validator"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// ValidationError lists what is wrong with a bundle, keyed by the path of the
+// field, such as "pages[2].widgets[0].config.title".
+type ValidationError struct {
+	Fields map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("bundle is invalid: %d problems", len(e.Fields))
+}
+
+// Import creates or replaces the store described by b in one unit of work and
+// returns the plan that was applied. Pages may not take routes that start with
+// one of reserved. With dryRun the plan is only worked out.
+func Import(ctx context.Context, m data.Models, b *Bundle, reserved []string, dryRun bool) (*Plan, error) {
+	var plan *Plan
+
+	err := m.InTx(ctx, func(m data.Models) error {
+		var err error
+		if plan, err = Diff(ctx, m, b, reserved); err != nil {
+			return err
+		}
+		if dryRun {
+			return nil
+		}
+		return plan.Apply(ctx, m)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// validate checks b as a whole before anything is planned. Routes are
+// normalized in place and must not start with one of reserved. Widgets are checked against the widget types of the
+// bundle and the built-in ones, which is all the store will have once the
+// bundle is applied.
+func validate(ctx context.Context, m data.Models, b *Bundle, reserved []string) error {
+	v := validator.New()
+
+	v.Check(b.SchemaVersion == SchemaVersion, "schema_version", fmt.Sprintf("must be %d", SchemaVersion))
+	v.Check(strings.TrimSpace(b.Store.Slug) != "", "store.slug", "is required")
+	v.Check(strings.TrimSpace(b.Store.Name) != "", "store.name", "is required")
+
+	types := make(map[string]*data.WidgetType)
+	for i, bt := range b.WidgetTypes {
+		key := fmt.Sprintf("widget_types[%d]", i)
+		wt := &data.WidgetType{
+			Name: bt.Name, Label: bt.Label,
+			Schema:        bt.Schema,
+			MinAppVersion: bt.MinAppVersion,
+			MaxAppVersion: bt.MaxAppVersion,
+		}
+		sub := validator.New()
+		data.ValidateWidgetType(sub, wt)
+		nest(v, key, sub)
+
+		builtin, err := lookupBuiltin(ctx, m, bt.Name)
+		if err != nil {
+			return err
+		}
+		v.Check(builtin == nil, key+".name", "is the name of a built-in widget type")
+		v.Check(types[bt.Name] == nil, key+".name", "is used by another widget type of the bundle")
+		types[bt.Name] = wt
+	}
+	var patterns []routing.Pattern
+	homes := 0
+
+	for i := range b.Pages {
+		bp := &b.Pages[i]
+		key := fmt.Sprintf("pages[%d]", i)
+
+		v.Check(strings.TrimSpace(bp.Name) != "", key+".name", "is required")
+		pattern, err := routing.Parse(bp.Route)
+		if err != nil {
+			v.AddError(key+".route", err.Error())
+		} else {
+			bp.Route = pattern.String()
+			for _, prefix := range reserved {
+				if pattern.HasPrefix(prefix) {
+					v.AddError(key+".route", fmt.Sprintf("must not start with the reserved prefix %s", prefix))
+					break
+				}
+			}
+			for j, other := range patterns {
+				if pattern.Conflicts(other) {
+					v.AddError(key+".route", fmt.Sprintf("conflicts with the route of pages[%d]", j))
+					break
+				}
+			}
+		}
+		patterns = append(patterns, pattern)
+		if bp.IsHome {
+			homes++
+		}
+		for j, bw := range bp.Widgets {
+			wt := types[bw.Type]
+			if wt == nil {
+				if wt, err = lookupBuiltin(ctx, m, bw.Type); err != nil {
+					return err
+				}
+			}
+			sub := validator.New()
+			data.ValidateWidget(sub, &data.Widget{Type: bw.Type, Config: bw.Config}, wt)
+			nest(v, fmt.Sprintf("%s.widgets[%d]", key, j), sub)
+		}
+	}
+	if len(b.Pages) > 0 {
+		v.Check(homes == 1, "pages", "must have exactly one home page")
+	}
+	if !v.Valid() {
+		return &ValidationError{Fields: v.Errors}
+	}
+	return nil
+}
+
+// lookupBuiltin returns the built-in widget type called name, or nil.
+func lookupBuiltin(ctx context.Context, m data.Models, name string) (*data.WidgetType, error) {
+	wt, err := m.WidgetTypes.Lookup(ctx, uuid.Nil, name)
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	return wt, nil
+}
+
+// nest copies the errors of sub into v under prefix.
+func nest(v *validator.Validator, prefix string, sub *validator.Validator) {
+	for key, message := range sub.Errors {
+		v.AddError(prefix+"."+key, message)
+	}
+}
@@ -0,0 +1,406 @@
+package bundle
+
+import (
+	"appdrop/internal/data"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+// Action is what a change does to an item.
+type Action string
+
+const (
+	Create Action = "create"
+	Update Action = "update"
+	Delete Action = "delete"
+
+	// Rename, Reroute and Reorder are the updates that ApplyToStore lists
+	// on their own: the name or the route of a page, or the order of the
+	// widgets of a page.
+	Rename  Action = "rename"
+	Reroute Action = "reroute"
+	Reorder Action = "reorder"
+)
+
+// Kind is the kind of item a change applies to.
+type Kind string
+
+const (
+	KindStore      Kind = "store"
+	KindWidgetType Kind = "widget_type"
+	KindPage       Kind = "page"
+	KindWidget     Kind = "widget"
+)
+
+// Change is one entry of a plan. Key is the natural key of the item: the
+// store slug, the widget type name or the page route. For a widget it is the
+// route of its page, and Position is its place on the page. Fields lists what
+// an update changes. From and To, when set, are the old and new values.
+type Change struct {
+	Action   Action   `json:"action"`
+	Kind     Kind     `json:"kind"`
+	Key      string   `json:"key"`
+	Position *int     `json:"position,omitempty"`
+	Fields   []string `json:"fields,omitempty"`
+	From     any      `json:"from,omitempty"`
+	To       any      `json:"to,omitempty"`
+}
+
+// Summary counts the changes of a plan by action. Renames, reroutes and
+// reorders count as updates.
+type Summary struct {
+	Create int `json:"create"`
+	Update int `json:"update"`
+	Delete int `json:"delete"`
+}
+
+// Plan is the list of changes that make the database match a bundle, in the
+// order Apply makes them. A plan is only good for the state it was computed
+// against, so computing and applying it belong in one unit of work.
+type Plan struct {
+	Changes []Change `json:"changes"`
+	Summary Summary  `json:"summary"`
+
+	bundle  *Bundle
+	store   *data.Store
+	pages   []*data.Page
+	steps   []step
+	touched []uuid.UUID
+	deleted map[uuid.UUID]bool
+}
+
+// step makes one change of a plan. A change may have no step of its own when
+// the step of the change before it makes both.
+type step func(ctx context.Context, m data.Models) error
+
+// Store returns the store the plan writes to. Before the plan is applied, a
+// store that is yet to be created has no timestamps or version.
+func (p *Plan) Store() *data.Store {
+	return p.store
+}
+
+// Apply makes the changes of the plan through m and records a new version of
+// every page it created or changed.
+func (p *Plan) Apply(ctx context.Context, m data.Models) error {
+	for _, s := range p.steps {
+		if s == nil {
+			continue
+		}
+		if err := s(ctx, m); err != nil {
+			return err
+		}
+	}
+	for _, pageId := range p.touched {
+		if p.deleted[pageId] {
+			continue
+		}
+		if _, err := m.PageVersions.Record(ctx, pageId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Plan) add(c Change, s step) {
+	p.Changes = append(p.Changes, c)
+	p.steps = append(p.steps, s)
+
+	switch c.Action {
+	case Create:
+		p.Summary.Create++
+	case Update, Rename, Reroute, Reorder:
+		p.Summary.Update++
+	case Delete:
+		p.Summary.Delete++
+	}
+}
+
+func (p *Plan) touch(pageId uuid.UUID) {
+	if !slices.Contains(p.touched, pageId) {
+		p.touched = append(p.touched, pageId)
+	}
+}
+
+// madeHome follows on the pages of the plan what a step that saved page does
+// to the others if page is home: the previous home page gives it up and moves
+// to its next version, which the steps after it must hold, and gets a new
+// page version of its own.
+func (p *Plan) madeHome(page *data.Page) {
+	if !page.IsHome {
+		return
+	}
+	for _, other := range p.pages {
+		if other != page && other.IsHome {
+			other.IsHome = false
+			other.Version++
+			p.touch(other.Id)
+		}
+	}
+}
+
+// Diff validates b and works out the plan that turns the store with b's slug
+// into what b describes, creating the store if there is none. Anything the
+// store has that b does not list is deleted. Pages may not take routes that
+// start with one of reserved.
+func Diff(ctx context.Context, m data.Models, b *Bundle, reserved []string) (*Plan, error) {
+	if err := validate(ctx, m, b, reserved); err != nil {
+		return nil, err
+	}
+	p := &Plan{Changes: []Change{}, bundle: b, deleted: make(map[uuid.UUID]bool)}
+
+	current, err := m.Stores.GetBySlug(ctx, b.Store.Slug)
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		current = nil
+	case err != nil:
+		return nil, err
+	}
+	p.diffStore(current, b.Store)
+
+	var types []*data.WidgetType
+	var pages []*data.Page
+	if current != nil {
+		if types, err = m.WidgetTypes.GetAllForStore(ctx, current.Id); err != nil {
+			return nil, err
+		}
+		if pages, err = m.Pages.GetAllForStore(ctx, current.Id); err != nil {
+			return nil, err
+		}
+		for _, page := range pages {
+			if page.Widgets, err = m.Widgets.GetForPage(ctx, page.Id); err != nil {
+				return nil, err
+			}
+		}
+	}
+	deleteTypes := p.diffWidgetTypes(types, b.WidgetTypes)
+	p.diffPages(pages, b.Pages)
+
+	// Types go last, once no widget uses them any more.
+	for _, s := range deleteTypes {
+		s()
+	}
+	return p, nil
+}
+
+func (p *Plan) diffStore(current *data.Store, want Store) {
+	if current == nil {
+		store := &data.Store{Id: uuid.New(), Slug: want.Slug, Name: want.Name, IsTemplate: want.IsTemplate}
+		p.store = store
+		p.add(Change{Action: Create, Kind: KindStore, Key: want.Slug}, func(ctx context.Context, m data.Models) error {
+			return m.Stores.Insert(ctx, store)
+		})
+		return
+	}
+	p.store = current
+
+	var fields []string
+	if current.Name != want.Name {
+		fields = append(fields, "name")
+	}
+	if current.IsTemplate != want.IsTemplate {
+		fields = append(fields, "is_template")
+	}
+	if len(fields) == 0 {
+		return
+	}
+	p.add(Change{Action: Update, Kind: KindStore, Key: want.Slug, Fields: fields}, func(ctx context.Context, m data.Models) error {
+		current.Name, current.IsTemplate = want.Name, want.IsTemplate
+		return m.Stores.Update(ctx, current)
+	})
+}
+
+// diffWidgetTypes plans the creates and updates of the store's own widget
+// types. The deletes are returned for the caller to add at the end.
+func (p *Plan) diffWidgetTypes(current []*data.WidgetType, want []WidgetType) []func() {
+	byName := make(map[string]*data.WidgetType)
+	for _, wt := range current {
+		if wt.StoreId != nil {
+			byName[wt.Name] = wt
+		}
+	}
+	for _, bt := range want {
+		wt, ok := byName[bt.Name]
+		delete(byName, bt.Name)
+
+		if !ok {
+			wt := &data.WidgetType{
+				Id: uuid.New(), StoreId: &p.store.Id,
+				Name: bt.Name, Label: bt.Label,
+				Schema:        bt.Schema,
+				MinAppVersion: bt.MinAppVersion,
+				MaxAppVersion: bt.MaxAppVersion,
+			}
+			p.add(Change{Action: Create, Kind: KindWidgetType, Key: bt.Name}, func(ctx context.Context, m data.Models) error {
+				return m.WidgetTypes.Insert(ctx, wt)
+			})
+			continue
+		}
+		var fields []string
+		if wt.Label != bt.Label {
+			fields = append(fields, "label")
+		}
+		if !sameJSON(wt.Schema, bt.Schema) {
+			fields = append(fields, "schema")
+		}
+		if wt.MinAppVersion != bt.MinAppVersion {
+			fields = append(fields, "min_app_version")
+		}
+		if wt.MaxAppVersion != bt.MaxAppVersion {
+			fields = append(fields, "max_app_version")
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		p.add(Change{Action: Update, Kind: KindWidgetType, Key: bt.Name, Fields: fields}, func(ctx context.Context, m data.Models) error {
+			wt.Label, wt.Schema = bt.Label, bt.Schema
+			wt.MinAppVersion, wt.MaxAppVersion = bt.MinAppVersion, bt.MaxAppVersion
+			return m.WidgetTypes.Update(ctx, wt)
+		})
+	}
+	var deletes []func()
+	for _, name := range sortedKeys(byName) {
+		wt := byName[name]
+		deletes = append(deletes, func() {
+			p.add(Change{Action: Delete, Kind: KindWidgetType, Key: name}, func(ctx context.Context, m data.Models) error {
+				return m.WidgetTypes.Delete(ctx, wt.Id)
+			})
+		})
+	}
+	return deletes
+}
+
+// diffPages plans the changes to the pages, each with its widgets. Pages are
+// deleted last, when the home page has already moved to where want puts it.
+func (p *Plan) diffPages(current []*data.Page, want []Page) {
+	p.pages = current
+	byRoute := make(map[string]*data.Page)
+	for _, page := range current {
+		byRoute[page.Route] = page
+	}
+	for _, bp := range want {
+		page, ok := byRoute[bp.Route]
+		delete(byRoute, bp.Route)
+
+		if !ok {
+			page := &data.Page{
+				Id: uuid.New(), StoreId: p.store.Id,
+				Name: bp.Name, Route: bp.Route,
+				IsHome: bp.IsHome,
+			}
+			p.add(Change{Action: Create, Kind: KindPage, Key: bp.Route}, func(ctx context.Context, m data.Models) error {
+				if err := m.Pages.Insert(ctx, page); err != nil {
+					return err
+				}
+				p.madeHome(page)
+				return nil
+			})
+			p.pages = append(p.pages, page)
+			p.touch(page.Id)
+			p.diffWidgets(page, nil, bp)
+			continue
+		}
+		var fields []string
+		if page.Name != bp.Name {
+			fields = append(fields, "name")
+		}
+		if page.IsHome != bp.IsHome {
+			fields = append(fields, "is_home")
+		}
+		if len(fields) > 0 {
+			p.add(Change{Action: Update, Kind: KindPage, Key: bp.Route, Fields: fields}, func(ctx context.Context, m data.Models) error {
+				page.Name, page.IsHome = bp.Name, bp.IsHome
+				if err := m.Pages.Update(ctx, page); err != nil {
+					return err
+				}
+				p.madeHome(page)
+				return nil
+			})
+			p.touch(page.Id)
+		}
+		p.diffWidgets(page, page.Widgets, bp)
+	}
+	for _, route := range sortedKeys(byRoute) {
+		page := byRoute[route]
+		p.add(Change{Action: Delete, Kind: KindPage, Key: route}, func(ctx context.Context, m data.Models) error {
+			return m.Pages.Delete(ctx, page.Id, page.Version)
+		})
+		p.deleted[page.Id] = true
+	}
+}
+
+// diffWidgets plans the changes to the widgets of page, which currently has
+// the widgets current. Widgets are matched by position: a differing widget is
+// updated in place, missing ones are appended and surplus ones removed from
+// the end, so no other widget has to move.
+func (p *Plan) diffWidgets(page *data.Page, current []*data.Widget, want Page) {
+	changed := false
+
+	for i, bw := range want.Widgets {
+		position := i
+		change := Change{Kind: KindWidget, Key: want.Route, Position: &position}
+
+		if i >= len(current) {
+			change.Action = Create
+			p.add(change, func(ctx context.Context, m data.Models) error {
+				widget := &data.Widget{Id: uuid.New(), PageId: page.Id, Type: bw.Type, Config: bw.Config}
+				return m.Widgets.Insert(ctx, widget)
+			})
+			changed = true
+			continue
+		}
+		widget := current[i]
+		if widget.Type != bw.Type {
+			change.Fields = append(change.Fields, "type")
+		}
+		if !sameJSON(widget.Config, bw.Config) {
+			change.Fields = append(change.Fields, "config")
+		}
+		if len(change.Fields) == 0 {
+			continue
+		}
+		change.Action = Update
+		p.add(change, func(ctx context.Context, m data.Models) error {
+			widget.Type, widget.Config = bw.Type, bw.Config
+			return m.Widgets.Update(ctx, widget)
+		})
+		changed = true
+	}
+	for i := len(current) - 1; i >= len(want.Widgets); i-- {
+		position := i
+		widget := current[i]
+		p.add(Change{Action: Delete, Kind: KindWidget, Key: want.Route, Position: &position}, func(ctx context.Context, m data.Models) error {
+			return m.Widgets.Delete(ctx, widget.Id, widget.Version)
+		})
+		changed = true
+	}
+	if changed {
+		p.touch(page.Id)
+	}
+}
+
+// sameJSON reports whether a and b encode to the same JSON. Maps encode with
+// sorted keys, so this compares configs and schemas regardless of how they
+// were decoded; an empty config and no config are the same.
+func sameJSON(a, b any) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	empty := func(j []byte) bool { return bytes.Equal(j, []byte("null")) || bytes.Equal(j, []byte("{}")) }
+	return bytes.Equal(ja, jb) || empty(ja) && empty(jb)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
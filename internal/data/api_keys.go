@@ -0,0 +1,193 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// apiKeyScheme starts every API key, so that a leaked one is easy to spot.
+const apiKeyScheme = "adk_"
+
+var apiKeyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// ApiKey authenticates the requests of a client. The key itself is only known
+// when it is generated, as Plaintext; what is kept is its prefix, which finds
+// the key, and a hash of the whole of it, which proves it. A key with no
+// StoreIds can access every store.
+type ApiKey struct {
+	Id        uuid.UUID   `json:"id"`
+	Name      string      `json:"name"`
+	Prefix    string      `json:"prefix"`
+	Plaintext string      `json:"key,omitempty"`
+	Hash      []byte      `json:"-"`
+	StoreIds  []uuid.UUID `json:"store_ids"`
+	ExpiresAt *time.Time  `json:"expires_at"`
+	RevokedAt *time.Time  `json:"revoked_at,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// GenerateApiKey returns a new key with a random secret, which is only ever
+// available as its Plaintext.
+func GenerateApiKey(name string, storeIds []uuid.UUID, expiresAt *time.Time) (*ApiKey, error) {
+	prefix := make([]byte, 5)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	key := &ApiKey{
+		Id:        uuid.New(),
+		Name:      name,
+		Prefix:    strings.ToLower(apiKeyEncoding.EncodeToString(prefix)),
+		StoreIds:  storeIds,
+		ExpiresAt: expiresAt,
+	}
+	if key.StoreIds == nil {
+		key.StoreIds = []uuid.UUID{}
+	}
+	key.Plaintext = apiKeyScheme + key.Prefix + "_" + strings.ToLower(apiKeyEncoding.EncodeToString(secret))
+	hash := sha256.Sum256([]byte(key.Plaintext))
+	key.Hash = hash[:]
+	return key, nil
+}
+
+// ApiKeyPrefix returns the prefix of the API key plaintext, and false if it
+// is not shaped like one.
+func ApiKeyPrefix(plaintext string) (string, bool) {
+	rest, ok := strings.CutPrefix(plaintext, apiKeyScheme)
+	if !ok {
+		return "", false
+	}
+	prefix, secret, ok := strings.Cut(rest, "_")
+	return prefix, ok && prefix != "" && secret != ""
+}
+
+// Matches reports whether plaintext is the key.
+func (k *ApiKey) Matches(plaintext string) bool {
+	hash := sha256.Sum256([]byte(plaintext))
+	return subtle.ConstantTimeCompare(hash[:], k.Hash) == 1
+}
+
+// Expired reports whether the key is past its expiry at the given time.
+func (k *ApiKey) Expired(at time.Time) bool {
+	return k.ExpiresAt != nil && !at.Before(*k.ExpiresAt)
+}
+
+// Unrestricted reports whether the key can access every store.
+func (k *ApiKey) Unrestricted() bool {
+	return len(k.StoreIds) == 0
+}
+
+// CanAccess reports whether the key can access the store storeId.
+func (k *ApiKey) CanAccess(storeId uuid.UUID) bool {
+	return k.Unrestricted() || slices.Contains(k.StoreIds, storeId)
+}
+
+type ApiKeyModel struct {
+	Db dbtx
+}
+
+func (m *ApiKeyModel) Insert(ctx context.Context, key *ApiKey) error {
+	query := `INSERT INTO api_keys (id, name, prefix, hash, store_ids, expires_at)
+		    VALUES ($1, $2, $3, $4, $5, $6) RETURNING created_at`
+
+	args := []any{key.Id, key.Name, key.Prefix, key.Hash, pq.Array(key.StoreIds), key.ExpiresAt}
+	return m.Db.QueryRowContext(ctx, query, args...).Scan(&key.CreatedAt)
+}
+
+// GetByPrefix returns the key with the given prefix that has not been
+// revoked.
+func (m *ApiKeyModel) GetByPrefix(ctx context.Context, prefix string) (*ApiKey, error) {
+	query := `SELECT id, name, prefix, hash, store_ids, expires_at, revoked_at, created_at FROM api_keys
+		    WHERE prefix = $1 AND revoked_at IS NULL`
+
+	key, err := scanApiKey(m.Db.QueryRowContext(ctx, query, prefix))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return key, nil
+}
+
+// GetAll returns every key, revoked ones included, newest first.
+func (m *ApiKeyModel) GetAll(ctx context.Context) ([]*ApiKey, error) {
+	query := `SELECT id, name, prefix, hash, store_ids, expires_at, revoked_at, created_at FROM api_keys
+		    ORDER BY created_at DESC`
+
+	rows, err := m.Db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			slog.Error("failed to close rows", "err", err)
+		}
+	}()
+	keys := []*ApiKey{}
+
+	for rows.Next() {
+		key, err := scanApiKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Revoke stops the key from authenticating any further request. It is kept,
+// so that the audit entries made with it can still be told apart.
+func (m *ApiKeyModel) Revoke(ctx context.Context, id uuid.UUID) error {
+	query := `UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`
+
+	result, err := m.Db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// scanApiKey reads a key from row, which is a *sql.Row or *sql.Rows.
+func scanApiKey(row interface{ Scan(...any) error }) (*ApiKey, error) {
+	var key ApiKey
+	err := row.Scan(
+		&key.Id, &key.Name,
+		&key.Prefix, &key.Hash,
+		pq.Array(&key.StoreIds),
+		&key.ExpiresAt, &key.RevokedAt,
+		&key.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if key.StoreIds == nil {
+		key.StoreIds = []uuid.UUID{}
+	}
+	return &key, nil
+}
@@ -0,0 +1,36 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestApiKey(t *testing.T) {
+	storeId := uuid.New()
+	expiresAt := time.Now().Add(time.Hour)
+
+	key, err := GenerateApiKey("ci", []uuid.UUID{storeId}, &expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix, ok := ApiKeyPrefix(key.Plaintext)
+	if !ok || prefix != key.Prefix {
+		t.Fatalf("expected prefix %q from %q, got %q", key.Prefix, key.Plaintext, prefix)
+	}
+	if !key.Matches(key.Plaintext) || key.Matches(key.Plaintext+"x") {
+		t.Fatal("expected the key to match its plaintext only")
+	}
+	if key.Expired(time.Now()) || !key.Expired(expiresAt) {
+		t.Fatal("expected the key to expire at its expiry")
+	}
+	if !key.CanAccess(storeId) || key.CanAccess(uuid.New()) {
+		t.Fatal("expected the key to access its store only")
+	}
+	for _, plaintext := range []string{"", "adk_", "adk_prefix", "adk__secret", "xyz_prefix_secret"} {
+		if _, ok := ApiKeyPrefix(plaintext); ok {
+			t.Errorf("expected %q not to be an API key", plaintext)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The entity types an audit entry can be about.
+const (
+	AuditStore      = "store"
+	AuditPage       = "page"
+	AuditWidget     = "widget"
+	AuditWidgetType = "widget_type"
+	AuditMember     = "member"
+	AuditInvitation = "invitation"
+)
+
+// AuditEntities lists the entity types. The id of a member entry is the id of
+// the user.
+var AuditEntities = []string{AuditStore, AuditPage, AuditWidget, AuditWidgetType, AuditMember, AuditInvitation}
+
+// AuditEntry records one change to a store: who made it, in which request,
+// to what, and the entity as it was before and after. Before is null for a
+// create and After is null for a delete.
+type AuditEntry struct {
+	Id         int64           `json:"id"`
+	StoreId    uuid.UUID       `json:"store_id"`
+	Actor      string          `json:"actor"`
+	RequestId  string          `json:"request_id"`
+	EntityType string          `json:"entity_type"`
+	EntityId   uuid.UUID       `json:"entity_id"`
+	Action     string          `json:"action"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// AuditFilter narrows down the entries returned by GetForStore. Zero fields
+// do not filter. Cursor is the id of the last entry of the previous page;
+// only older entries are returned.
+type AuditFilter struct {
+	EntityType string
+	EntityId   *uuid.UUID
+	Actor      string
+	Since      *time.Time
+	Until      *time.Time
+	Cursor     int64
+	Limit      int
+}
+
+type AuditModel struct {
+	Db dbtx
+}
+
+// Record appends entry to the audit log and sets its id and creation time.
+// It joins the unit of work the model is part of, which is what ties the
+// entry to the change it records.
+func (m *AuditModel) Record(ctx context.Context, entry *AuditEntry) error {
+	query := `INSERT INTO audit_log (store_id, actor, request_id, entity_type, entity_id, action, before, after)
+		    VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, created_at`
+
+	args := []any{
+		entry.StoreId, entry.Actor, entry.RequestId,
+		entry.EntityType, entry.EntityId, entry.Action,
+		nullJSON(entry.Before), nullJSON(entry.After),
+	}
+	return m.Db.QueryRowContext(ctx, query, args...).Scan(&entry.Id, &entry.CreatedAt)
+}
+
+// GetForStore returns the entries of a store that match filter, newest first.
+func (m *AuditModel) GetForStore(ctx context.Context, storeId uuid.UUID, filter AuditFilter) ([]*AuditEntry, error) {
+	query := `SELECT id, store_id, actor, request_id, entity_type, entity_id, action, before, after, created_at
+		    FROM audit_log
+		    WHERE store_id = $1
+		      AND ($2 = '' OR entity_type = $2)
+		      AND ($3::uuid IS NULL OR entity_id = $3)
+		      AND ($4 = '' OR actor = $4)
+		      AND ($5::timestamptz IS NULL OR created_at >= $5)
+		      AND ($6::timestamptz IS NULL OR created_at < $6)
+		      AND ($7 = 0 OR id < $7)
+		    ORDER BY id DESC
+		    LIMIT $8`
+
+	args := []any{storeId, filter.EntityType, filter.EntityId, filter.Actor, filter.Since, filter.Until, filter.Cursor, filter.Limit}
+
+	rows, err := m.Db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			slog.Error("failed to close rows", "err", err)
+		}
+	}()
+	entries := []*AuditEntry{}
+
+	for rows.Next() {
+		var entry AuditEntry
+		var before, after []byte
+
+		err := rows.Scan(
+			&entry.Id, &entry.StoreId,
+			&entry.Actor, &entry.RequestId,
+			&entry.EntityType, &entry.EntityId,
+			&entry.Action,
+			&before, &after,
+			&entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		entry.Before, entry.After = before, after
+		entries = append(entries, &entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// nullJSON returns raw as a query argument, with no value stored as NULL.
+func nullJSON(raw json.RawMessage) any {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	return []byte(raw)
+}
@@ -0,0 +1,184 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// invitationScheme starts every invitation token.
+const invitationScheme = "adi_"
+
+// Invitation offers a role in a store to whoever holds its token and logs in
+// with its email. The token is only known when it is generated; what is kept
+// is a hash of it. An invitation is pending until it is accepted, revoked or
+// expires.
+type Invitation struct {
+	Id         uuid.UUID  `json:"id"`
+	StoreId    uuid.UUID  `json:"store_id"`
+	Email      string     `json:"email"`
+	Role       string     `json:"role"`
+	Token      string     `json:"-"`
+	Hash       []byte     `json:"-"`
+	InvitedBy  string     `json:"invited_by"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at"`
+	AcceptedBy *uuid.UUID `json:"accepted_by"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// GenerateInvitation returns a new invitation to the store storeId that
+// lasts for ttl, with a random token.
+func GenerateInvitation(storeId uuid.UUID, email, role, invitedBy string, ttl time.Duration) (*Invitation, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	inv := &Invitation{
+		Id:        uuid.New(),
+		StoreId:   storeId,
+		Email:     email,
+		Role:      role,
+		Token:     invitationScheme + strings.ToLower(apiKeyEncoding.EncodeToString(secret)),
+		InvitedBy: invitedBy,
+		ExpiresAt: time.Now().Add(ttl).UTC().Truncate(time.Second),
+	}
+	inv.Hash = invitationHash(inv.Token)
+	return inv, nil
+}
+
+func invitationHash(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
+
+// Pending reports whether the invitation can still be accepted at the given
+// time.
+func (inv *Invitation) Pending(at time.Time) bool {
+	return inv.AcceptedAt == nil && inv.RevokedAt == nil && at.Before(inv.ExpiresAt)
+}
+
+type InvitationModel struct {
+	Db dbtx
+}
+
+func (m *InvitationModel) Insert(ctx context.Context, inv *Invitation) error {
+	query := `INSERT INTO invitations (id, store_id, email, role, hash, invited_by, expires_at)
+		    VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING created_at`
+
+	args := []any{inv.Id, inv.StoreId, inv.Email, inv.Role, inv.Hash, inv.InvitedBy, inv.ExpiresAt}
+	return m.Db.QueryRowContext(ctx, query, args...).Scan(&inv.CreatedAt)
+}
+
+// GetAllForStore returns every invitation of a store, newest first.
+func (m *InvitationModel) GetAllForStore(ctx context.Context, storeId uuid.UUID) ([]*Invitation, error) {
+	query := `SELECT id, store_id, email, role, hash, invited_by, expires_at, accepted_at, accepted_by, revoked_at, created_at
+		    FROM invitations WHERE store_id = $1
+		    ORDER BY created_at DESC, id`
+
+	rows, err := m.Db.QueryContext(ctx, query, storeId)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			slog.Error("failed to close rows", "err", err)
+		}
+	}()
+	invitations := []*Invitation{}
+
+	for rows.Next() {
+		inv, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, inv)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+// GetPending returns the pending invitation with the given token.
+func (m *InvitationModel) GetPending(ctx context.Context, token string) (*Invitation, error) {
+	query := `SELECT id, store_id, email, role, hash, invited_by, expires_at, accepted_at, accepted_by, revoked_at, created_at
+		    FROM invitations
+		    WHERE hash = $1 AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > NOW()`
+
+	inv, err := scanInvitation(m.Db.QueryRowContext(ctx, query, invitationHash(token)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return inv, nil
+}
+
+// Accept marks the pending invitation id as accepted by the user userId. It
+// fails with ErrRecordNotFound if the invitation is no longer pending, so that
+// it is only ever accepted once.
+func (m *InvitationModel) Accept(ctx context.Context, id, userId uuid.UUID) error {
+	query := `UPDATE invitations SET accepted_at = NOW(), accepted_by = $2
+		    WHERE id = $1 AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > NOW()`
+
+	result, err := m.Db.ExecContext(ctx, query, id, userId)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Revoke withdraws the pending invitation id of the store storeId.
+func (m *InvitationModel) Revoke(ctx context.Context, storeId, id uuid.UUID) error {
+	query := `UPDATE invitations SET revoked_at = NOW()
+		    WHERE id = $1 AND store_id = $2 AND accepted_at IS NULL AND revoked_at IS NULL`
+
+	result, err := m.Db.ExecContext(ctx, query, id, storeId)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// scanInvitation reads an invitation from row, which is a *sql.Row or
+// *sql.Rows.
+func scanInvitation(row interface{ Scan(...any) error }) (*Invitation, error) {
+	var inv Invitation
+	err := row.Scan(
+		&inv.Id, &inv.StoreId,
+		&inv.Email, &inv.Role,
+		&inv.Hash, &inv.InvitedBy,
+		&inv.ExpiresAt,
+		&inv.AcceptedAt, &inv.AcceptedBy,
+		&inv.RevokedAt,
+		&inv.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
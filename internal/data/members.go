@@ -0,0 +1,200 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The roles a user can have in a store. A viewer can read it, an editor can
+// also change its content, and an owner can also delete it and manage its
+// members.
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// Roles lists the roles.
+var Roles = []string{RoleOwner, RoleEditor, RoleViewer}
+
+// Member gives a user a role in a store. Name and Email are the user's.
+type Member struct {
+	StoreId   uuid.UUID `json:"store_id"`
+	UserId    uuid.UUID `json:"user_id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type MemberModel struct {
+	Db dbtx
+}
+
+func (m *MemberModel) Insert(ctx context.Context, member *Member) error {
+	query := `INSERT INTO store_members (store_id, user_id, role) VALUES ($1, $2, $3) RETURNING created_at`
+
+	err := m.Db.QueryRowContext(ctx, query, member.StoreId, member.UserId, member.Role).Scan(&member.CreatedAt)
+	if isUniqueViolation(err) {
+		return ErrDuplicateMember
+	}
+	return err
+}
+
+func (m *MemberModel) Get(ctx context.Context, storeId, userId uuid.UUID) (*Member, error) {
+	query := `SELECT m.store_id, m.user_id, u.name, u.email, m.role, m.created_at
+		    FROM store_members m JOIN users u ON u.id = m.user_id
+		    WHERE m.store_id = $1 AND m.user_id = $2`
+
+	var member Member
+	err := m.Db.QueryRowContext(ctx, query, storeId, userId).Scan(
+		&member.StoreId, &member.UserId,
+		&member.Name, &member.Email,
+		&member.Role, &member.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return &member, nil
+}
+
+// GetAllForStore returns the members of a store, by email.
+func (m *MemberModel) GetAllForStore(ctx context.Context, storeId uuid.UUID) ([]*Member, error) {
+	query := `SELECT m.store_id, m.user_id, u.name, u.email, m.role, m.created_at
+		    FROM store_members m JOIN users u ON u.id = m.user_id
+		    WHERE m.store_id = $1
+		    ORDER BY u.email`
+
+	rows, err := m.Db.QueryContext(ctx, query, storeId)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			slog.Error("failed to close rows", "err", err)
+		}
+	}()
+	members := []*Member{}
+
+	for rows.Next() {
+		var member Member
+		err := rows.Scan(
+			&member.StoreId, &member.UserId,
+			&member.Name, &member.Email,
+			&member.Role, &member.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, &member)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// GetRolesForUser returns the role of the user in each store they are a
+// member of, by store.
+func (m *MemberModel) GetRolesForUser(ctx context.Context, userId uuid.UUID) (map[uuid.UUID]string, error) {
+	rows, err := m.Db.QueryContext(ctx, `SELECT store_id, role FROM store_members WHERE user_id = $1`, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			slog.Error("failed to close rows", "err", err)
+		}
+	}()
+	roles := make(map[uuid.UUID]string)
+
+	for rows.Next() {
+		var storeId uuid.UUID
+		var role string
+		if err := rows.Scan(&storeId, &role); err != nil {
+			return nil, err
+		}
+		roles[storeId] = role
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// UpdateRole gives the member a new role. A store keeps its last owner: they
+// cannot be given another role.
+func (m *MemberModel) UpdateRole(ctx context.Context, storeId, userId uuid.UUID, role string) error {
+	return withTx(ctx, m.Db, func(tx dbtx) error {
+		if err := keepOwner(ctx, tx, storeId, userId, role); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `UPDATE store_members SET role = $3 WHERE store_id = $1 AND user_id = $2`,
+			storeId, userId, role)
+		return err
+	})
+}
+
+// Delete removes the user from the store. The last owner cannot be removed.
+func (m *MemberModel) Delete(ctx context.Context, storeId, userId uuid.UUID) error {
+	return withTx(ctx, m.Db, func(tx dbtx) error {
+		if err := keepOwner(ctx, tx, storeId, userId, ""); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `DELETE FROM store_members WHERE store_id = $1 AND user_id = $2`, storeId, userId)
+		return err
+	})
+}
+
+// keepOwner checks that the member userId can be given the role role, none
+// for a removal, without taking the last owner away from the store. It locks
+// the memberships of the store for the rest of the transaction, so that two
+// owners cannot demote each other at once.
+func keepOwner(ctx context.Context, tx dbtx, storeId, userId uuid.UUID, role string) error {
+	query := `SELECT user_id, role FROM store_members WHERE store_id = $1 FOR UPDATE`
+
+	rows, err := tx.QueryContext(ctx, query, storeId)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			slog.Error("failed to close rows", "err", err)
+		}
+	}()
+	var found, wasOwner bool
+	var owners int
+
+	for rows.Next() {
+		var id uuid.UUID
+		var current string
+		if err := rows.Scan(&id, &current); err != nil {
+			return err
+		}
+		if id == userId {
+			found, wasOwner = true, current == RoleOwner
+			current = role
+		}
+		if current == RoleOwner {
+			owners++
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	switch {
+	case !found:
+		return ErrRecordNotFound
+	case wasOwner && owners == 0:
+		return ErrLastOwner
+	}
+	return nil
+}
@@ -0,0 +1,1969 @@
+package data
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// NewMemoryModels returns Models backed by memory instead of PostgreSQL. It
+// keeps the semantics of the database: unique slugs, unique routes per store,
+// a single home page per store, deletes that go to the trash and widgets
+// ordered by position. It starts out empty, so call WidgetTypes.EnsureBuiltins before
+// adding widgets. It is meant for tests.
+func NewMemoryModels() Models {
+	db := &memoryDB{memoryTables: memoryTables{
+		order:        make(map[uuid.UUID]int),
+		stores:       make(map[uuid.UUID]*Store),
+		pages:        make(map[uuid.UUID]*Page),
+		widgets:      make(map[uuid.UUID]*Widget),
+		widgetTypes:  make(map[uuid.UUID]*WidgetType),
+		publications: make(map[uuid.UUID][]*Publication),
+		versions:     make(map[uuid.UUID][]*PageVersion),
+
+		trashedStores:  make(map[uuid.UUID]*Store),
+		trashedPages:   make(map[uuid.UUID]*Page),
+		trashedWidgets: make(map[uuid.UUID]*Widget),
+
+		apiKeys:  make(map[uuid.UUID]*ApiKey),
+		users:    make(map[uuid.UUID]*User),
+		sessions: make(map[string]*Session),
+		members:  make(map[memberKey]*Member),
+
+		invitations:  make(map[uuid.UUID]*Invitation),
+		oauthClients: make(map[uuid.UUID]*OAuthClient),
+	}}
+	m := Models{
+		Stores:       &memoryStores{db},
+		Pages:        &memoryPages{db},
+		Widgets:      &memoryWidgets{db},
+		WidgetTypes:  &memoryWidgetTypes{db},
+		Publications: &memoryPublications{db},
+		PageVersions: &memoryPageVersions{db},
+		Trash:        &memoryTrash{db},
+		Audit:        &memoryAudit{db},
+		ApiKeys:      &memoryApiKeys{db},
+		Users:        &memoryUsers{db},
+		Sessions:     &memorySessions{db},
+		Members:      &memoryMembers{db},
+		Invitations:  &memoryInvitations{db},
+		OAuthClients: &memoryOAuthClients{db},
+	}
+	m.inTx = db.txRunner(m)
+	return m
+}
+
+// memoryDB holds the tables of the in-memory backend. A single mutex guards
+// all of them. Records are stored as private copies and copied again on the
+// way out, so callers can never modify them behind the backend's back.
+type memoryDB struct {
+	mu sync.Mutex
+
+	// txMu serializes units of work. A unit of work is not isolated from
+	// calls made outside of one, which is fine for tests.
+	txMu sync.Mutex
+
+	memoryTables
+}
+
+type memoryTables struct {
+	// order records the insertion sequence of rows, standing in for the
+	// created_at ordering of the SQL queries.
+	seq   int
+	order map[uuid.UUID]int
+
+	stores       map[uuid.UUID]*Store
+	pages        map[uuid.UUID]*Page
+	widgets      map[uuid.UUID]*Widget
+	widgetTypes  map[uuid.UUID]*WidgetType
+	publications map[uuid.UUID][]*Publication // by page, oldest revision first
+	versions     map[uuid.UUID][]*PageVersion // by page, oldest version first
+
+	// Deleted rows move to the trash tables with their DeletedAt set, which
+	// keeps them out of every live read without a check in each of them.
+	trashedStores  map[uuid.UUID]*Store
+	trashedPages   map[uuid.UUID]*Page
+	trashedWidgets map[uuid.UUID]*Widget
+
+	audit []*AuditEntry // oldest first, the id is the index plus one
+
+	apiKeys  map[uuid.UUID]*ApiKey
+	users    map[uuid.UUID]*User
+	sessions map[string]*Session // by hash
+	members  map[memberKey]*Member
+
+	invitations  map[uuid.UUID]*Invitation
+	oauthClients map[uuid.UUID]*OAuthClient
+}
+
+type memberKey struct {
+	storeId, userId uuid.UUID
+}
+
+// clone returns a deep copy of the tables.
+func (t *memoryTables) clone() memoryTables {
+	c := memoryTables{
+		seq:          t.seq,
+		order:        maps.Clone(t.order),
+		stores:       make(map[uuid.UUID]*Store, len(t.stores)),
+		pages:        make(map[uuid.UUID]*Page, len(t.pages)),
+		widgets:      make(map[uuid.UUID]*Widget, len(t.widgets)),
+		widgetTypes:  make(map[uuid.UUID]*WidgetType, len(t.widgetTypes)),
+		publications: make(map[uuid.UUID][]*Publication, len(t.publications)),
+		versions:     make(map[uuid.UUID][]*PageVersion, len(t.versions)),
+
+		trashedStores:  make(map[uuid.UUID]*Store, len(t.trashedStores)),
+		trashedPages:   make(map[uuid.UUID]*Page, len(t.trashedPages)),
+		trashedWidgets: make(map[uuid.UUID]*Widget, len(t.trashedWidgets)),
+
+		apiKeys:  make(map[uuid.UUID]*ApiKey, len(t.apiKeys)),
+		users:    make(map[uuid.UUID]*User, len(t.users)),
+		sessions: maps.Clone(t.sessions),
+		members:  make(map[memberKey]*Member, len(t.members)),
+
+		invitations:  make(map[uuid.UUID]*Invitation, len(t.invitations)),
+		oauthClients: make(map[uuid.UUID]*OAuthClient, len(t.oauthClients)),
+	}
+	for id, s := range t.stores {
+		store := *s
+		c.stores[id] = &store
+	}
+	for id, s := range t.trashedStores {
+		store := *s
+		c.trashedStores[id] = &store
+	}
+	for id, p := range t.pages {
+		page := *p
+		c.pages[id] = &page
+	}
+	for id, p := range t.trashedPages {
+		page := *p
+		c.trashedPages[id] = &page
+	}
+	for id, w := range t.widgets {
+		c.widgets[id] = copyWidget(w)
+	}
+	for id, w := range t.trashedWidgets {
+		c.trashedWidgets[id] = copyWidget(w)
+	}
+	for id, wt := range t.widgetTypes {
+		c.widgetTypes[id] = copyWidgetType(wt)
+	}
+	// Publications and versions are never modified once appended, so the
+	// records themselves can be shared.
+	for id, pubs := range t.publications {
+		c.publications[id] = slices.Clone(pubs)
+	}
+	for id, versions := range t.versions {
+		c.versions[id] = slices.Clone(versions)
+	}
+	c.audit = slices.Clone(t.audit)
+	for id, k := range t.apiKeys {
+		c.apiKeys[id] = copyApiKey(k)
+	}
+	for id, u := range t.users {
+		c.users[id] = copyUser(u)
+	}
+	for k, member := range t.members {
+		m := *member
+		c.members[k] = &m
+	}
+	for id, inv := range t.invitations {
+		c.invitations[id] = copyInvitation(inv)
+	}
+	for id, client := range t.oauthClients {
+		c.oauthClients[id] = copyOAuthClient(client)
+	}
+	return c
+}
+
+// txRunner returns the unit of work of the in-memory backend. It saves the
+// tables before running fn and puts them back if fn fails.
+func (db *memoryDB) txRunner(m Models) txRunner {
+	return func(_ context.Context, fn func(Models) error) error {
+		db.txMu.Lock()
+		defer db.txMu.Unlock()
+
+		db.mu.Lock()
+		saved := db.clone()
+		db.mu.Unlock()
+
+		inner := m
+		inner.inTx = joinTx(inner)
+
+		if err := fn(inner); err != nil {
+			db.mu.Lock()
+			db.memoryTables = saved
+			db.mu.Unlock()
+			return err
+		}
+		return nil
+	}
+}
+
+// now returns the current time at the precision of the TIMESTAMP(0) columns.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// jsonCopy returns a deep copy of v made the way a JSONB column stores it, so
+// numbers come back as float64 just like they do from PostgreSQL.
+func jsonCopy[T any](v T) (T, error) {
+	var out T
+	b, err := json.Marshal(v)
+	if err != nil {
+		return out, err
+	}
+	err = json.Unmarshal(b, &out)
+	return out, err
+}
+
+func (db *memoryDB) insertOrder(id uuid.UUID) {
+	db.seq++
+	db.order[id] = db.seq
+}
+
+// newestFirst sorts records by descending insertion order.
+func newestFirst[T any](db *memoryDB, records []T, id func(T) uuid.UUID) {
+	slices.SortFunc(records, func(a, b T) int {
+		return cmp.Compare(db.order[id(b)], db.order[id(a)])
+	})
+}
+
+func copyWidget(w *Widget) *Widget {
+	c := *w
+	c.Config, _ = jsonCopy(w.Config)
+	return &c
+}
+
+func copyWidgets(widgets []*Widget) []*Widget {
+	if widgets == nil {
+		return nil
+	}
+	out := make([]*Widget, len(widgets))
+	for i, w := range widgets {
+		out[i] = copyWidget(w)
+	}
+	return out
+}
+
+func copyWidgetType(wt *WidgetType) *WidgetType {
+	c := *wt
+	c.Schema, _ = jsonCopy(wt.Schema)
+	if wt.StoreId != nil {
+		storeId := *wt.StoreId
+		c.StoreId = &storeId
+	}
+	return &c
+}
+
+// pageWidgets returns copies of the widgets of a page ordered by position, or
+// nil if it has none.
+func (db *memoryDB) pageWidgets(pageId uuid.UUID) []*Widget {
+	var widgets []*Widget
+	for _, w := range db.widgets {
+		if w.PageId == pageId {
+			widgets = append(widgets, copyWidget(w))
+		}
+	}
+	slices.SortFunc(widgets, func(a, b *Widget) int { return cmp.Compare(a.Position, b.Position) })
+	return widgets
+}
+
+// snapshot returns a copy of the page with its widgets, which are never nil.
+func (db *memoryDB) snapshot(pageId uuid.UUID) (*Page, error) {
+	page, ok := db.pages[pageId]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	c := *page
+	c.Widgets = db.pageWidgets(pageId)
+	if c.Widgets == nil {
+		c.Widgets = []*Widget{}
+	}
+	return &c, nil
+}
+
+// routeTaken reports whether another page of the store already uses route.
+func (db *memoryDB) routeTaken(storeId, pageId uuid.UUID, route string) bool {
+	for _, p := range db.pages {
+		if p.StoreId == storeId && p.Id != pageId && p.Route == route {
+			return true
+		}
+	}
+	return false
+}
+
+// clearHome unsets the home page of the store, except for pageId, which moves
+// to its next version.
+func (db *memoryDB) clearHome(storeId, pageId uuid.UUID) {
+	for _, p := range db.pages {
+		if p.StoreId == storeId && p.Id != pageId && p.IsHome {
+			p.IsHome = false
+			p.UpdatedAt = now()
+			p.Version++
+		}
+	}
+}
+
+// trashPage moves a page and its widgets to the trash, marked with deletedAt.
+func (db *memoryDB) trashPage(id uuid.UUID, deletedAt time.Time) {
+	for wid, w := range db.widgets {
+		if w.PageId == id {
+			w.DeletedAt = &deletedAt
+			db.trashedWidgets[wid] = w
+			delete(db.widgets, wid)
+		}
+	}
+	page := db.pages[id]
+	page.DeletedAt = &deletedAt
+	db.trashedPages[id] = page
+	delete(db.pages, id)
+}
+
+// purgePage removes a page from the trash for good, with everything that
+// references it.
+func (db *memoryDB) purgePage(id uuid.UUID) {
+	for wid, w := range db.trashedWidgets {
+		if w.PageId == id {
+			delete(db.trashedWidgets, wid)
+		}
+	}
+	delete(db.publications, id)
+	delete(db.versions, id)
+	delete(db.trashedPages, id)
+	delete(db.order, id)
+}
+
+// anyPage returns the page id, whether it is in the trash or not, or nil.
+func (db *memoryDB) anyPage(id uuid.UUID) *Page {
+	if page, ok := db.pages[id]; ok {
+		return page
+	}
+	return db.trashedPages[id]
+}
+
+// resolvePlacement returns the position a widget placed at lands on, between
+// 0 and the number of widgets on the page, leaving out the widget self.
+func (db *memoryDB) resolvePlacement(pageId uuid.UUID, at Placement, self uuid.UUID) (int, error) {
+	count := 0
+	for _, w := range db.widgets {
+		if w.PageId == pageId && w.Id != self {
+			count++
+		}
+	}
+	anchor, offset := at.Before, 0
+	if at.After != nil {
+		anchor, offset = at.After, 1
+	}
+	switch {
+	case anchor != nil:
+		w, ok := db.widgets[*anchor]
+		if !ok || w.PageId != pageId || w.Id == self {
+			return 0, ErrForeignWidgets
+		}
+		return w.Position + offset, nil
+	case at.Position != nil:
+		return min(max(*at.Position, 0), count), nil
+	default:
+		return count, nil
+	}
+}
+
+// lookupType returns the widget type called name that is visible to the
+// store, preferring a type defined by the store over a built-in.
+func (db *memoryDB) lookupType(storeId uuid.UUID, name string) *WidgetType {
+	var found *WidgetType
+	for _, wt := range db.widgetTypes {
+		if wt.Name != name {
+			continue
+		}
+		if wt.StoreId != nil && *wt.StoreId == storeId {
+			return wt
+		}
+		if wt.StoreId == nil {
+			found = wt
+		}
+	}
+	return found
+}
+
+// typeRegistered reports whether the type can be used on the page.
+func (db *memoryDB) typeRegistered(pageId uuid.UUID, name string) bool {
+	page, ok := db.pages[pageId]
+	if !ok {
+		return false
+	}
+	return db.lookupType(page.StoreId, name) != nil
+}
+
+type memoryStores struct {
+	db *memoryDB
+}
+
+func (m *memoryStores) Insert(_ context.Context, store *Store) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	if _, ok := m.db.stores[store.Id]; ok {
+		return fmt.Errorf("store %s already exists", store.Id)
+	}
+	for _, s := range m.db.stores {
+		if s.Slug == store.Slug {
+			return ErrDuplicateSlug
+		}
+	}
+	store.CreatedAt, store.UpdatedAt = now(), now()
+	store.Version = 1
+
+	c := *store
+	m.db.stores[store.Id] = &c
+	m.db.insertOrder(store.Id)
+	return nil
+}
+
+func (m *memoryStores) Get(_ context.Context, id uuid.UUID) (*Store, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	store, ok := m.db.stores[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	c := *store
+	return &c, nil
+}
+
+func (m *memoryStores) GetBySlug(_ context.Context, slug string) (*Store, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	for _, store := range m.db.stores {
+		if store.Slug == slug {
+			c := *store
+			return &c, nil
+		}
+	}
+	return nil, ErrRecordNotFound
+}
+
+func (m *memoryStores) GetAll(_ context.Context, filter StoreFilter) ([]*Store, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	var stores []*Store
+	for _, store := range m.db.stores {
+		if filter.Template != nil && store.IsTemplate != *filter.Template {
+			continue
+		}
+		c := *store
+		stores = append(stores, &c)
+	}
+	newestFirst(m.db, stores, func(s *Store) uuid.UUID { return s.Id })
+	return stores, nil
+}
+
+func (m *memoryStores) Update(_ context.Context, store *Store) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	current, ok := m.db.stores[store.Id]
+	if !ok || current.Version != store.Version {
+		return ErrEditConflict
+	}
+	for _, s := range m.db.stores {
+		if s.Id != store.Id && s.Slug == store.Slug {
+			return ErrDuplicateSlug
+		}
+	}
+	current.Name, current.Slug, current.IsTemplate = store.Name, store.Slug, store.IsTemplate
+	current.UpdatedAt = now()
+	current.Version++
+
+	store.UpdatedAt, store.Version = current.UpdatedAt, current.Version
+	return nil
+}
+
+func (m *memoryStores) Delete(_ context.Context, id uuid.UUID, version int) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	store, ok := m.db.stores[id]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	if store.Version != version {
+		return ErrEditConflict
+	}
+	deletedAt := time.Now().UTC()
+	for pageId, page := range m.db.pages {
+		if page.StoreId == id {
+			m.db.trashPage(pageId, deletedAt)
+		}
+	}
+	store.DeletedAt = &deletedAt
+	m.db.trashedStores[id] = store
+	delete(m.db.stores, id)
+	return nil
+}
+
+func (m *memoryStores) Clone(_ context.Context, sourceId uuid.UUID, store *Store) ([]*Page, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	if _, ok := m.db.stores[sourceId]; !ok {
+		return nil, ErrRecordNotFound
+	}
+	if _, ok := m.db.stores[store.Id]; ok {
+		return nil, fmt.Errorf("store %s already exists", store.Id)
+	}
+	for _, s := range m.db.stores {
+		if s.Slug == store.Slug {
+			return nil, ErrDuplicateSlug
+		}
+	}
+	store.CreatedAt, store.UpdatedAt = now(), now()
+	store.Version = 1
+
+	c := *store
+	m.db.stores[store.Id] = &c
+	m.db.insertOrder(store.Id)
+
+	for _, wt := range m.db.widgetTypes {
+		if wt.StoreId == nil || *wt.StoreId != sourceId {
+			continue
+		}
+		t := copyWidgetType(wt)
+		t.Id, t.StoreId = uuid.New(), &store.Id
+		t.CreatedAt, t.UpdatedAt = now(), now()
+		m.db.widgetTypes[t.Id] = t
+		m.db.insertOrder(t.Id)
+	}
+	var sources []*Page
+	for _, page := range m.db.pages {
+		if page.StoreId == sourceId {
+			sources = append(sources, page)
+		}
+	}
+	newestFirst(m.db, sources, func(p *Page) uuid.UUID { return p.Id })
+	slices.Reverse(sources)
+
+	var pages []*Page
+	for _, source := range sources {
+		page := &Page{
+			Id: uuid.New(), StoreId: store.Id,
+			Name: source.Name, Route: source.Route,
+			IsHome:    source.IsHome,
+			CreatedAt: now(), UpdatedAt: now(),
+			Version: 1,
+		}
+		c := *page
+		m.db.pages[page.Id] = &c
+		m.db.insertOrder(page.Id)
+
+		page.Widgets = []*Widget{}
+		for _, w := range m.db.pageWidgets(source.Id) {
+			w.Id, w.PageId = uuid.New(), page.Id
+			w.CreatedAt, w.UpdatedAt = now(), now()
+			w.Version = 1
+			m.db.widgets[w.Id] = copyWidget(w)
+			page.Widgets = append(page.Widgets, w)
+		}
+		pages = append(pages, page)
+	}
+	return pages, nil
+}
+
+type memoryPages struct {
+	db *memoryDB
+}
+
+func (m *memoryPages) Insert(_ context.Context, page *Page) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	if _, ok := m.db.stores[page.StoreId]; !ok {
+		return fmt.Errorf("store %s does not exist", page.StoreId)
+	}
+	if _, ok := m.db.pages[page.Id]; ok {
+		return fmt.Errorf("page %s already exists", page.Id)
+	}
+	if m.db.routeTaken(page.StoreId, page.Id, page.Route) {
+		return ErrDuplicateRoute
+	}
+	if page.IsHome {
+		m.db.clearHome(page.StoreId, page.Id)
+	}
+	page.CreatedAt, page.UpdatedAt = now(), now()
+	page.Version = 1
+
+	c := *page
+	c.Widgets = nil
+	m.db.pages[page.Id] = &c
+	m.db.insertOrder(page.Id)
+	return nil
+}
+
+func (m *memoryPages) GetAllForStore(_ context.Context, storeId uuid.UUID) ([]*Page, error) {
+	if storeId == uuid.Nil {
+		return nil, errors.New("storeId is required")
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	var pages []*Page
+	for _, page := range m.db.pages {
+		if page.StoreId == storeId {
+			c := *page
+			pages = append(pages, &c)
+		}
+	}
+	newestFirst(m.db, pages, func(p *Page) uuid.UUID { return p.Id })
+	return pages, nil
+}
+
+func (m *memoryPages) Get(_ context.Context, id uuid.UUID) (*Page, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	page, ok := m.db.pages[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	c := *page
+	c.Widgets = m.db.pageWidgets(id)
+	return &c, nil
+}
+
+func (m *memoryPages) Update(_ context.Context, page *Page) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	current, ok := m.db.pages[page.Id]
+	if !ok || current.Version != page.Version {
+		return ErrEditConflict
+	}
+	if m.db.routeTaken(current.StoreId, page.Id, page.Route) {
+		return ErrDuplicateRoute
+	}
+	if page.IsHome {
+		m.db.clearHome(current.StoreId, page.Id)
+	}
+	current.Name, current.Route, current.IsHome = page.Name, page.Route, page.IsHome
+	current.UpdatedAt = now()
+	current.Version++
+
+	page.UpdatedAt, page.Version = current.UpdatedAt, current.Version
+	return nil
+}
+
+func (m *memoryPages) Delete(_ context.Context, id uuid.UUID, version int) error {
+	if id == uuid.Nil {
+		return errors.New("id is required")
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	page, ok := m.db.pages[id]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	if page.Version != version {
+		return ErrEditConflict
+	}
+	if page.IsHome {
+		return ErrDeleteHomePage
+	}
+	m.db.trashPage(id, time.Now().UTC())
+	return nil
+}
+
+func (m *memoryPages) Duplicate(_ context.Context, sourceId uuid.UUID, page *Page) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	if _, ok := m.db.pages[sourceId]; !ok {
+		return ErrRecordNotFound
+	}
+	if _, ok := m.db.stores[page.StoreId]; !ok {
+		return fmt.Errorf("store %s does not exist", page.StoreId)
+	}
+	if m.db.routeTaken(page.StoreId, page.Id, page.Route) {
+		return ErrDuplicateRoute
+	}
+	widgets := m.db.pageWidgets(sourceId)
+	for _, w := range widgets {
+		if m.db.lookupType(page.StoreId, w.Type) == nil {
+			return ErrUnknownWidgetType
+		}
+	}
+	page.IsHome = false
+	page.CreatedAt, page.UpdatedAt = now(), now()
+	page.Version = 1
+
+	c := *page
+	c.Widgets = nil
+	m.db.pages[page.Id] = &c
+	m.db.insertOrder(page.Id)
+
+	page.Widgets = make([]*Widget, 0, len(widgets))
+	for _, w := range widgets {
+		w.Id, w.PageId = uuid.New(), page.Id
+		w.CreatedAt, w.UpdatedAt = now(), now()
+		w.Version = 1
+		m.db.widgets[w.Id] = copyWidget(w)
+		page.Widgets = append(page.Widgets, w)
+	}
+	return nil
+}
+
+type memoryWidgets struct {
+	db *memoryDB
+}
+
+func (m *memoryWidgets) GetForPage(_ context.Context, pageId uuid.UUID) ([]*Widget, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	return m.db.pageWidgets(pageId), nil
+}
+
+func (m *memoryWidgets) Insert(ctx context.Context, widget *Widget) error {
+	return m.InsertAt(ctx, widget, Placement{})
+}
+
+func (m *memoryWidgets) InsertAt(_ context.Context, widget *Widget, at Placement) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	if _, ok := m.db.pages[widget.PageId]; !ok {
+		return ErrRecordNotFound
+	}
+	if _, ok := m.db.widgets[widget.Id]; ok {
+		return fmt.Errorf("widget %s already exists", widget.Id)
+	}
+	position, err := m.db.resolvePlacement(widget.PageId, at, uuid.Nil)
+	if err != nil {
+		return err
+	}
+	if !m.db.typeRegistered(widget.PageId, widget.Type) {
+		return ErrUnknownWidgetType
+	}
+	config, err := jsonCopy(widget.Config)
+	if err != nil {
+		return err
+	}
+	for _, w := range m.db.widgets {
+		if w.PageId == widget.PageId && w.Position >= position {
+			w.Position++
+			w.Version++
+		}
+	}
+	widget.Position = position
+	widget.CreatedAt, widget.UpdatedAt = now(), now()
+	widget.Version = 1
+
+	c := *widget
+	c.Config = config
+	m.db.widgets[widget.Id] = &c
+	return nil
+}
+
+func (m *memoryWidgets) Get(_ context.Context, id uuid.UUID) (*Widget, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	widget, ok := m.db.widgets[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	return copyWidget(widget), nil
+}
+
+func (m *memoryWidgets) Update(_ context.Context, widget *Widget) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	current, ok := m.db.widgets[widget.Id]
+	if !ok || current.Version != widget.Version || current.PageId != widget.PageId {
+		return ErrEditConflict
+	}
+	if !m.db.typeRegistered(widget.PageId, widget.Type) {
+		return ErrUnknownWidgetType
+	}
+	config, err := jsonCopy(widget.Config)
+	if err != nil {
+		return err
+	}
+	current.Position, current.Type, current.Config = widget.Position, widget.Type, config
+	current.UpdatedAt = now()
+	current.Version++
+
+	widget.UpdatedAt, widget.Version = current.UpdatedAt, current.Version
+	return nil
+}
+
+func (m *memoryWidgets) Delete(_ context.Context, id uuid.UUID, version int) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	widget, ok := m.db.widgets[id]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	if widget.Version != version {
+		return ErrEditConflict
+	}
+	deletedAt := time.Now().UTC()
+	widget.DeletedAt = &deletedAt
+	m.db.trashedWidgets[id] = widget
+	delete(m.db.widgets, id)
+	for _, w := range m.db.widgets {
+		if w.PageId == widget.PageId && w.Position > widget.Position {
+			w.Position--
+			w.Version++
+		}
+	}
+	return nil
+}
+
+func (m *memoryWidgets) Move(_ context.Context, widget *Widget, pageId uuid.UUID, at Placement) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	from, okFrom := m.db.pages[widget.PageId]
+	to, okTo := m.db.pages[pageId]
+	if !okFrom || !okTo {
+		return ErrRecordNotFound
+	}
+	if from.StoreId != to.StoreId {
+		return ErrForeignPage
+	}
+	current, ok := m.db.widgets[widget.Id]
+	if !ok || current.Version != widget.Version || current.PageId != widget.PageId {
+		return ErrEditConflict
+	}
+	// Check the anchor before anything changes; the position it gives is
+	// only right once the gap on the old page is closed.
+	if _, err := m.db.resolvePlacement(pageId, at, current.Id); err != nil {
+		return err
+	}
+	for _, w := range m.db.widgets {
+		if w.PageId == current.PageId && w.Position > current.Position {
+			w.Position--
+			w.Version++
+		}
+	}
+	position, err := m.db.resolvePlacement(pageId, at, current.Id)
+	if err != nil {
+		return err
+	}
+	for _, w := range m.db.widgets {
+		if w.PageId == pageId && w.Position >= position && w.Id != current.Id {
+			w.Position++
+			w.Version++
+		}
+	}
+	current.PageId, current.Position = pageId, position
+	current.UpdatedAt = now()
+	current.Version++
+
+	widget.PageId, widget.Position = current.PageId, current.Position
+	widget.UpdatedAt, widget.Version = current.UpdatedAt, current.Version
+	return nil
+}
+
+func (m *memoryWidgets) Reorder(_ context.Context, pageId uuid.UUID, order Ordering) ([]*Widget, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	if _, ok := m.db.pages[pageId]; !ok {
+		return nil, ErrRecordNotFound
+	}
+	var ids []uuid.UUID
+	for _, w := range m.db.pageWidgets(pageId) {
+		ids = append(ids, w.Id)
+	}
+	ids, err := order.apply(ids)
+	if err != nil {
+		return nil, err
+	}
+	for i, id := range ids {
+		if w := m.db.widgets[id]; w.Position != i {
+			w.Position = i
+			w.Version++
+		}
+	}
+	return m.db.pageWidgets(pageId), nil
+}
+
+type memoryWidgetTypes struct {
+	db *memoryDB
+}
+
+func (m *memoryWidgetTypes) EnsureBuiltins(_ context.Context) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	for _, builtin := range builtinWidgetTypes {
+		wt := m.db.lookupType(uuid.Nil, builtin.Name)
+		if wt == nil {
+			wt = &WidgetType{Id: uuid.New(), Name: builtin.Name, CreatedAt: now()}
+			m.db.widgetTypes[wt.Id] = wt
+			m.db.insertOrder(wt.Id)
+		}
+		wt.Label = builtin.Label
+		wt.Schema, _ = jsonCopy(builtin.Schema)
+		wt.UpdatedAt = now()
+	}
+	return nil
+}
+
+func (m *memoryWidgetTypes) GetAllForStore(_ context.Context, storeId uuid.UUID) ([]*WidgetType, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	var types []*WidgetType
+	for _, wt := range m.db.widgetTypes {
+		if wt.StoreId == nil || *wt.StoreId == storeId {
+			types = append(types, copyWidgetType(wt))
+		}
+	}
+	slices.SortFunc(types, func(a, b *WidgetType) int {
+		if a.BuiltIn() != b.BuiltIn() {
+			if a.BuiltIn() {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Compare(a.Name, b.Name)
+	})
+	return types, nil
+}
+
+func (m *memoryWidgetTypes) Get(_ context.Context, id uuid.UUID) (*WidgetType, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	wt, ok := m.db.widgetTypes[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	return copyWidgetType(wt), nil
+}
+
+func (m *memoryWidgetTypes) Lookup(_ context.Context, storeId uuid.UUID, name string) (*WidgetType, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	wt := m.db.lookupType(storeId, name)
+	if wt == nil {
+		return nil, ErrRecordNotFound
+	}
+	return copyWidgetType(wt), nil
+}
+
+func (m *memoryWidgetTypes) Insert(_ context.Context, wt *WidgetType) error {
+	if wt.StoreId == nil {
+		return errors.New("storeId is required")
+	}
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	if m.db.lookupType(*wt.StoreId, wt.Name) != nil {
+		return ErrDuplicateWidgetType
+	}
+	wt.CreatedAt, wt.UpdatedAt = now(), now()
+
+	m.db.widgetTypes[wt.Id] = copyWidgetType(wt)
+	m.db.insertOrder(wt.Id)
+	return nil
+}
+
+func (m *memoryWidgetTypes) Update(_ context.Context, wt *WidgetType) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	current, ok := m.db.widgetTypes[wt.Id]
+	if !ok || current.BuiltIn() {
+		return ErrRecordNotFound
+	}
+	schema, err := jsonCopy(wt.Schema)
+	if err != nil {
+		return err
+	}
+	current.Label, current.Schema = wt.Label, schema
+	current.MinAppVersion, current.MaxAppVersion = wt.MinAppVersion, wt.MaxAppVersion
+	current.UpdatedAt = now()
+
+	wt.UpdatedAt = current.UpdatedAt
+	return nil
+}
+
+func (m *memoryWidgetTypes) Delete(_ context.Context, id uuid.UUID) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	wt, ok := m.db.widgetTypes[id]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	// Like the SQL model, built-in types are never deleted and are reported
+	// as in use.
+	if wt.BuiltIn() {
+		return ErrWidgetTypeInUse
+	}
+	for _, w := range m.db.widgets {
+		if m.db.pages[w.PageId].StoreId == *wt.StoreId && w.Type == wt.Name {
+			return ErrWidgetTypeInUse
+		}
+	}
+	for wid, w := range m.db.trashedWidgets {
+		if m.db.anyPage(w.PageId).StoreId == *wt.StoreId && w.Type == wt.Name {
+			delete(m.db.trashedWidgets, wid)
+		}
+	}
+	delete(m.db.widgetTypes, id)
+	delete(m.db.order, id)
+	return nil
+}
+
+type memoryPublications struct {
+	db *memoryDB
+}
+
+func copyPublication(pub *Publication) *Publication {
+	c := *pub
+	c.Widgets = copyWidgets(pub.Widgets)
+	return &c
+}
+
+func (m *memoryPublications) Publish(_ context.Context, pageId uuid.UUID) (*Publication, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	page, err := m.db.snapshot(pageId)
+	if err != nil {
+		return nil, err
+	}
+	pub := &Publication{
+		PageId: page.Id, StoreId: page.StoreId,
+		Revision: len(m.db.publications[pageId]) + 1,
+		Name:     page.Name, Route: page.Route,
+		IsHome:      page.IsHome,
+		Widgets:     page.Widgets,
+		PublishedAt: now(),
+	}
+	m.db.publications[pageId] = append(m.db.publications[pageId], copyPublication(pub))
+	return pub, nil
+}
+
+func (m *memoryPublications) GetLatest(_ context.Context, pageId uuid.UUID) (*Publication, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	pubs := m.db.publications[pageId]
+	if _, ok := m.db.pages[pageId]; !ok || len(pubs) == 0 {
+		return nil, ErrRecordNotFound
+	}
+	return copyPublication(pubs[len(pubs)-1]), nil
+}
+
+func (m *memoryPublications) GetAllForStore(_ context.Context, storeId uuid.UUID) ([]*Publication, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	var live []*Publication
+	for pageId, pubs := range m.db.publications {
+		if _, ok := m.db.pages[pageId]; !ok {
+			continue
+		}
+		if latest := pubs[len(pubs)-1]; latest.StoreId == storeId {
+			live = append(live, copyPublication(latest))
+		}
+	}
+	slices.SortFunc(live, func(a, b *Publication) int { return cmp.Compare(a.Route, b.Route) })
+	return live, nil
+}
+
+type memoryPageVersions struct {
+	db *memoryDB
+}
+
+func copyPageVersion(pv *PageVersion) *PageVersion {
+	c := *pv
+	c.Widgets = copyWidgets(pv.Widgets)
+	return &c
+}
+
+// record snapshots the page as its next version. The caller holds the lock.
+func (m *memoryPageVersions) record(pageId uuid.UUID) (*PageVersion, error) {
+	page, err := m.db.snapshot(pageId)
+	if err != nil {
+		return nil, err
+	}
+	pv := &PageVersion{
+		PageId:  pageId,
+		Version: len(m.db.versions[pageId]) + 1,
+		Name:    page.Name, Route: page.Route,
+		IsHome:      page.IsHome,
+		WidgetCount: len(page.Widgets),
+		Widgets:     page.Widgets,
+		CreatedAt:   now(),
+	}
+	m.db.versions[pageId] = append(m.db.versions[pageId], copyPageVersion(pv))
+	return pv, nil
+}
+
+func (m *memoryPageVersions) get(pageId uuid.UUID, version int) (*PageVersion, error) {
+	versions := m.db.versions[pageId]
+	if version < 1 || version > len(versions) {
+		return nil, ErrRecordNotFound
+	}
+	return copyPageVersion(versions[version-1]), nil
+}
+
+func (m *memoryPageVersions) Record(_ context.Context, pageId uuid.UUID) (*PageVersion, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	return m.record(pageId)
+}
+
+func (m *memoryPageVersions) GetAllForPage(_ context.Context, pageId uuid.UUID) ([]*PageVersion, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	var versions []*PageVersion
+	for _, pv := range slices.Backward(m.db.versions[pageId]) {
+		c := *pv
+		c.Widgets = nil
+		versions = append(versions, &c)
+	}
+	return versions, nil
+}
+
+func (m *memoryPageVersions) Get(_ context.Context, pageId uuid.UUID, version int) (*PageVersion, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	return m.get(pageId, version)
+}
+
+func (m *memoryPageVersions) Restore(_ context.Context, pageId uuid.UUID, version int) (*PageVersion, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	page, ok := m.db.pages[pageId]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	pv, err := m.get(pageId, version)
+	if err != nil {
+		return nil, err
+	}
+	if m.db.routeTaken(page.StoreId, pageId, pv.Route) {
+		return nil, ErrDuplicateRoute
+	}
+	for _, w := range pv.Widgets {
+		if !m.db.typeRegistered(pageId, w.Type) {
+			return nil, ErrUnknownWidgetType
+		}
+	}
+	if pv.IsHome && !page.IsHome {
+		m.db.clearHome(page.StoreId, pageId)
+	}
+	page.Name, page.Route = pv.Name, pv.Route
+	page.IsHome = page.IsHome || pv.IsHome
+	page.UpdatedAt = now()
+	page.Version++
+
+	// As in Postgres: a widget moves past the version it has now, live or in
+	// the trash, and one that has moved to another page is copied instead.
+	restored := make([]*Widget, len(pv.Widgets))
+	for i, w := range pv.Widgets {
+		c := copyWidget(w)
+		if live, ok := m.db.widgets[w.Id]; ok && live.PageId != pageId {
+			c.Id = uuid.New()
+		} else if ok {
+			c.Version = max(c.Version, live.Version)
+		} else if trashed, ok := m.db.trashedWidgets[w.Id]; ok {
+			c.Version = max(c.Version, trashed.Version)
+			delete(m.db.trashedWidgets, w.Id)
+		}
+		restored[i] = c
+	}
+	comesBack := make(map[uuid.UUID]bool, len(restored))
+	for _, c := range restored {
+		comesBack[c.Id] = true
+	}
+	deletedAt := time.Now().UTC()
+	for id, w := range m.db.widgets {
+		if w.PageId != pageId {
+			continue
+		}
+		if !comesBack[id] {
+			w.DeletedAt = &deletedAt
+			m.db.trashedWidgets[id] = w
+		}
+		delete(m.db.widgets, id)
+	}
+	for _, c := range restored {
+		c.PageId = pageId
+		c.UpdatedAt = now()
+		c.Version++
+		m.db.widgets[c.Id] = c
+	}
+	return m.record(pageId)
+}
+
+type memoryTrash struct {
+	db *memoryDB
+}
+
+// byDeletedAt sorts records most recently deleted first.
+func byDeletedAt[T any](records []T, deletedAt func(T) *time.Time) {
+	slices.SortFunc(records, func(a, b T) int { return deletedAt(b).Compare(*deletedAt(a)) })
+}
+
+// deletedWith returns copies of the trashed widgets of a trashed page that
+// were deleted along with it, ordered by position.
+func (db *memoryDB) deletedWith(page *Page) []*Widget {
+	widgets := []*Widget{}
+	for _, w := range db.trashedWidgets {
+		if w.PageId == page.Id && w.DeletedAt.Equal(*page.DeletedAt) {
+			widgets = append(widgets, copyWidget(w))
+		}
+	}
+	slices.SortFunc(widgets, func(a, b *Widget) int { return cmp.Compare(a.Position, b.Position) })
+	return widgets
+}
+
+// trashedPage returns the trashed page id of a store that is not in the
+// trash itself.
+func (db *memoryDB) trashedPage(id uuid.UUID) (*Page, bool) {
+	page, ok := db.trashedPages[id]
+	if !ok {
+		return nil, false
+	}
+	_, ok = db.stores[page.StoreId]
+	return page, ok
+}
+
+func (m *memoryTrash) GetStores(_ context.Context) ([]*Store, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	stores := []*Store{}
+	for _, store := range m.db.trashedStores {
+		c := *store
+		stores = append(stores, &c)
+	}
+	byDeletedAt(stores, func(s *Store) *time.Time { return s.DeletedAt })
+	return stores, nil
+}
+
+func (m *memoryTrash) GetForStore(_ context.Context, storeId uuid.UUID) (*Trash, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	if _, ok := m.db.stores[storeId]; !ok {
+		return nil, ErrRecordNotFound
+	}
+	trash := &Trash{Pages: []*Page{}, Widgets: []*Widget{}}
+	for _, page := range m.db.trashedPages {
+		if page.StoreId == storeId {
+			c := *page
+			c.Widgets = m.db.deletedWith(page)
+			trash.Pages = append(trash.Pages, &c)
+		}
+	}
+	for _, w := range m.db.trashedWidgets {
+		if page, ok := m.db.pages[w.PageId]; ok && page.StoreId == storeId {
+			trash.Widgets = append(trash.Widgets, copyWidget(w))
+		}
+	}
+	byDeletedAt(trash.Pages, func(p *Page) *time.Time { return p.DeletedAt })
+	byDeletedAt(trash.Widgets, func(w *Widget) *time.Time { return w.DeletedAt })
+	return trash, nil
+}
+
+func (m *memoryTrash) GetPage(_ context.Context, id uuid.UUID) (*Page, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	page, ok := m.db.trashedPage(id)
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	c := *page
+	c.Widgets = m.db.deletedWith(page)
+	return &c, nil
+}
+
+func (m *memoryTrash) GetWidget(_ context.Context, id uuid.UUID) (*Widget, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	widget, ok := m.db.trashedWidgets[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	if _, ok = m.db.pages[widget.PageId]; !ok {
+		return nil, ErrRecordNotFound
+	}
+	return copyWidget(widget), nil
+}
+
+func (m *memoryTrash) RestoreStore(_ context.Context, id uuid.UUID, slug string) (*Store, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	store, ok := m.db.trashedStores[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	if slug == "" {
+		slug = store.Slug
+	}
+	for _, s := range m.db.stores {
+		if s.Slug == slug {
+			return nil, ErrDuplicateSlug
+		}
+	}
+	deletedAt := *store.DeletedAt
+	for pageId, page := range m.db.trashedPages {
+		if page.StoreId == id && page.DeletedAt.Equal(deletedAt) {
+			m.db.untrashPage(pageId)
+		}
+	}
+	store.Slug, store.DeletedAt = slug, nil
+	store.UpdatedAt = now()
+	store.Version++
+	m.db.stores[id] = store
+	delete(m.db.trashedStores, id)
+
+	c := *store
+	return &c, nil
+}
+
+func (m *memoryTrash) RestorePage(_ context.Context, id uuid.UUID, route string) (*Page, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	page, ok := m.db.trashedPage(id)
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	if m.db.routeTaken(page.StoreId, id, route) {
+		return nil, ErrDuplicateRoute
+	}
+	m.db.untrashPage(id)
+	page.Route, page.IsHome = route, false
+	page.UpdatedAt = now()
+	page.Version++
+
+	c := *page
+	c.Widgets = m.db.pageWidgets(id)
+	return &c, nil
+}
+
+// untrashPage moves a trashed page back, with the widgets deleted along
+// with it.
+func (db *memoryDB) untrashPage(id uuid.UUID) {
+	page := db.trashedPages[id]
+	for wid, w := range db.trashedWidgets {
+		if w.PageId == id && w.DeletedAt.Equal(*page.DeletedAt) {
+			w.DeletedAt = nil
+			db.widgets[wid] = w
+			delete(db.trashedWidgets, wid)
+		}
+	}
+	page.DeletedAt = nil
+	db.pages[id] = page
+	delete(db.trashedPages, id)
+}
+
+func (m *memoryTrash) RestoreWidget(_ context.Context, id uuid.UUID) (*Widget, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	widget, ok := m.db.trashedWidgets[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	if _, ok = m.db.pages[widget.PageId]; !ok {
+		return nil, ErrRecordNotFound
+	}
+	position, err := m.db.resolvePlacement(widget.PageId, Placement{Position: &widget.Position}, id)
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range m.db.widgets {
+		if w.PageId == widget.PageId && w.Position >= position {
+			w.Position++
+			w.Version++
+		}
+	}
+	widget.Position, widget.DeletedAt = position, nil
+	widget.UpdatedAt = now()
+	widget.Version++
+	m.db.widgets[id] = widget
+	delete(m.db.trashedWidgets, id)
+
+	return copyWidget(widget), nil
+}
+
+func (m *memoryTrash) Purge(_ context.Context, before time.Time) (int64, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	var purged int64
+	for id, store := range m.db.trashedStores {
+		if !store.DeletedAt.Before(before) {
+			continue
+		}
+		for pageId, page := range m.db.trashedPages {
+			if page.StoreId == id {
+				m.db.purgePage(pageId)
+			}
+		}
+		for typeId, wt := range m.db.widgetTypes {
+			if wt.StoreId != nil && *wt.StoreId == id {
+				delete(m.db.widgetTypes, typeId)
+				delete(m.db.order, typeId)
+			}
+		}
+		for k := range m.db.members {
+			if k.storeId == id {
+				delete(m.db.members, k)
+			}
+		}
+		for invId, inv := range m.db.invitations {
+			if inv.StoreId == id {
+				delete(m.db.invitations, invId)
+				delete(m.db.order, invId)
+			}
+		}
+		delete(m.db.trashedStores, id)
+		delete(m.db.order, id)
+		purged++
+	}
+	for id, page := range m.db.trashedPages {
+		if page.DeletedAt.Before(before) {
+			m.db.purgePage(id)
+			purged++
+		}
+	}
+	for id, w := range m.db.trashedWidgets {
+		if w.DeletedAt.Before(before) {
+			delete(m.db.trashedWidgets, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+type memoryAudit struct {
+	db *memoryDB
+}
+
+func copyAuditEntry(entry *AuditEntry) *AuditEntry {
+	c := *entry
+	c.Before = slices.Clone(entry.Before)
+	c.After = slices.Clone(entry.After)
+	return &c
+}
+
+func (m *memoryAudit) Record(_ context.Context, entry *AuditEntry) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	entry.Id = int64(len(m.db.audit) + 1)
+	entry.CreatedAt = now()
+	m.db.audit = append(m.db.audit, copyAuditEntry(entry))
+	return nil
+}
+
+func (m *memoryAudit) GetForStore(_ context.Context, storeId uuid.UUID, filter AuditFilter) ([]*AuditEntry, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	entries := []*AuditEntry{}
+	for _, entry := range slices.Backward(m.db.audit) {
+		switch {
+		case len(entries) == filter.Limit:
+			return entries, nil
+		case entry.StoreId != storeId,
+			filter.EntityType != "" && entry.EntityType != filter.EntityType,
+			filter.EntityId != nil && entry.EntityId != *filter.EntityId,
+			filter.Actor != "" && entry.Actor != filter.Actor,
+			filter.Since != nil && entry.CreatedAt.Before(*filter.Since),
+			filter.Until != nil && !entry.CreatedAt.Before(*filter.Until),
+			filter.Cursor != 0 && entry.Id >= filter.Cursor:
+			continue
+		}
+		entries = append(entries, copyAuditEntry(entry))
+	}
+	return entries, nil
+}
+
+type memoryApiKeys struct {
+	db *memoryDB
+}
+
+func copyApiKey(key *ApiKey) *ApiKey {
+	c := *key
+	c.Plaintext = ""
+	c.Hash = slices.Clone(key.Hash)
+	c.StoreIds = slices.Clone(key.StoreIds)
+	if c.StoreIds == nil {
+		c.StoreIds = []uuid.UUID{}
+	}
+	return &c
+}
+
+func (m *memoryApiKeys) Insert(_ context.Context, key *ApiKey) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	for _, k := range m.db.apiKeys {
+		if k.Prefix == key.Prefix {
+			return fmt.Errorf("api key prefix %s already exists", key.Prefix)
+		}
+	}
+	key.CreatedAt = now()
+	m.db.apiKeys[key.Id] = copyApiKey(key)
+	m.db.insertOrder(key.Id)
+	return nil
+}
+
+func (m *memoryApiKeys) GetByPrefix(_ context.Context, prefix string) (*ApiKey, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	for _, k := range m.db.apiKeys {
+		if k.Prefix == prefix && k.RevokedAt == nil {
+			return copyApiKey(k), nil
+		}
+	}
+	return nil, ErrRecordNotFound
+}
+
+func (m *memoryApiKeys) GetAll(_ context.Context) ([]*ApiKey, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	keys := []*ApiKey{}
+	for _, k := range m.db.apiKeys {
+		keys = append(keys, copyApiKey(k))
+	}
+	newestFirst(m.db, keys, func(k *ApiKey) uuid.UUID { return k.Id })
+	return keys, nil
+}
+
+func (m *memoryApiKeys) Revoke(_ context.Context, id uuid.UUID) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	key, ok := m.db.apiKeys[id]
+	if !ok || key.RevokedAt != nil {
+		return ErrRecordNotFound
+	}
+	revokedAt := now()
+	key.RevokedAt = &revokedAt
+	return nil
+}
+
+type memoryUsers struct {
+	db *memoryDB
+}
+
+func copyUser(user *User) *User {
+	c := *user
+	c.Password = password{hash: slices.Clone(user.Password.hash)}
+	return &c
+}
+
+func (m *memoryUsers) Insert(_ context.Context, user *User) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	for _, u := range m.db.users {
+		if u.Email == user.Email {
+			return ErrDuplicateEmail
+		}
+	}
+	user.Id = uuid.New()
+	user.CreatedAt = now()
+	user.Version = 1
+	m.db.users[user.Id] = copyUser(user)
+	m.db.insertOrder(user.Id)
+	return nil
+}
+
+func (m *memoryUsers) Get(_ context.Context, id uuid.UUID) (*User, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	user, ok := m.db.users[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	return copyUser(user), nil
+}
+
+func (m *memoryUsers) GetByEmail(_ context.Context, email string) (*User, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	for _, u := range m.db.users {
+		if u.Email == email {
+			return copyUser(u), nil
+		}
+	}
+	return nil, ErrRecordNotFound
+}
+
+type memorySessions struct {
+	db *memoryDB
+}
+
+func (m *memorySessions) Insert(_ context.Context, session *Session) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	if _, ok := m.db.users[session.UserId]; !ok {
+		return fmt.Errorf("user %s does not exist", session.UserId)
+	}
+	m.db.sessions[string(session.Hash)] = &Session{
+		Hash:      slices.Clone(session.Hash),
+		UserId:    session.UserId,
+		ExpiresAt: session.ExpiresAt,
+	}
+	return nil
+}
+
+func (m *memorySessions) GetUser(_ context.Context, token string) (*User, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	session, ok := m.db.sessions[string(sessionHash(token))]
+	if !ok || !session.ExpiresAt.After(time.Now()) {
+		return nil, ErrRecordNotFound
+	}
+	user, ok := m.db.users[session.UserId]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	return copyUser(user), nil
+}
+
+func (m *memorySessions) Delete(_ context.Context, token string) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	hash := string(sessionHash(token))
+	if _, ok := m.db.sessions[hash]; !ok {
+		return ErrRecordNotFound
+	}
+	delete(m.db.sessions, hash)
+	return nil
+}
+
+func (m *memorySessions) DeleteExpired(_ context.Context, before time.Time) (int64, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	var deleted int64
+	for hash, session := range m.db.sessions {
+		if session.ExpiresAt.Before(before) {
+			delete(m.db.sessions, hash)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+type memoryMembers struct {
+	db *memoryDB
+}
+
+// member returns a copy of the membership with the user's name and email
+// filled in, as the join of the SQL queries does.
+func (db *memoryDB) member(member *Member) *Member {
+	c := *member
+	if user, ok := db.users[member.UserId]; ok {
+		c.Name, c.Email = user.Name, user.Email
+	}
+	return &c
+}
+
+func (m *memoryMembers) Insert(_ context.Context, member *Member) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	if _, ok := m.db.users[member.UserId]; !ok {
+		return fmt.Errorf("user %s does not exist", member.UserId)
+	}
+	_, live := m.db.stores[member.StoreId]
+	_, trashed := m.db.trashedStores[member.StoreId]
+	if !live && !trashed {
+		return fmt.Errorf("store %s does not exist", member.StoreId)
+	}
+	k := memberKey{member.StoreId, member.UserId}
+	if _, ok := m.db.members[k]; ok {
+		return ErrDuplicateMember
+	}
+	member.CreatedAt = now()
+	m.db.members[k] = &Member{
+		StoreId:   member.StoreId,
+		UserId:    member.UserId,
+		Role:      member.Role,
+		CreatedAt: member.CreatedAt,
+	}
+	return nil
+}
+
+func (m *memoryMembers) Get(_ context.Context, storeId, userId uuid.UUID) (*Member, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	member, ok := m.db.members[memberKey{storeId, userId}]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	return m.db.member(member), nil
+}
+
+func (m *memoryMembers) GetAllForStore(_ context.Context, storeId uuid.UUID) ([]*Member, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	members := []*Member{}
+	for k, member := range m.db.members {
+		if k.storeId == storeId {
+			members = append(members, m.db.member(member))
+		}
+	}
+	slices.SortFunc(members, func(a, b *Member) int { return cmp.Compare(a.Email, b.Email) })
+	return members, nil
+}
+
+func (m *memoryMembers) GetRolesForUser(_ context.Context, userId uuid.UUID) (map[uuid.UUID]string, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	roles := make(map[uuid.UUID]string)
+	for k, member := range m.db.members {
+		if k.userId == userId {
+			roles[k.storeId] = member.Role
+		}
+	}
+	return roles, nil
+}
+
+func (m *memoryMembers) UpdateRole(_ context.Context, storeId, userId uuid.UUID, role string) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	if err := m.db.keepOwner(storeId, userId, role); err != nil {
+		return err
+	}
+	m.db.members[memberKey{storeId, userId}].Role = role
+	return nil
+}
+
+func (m *memoryMembers) Delete(_ context.Context, storeId, userId uuid.UUID) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	if err := m.db.keepOwner(storeId, userId, ""); err != nil {
+		return err
+	}
+	delete(m.db.members, memberKey{storeId, userId})
+	return nil
+}
+
+// keepOwner checks that the member userId can be given the role role, none
+// for a removal, without taking the last owner away from the store.
+func (db *memoryDB) keepOwner(storeId, userId uuid.UUID, role string) error {
+	current, ok := db.members[memberKey{storeId, userId}]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	if current.Role != RoleOwner || role == RoleOwner {
+		return nil
+	}
+	for k, member := range db.members {
+		if k.storeId == storeId && k.userId != userId && member.Role == RoleOwner {
+			return nil
+		}
+	}
+	return ErrLastOwner
+}
+
+type memoryInvitations struct {
+	db *memoryDB
+}
+
+func copyInvitation(inv *Invitation) *Invitation {
+	c := *inv
+	c.Token = ""
+	c.Hash = slices.Clone(inv.Hash)
+	if inv.AcceptedAt != nil {
+		at := *inv.AcceptedAt
+		c.AcceptedAt = &at
+	}
+	if inv.AcceptedBy != nil {
+		by := *inv.AcceptedBy
+		c.AcceptedBy = &by
+	}
+	if inv.RevokedAt != nil {
+		at := *inv.RevokedAt
+		c.RevokedAt = &at
+	}
+	return &c
+}
+
+func (m *memoryInvitations) Insert(_ context.Context, inv *Invitation) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	if _, ok := m.db.stores[inv.StoreId]; !ok {
+		return fmt.Errorf("store %s does not exist", inv.StoreId)
+	}
+	inv.CreatedAt = now()
+	m.db.invitations[inv.Id] = copyInvitation(inv)
+	m.db.insertOrder(inv.Id)
+	return nil
+}
+
+func (m *memoryInvitations) GetAllForStore(_ context.Context, storeId uuid.UUID) ([]*Invitation, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	invitations := []*Invitation{}
+	for _, inv := range m.db.invitations {
+		if inv.StoreId == storeId {
+			invitations = append(invitations, copyInvitation(inv))
+		}
+	}
+	newestFirst(m.db, invitations, func(inv *Invitation) uuid.UUID { return inv.Id })
+	return invitations, nil
+}
+
+func (m *memoryInvitations) GetPending(_ context.Context, token string) (*Invitation, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	hash := invitationHash(token)
+	for _, inv := range m.db.invitations {
+		if slices.Equal(inv.Hash, hash) && inv.Pending(time.Now()) {
+			return copyInvitation(inv), nil
+		}
+	}
+	return nil, ErrRecordNotFound
+}
+
+func (m *memoryInvitations) Accept(_ context.Context, id, userId uuid.UUID) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	inv, ok := m.db.invitations[id]
+	if !ok || !inv.Pending(time.Now()) {
+		return ErrRecordNotFound
+	}
+	acceptedAt := now()
+	inv.AcceptedAt, inv.AcceptedBy = &acceptedAt, &userId
+	return nil
+}
+
+func (m *memoryInvitations) Revoke(_ context.Context, storeId, id uuid.UUID) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	inv, ok := m.db.invitations[id]
+	if !ok || inv.StoreId != storeId || inv.AcceptedAt != nil || inv.RevokedAt != nil {
+		return ErrRecordNotFound
+	}
+	revokedAt := now()
+	inv.RevokedAt = &revokedAt
+	return nil
+}
+
+type memoryOAuthClients struct {
+	db *memoryDB
+}
+
+func copyOAuthClient(client *OAuthClient) *OAuthClient {
+	c := *client
+	c.Secret = ""
+	c.Hash = slices.Clone(client.Hash)
+	c.Scopes = slices.Clone(client.Scopes)
+	c.StoreIds = slices.Clone(client.StoreIds)
+	if c.StoreIds == nil {
+		c.StoreIds = []uuid.UUID{}
+	}
+	return &c
+}
+
+func (m *memoryOAuthClients) Insert(_ context.Context, client *OAuthClient) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	for _, c := range m.db.oauthClients {
+		if c.ClientId == client.ClientId {
+			return fmt.Errorf("client id %s already exists", client.ClientId)
+		}
+	}
+	client.CreatedAt = now()
+	m.db.oauthClients[client.Id] = copyOAuthClient(client)
+	m.db.insertOrder(client.Id)
+	return nil
+}
+
+func (m *memoryOAuthClients) GetByClientId(_ context.Context, clientId string) (*OAuthClient, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	for _, c := range m.db.oauthClients {
+		if c.ClientId == clientId && c.RevokedAt == nil {
+			return copyOAuthClient(c), nil
+		}
+	}
+	return nil, ErrRecordNotFound
+}
+
+func (m *memoryOAuthClients) GetAll(_ context.Context) ([]*OAuthClient, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	clients := []*OAuthClient{}
+	for _, c := range m.db.oauthClients {
+		clients = append(clients, copyOAuthClient(c))
+	}
+	newestFirst(m.db, clients, func(c *OAuthClient) uuid.UUID { return c.Id })
+	return clients, nil
+}
+
+func (m *memoryOAuthClients) Revoke(_ context.Context, id uuid.UUID) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	client, ok := m.db.oauthClients[id]
+	if !ok || client.RevokedAt != nil {
+		return ErrRecordNotFound
+	}
+	revokedAt := now()
+	client.RevokedAt = &revokedAt
+	return nil
+}
@@ -0,0 +1,205 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestMemoryModels_InTx(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryModels()
+
+	store := &Store{Id: uuid.New(), Name: "Acme", Slug: "acme"}
+	if err := m.Stores.Insert(ctx, store); err != nil {
+		t.Fatal(err)
+	}
+	home := &Page{Id: uuid.New(), StoreId: store.Id, Name: "Home", Route: "/", IsHome: true}
+	if err := m.Pages.Insert(ctx, home); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("rolls back on error", func(t *testing.T) {
+		err := m.InTx(ctx, func(m Models) error {
+			page := &Page{Id: uuid.New(), StoreId: store.Id, Name: "Sale", Route: "/sale", IsHome: true}
+			if err := m.Pages.Insert(ctx, page); err != nil {
+				return err
+			}
+			dup := &Page{Id: uuid.New(), StoreId: store.Id, Name: "Sale again", Route: "/sale"}
+			return m.Pages.Insert(ctx, dup)
+		})
+		if !errors.Is(err, ErrDuplicateRoute) {
+			t.Fatalf("expected ErrDuplicateRoute, got %v", err)
+		}
+		pages, err := m.Pages.GetAllForStore(ctx, store.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pages) != 1 || !pages[0].IsHome {
+			t.Fatalf("expected only the original home page to remain, got %+v", pages)
+		}
+	})
+
+	t.Run("commits on success", func(t *testing.T) {
+		err := m.InTx(ctx, func(m Models) error {
+			page := &Page{Id: uuid.New(), StoreId: store.Id, Name: "Sale", Route: "/sale"}
+			if err := m.Pages.Insert(ctx, page); err != nil {
+				return err
+			}
+			// A nested unit of work joins the outer one.
+			return m.InTx(ctx, func(m Models) error {
+				_, err := m.PageVersions.Record(ctx, page.Id)
+				return err
+			})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages, err := m.Pages.GetAllForStore(ctx, store.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pages) != 2 {
+			t.Fatalf("expected 2 pages, got %d", len(pages))
+		}
+	})
+}
+
+func TestMemoryTrash(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryModels()
+	if err := m.WidgetTypes.EnsureBuiltins(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	store := &Store{Id: uuid.New(), Name: "Acme", Slug: "acme"}
+	if err := m.Stores.Insert(ctx, store); err != nil {
+		t.Fatal(err)
+	}
+	home := &Page{Id: uuid.New(), StoreId: store.Id, Name: "Home", Route: "/", IsHome: true}
+	sale := &Page{Id: uuid.New(), StoreId: store.Id, Name: "Sale", Route: "/sale"}
+	for _, page := range []*Page{home, sale} {
+		if err := m.Pages.Insert(ctx, page); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var widgets []*Widget
+	for _, content := range []string{"first", "second", "third"} {
+		w := &Widget{Id: uuid.New(), PageId: home.Id, Type: "text", Config: map[string]any{"content": content}}
+		if err := m.Widgets.Insert(ctx, w); err != nil {
+			t.Fatal(err)
+		}
+		widgets = append(widgets, w)
+	}
+	saleWidget := &Widget{Id: uuid.New(), PageId: sale.Id, Type: "spacer"}
+	if err := m.Widgets.Insert(ctx, saleWidget); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("widget goes back to its position", func(t *testing.T) {
+		if err := m.Widgets.Delete(ctx, widgets[1].Id, widgets[1].Version-1); !errors.Is(err, ErrEditConflict) {
+			t.Fatalf("expected a stale version to be refused, got %v", err)
+		}
+		if err := m.Widgets.Delete(ctx, widgets[1].Id, widgets[1].Version); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.Widgets.Get(ctx, widgets[1].Id); !errors.Is(err, ErrRecordNotFound) {
+			t.Fatalf("expected a deleted widget to be gone, got %v", err)
+		}
+		trash, err := m.Trash.GetForStore(ctx, store.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(trash.Widgets) != 1 || len(trash.Pages) != 0 {
+			t.Fatalf("expected one widget in the trash, got %+v", trash)
+		}
+		restored, err := m.Trash.RestoreWidget(ctx, widgets[1].Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restored.Position != 1 || restored.DeletedAt != nil {
+			t.Fatalf("expected the widget back at position 1, got %+v", restored)
+		}
+		live, err := m.Widgets.GetForPage(ctx, home.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, w := range live {
+			if w.Id != widgets[i].Id || w.Position != i {
+				t.Fatalf("expected the original order, got %+v", live)
+			}
+		}
+	})
+
+	t.Run("page comes back with its widgets under a free route", func(t *testing.T) {
+		if err := m.Pages.Delete(ctx, sale.Id, sale.Version); err != nil {
+			t.Fatal(err)
+		}
+		taken := &Page{Id: uuid.New(), StoreId: store.Id, Name: "New sale", Route: "/sale"}
+		if err := m.Pages.Insert(ctx, taken); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.Trash.RestorePage(ctx, sale.Id, "/sale"); !errors.Is(err, ErrDuplicateRoute) {
+			t.Fatalf("expected ErrDuplicateRoute, got %v", err)
+		}
+		page, err := m.Trash.RestorePage(ctx, sale.Id, "/old-sale")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.Route != "/old-sale" || len(page.Widgets) != 1 || page.Widgets[0].Id != saleWidget.Id {
+			t.Fatalf("expected the page back with its widget, got %+v", page)
+		}
+	})
+
+	t.Run("store comes back whole and is purged once old", func(t *testing.T) {
+		if err := m.Stores.Delete(ctx, store.Id, store.Version); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.Trash.GetForStore(ctx, store.Id); !errors.Is(err, ErrRecordNotFound) {
+			t.Fatalf("expected the trash of a deleted store to be out of reach, got %v", err)
+		}
+		other := &Store{Id: uuid.New(), Name: "Other", Slug: "acme"}
+		if err := m.Stores.Insert(ctx, other); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.Trash.RestoreStore(ctx, store.Id, ""); !errors.Is(err, ErrDuplicateSlug) {
+			t.Fatalf("expected ErrDuplicateSlug, got %v", err)
+		}
+		restored, err := m.Trash.RestoreStore(ctx, store.Id, "acme-old")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages, err := m.Pages.GetAllForStore(ctx, store.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pages) != 3 {
+			t.Fatalf("expected the 3 pages back, got %d", len(pages))
+		}
+
+		if err = m.Stores.Delete(ctx, store.Id, restored.Version); err != nil {
+			t.Fatal(err)
+		}
+		purged, err := m.Trash.Purge(ctx, time.Now().Add(-time.Hour))
+		if err != nil || purged != 0 {
+			t.Fatalf("expected nothing to be purged yet, got %d, %v", purged, err)
+		}
+		entry := &AuditEntry{StoreId: store.Id, Actor: "anonymous", EntityType: AuditStore, EntityId: store.Id, Action: "delete"}
+		if err = m.Audit.Record(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+		if purged, err = m.Trash.Purge(ctx, time.Now().Add(time.Hour)); err != nil || purged != 1 {
+			t.Fatalf("expected the store to be purged, got %d, %v", purged, err)
+		}
+		entries, err := m.Audit.GetForStore(ctx, store.Id, AuditFilter{Limit: 10})
+		if err != nil || len(entries) != 1 {
+			t.Fatalf("expected the audit trail to outlive the store, got %v, %v", entries, err)
+		}
+		if _, err = m.Trash.RestoreStore(ctx, store.Id, "acme-old"); !errors.Is(err, ErrRecordNotFound) {
+			t.Fatalf("expected a purged store to be gone, got %v", err)
+		}
+	})
+}
@@ -1,35 +1,227 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 var (
 	ErrRecordNotFound = errors.New("record not found")
 	ErrEditConflict   = errors.New("edit conflict")
+
+	ErrDuplicateSlug  = errors.New("store slug already exists")
+	ErrDuplicateRoute = errors.New("page route already exists for this app")
+	ErrHomeConflict   = errors.New("another page became the home page at the same time")
+	ErrDeleteHomePage = errors.New("cannot delete home page")
+	ErrForeignWidgets = errors.New("some widgets do not belong to this page")
+	ErrForeignPage    = errors.New("page belongs to another store")
+	ErrDuplicateIds   = errors.New("widget list names a widget more than once")
+	ErrPartialOrder   = errors.New("widget list must name every widget of the page")
+
+	ErrUnknownWidgetType   = errors.New("widget type is not registered for this store")
+	ErrDuplicateWidgetType = errors.New("widget type name already exists")
+	ErrWidgetTypeInUse     = errors.New("widget type is used by existing widgets")
+
+	ErrDuplicateEmail  = errors.New("a user with this email already exists")
+	ErrDuplicateMember = errors.New("user is already a member of this store")
+	ErrLastOwner       = errors.New("a store must keep at least one owner")
 )
 
+// StoreRepository stores the merchants' stores. Slugs are unique among the
+// stores that are not in the trash.
+type StoreRepository interface {
+	Insert(ctx context.Context, store *Store) error
+	Get(ctx context.Context, id uuid.UUID) (*Store, error)
+	GetBySlug(ctx context.Context, slug string) (*Store, error)
+	GetAll(ctx context.Context, filter StoreFilter) ([]*Store, error)
+	Update(ctx context.Context, store *Store) error
+	Delete(ctx context.Context, id uuid.UUID, version int) error
+	Clone(ctx context.Context, sourceId uuid.UUID, store *Store) ([]*Page, error)
+}
+
+// PageRepository stores the pages of a store. Routes are unique per store and
+// a store has at most one home page, among the pages that are not in the
+// trash.
+type PageRepository interface {
+	Insert(ctx context.Context, page *Page) error
+	GetAllForStore(ctx context.Context, storeId uuid.UUID) ([]*Page, error)
+	Get(ctx context.Context, id uuid.UUID) (*Page, error)
+	Update(ctx context.Context, page *Page) error
+	Delete(ctx context.Context, id uuid.UUID, version int) error
+	Duplicate(ctx context.Context, sourceId uuid.UUID, page *Page) error
+}
+
+// WidgetRepository stores the widgets of a page, ordered by position.
+type WidgetRepository interface {
+	GetForPage(ctx context.Context, pageId uuid.UUID) ([]*Widget, error)
+	Insert(ctx context.Context, widget *Widget) error
+	InsertAt(ctx context.Context, widget *Widget, at Placement) error
+	Get(ctx context.Context, id uuid.UUID) (*Widget, error)
+	Update(ctx context.Context, widget *Widget) error
+	Delete(ctx context.Context, id uuid.UUID, version int) error
+	Move(ctx context.Context, widget *Widget, pageId uuid.UUID, at Placement) error
+	Reorder(ctx context.Context, pageId uuid.UUID, order Ordering) ([]*Widget, error)
+}
+
+// WidgetTypeRepository is the widget type registry.
+type WidgetTypeRepository interface {
+	EnsureBuiltins(ctx context.Context) error
+	GetAllForStore(ctx context.Context, storeId uuid.UUID) ([]*WidgetType, error)
+	Get(ctx context.Context, id uuid.UUID) (*WidgetType, error)
+	Lookup(ctx context.Context, storeId uuid.UUID, name string) (*WidgetType, error)
+	Insert(ctx context.Context, wt *WidgetType) error
+	Update(ctx context.Context, wt *WidgetType) error
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
+// PublicationRepository stores the published snapshots of pages.
+type PublicationRepository interface {
+	Publish(ctx context.Context, pageId uuid.UUID) (*Publication, error)
+	GetLatest(ctx context.Context, pageId uuid.UUID) (*Publication, error)
+	GetAllForStore(ctx context.Context, storeId uuid.UUID) ([]*Publication, error)
+}
+
+// PageVersionRepository stores the version history of pages.
+type PageVersionRepository interface {
+	Record(ctx context.Context, pageId uuid.UUID) (*PageVersion, error)
+	GetAllForPage(ctx context.Context, pageId uuid.UUID) ([]*PageVersion, error)
+	Get(ctx context.Context, pageId uuid.UUID, version int) (*PageVersion, error)
+	Restore(ctx context.Context, pageId uuid.UUID, version int) (*PageVersion, error)
+}
+
+// TrashRepository holds what deletes leave behind. A deleted store, page or
+// widget is out of every other repository's reach until it is restored, and
+// gone for good once purged.
+type TrashRepository interface {
+	GetStores(ctx context.Context) ([]*Store, error)
+	GetForStore(ctx context.Context, storeId uuid.UUID) (*Trash, error)
+	GetPage(ctx context.Context, id uuid.UUID) (*Page, error)
+	GetWidget(ctx context.Context, id uuid.UUID) (*Widget, error)
+	RestoreStore(ctx context.Context, id uuid.UUID, slug string) (*Store, error)
+	RestorePage(ctx context.Context, id uuid.UUID, route string) (*Page, error)
+	RestoreWidget(ctx context.Context, id uuid.UUID) (*Widget, error)
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
+
+// AuditRepository stores the audit log of the stores. Entries are only ever
+// appended.
+type AuditRepository interface {
+	Record(ctx context.Context, entry *AuditEntry) error
+	GetForStore(ctx context.Context, storeId uuid.UUID, filter AuditFilter) ([]*AuditEntry, error)
+}
+
+// ApiKeyRepository stores the keys that authenticate API clients.
+type ApiKeyRepository interface {
+	Insert(ctx context.Context, key *ApiKey) error
+	GetByPrefix(ctx context.Context, prefix string) (*ApiKey, error)
+	GetAll(ctx context.Context) ([]*ApiKey, error)
+	Revoke(ctx context.Context, id uuid.UUID) error
+}
+
+// UserRepository stores the staff's user accounts. Emails are unique.
+type UserRepository interface {
+	Insert(ctx context.Context, user *User) error
+	Get(ctx context.Context, id uuid.UUID) (*User, error)
+	GetByEmail(ctx context.Context, email string) (*User, error)
+}
+
+// SessionRepository stores the sessions users open by logging in.
+type SessionRepository interface {
+	Insert(ctx context.Context, session *Session) error
+	GetUser(ctx context.Context, token string) (*User, error)
+	Delete(ctx context.Context, token string) error
+	DeleteExpired(ctx context.Context, before time.Time) (int64, error)
+}
+
+// MemberRepository stores the role of each user in the stores they work on.
+// A store that has an owner keeps at least one.
+type MemberRepository interface {
+	Insert(ctx context.Context, member *Member) error
+	Get(ctx context.Context, storeId, userId uuid.UUID) (*Member, error)
+	GetAllForStore(ctx context.Context, storeId uuid.UUID) ([]*Member, error)
+	GetRolesForUser(ctx context.Context, userId uuid.UUID) (map[uuid.UUID]string, error)
+	UpdateRole(ctx context.Context, storeId, userId uuid.UUID, role string) error
+	Delete(ctx context.Context, storeId, userId uuid.UUID) error
+}
+
+// InvitationRepository stores the invitations to join a store. An
+// invitation is accepted at most once.
+type InvitationRepository interface {
+	Insert(ctx context.Context, inv *Invitation) error
+	GetAllForStore(ctx context.Context, storeId uuid.UUID) ([]*Invitation, error)
+	GetPending(ctx context.Context, token string) (*Invitation, error)
+	Accept(ctx context.Context, id, userId uuid.UUID) error
+	Revoke(ctx context.Context, storeId, id uuid.UUID) error
+}
+
+// OAuthClientRepository stores the machine clients that are issued access
+// tokens.
+type OAuthClientRepository interface {
+	Insert(ctx context.Context, client *OAuthClient) error
+	GetByClientId(ctx context.Context, clientId string) (*OAuthClient, error)
+	GetAll(ctx context.Context) ([]*OAuthClient, error)
+	Revoke(ctx context.Context, id uuid.UUID) error
+}
+
 // Models groups the application’s data models behind a single dependency.
 // It provides a convenient way to pass model access through handlers and
-// services.
+// services. NewModels backs it with PostgreSQL and NewMemoryModels keeps
+// everything in memory, which is meant for tests.
 type Models struct {
-	Stores  StoreModel
-	Pages   PageModel
-	Widgets WidgetModel
+	Stores       StoreRepository
+	Pages        PageRepository
+	Widgets      WidgetRepository
+	WidgetTypes  WidgetTypeRepository
+	Publications PublicationRepository
+	PageVersions PageVersionRepository
+	Trash        TrashRepository
+	Audit        AuditRepository
+	ApiKeys      ApiKeyRepository
+	Users        UserRepository
+	Sessions     SessionRepository
+	Members      MemberRepository
+	Invitations  InvitationRepository
+	OAuthClients OAuthClientRepository
+
+	inTx txRunner
 }
 
+// txRunner runs fn as a single unit of work.
+type txRunner func(ctx context.Context, fn func(Models) error) error
+
 // NewModels returns a new model with the fields initialized with the given db.
 func NewModels(db *sql.DB) Models {
-	return Models{
-		Stores: StoreModel{
-			Db: db,
-		},
-		Pages: PageModel{
-			Db: db,
-		},
-		Widgets: WidgetModel{
-			Db: db,
-		},
+	m := newModels(db)
+	m.inTx = sqlTxRunner(db)
+	return m
+}
+
+// InTx runs fn as a single unit of work: every call made through the Models
+// passed to fn commits together if fn returns nil, and none of them does
+// otherwise. Calling InTx again inside fn joins the unit of work.
+func (m Models) InTx(ctx context.Context, fn func(Models) error) error {
+	if m.inTx == nil {
+		return fn(m)
 	}
+	return m.inTx(ctx, fn)
+}
+
+// isUniqueViolation reports whether err is a PostgreSQL unique constraint
+// violation.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation"
+}
+
+// isHomeViolation reports whether err is a unique violation of the index that
+// keeps one home page per store, which two pages made home at the same time
+// can hit.
+func isHomeViolation(err error) bool {
+	var pqErr *pq.Error
+	return isUniqueViolation(err) && errors.As(err, &pqErr) && pqErr.Constraint == "idx_pages_is_home_per_app"
 }
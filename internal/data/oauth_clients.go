@@ -0,0 +1,164 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// oauthClientScheme starts every client id.
+const oauthClientScheme = "adc_"
+
+// OAuthClient is a machine client, such as a CI pipeline, which trades its
+// client id and secret for access tokens. The secret is only known when the
+// client is generated, as Secret; what is kept is a hash of it. A client with
+// no StoreIds can access every store.
+type OAuthClient struct {
+	Id        uuid.UUID   `json:"id"`
+	Name      string      `json:"name"`
+	ClientId  string      `json:"client_id"`
+	Secret    string      `json:"client_secret,omitempty"`
+	Hash      []byte      `json:"-"`
+	Scopes    []string    `json:"scopes"`
+	StoreIds  []uuid.UUID `json:"store_ids"`
+	RevokedAt *time.Time  `json:"revoked_at,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// GenerateOAuthClient returns a new client with a random id and secret, which
+// is only ever available as its Secret.
+func GenerateOAuthClient(name string, scopes []string, storeIds []uuid.UUID) (*OAuthClient, error) {
+	id := make([]byte, 10)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	client := &OAuthClient{
+		Id:       uuid.New(),
+		Name:     name,
+		ClientId: oauthClientScheme + strings.ToLower(apiKeyEncoding.EncodeToString(id)),
+		Secret:   strings.ToLower(apiKeyEncoding.EncodeToString(secret)),
+		Scopes:   scopes,
+		StoreIds: storeIds,
+	}
+	if client.StoreIds == nil {
+		client.StoreIds = []uuid.UUID{}
+	}
+	hash := sha256.Sum256([]byte(client.Secret))
+	client.Hash = hash[:]
+	return client, nil
+}
+
+// Matches reports whether secret is the client's secret.
+func (c *OAuthClient) Matches(secret string) bool {
+	hash := sha256.Sum256([]byte(secret))
+	return subtle.ConstantTimeCompare(hash[:], c.Hash) == 1
+}
+
+type OAuthClientModel struct {
+	Db dbtx
+}
+
+func (m *OAuthClientModel) Insert(ctx context.Context, client *OAuthClient) error {
+	query := `INSERT INTO oauth_clients (id, name, client_id, secret_hash, scopes, store_ids)
+		    VALUES ($1, $2, $3, $4, $5, $6) RETURNING created_at`
+
+	args := []any{client.Id, client.Name, client.ClientId, client.Hash, pq.Array(client.Scopes), pq.Array(client.StoreIds)}
+	return m.Db.QueryRowContext(ctx, query, args...).Scan(&client.CreatedAt)
+}
+
+// GetByClientId returns the client with the given client id that has not
+// been revoked.
+func (m *OAuthClientModel) GetByClientId(ctx context.Context, clientId string) (*OAuthClient, error) {
+	query := `SELECT id, name, client_id, secret_hash, scopes, store_ids, revoked_at, created_at FROM oauth_clients
+		    WHERE client_id = $1 AND revoked_at IS NULL`
+
+	client, err := scanOAuthClient(m.Db.QueryRowContext(ctx, query, clientId))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return client, nil
+}
+
+// GetAll returns every client, revoked ones included, newest first.
+func (m *OAuthClientModel) GetAll(ctx context.Context) ([]*OAuthClient, error) {
+	query := `SELECT id, name, client_id, secret_hash, scopes, store_ids, revoked_at, created_at FROM oauth_clients
+		    ORDER BY created_at DESC`
+
+	rows, err := m.Db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			slog.Error("failed to close rows", "err", err)
+		}
+	}()
+	clients := []*OAuthClient{}
+
+	for rows.Next() {
+		client, err := scanOAuthClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+// Revoke stops the client from being issued any further token. The tokens it
+// was already issued stay valid until they expire.
+func (m *OAuthClientModel) Revoke(ctx context.Context, id uuid.UUID) error {
+	query := `UPDATE oauth_clients SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`
+
+	result, err := m.Db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// scanOAuthClient reads a client from row, which is a *sql.Row or *sql.Rows.
+func scanOAuthClient(row interface{ Scan(...any) error }) (*OAuthClient, error) {
+	var client OAuthClient
+	err := row.Scan(
+		&client.Id, &client.Name,
+		&client.ClientId, &client.Hash,
+		pq.Array(&client.Scopes),
+		pq.Array(&client.StoreIds),
+		&client.RevokedAt,
+		&client.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if client.StoreIds == nil {
+		client.StoreIds = []uuid.UUID{}
+	}
+	return &client, nil
+}
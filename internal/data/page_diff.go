@@ -0,0 +1,143 @@
+package data
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+// PageDiff is the structural difference between two versions of a page.
+// Widgets are matched by id, so a widget that changed position shows up as
+// moved rather than as removed and added again. Every list is sorted so the
+// same pair of versions always produces the same document.
+type PageDiff struct {
+	PageId  uuid.UUID     `json:"page_id"`
+	From    int           `json:"from"`
+	To      int           `json:"to"`
+	Page    []FieldChange `json:"page"`
+	Added   []*Widget     `json:"added"`
+	Removed []*Widget     `json:"removed"`
+	Moved   []WidgetMove  `json:"moved"`
+	Changed []WidgetEdit  `json:"changed"`
+}
+
+// FieldChange is a change to a single named value.
+type FieldChange struct {
+	Field string `json:"field"`
+	From  any    `json:"from"`
+	To    any    `json:"to"`
+}
+
+// WidgetMove records a widget whose position differs between the versions.
+type WidgetMove struct {
+	Id   uuid.UUID `json:"id"`
+	From int       `json:"from"`
+	To   int       `json:"to"`
+}
+
+// WidgetEdit lists the changes made to a widget present in both versions.
+// Config changes are reported per top-level key; a key that only exists on
+// one side has a nil From or To.
+type WidgetEdit struct {
+	Id     uuid.UUID     `json:"id"`
+	Type   *FieldChange  `json:"type,omitempty"`
+	Config []FieldChange `json:"config"`
+}
+
+// Empty reports whether the two versions are structurally identical.
+func (d *PageDiff) Empty() bool {
+	return len(d.Page) == 0 && len(d.Added) == 0 && len(d.Removed) == 0 &&
+		len(d.Moved) == 0 && len(d.Changed) == 0
+}
+
+// DiffPageVersions compares two versions of the same page. A nil from is
+// treated as an empty page, so every widget of to is reported as added.
+func DiffPageVersions(from, to *PageVersion) *PageDiff {
+	if from == nil {
+		from = &PageVersion{PageId: to.PageId}
+	}
+	diff := &PageDiff{
+		PageId:  to.PageId,
+		From:    from.Version,
+		To:      to.Version,
+		Page:    []FieldChange{},
+		Added:   []*Widget{},
+		Removed: []*Widget{},
+		Moved:   []WidgetMove{},
+		Changed: []WidgetEdit{},
+	}
+	if from.Name != to.Name {
+		diff.Page = append(diff.Page, FieldChange{Field: "name", From: from.Name, To: to.Name})
+	}
+	if from.Route != to.Route {
+		diff.Page = append(diff.Page, FieldChange{Field: "route", From: from.Route, To: to.Route})
+	}
+	if from.IsHome != to.IsHome {
+		diff.Page = append(diff.Page, FieldChange{Field: "is_home", From: from.IsHome, To: to.IsHome})
+	}
+	before := make(map[uuid.UUID]*Widget, len(from.Widgets))
+	for _, w := range from.Widgets {
+		before[w.Id] = w
+	}
+	after := make(map[uuid.UUID]*Widget, len(to.Widgets))
+	for _, w := range to.Widgets {
+		after[w.Id] = w
+	}
+	for _, w := range from.Widgets {
+		if _, ok := after[w.Id]; !ok {
+			diff.Removed = append(diff.Removed, w)
+		}
+	}
+	for _, w := range to.Widgets {
+		old, ok := before[w.Id]
+		if !ok {
+			diff.Added = append(diff.Added, w)
+			continue
+		}
+		if old.Position != w.Position {
+			diff.Moved = append(diff.Moved, WidgetMove{Id: w.Id, From: old.Position, To: w.Position})
+		}
+		edit := WidgetEdit{Id: w.Id, Config: diffConfig(old.Config, w.Config)}
+		if old.Type != w.Type {
+			edit.Type = &FieldChange{Field: "type", From: old.Type, To: w.Type}
+		}
+		if edit.Type != nil || len(edit.Config) > 0 {
+			diff.Changed = append(diff.Changed, edit)
+		}
+	}
+	sort.SliceStable(diff.Removed, func(i, j int) bool { return diff.Removed[i].Position < diff.Removed[j].Position })
+	sort.SliceStable(diff.Added, func(i, j int) bool { return diff.Added[i].Position < diff.Added[j].Position })
+	sort.SliceStable(diff.Moved, func(i, j int) bool { return diff.Moved[i].To < diff.Moved[j].To })
+	sort.SliceStable(diff.Changed, func(i, j int) bool {
+		return after[diff.Changed[i].Id].Position < after[diff.Changed[j].Id].Position
+	})
+	return diff
+}
+
+// diffConfig compares two widget configs key by key, sorted by key.
+func diffConfig(from, to map[string]any) []FieldChange {
+	keys := make(map[string]struct{}, len(from)+len(to))
+	for k := range from {
+		keys[k] = struct{}{}
+	}
+	for k := range to {
+		keys[k] = struct{}{}
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	changes := []FieldChange{}
+	for _, k := range sorted {
+		a, inFrom := from[k]
+		b, inTo := to[k]
+		if inFrom && inTo && reflect.DeepEqual(a, b) {
+			continue
+		}
+		changes = append(changes, FieldChange{Field: k, From: a, To: b})
+	}
+	return changes
+}
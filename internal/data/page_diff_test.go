@@ -0,0 +1,79 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestDiffPageVersions(t *testing.T) {
+	banner, text, spacer, image := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+
+	from := &PageVersion{
+		Version: 3, Name: "Home", Route: "/home", IsHome: true,
+		Widgets: []*Widget{
+			{Id: banner, Type: "banner", Position: 0, Config: map[string]any{"image_url": "https://a.test/1.jpg", "link": "/sale"}},
+			{Id: text, Type: "text", Position: 1, Config: map[string]any{"content": "hi"}},
+			{Id: spacer, Type: "spacer", Position: 2},
+		},
+	}
+	to := &PageVersion{
+		Version: 5, Name: "Home", Route: "/", IsHome: true,
+		Widgets: []*Widget{
+			{Id: text, Type: "text", Position: 0, Config: map[string]any{"content": "hi"}},
+			{Id: image, Type: "image", Position: 1, Config: map[string]any{"src": "https://a.test/2.jpg"}},
+			{Id: banner, Type: "banner", Position: 2, Config: map[string]any{"image_url": "https://a.test/3.jpg", "title": "Sale"}},
+		},
+	}
+	diff := DiffPageVersions(from, to)
+
+	if diff.From != 3 || diff.To != 5 {
+		t.Fatalf("expected versions 3..5, got %d..%d", diff.From, diff.To)
+	}
+	if len(diff.Page) != 1 || diff.Page[0].Field != "route" || diff.Page[0].To != "/" {
+		t.Errorf("expected a single route change, got %+v", diff.Page)
+	}
+	if len(diff.Added) != 1 || diff.Added[0].Id != image {
+		t.Errorf("expected the image to be added, got %+v", diff.Added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0].Id != spacer {
+		t.Errorf("expected the spacer to be removed, got %+v", diff.Removed)
+	}
+	wantMoves := []WidgetMove{{Id: text, From: 1, To: 0}, {Id: banner, From: 0, To: 2}}
+	if len(diff.Moved) != len(wantMoves) {
+		t.Fatalf("expected moves %+v, got %+v", wantMoves, diff.Moved)
+	}
+	for i, want := range wantMoves {
+		if diff.Moved[i] != want {
+			t.Errorf("move %d: expected %+v, got %+v", i, want, diff.Moved[i])
+		}
+	}
+	if len(diff.Changed) != 1 || diff.Changed[0].Id != banner {
+		t.Fatalf("expected only the banner to change, got %+v", diff.Changed)
+	}
+	config := diff.Changed[0].Config
+	wantKeys := []string{"image_url", "link", "title"}
+	if len(config) != len(wantKeys) {
+		t.Fatalf("expected config changes for %v, got %+v", wantKeys, config)
+	}
+	for i, key := range wantKeys {
+		if config[i].Field != key {
+			t.Errorf("config change %d: expected %s, got %s", i, key, config[i].Field)
+		}
+	}
+	if config[1].To != nil {
+		t.Errorf("expected removed link to have a nil To, got %v", config[1].To)
+	}
+}
+
+func TestDiffPageVersionsFromEmpty(t *testing.T) {
+	to := &PageVersion{Version: 1, Name: "Home", Route: "/home", Widgets: []*Widget{{Id: uuid.New(), Type: "spacer"}}}
+
+	diff := DiffPageVersions(nil, to)
+	if len(diff.Added) != 1 || len(diff.Page) != 2 {
+		t.Errorf("expected one added widget and name/route changes, got %+v", diff)
+	}
+	if !DiffPageVersions(to, to).Empty() {
+		t.Errorf("expected a version compared with itself to be empty")
+	}
+}
@@ -0,0 +1,302 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// PageVersion is a numbered snapshot of a page taken after every save. The
+// version list leaves out the widgets and only reports how many there were.
+type PageVersion struct {
+	PageId      uuid.UUID `json:"page_id"`
+	Version     int       `json:"version"`
+	Name        string    `json:"name"`
+	Route       string    `json:"route"`
+	IsHome      bool      `json:"is_home"`
+	WidgetCount int       `json:"widget_count"`
+	Widgets     []*Widget `json:"widgets,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type PageVersionModel struct {
+	Db dbtx
+}
+
+// Record snapshots the current state of the page as its next version.
+func (m *PageVersionModel) Record(ctx context.Context, pageId uuid.UUID) (*PageVersion, error) {
+	var version *PageVersion
+
+	err := withTx(ctx, m.Db, func(tx dbtx) error {
+		var err error
+		version, err = recordPageVersion(ctx, tx, pageId)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+// GetAllForPage returns the versions of a page, newest first, without widgets.
+func (m *PageVersionModel) GetAllForPage(ctx context.Context, pageId uuid.UUID) ([]*PageVersion, error) {
+	query := `SELECT page_id, version, name, route, is_home, jsonb_array_length(widgets), created_at
+		    FROM page_versions WHERE page_id = $1 ORDER BY version DESC`
+
+	rows, err := m.Db.QueryContext(ctx, query, pageId)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			slog.Error("failed to close rows", "err", err)
+		}
+	}()
+	var versions []*PageVersion
+
+	for rows.Next() {
+		var pv PageVersion
+
+		err := rows.Scan(
+			&pv.PageId, &pv.Version,
+			&pv.Name, &pv.Route,
+			&pv.IsHome,
+			&pv.WidgetCount,
+			&pv.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, &pv)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// Get returns a single version of a page including its widgets.
+func (m *PageVersionModel) Get(ctx context.Context, pageId uuid.UUID, version int) (*PageVersion, error) {
+	return getPageVersion(ctx, m.Db, pageId, version)
+}
+
+// Restore puts the page back into the state captured by version: name, route
+// and the exact widget list with its ids, positions and configs, except that a
+// widget that has since moved to another page is copied under a new id. A
+// version that was the home page makes the page home again, but a restore
+// never leaves the store without a home page, and a version with a widget
+// whose type is no longer registered cannot be restored. Live widgets that the
+// version does not have go to the trash. The restored state is recorded as a
+// new version, which is returned.
+func (m *PageVersionModel) Restore(ctx context.Context, pageId uuid.UUID, version int) (*PageVersion, error) {
+	var restored *PageVersion
+
+	err := withTx(ctx, m.Db, func(tx dbtx) error {
+		var err error
+		restored, err = restorePageVersion(ctx, tx, pageId, version)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return restored, nil
+}
+
+// restorePageVersion does the work of Restore inside tx.
+func restorePageVersion(ctx context.Context, tx dbtx, pageId uuid.UUID, version int) (*PageVersion, error) {
+	page, err := lockPageWithWidgets(ctx, tx, pageId)
+	if err != nil {
+		return nil, err
+	}
+	pv, err := getPageVersion(ctx, tx, pageId, version)
+	if err != nil {
+		return nil, err
+	}
+	if pv.IsHome && !page.IsHome {
+		if err = changeIsHomePage(ctx, tx, page.StoreId, nil); err != nil {
+			return nil, err
+		}
+	}
+	pageQuery := `UPDATE pages SET name = $1, route = $2, is_home = is_home OR $3, updated_at = NOW(), version = version + 1 WHERE id = $4`
+
+	_, err = tx.ExecContext(ctx, pageQuery, pv.Name, pv.Route, pv.IsHome, pageId)
+	if err != nil {
+		switch {
+		case isHomeViolation(err):
+			return nil, ErrHomeConflict
+		case isUniqueViolation(err):
+			return nil, ErrDuplicateRoute
+		}
+		return nil, err
+	}
+	// A restored widget must not come back with a version a client may still
+	// hold from before the restore, wherever the widget is now, so it always
+	// moves past both. A widget that has since moved to another page stays
+	// there, and the version gets a copy of it under a new id.
+	ids := make([]uuid.UUID, len(pv.Widgets))
+	for i, widget := range pv.Widgets {
+		ids[i] = widget.Id
+	}
+	current, err := currentWidgetStates(ctx, tx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i, widget := range pv.Widgets {
+		if current[widget.Id].elsewhere(pageId) {
+			widget.Id = uuid.New()
+			ids[i] = widget.Id
+		}
+	}
+	// The version replaces the live widgets. Those it does not have go to the
+	// trash, where they can be restored from like any deleted widget. A widget
+	// of the version, live or in the trash, comes back through the version, so
+	// its row is replaced.
+	trashQuery := `UPDATE widgets SET deleted_at = NOW()
+		    WHERE page_id = $1 AND deleted_at IS NULL AND NOT (id = ANY($2))`
+
+	if _, err = tx.ExecContext(ctx, trashQuery, pageId, pq.Array(ids)); err != nil {
+		return nil, err
+	}
+	if _, err = tx.ExecContext(ctx, `DELETE FROM widgets WHERE id = ANY($1)`, pq.Array(ids)); err != nil {
+		return nil, err
+	}
+	// A type deleted since the version was taken cannot come back with it.
+	widgetQuery := `INSERT INTO widgets (id, page_id, type, position, config, created_at, version)
+		    SELECT $1::uuid, $2::uuid, $3::varchar, $4::int, $5::jsonb, $6::timestamptz, $7::int
+		    WHERE ` + registeredTypeClause
+
+	for _, widget := range pv.Widgets {
+		var configJSON []byte
+		if widget.Config != nil {
+			configJSON, err = json.Marshal(widget.Config)
+			if err != nil {
+				return nil, err
+			}
+		}
+		version := max(widget.Version, current[widget.Id].version) + 1
+		args := []any{widget.Id, pageId, widget.Type, widget.Position, configJSON, widget.CreatedAt, version}
+
+		result, err := tx.ExecContext(ctx, widgetQuery, args...)
+		if err != nil {
+			return nil, err
+		}
+		count, err := result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return nil, ErrUnknownWidgetType
+		}
+	}
+	return recordPageVersion(ctx, tx, pageId)
+}
+
+// widgetState is where a widget is now, and at which version.
+type widgetState struct {
+	pageId  uuid.UUID
+	live    bool
+	version int
+}
+
+// elsewhere reports whether the widget is live on a page other than pageId.
+func (s widgetState) elsewhere(pageId uuid.UUID) bool {
+	return s.live && s.pageId != pageId
+}
+
+// currentWidgetStates returns the state of those of the widgets ids that still
+// exist, live or in the trash, on any page.
+func currentWidgetStates(ctx context.Context, tx dbtx, ids []uuid.UUID) (map[uuid.UUID]widgetState, error) {
+	current := make(map[uuid.UUID]widgetState, len(ids))
+	query := `SELECT id, page_id, deleted_at IS NULL, version FROM widgets WHERE id = ANY($1) FOR UPDATE`
+
+	rows, err := tx.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			slog.Error("failed to close rows", "err", err)
+		}
+	}()
+	for rows.Next() {
+		var id uuid.UUID
+		var state widgetState
+
+		if err := rows.Scan(&id, &state.pageId, &state.live, &state.version); err != nil {
+			return nil, err
+		}
+		current[id] = state
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return current, nil
+}
+
+// recordPageVersion snapshots the page inside tx as its next version.
+func recordPageVersion(ctx context.Context, tx dbtx, pageId uuid.UUID) (*PageVersion, error) {
+	page, err := lockPageWithWidgets(ctx, tx, pageId)
+	if err != nil {
+		return nil, err
+	}
+	widgetsJSON, err := json.Marshal(page.Widgets)
+	if err != nil {
+		return nil, err
+	}
+	query := `INSERT INTO page_versions (page_id, version, name, route, is_home, widgets)
+		    SELECT $1, COALESCE(MAX(version), 0) + 1, $2::varchar, $3::varchar, $4::boolean, $5::jsonb
+		    FROM page_versions WHERE page_id = $1
+		    RETURNING version, created_at`
+
+	pv := PageVersion{
+		PageId: pageId,
+		Name:   page.Name, Route: page.Route,
+		IsHome:      page.IsHome,
+		WidgetCount: len(page.Widgets),
+		Widgets:     page.Widgets,
+	}
+	args := []any{pageId, page.Name, page.Route, page.IsHome, widgetsJSON}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&pv.Version, &pv.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &pv, nil
+}
+
+// getPageVersion reads one version through q, which is either the pool or an
+// open transaction.
+func getPageVersion(ctx context.Context, q dbtx, pageId uuid.UUID, version int) (*PageVersion, error) {
+	query := `SELECT page_id, version, name, route, is_home, widgets, created_at
+		    FROM page_versions WHERE page_id = $1 AND version = $2`
+
+	var pv PageVersion
+	var widgetsJSON []byte
+
+	err := q.QueryRowContext(ctx, query, pageId, version).Scan(
+		&pv.PageId, &pv.Version,
+		&pv.Name, &pv.Route,
+		&pv.IsHome,
+		&widgetsJSON,
+		&pv.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	if err = json.Unmarshal(widgetsJSON, &pv.Widgets); err != nil {
+		return nil, err
+	}
+	pv.WidgetCount = len(pv.Widgets)
+	return &pv, nil
+}
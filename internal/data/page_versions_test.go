@@ -0,0 +1,113 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// testBackends returns the backends a test runs against: the in-memory one,
+// and Postgres as well when APP_DROP_TEST_DSN names a migrated database.
+func testBackends(t *testing.T) map[string]Models {
+	t.Helper()
+
+	backends := map[string]Models{"memory": NewMemoryModels()}
+	dsn := os.Getenv("APP_DROP_TEST_DSN")
+	if dsn == "" {
+		return backends
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	backends["postgres"] = NewModels(db)
+	return backends
+}
+
+func TestRestoreMovedWidgets(t *testing.T) {
+	for name, m := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if err := m.WidgetTypes.EnsureBuiltins(ctx); err != nil {
+				t.Fatal(err)
+			}
+			store := &Store{Id: uuid.New(), Name: "Acme", Slug: "acme-" + uuid.NewString()[:8]}
+			if err := m.Stores.Insert(ctx, store); err != nil {
+				t.Fatal(err)
+			}
+			home := &Page{Id: uuid.New(), StoreId: store.Id, Name: "Home", Route: "/", IsHome: true}
+			sale := &Page{Id: uuid.New(), StoreId: store.Id, Name: "Sale", Route: "/sale"}
+			for _, page := range []*Page{home, sale} {
+				if err := m.Pages.Insert(ctx, page); err != nil {
+					t.Fatal(err)
+				}
+			}
+			moved := &Widget{Id: uuid.New(), PageId: home.Id, Type: "spacer"}
+			kept := &Widget{Id: uuid.New(), PageId: home.Id, Type: "spacer"}
+			for _, widget := range []*Widget{moved, kept} {
+				if err := m.Widgets.Insert(ctx, widget); err != nil {
+					t.Fatal(err)
+				}
+			}
+			snapshot, err := m.PageVersions.Record(ctx, home.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// One widget moves to the sale page; the other is edited and
+			// then trashed, so its version is past the snapshot's.
+			if err = m.Widgets.Move(ctx, moved, sale.Id, Placement{}); err != nil {
+				t.Fatal(err)
+			}
+			if kept, err = m.Widgets.Get(ctx, kept.Id); err != nil {
+				t.Fatal(err)
+			}
+			if err = m.Widgets.Update(ctx, kept); err != nil {
+				t.Fatal(err)
+			}
+			if err = m.Widgets.Delete(ctx, kept.Id, kept.Version); err != nil {
+				t.Fatal(err)
+			}
+			// A widget added since goes to the trash.
+			added := &Widget{Id: uuid.New(), PageId: home.Id, Type: "spacer"}
+			if err = m.Widgets.Insert(ctx, added); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err = m.PageVersions.Restore(ctx, home.Id, snapshot.Version); err != nil {
+				t.Fatal(err)
+			}
+			restored, err := m.Widgets.GetForPage(ctx, home.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(restored) != 2 || restored[0].Position != 0 || restored[1].Position != 1 {
+				t.Fatalf("expected both widgets back in place, got %+v", restored)
+			}
+			if restored[0].Id == moved.Id {
+				t.Fatal("expected the moved widget to come back as a copy")
+			}
+			if restored[1].Id != kept.Id || restored[1].Version <= kept.Version {
+				t.Fatalf("expected the trashed widget back past version %d, got %+v", kept.Version, restored[1])
+			}
+			if _, err = m.Trash.GetWidget(ctx, kept.Id); !errors.Is(err, ErrRecordNotFound) {
+				t.Fatalf("expected the widget that came back to leave the trash, got %v", err)
+			}
+			if _, err = m.Trash.RestoreWidget(ctx, added.Id); err != nil {
+				t.Fatalf("expected the widget added since to be restorable from the trash: %v", err)
+			}
+			left, err := m.Widgets.GetForPage(ctx, sale.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(left) != 1 || left[0].Id != moved.Id || left[0].Position != 0 || left[0].Version != moved.Version {
+				t.Fatalf("expected the moved widget to stay on the sale page as it was, got %+v", left)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package data
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -13,42 +14,45 @@ import (
 )
 
 type Page struct {
-	Id        uuid.UUID `json:"id"`
-	StoreId   uuid.UUID `json:"store_id"`
-	Name      string    `json:"name"`
-	Route     string    `json:"route"`
-	IsHome    bool      `json:"is_home"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Widgets   []*Widget `json:"widgets,omitempty"`
+	Id        uuid.UUID  `json:"id"`
+	StoreId   uuid.UUID  `json:"store_id"`
+	Name      string     `json:"name"`
+	Route     string     `json:"route"`
+	IsHome    bool       `json:"is_home"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	Version   int        `json:"version"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	Widgets   []*Widget  `json:"widgets,omitempty"`
 }
 
 type PageModel struct {
-	Db *sql.DB
+	Db dbtx
 }
 
 // Insert creates a new page and returns created at and updated at from db.
-func (pm *PageModel) Insert(page *Page) error {
+func (pm *PageModel) Insert(ctx context.Context, page *Page) error {
 	query := `INSERT INTO pages (id, store_id, name, route, is_home) VALUES ($1, $2, $3, $4, $5) 
-		    RETURNING created_at, updated_at`
+		    RETURNING created_at, updated_at, version`
 
 	args := []any{page.Id, page.StoreId, page.Name, page.Route, page.IsHome}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	// If this page is set as home, unset all other home pages for this app
-	if page.IsHome {
-		err := pm.changeIsHomePage(ctx, page.StoreId, nil)
-		if err != nil {
-			return err
+	// Unsetting the old home page and inserting the new one happen in one
+	// transaction, so a failed insert never leaves the store without a home.
+	err := withTx(ctx, pm.Db, func(tx dbtx) error {
+		if page.IsHome {
+			if err := changeIsHomePage(ctx, tx, page.StoreId, nil); err != nil {
+				return err
+			}
 		}
-	}
-	err := pm.Db.QueryRowContext(ctx, query, args...).Scan(&page.CreatedAt, &page.UpdatedAt)
+		return tx.QueryRowContext(ctx, query, args...).Scan(&page.CreatedAt, &page.UpdatedAt, &page.Version)
+	})
 	if err != nil {
 		switch {
-		case err.(*pq.Error).Code.Name() == "unique_violation":
-			return errors.New("page route already exists for this app")
+		case isHomeViolation(err):
+			return ErrHomeConflict
+		case isUniqueViolation(err):
+			return ErrDuplicateRoute
 		default:
 			return err
 		}
@@ -57,15 +61,12 @@ func (pm *PageModel) Insert(page *Page) error {
 }
 
 // GetAllForStore returns all pages for a specific store.
-func (pm *PageModel) GetAllForStore(storeId uuid.UUID) ([]*Page, error) {
+func (pm *PageModel) GetAllForStore(ctx context.Context, storeId uuid.UUID) ([]*Page, error) {
 	if storeId == uuid.Nil {
 		return nil, errors.New("storeId is required")
 	}
-	query := `SELECT id, store_id, name, route, is_home, created_at, updated_at FROM pages 
-		    WHERE store_id = $1 ORDER BY created_at DESC`
-
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	query := `SELECT id, store_id, name, route, is_home, created_at, updated_at, version FROM pages 
+		    WHERE store_id = $1 AND deleted_at IS NULL ORDER BY created_at DESC`
 
 	rows, err := pm.Db.QueryContext(ctx, query, storeId)
 	if err != nil {
@@ -86,6 +87,7 @@ func (pm *PageModel) GetAllForStore(storeId uuid.UUID) ([]*Page, error) {
 			&page.Name, &page.Route,
 			&page.IsHome,
 			&page.CreatedAt, &page.UpdatedAt,
+			&page.Version,
 		)
 		if err != nil {
 			return nil, err
@@ -99,20 +101,18 @@ func (pm *PageModel) GetAllForStore(storeId uuid.UUID) ([]*Page, error) {
 }
 
 // Get returns a single page with its widgets.
-func (pm *PageModel) Get(id uuid.UUID) (*Page, error) {
-	query := `SELECT id, store_id, name, route, is_home, created_at, updated_at
-		    FROM pages WHERE id = $1`
+func (pm *PageModel) Get(ctx context.Context, id uuid.UUID) (*Page, error) {
+	query := `SELECT id, store_id, name, route, is_home, created_at, updated_at, version
+		    FROM pages WHERE id = $1 AND deleted_at IS NULL`
 
 	var page Page
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
 	err := pm.Db.QueryRowContext(ctx, query, id).Scan(
 		&page.Id, &page.StoreId,
 		&page.Name, &page.Route,
 		&page.IsHome,
 		&page.CreatedAt, &page.UpdatedAt,
+		&page.Version,
 	)
 	if err != nil {
 		switch {
@@ -124,7 +124,7 @@ func (pm *PageModel) Get(id uuid.UUID) (*Page, error) {
 	}
 	wm := WidgetModel{Db: pm.Db}
 
-	w, err := wm.GetForPage(page.Id)
+	w, err := wm.GetForPage(ctx, page.Id)
 	if err != nil {
 		return nil, fmt.Errorf("error getting page widgets: %w", err)
 	}
@@ -134,29 +134,30 @@ func (pm *PageModel) Get(id uuid.UUID) (*Page, error) {
 }
 
 // Update modifies an existing page properties.
-func (pm *PageModel) Update(page *Page) error {
-	query := `UPDATE pages SET name = $1, route = $2, is_home = $3 WHERE id = $4 
-		    RETURNING updated_at`
-
-	args := []any{page.Name, page.Route, page.IsHome, page.Id}
+func (pm *PageModel) Update(ctx context.Context, page *Page) error {
+	query := `UPDATE pages SET name = $1, route = $2, is_home = $3, updated_at = NOW(), version = version + 1
+		    WHERE id = $4 AND version = $5 AND deleted_at IS NULL RETURNING updated_at, version`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	args := []any{page.Name, page.Route, page.IsHome, page.Id, page.Version}
 
-	// If setting this as home, unset all others for this app first
-	if page.IsHome {
-		err := pm.changeIsHomePage(ctx, page.StoreId, &page.Id)
-		if err != nil {
-			return err
+	// If setting this as home, unset all others for this app first, in the
+	// same transaction as the update itself
+	err := withTx(ctx, pm.Db, func(tx dbtx) error {
+		if page.IsHome {
+			if err := changeIsHomePage(ctx, tx, page.StoreId, &page.Id); err != nil {
+				return err
+			}
 		}
-	}
-	err := pm.Db.QueryRowContext(ctx, query, args...).Scan(&page.UpdatedAt)
+		return tx.QueryRowContext(ctx, query, args...).Scan(&page.UpdatedAt, &page.Version)
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return ErrRecordNotFound
-		case err.(*pq.Error).Code.Name() == "unique_violation":
-			return errors.New("page route already exists for this app")
+			return ErrEditConflict
+		case isHomeViolation(err):
+			return ErrHomeConflict
+		case isUniqueViolation(err):
+			return ErrDuplicateRoute
 		default:
 			return err
 		}
@@ -164,59 +165,228 @@ func (pm *PageModel) Update(page *Page) error {
 	return nil
 }
 
-// Delete removes a page and all its widgets (CASCADE)
-func (pm *PageModel) Delete(id uuid.UUID) error {
+// Delete moves a page and its widgets to the trash, marking them with the same
+// deleted_at. The home check and the delete run in one transaction with the
+// page row locked, so the page cannot become the home page in between. version
+// must match the stored version, or ErrEditConflict is returned.
+func (pm *PageModel) Delete(ctx context.Context, id uuid.UUID, version int) error {
 	if id == uuid.Nil {
 		return errors.New("id is required")
 	}
-	checkQuery := `SELECT is_home FROM pages WHERE id = $1`
-	var isHome bool
+	return withTx(ctx, pm.Db, func(tx dbtx) error {
+		checkQuery := `SELECT is_home, version FROM pages WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`
+		var isHome bool
+		var current int
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+		err := tx.QueryRowContext(ctx, checkQuery, id).Scan(&isHome, &current)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+		if current != version {
+			return ErrEditConflict
+		}
+		if isHome {
+			return ErrDeleteHomePage
+		}
+		var deletedAt time.Time
 
-	err := pm.Db.QueryRowContext(ctx, checkQuery, id).Scan(&isHome)
+		query := `UPDATE pages SET deleted_at = NOW() WHERE id = $1 AND version = $2 RETURNING deleted_at`
+		if err = tx.QueryRowContext(ctx, query, id, version).Scan(&deletedAt); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrEditConflict
+			}
+			return err
+		}
+		widgetsQuery := `UPDATE widgets SET deleted_at = $2 WHERE page_id = $1 AND deleted_at IS NULL`
+		_, err = tx.ExecContext(ctx, widgetsQuery, id, deletedAt)
+		return err
+	})
+}
+
+// changeIsHomePage sets is_home to false for all pages except the specified
+// one; the page that gives it up moves to its next version.
+func changeIsHomePage(ctx context.Context, tx dbtx, appId uuid.UUID, excludeId *uuid.UUID) error {
+	var query string
+	var args []any
+
+	if excludeId == nil {
+		// Unset all home pages for this app
+		query = `UPDATE pages SET is_home = FALSE, updated_at = NOW(), version = version + 1
+		    WHERE store_id = $1 AND is_home = TRUE AND deleted_at IS NULL`
+		args = []any{appId}
+	} else {
+		// Unset all except the specified page
+		query = `UPDATE pages SET is_home = FALSE, updated_at = NOW(), version = version + 1
+		    WHERE store_id = $1 AND is_home = TRUE AND id != $2 AND deleted_at IS NULL`
+		args = []any{appId, *excludeId}
+	}
+	_, err := tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Duplicate creates page as a copy of the page sourceId. page carries the id,
+// store, name and route of the copy; the copy is never the home page. Every
+// widget is copied under a new id with its position and config, and the new
+// widgets are set on page.Widgets. The store of the copy may differ from the
+// source's, in which case every widget type must be registered for it too,
+// or ErrUnknownWidgetType is returned.
+func (pm *PageModel) Duplicate(ctx context.Context, sourceId uuid.UUID, page *Page) error {
+	page.IsHome = false
+
+	err := withTx(ctx, pm.Db, func(tx dbtx) error {
+		return duplicatePage(ctx, tx, sourceId, page)
+	})
 	if err != nil {
 		switch {
-		case errors.Is(err, sql.ErrNoRows):
-			return ErrRecordNotFound
+		case isHomeViolation(err):
+			return ErrHomeConflict
+		case isUniqueViolation(err):
+			return ErrDuplicateRoute
 		default:
 			return err
 		}
 	}
-	if isHome {
-		return errors.New("cannot delete home page")
+	return nil
+}
+
+// duplicatePage does the work of Duplicate inside tx, keeping page.IsHome as
+// given.
+func duplicatePage(ctx context.Context, tx dbtx, sourceId uuid.UUID, page *Page) error {
+	pageQuery := `INSERT INTO pages (id, store_id, name, route, is_home) VALUES ($1, $2, $3, $4, $5)
+		    RETURNING created_at, updated_at, version`
+	widgetQuery := `INSERT INTO widgets (id, page_id, type, position, config)
+		    SELECT $1::uuid, $2::uuid, $3::varchar, $4::int, $5::jsonb WHERE ` + registeredTypeClause + `
+		    RETURNING created_at, updated_at, version`
+
+	source, err := lockPageWithWidgets(ctx, tx, sourceId)
+	if err != nil {
+		return err
+	}
+	args := []any{page.Id, page.StoreId, page.Name, page.Route, page.IsHome}
+
+	if err = tx.QueryRowContext(ctx, pageQuery, args...).Scan(&page.CreatedAt, &page.UpdatedAt, &page.Version); err != nil {
+		return err
+	}
+	page.Widgets = make([]*Widget, 0, len(source.Widgets))
+
+	for _, widget := range source.Widgets {
+		var configJSON []byte
+		if widget.Config != nil {
+			if configJSON, err = json.Marshal(widget.Config); err != nil {
+				return err
+			}
+		}
+		c := *widget
+		c.Id, c.PageId = uuid.New(), page.Id
+		args := []any{c.Id, c.PageId, c.Type, c.Position, configJSON}
+
+		err = tx.QueryRowContext(ctx, widgetQuery, args...).Scan(&c.CreatedAt, &c.UpdatedAt, &c.Version)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrUnknownWidgetType
+			}
+			return err
+		}
+		page.Widgets = append(page.Widgets, &c)
 	}
-	query := `DELETE FROM pages WHERE id = $1`
+	return nil
+}
 
-	result, err := pm.Db.ExecContext(ctx, query, id)
+// lockPage locks the page row until tx ends. Every statement that changes the
+// widget positions of a page takes this lock first, which serializes them.
+func lockPage(ctx context.Context, tx dbtx, pageId uuid.UUID) error {
+	var id uuid.UUID
+	err := tx.QueryRowContext(ctx, `SELECT id FROM pages WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, pageId).Scan(&id)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
 		return err
 	}
-	count, err := result.RowsAffected()
+	return nil
+}
+
+// lockPagesOfStore locks both pages, in id order so that two moves in opposite
+// directions cannot deadlock, and checks that they belong to the same store.
+func lockPagesOfStore(ctx context.Context, tx dbtx, a, b uuid.UUID) error {
+	query := `SELECT store_id FROM pages WHERE id = ANY($1) AND deleted_at IS NULL ORDER BY id FOR UPDATE`
+
+	rows, err := tx.QueryContext(ctx, query, pq.Array([]uuid.UUID{a, b}))
 	if err != nil {
 		return err
 	}
-	if count == 0 {
+	defer func() {
+		if err := rows.Close(); err != nil {
+			slog.Error("failed to close rows", "err", err)
+		}
+	}()
+	var stores []uuid.UUID
+
+	for rows.Next() {
+		var storeId uuid.UUID
+		if err := rows.Scan(&storeId); err != nil {
+			return err
+		}
+		stores = append(stores, storeId)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	want := 2
+	if a == b {
+		want = 1
+	}
+	switch {
+	case len(stores) != want:
 		return ErrRecordNotFound
+	case stores[0] != stores[len(stores)-1]:
+		return ErrForeignPage
 	}
 	return nil
 }
 
-// changeIsHomePage sets is_home to false for all pages except the specified one
-func (pm *PageModel) changeIsHomePage(ctx context.Context, appId uuid.UUID, excludeId *uuid.UUID) error {
-	var query string
-	var args []any
+// lockPageWithWidgets reads a page and its ordered widgets inside tx. The page
+// row stays locked until tx ends so that the snapshot is consistent. Widgets is
+// never nil, so the snapshot marshals to an empty JSON array.
+func lockPageWithWidgets(ctx context.Context, tx dbtx, pageId uuid.UUID) (*Page, error) {
+	query := `SELECT id, store_id, name, route, is_home, created_at, updated_at, version
+		    FROM pages WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`
 
-	if excludeId == nil {
-		// Unset all home pages for this app
-		query = `UPDATE pages SET is_home = FALSE WHERE store_id = $1 AND is_home = TRUE`
-		args = []any{appId}
-	} else {
-		// Unset all except the specified page
-		query = `UPDATE pages SET is_home = FALSE WHERE store_id = $1 AND is_home = TRUE AND id != $2`
-		args = []any{appId, *excludeId}
+	var page Page
+
+	err := tx.QueryRowContext(ctx, query, pageId).Scan(
+		&page.Id, &page.StoreId,
+		&page.Name, &page.Route,
+		&page.IsHome,
+		&page.CreatedAt, &page.UpdatedAt,
+		&page.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
 	}
-	_, err := pm.Db.ExecContext(ctx, query, args...)
-	return err
+	widgetsQuery := `SELECT id, page_id, type, position, config, created_at, updated_at, version FROM widgets
+		    WHERE page_id = $1 AND deleted_at IS NULL ORDER BY position`
+
+	rows, err := tx.QueryContext(ctx, widgetsQuery, pageId)
+	if err != nil {
+		return nil, err
+	}
+	page.Widgets, err = scanWidgets(rows)
+	if err != nil {
+		return nil, err
+	}
+	if page.Widgets == nil {
+		page.Widgets = []*Widget{}
+	}
+	return &page, nil
 }
@@ -0,0 +1,20 @@
+package data
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestHomeViolation(t *testing.T) {
+	home := &pq.Error{Code: "23505", Constraint: "idx_pages_is_home_per_app"}
+	route := &pq.Error{Code: "23505", Constraint: "idx_pages_store_route"}
+
+	if !isHomeViolation(fmt.Errorf("insert: %w", home)) {
+		t.Fatal("expected a second home page to be a home violation")
+	}
+	if isHomeViolation(route) || !isUniqueViolation(route) {
+		t.Fatal("expected a taken route to be a plain unique violation")
+	}
+}
@@ -0,0 +1,149 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Publication is an immutable snapshot of a page and its ordered widgets. The
+// pages and widgets tables hold the working copy that merchants edit; the app
+// only ever reads the latest publication of each page.
+type Publication struct {
+	PageId      uuid.UUID `json:"page_id"`
+	StoreId     uuid.UUID `json:"store_id"`
+	Revision    int       `json:"revision"`
+	Name        string    `json:"name"`
+	Route       string    `json:"route"`
+	IsHome      bool      `json:"is_home"`
+	Widgets     []*Widget `json:"widgets"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+type PublicationModel struct {
+	Db dbtx
+}
+
+// Publish freezes the current working copy of the page into a new revision.
+// The page row is locked for the duration so no edit can interleave between
+// reading the page and reading its widgets.
+func (m *PublicationModel) Publish(ctx context.Context, pageId uuid.UUID) (*Publication, error) {
+	var pub *Publication
+
+	err := withTx(ctx, m.Db, func(tx dbtx) error {
+		var err error
+		pub, err = publishPage(ctx, tx, pageId)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pub, nil
+}
+
+// publishPage does the work of Publish inside tx.
+func publishPage(ctx context.Context, tx dbtx, pageId uuid.UUID) (*Publication, error) {
+	page, err := lockPageWithWidgets(ctx, tx, pageId)
+	if err != nil {
+		return nil, err
+	}
+	pub := Publication{
+		PageId: page.Id, StoreId: page.StoreId,
+		Name: page.Name, Route: page.Route,
+		IsHome:  page.IsHome,
+		Widgets: page.Widgets,
+	}
+	widgetsJSON, err := json.Marshal(pub.Widgets)
+	if err != nil {
+		return nil, err
+	}
+	insertQuery := `INSERT INTO page_publications (page_id, revision, store_id, name, route, is_home, widgets)
+		    SELECT $1, COALESCE(MAX(revision), 0) + 1, $2::uuid, $3::varchar, $4::varchar, $5::boolean, $6::jsonb
+		    FROM page_publications WHERE page_id = $1
+		    RETURNING revision, published_at`
+
+	args := []any{pageId, pub.StoreId, pub.Name, pub.Route, pub.IsHome, widgetsJSON}
+
+	err = tx.QueryRowContext(ctx, insertQuery, args...).Scan(&pub.Revision, &pub.PublishedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &pub, nil
+}
+
+// GetLatest returns the live publication of a page.
+func (m *PublicationModel) GetLatest(ctx context.Context, pageId uuid.UUID) (*Publication, error) {
+	query := `SELECT page_id, store_id, revision, name, route, is_home, widgets, published_at
+		    FROM page_publications
+		    WHERE page_id = $1 AND page_id IN (SELECT id FROM pages WHERE deleted_at IS NULL)
+		    ORDER BY revision DESC LIMIT 1`
+
+	pub, err := scanPublication(m.Db.QueryRowContext(ctx, query, pageId))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return pub, nil
+}
+
+// GetAllForStore returns the live publication of every published page of the
+// store, ordered by route.
+func (m *PublicationModel) GetAllForStore(ctx context.Context, storeId uuid.UUID) ([]*Publication, error) {
+	query := `SELECT * FROM (
+		        SELECT DISTINCT ON (page_id) page_id, store_id, revision, name, route, is_home, widgets, published_at
+		        FROM page_publications
+		        WHERE store_id = $1 AND page_id IN (SELECT id FROM pages WHERE store_id = $1 AND deleted_at IS NULL)
+		        ORDER BY page_id, revision DESC
+		    ) AS live ORDER BY route`
+
+	rows, err := m.Db.QueryContext(ctx, query, storeId)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			slog.Error("failed to close rows", "err", err)
+		}
+	}()
+	var pubs []*Publication
+
+	for rows.Next() {
+		pub, err := scanPublication(rows)
+		if err != nil {
+			return nil, err
+		}
+		pubs = append(pubs, pub)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return pubs, nil
+}
+
+func scanPublication(row interface{ Scan(...any) error }) (*Publication, error) {
+	var pub Publication
+	var widgetsJSON []byte
+
+	err := row.Scan(
+		&pub.PageId, &pub.StoreId,
+		&pub.Revision,
+		&pub.Name, &pub.Route,
+		&pub.IsHome,
+		&widgetsJSON,
+		&pub.PublishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(widgetsJSON, &pub.Widgets); err != nil {
+		return nil, err
+	}
+	return &pub, nil
+}
@@ -5,60 +5,68 @@ import (
 	"database/sql"
 	"errors"
 	"log/slog"
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/lib/pq"
 )
 
+// Store is a merchant's app. A store marked IsTemplate is offered as a
+// starting point that new stores are cloned from.
 type Store struct {
-	Id        uuid.UUID `json:"id"`
-	Name      string    `json:"name"`
-	Slug      string    `json:"slug"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	Id         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Slug       string     `json:"slug"`
+	IsTemplate bool       `json:"is_template"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	Version    int        `json:"version"`
+	DeletedAt  *time.Time `json:"deleted_at,omitempty"`
 }
 
-type StoreModel struct {
-	Db *sql.DB
+// StoreFilter narrows down the stores returned by GetAll. A nil Template
+// returns templates and regular stores alike.
+type StoreFilter struct {
+	Template *bool
 }
 
-func (m *StoreModel) Insert(store *Store) error {
-	query := `INSERT INTO stores (id, name, slug) VALUES ($1, $2, $3) RETURNING created_at, updated_at`
+type StoreModel struct {
+	Db dbtx
+}
 
-	args := []any{store.Id, store.Name, store.Slug}
+func (m *StoreModel) Insert(ctx context.Context, store *Store) error {
+	query := `INSERT INTO stores (id, name, slug, is_template) VALUES ($1, $2, $3, $4)
+		    RETURNING created_at, updated_at, version`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	args := []any{store.Id, store.Name, store.Slug, store.IsTemplate}
 
 	err := m.Db.QueryRowContext(ctx, query, args...).Scan(
 		&store.CreatedAt,
 		&store.UpdatedAt,
+		&store.Version,
 	)
 	if err != nil {
-		var pqErr *pq.Error
-		if errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation" {
-			return errors.New("store slug already exists")
+		if isUniqueViolation(err) {
+			return ErrDuplicateSlug
 		}
 		return err
 	}
 	return nil
 }
 
-func (m *StoreModel) Get(id uuid.UUID) (*Store, error) {
+func (m *StoreModel) Get(ctx context.Context, id uuid.UUID) (*Store, error) {
 	// todo: get pages here as well?
 
-	query := `SELECT id, name, slug, created_at, updated_at FROM stores WHERE id = $1`
+	query := `SELECT id, name, slug, is_template, created_at, updated_at, version FROM stores
+		    WHERE id = $1 AND deleted_at IS NULL`
 
 	var store Store
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
 	err := m.Db.QueryRowContext(ctx, query, id).Scan(
 		&store.Id, &store.Name,
-		&store.Slug,
+		&store.Slug, &store.IsTemplate,
 		&store.CreatedAt, &store.UpdatedAt,
+		&store.Version,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -69,13 +77,34 @@ func (m *StoreModel) Get(id uuid.UUID) (*Store, error) {
 	return &store, nil
 }
 
-func (m *StoreModel) GetAll() ([]*Store, error) {
-	query := `SELECT id, name, slug, created_at, updated_at FROM stores ORDER BY created_at DESC`
+// GetBySlug returns the store with the given slug.
+func (m *StoreModel) GetBySlug(ctx context.Context, slug string) (*Store, error) {
+	query := `SELECT id, name, slug, is_template, created_at, updated_at, version FROM stores
+		    WHERE slug = $1 AND deleted_at IS NULL`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	var store Store
 
-	rows, err := m.Db.QueryContext(ctx, query)
+	err := m.Db.QueryRowContext(ctx, query, slug).Scan(
+		&store.Id, &store.Name,
+		&store.Slug, &store.IsTemplate,
+		&store.CreatedAt, &store.UpdatedAt,
+		&store.Version,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return &store, nil
+}
+
+// GetAll returns the stores that match filter, newest first.
+func (m *StoreModel) GetAll(ctx context.Context, filter StoreFilter) ([]*Store, error) {
+	query := `SELECT id, name, slug, is_template, created_at, updated_at, version FROM stores
+		    WHERE ($1::boolean IS NULL OR is_template = $1) AND deleted_at IS NULL ORDER BY created_at DESC`
+
+	rows, err := m.Db.QueryContext(ctx, query, filter.Template)
 	if err != nil {
 		return nil, err
 	}
@@ -91,8 +120,9 @@ func (m *StoreModel) GetAll() ([]*Store, error) {
 
 		err := rows.Scan(
 			&store.Id, &store.Name,
-			&store.Slug,
+			&store.Slug, &store.IsTemplate,
 			&store.CreatedAt, &store.UpdatedAt,
+			&store.Version,
 		)
 		if err != nil {
 			return nil, err
@@ -105,26 +135,19 @@ func (m *StoreModel) GetAll() ([]*Store, error) {
 	return stores, nil
 }
 
-func (m *StoreModel) Update(store *Store) error {
-	query := `UPDATE stores SET name = $1, slug = $2, updated_at = NOW() WHERE id = $3 
-		    RETURNING updated_at`
-
-	args := []any{store.Name, store.Slug, store.Id}
+func (m *StoreModel) Update(ctx context.Context, store *Store) error {
+	query := `UPDATE stores SET name = $1, slug = $2, is_template = $5, updated_at = NOW(), version = version + 1
+		    WHERE id = $3 AND version = $4 AND deleted_at IS NULL RETURNING updated_at, version`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	args := []any{store.Name, store.Slug, store.Id, store.Version, store.IsTemplate}
 
-	err := m.Db.QueryRowContext(ctx, query, args...).Scan(&store.UpdatedAt)
+	err := m.Db.QueryRowContext(ctx, query, args...).Scan(&store.UpdatedAt, &store.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return ErrRecordNotFound
-		case func() bool {
-			var pqErr *pq.Error
-			ok := errors.As(err, &pqErr)
-			return ok && pqErr.Code.Name() == "unique_violation"
-		}():
-			return errors.New("store slug already exists")
+			return ErrEditConflict
+		case isUniqueViolation(err):
+			return ErrDuplicateSlug
 		default:
 			return err
 		}
@@ -132,20 +155,97 @@ func (m *StoreModel) Update(store *Store) error {
 	return nil
 }
 
-func (m *StoreModel) Delete(id uuid.UUID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+// Delete moves a store to the trash along with its pages and widgets, which
+// are marked with the same deleted_at so that restoring the store brings them
+// back. Widget types and publications stay as they are; they are out of reach
+// while the store is in the trash and go with it when it is purged. version
+// must match the stored version, or ErrEditConflict is returned.
+func (m *StoreModel) Delete(ctx context.Context, id uuid.UUID, version int) error {
+	return withTx(ctx, m.Db, func(tx dbtx) error {
+		var current int
+
+		checkQuery := `SELECT version FROM stores WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`
+		err := tx.QueryRowContext(ctx, checkQuery, id).Scan(&current)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrRecordNotFound
+			}
+			return err
+		}
+		if current != version {
+			return ErrEditConflict
+		}
+		var deletedAt time.Time
 
-	result, err := m.Db.ExecContext(ctx, `DELETE FROM stores WHERE id = $1`, id)
-	if err != nil {
+		query := `UPDATE stores SET deleted_at = NOW() WHERE id = $1 AND version = $2 AND deleted_at IS NULL RETURNING deleted_at`
+		if err = tx.QueryRowContext(ctx, query, id, version).Scan(&deletedAt); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrEditConflict
+			}
+			return err
+		}
+		pagesQuery := `UPDATE pages SET deleted_at = $2 WHERE store_id = $1 AND deleted_at IS NULL`
+		if _, err = tx.ExecContext(ctx, pagesQuery, id, deletedAt); err != nil {
+			return err
+		}
+		widgetsQuery := `UPDATE widgets SET deleted_at = $2
+		    WHERE page_id IN (SELECT id FROM pages WHERE store_id = $1) AND deleted_at IS NULL`
+		_, err = tx.ExecContext(ctx, widgetsQuery, id, deletedAt)
 		return err
-	}
-	count, err := result.RowsAffected()
+	})
+}
+
+// Clone creates store as a deep copy of the store sourceId: the store's own
+// widget types, and all of its pages with their widgets, each under a new id.
+// The home page of the source is the home page of the copy. The new pages are
+// returned oldest first.
+func (m *StoreModel) Clone(ctx context.Context, sourceId uuid.UUID, store *Store) ([]*Page, error) {
+	var pages []*Page
+
+	err := withTx(ctx, m.Db, func(tx dbtx) error {
+		if _, err := (&StoreModel{Db: tx}).Get(ctx, sourceId); err != nil {
+			return err
+		}
+		if err := (&StoreModel{Db: tx}).Insert(ctx, store); err != nil {
+			return err
+		}
+		widgetTypes := &WidgetTypeModel{Db: tx}
+
+		types, err := widgetTypes.GetAllForStore(ctx, sourceId)
+		if err != nil {
+			return err
+		}
+		for _, wt := range types {
+			if wt.StoreId == nil {
+				continue
+			}
+			c := *wt
+			c.Id, c.StoreId = uuid.New(), &store.Id
+			if err = widgetTypes.Insert(ctx, &c); err != nil {
+				return err
+			}
+		}
+		sources, err := (&PageModel{Db: tx}).GetAllForStore(ctx, sourceId)
+		if err != nil {
+			return err
+		}
+		slices.Reverse(sources)
+
+		for _, source := range sources {
+			page := &Page{
+				Id: uuid.New(), StoreId: store.Id,
+				Name: source.Name, Route: source.Route,
+				IsHome: source.IsHome,
+			}
+			if err = duplicatePage(ctx, tx, source.Id, page); err != nil {
+				return err
+			}
+			pages = append(pages, page)
+		}
+		return nil
+	})
 	if err != nil {
-		return err
-	}
-	if count == 0 {
-		return ErrRecordNotFound
+		return nil, err
 	}
-	return nil
+	return pages, nil
 }
@@ -0,0 +1,393 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Trash is what was deleted from a store and can still be restored. Each page
+// comes with the widgets that were deleted along with it. Widgets lists the
+// widgets deleted on their own from pages that are still there.
+type Trash struct {
+	Pages   []*Page   `json:"pages"`
+	Widgets []*Widget `json:"widgets"`
+}
+
+// TrashModel reads and restores the rows that deletes leave behind with a
+// deleted_at, and purges them for good once they are old enough. Rows deleted
+// together share their deleted_at, which is how a restore finds what goes
+// back with the store or page it restores.
+type TrashModel struct {
+	Db dbtx
+}
+
+// GetStores returns the stores in the trash, most recently deleted first.
+func (m *TrashModel) GetStores(ctx context.Context) ([]*Store, error) {
+	query := `SELECT id, name, slug, is_template, created_at, updated_at, version, deleted_at FROM stores
+		    WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC`
+
+	rows, err := m.Db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			slog.Error("failed to close rows", "err", err)
+		}
+	}()
+	stores := []*Store{}
+
+	for rows.Next() {
+		var store Store
+
+		err := rows.Scan(
+			&store.Id, &store.Name,
+			&store.Slug, &store.IsTemplate,
+			&store.CreatedAt, &store.UpdatedAt,
+			&store.Version, &store.DeletedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		stores = append(stores, &store)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return stores, nil
+}
+
+// GetForStore returns the trash of a store that is not in the trash itself,
+// most recently deleted first.
+func (m *TrashModel) GetForStore(ctx context.Context, storeId uuid.UUID) (*Trash, error) {
+	var exists bool
+	err := m.Db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM stores WHERE id = $1 AND deleted_at IS NULL)`,
+		storeId).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrRecordNotFound
+	}
+	pagesQuery := `SELECT id, store_id, name, route, is_home, created_at, updated_at, version, deleted_at FROM pages
+		    WHERE store_id = $1 AND deleted_at IS NOT NULL ORDER BY deleted_at DESC`
+
+	rows, err := m.Db.QueryContext(ctx, pagesQuery, storeId)
+	if err != nil {
+		return nil, err
+	}
+	trash := &Trash{Pages: []*Page{}}
+	if trash.Pages, err = scanTrashedPages(rows); err != nil {
+		return nil, err
+	}
+	for _, page := range trash.Pages {
+		if page.Widgets, err = m.deletedWith(ctx, page); err != nil {
+			return nil, err
+		}
+	}
+	widgetsQuery := `SELECT w.id, w.page_id, w.type, w.position, w.config, w.created_at, w.updated_at, w.version,
+		        w.deleted_at
+		    FROM widgets w JOIN pages p ON p.id = w.page_id
+		    WHERE p.store_id = $1 AND p.deleted_at IS NULL AND w.deleted_at IS NOT NULL
+		    ORDER BY w.deleted_at DESC`
+
+	rows, err = m.Db.QueryContext(ctx, widgetsQuery, storeId)
+	if err != nil {
+		return nil, err
+	}
+	if trash.Widgets, err = scanTrashedWidgets(rows); err != nil {
+		return nil, err
+	}
+	return trash, nil
+}
+
+// GetPage returns a page in the trash with the widgets deleted along with it.
+// A page whose store is in the trash is not found; it comes back with the
+// store.
+func (m *TrashModel) GetPage(ctx context.Context, id uuid.UUID) (*Page, error) {
+	query := `SELECT p.id, p.store_id, p.name, p.route, p.is_home, p.created_at, p.updated_at, p.version,
+		        p.deleted_at
+		    FROM pages p JOIN stores s ON s.id = p.store_id
+		    WHERE p.id = $1 AND p.deleted_at IS NOT NULL AND s.deleted_at IS NULL`
+
+	rows, err := m.Db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	pages, err := scanTrashedPages(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(pages) == 0 {
+		return nil, ErrRecordNotFound
+	}
+	page := pages[0]
+	if page.Widgets, err = m.deletedWith(ctx, page); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// GetWidget returns a widget in the trash whose page is not.
+func (m *TrashModel) GetWidget(ctx context.Context, id uuid.UUID) (*Widget, error) {
+	query := `SELECT w.id, w.page_id, w.type, w.position, w.config, w.created_at, w.updated_at, w.version,
+		        w.deleted_at
+		    FROM widgets w JOIN pages p ON p.id = w.page_id
+		    WHERE w.id = $1 AND w.deleted_at IS NOT NULL AND p.deleted_at IS NULL`
+
+	rows, err := m.Db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	widgets, err := scanTrashedWidgets(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(widgets) == 0 {
+		return nil, ErrRecordNotFound
+	}
+	return widgets[0], nil
+}
+
+// RestoreStore takes a store out of the trash together with the pages and
+// widgets deleted along with it. The store gets slug unless it is empty, in
+// which case it keeps the slug it had. ErrDuplicateSlug is returned if another
+// store has taken the slug in the meantime.
+func (m *TrashModel) RestoreStore(ctx context.Context, id uuid.UUID, slug string) (*Store, error) {
+	var store *Store
+
+	err := withTx(ctx, m.Db, func(tx dbtx) error {
+		var deletedAt time.Time
+
+		lockQuery := `SELECT deleted_at FROM stores WHERE id = $1 AND deleted_at IS NOT NULL FOR UPDATE`
+		err := tx.QueryRowContext(ctx, lockQuery, id).Scan(&deletedAt)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrRecordNotFound
+			}
+			return err
+		}
+		query := `UPDATE stores SET deleted_at = NULL, slug = COALESCE(NULLIF($2, ''), slug), updated_at = NOW(), version = version + 1
+			    WHERE id = $1`
+
+		if _, err = tx.ExecContext(ctx, query, id, slug); err != nil {
+			if isUniqueViolation(err) {
+				return ErrDuplicateSlug
+			}
+			return err
+		}
+		pagesQuery := `UPDATE pages SET deleted_at = NULL WHERE store_id = $1 AND deleted_at = $2`
+		if _, err = tx.ExecContext(ctx, pagesQuery, id, deletedAt); err != nil {
+			return err
+		}
+		widgetsQuery := `UPDATE widgets SET deleted_at = NULL
+			    WHERE page_id IN (SELECT id FROM pages WHERE store_id = $1) AND deleted_at = $2`
+		if _, err = tx.ExecContext(ctx, widgetsQuery, id, deletedAt); err != nil {
+			return err
+		}
+		store, err = (&StoreModel{Db: tx}).Get(ctx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// RestorePage takes a page out of the trash under route, which may differ
+// from the route it had, together with the widgets deleted along with it. The
+// page comes back as a regular page, never as the home page. ErrDuplicateRoute
+// is returned if another page has taken the route in the meantime.
+func (m *TrashModel) RestorePage(ctx context.Context, id uuid.UUID, route string) (*Page, error) {
+	var page *Page
+
+	err := withTx(ctx, m.Db, func(tx dbtx) error {
+		var deletedAt time.Time
+
+		lockQuery := `SELECT p.deleted_at FROM pages p JOIN stores s ON s.id = p.store_id
+			    WHERE p.id = $1 AND p.deleted_at IS NOT NULL AND s.deleted_at IS NULL FOR UPDATE OF p`
+		err := tx.QueryRowContext(ctx, lockQuery, id).Scan(&deletedAt)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrRecordNotFound
+			}
+			return err
+		}
+		query := `UPDATE pages SET deleted_at = NULL, route = $2, is_home = FALSE, updated_at = NOW(),
+			        version = version + 1
+			    WHERE id = $1`
+
+		if _, err = tx.ExecContext(ctx, query, id, route); err != nil {
+			if isUniqueViolation(err) {
+				return ErrDuplicateRoute
+			}
+			return err
+		}
+		widgetsQuery := `UPDATE widgets SET deleted_at = NULL WHERE page_id = $1 AND deleted_at = $2`
+		if _, err = tx.ExecContext(ctx, widgetsQuery, id, deletedAt); err != nil {
+			return err
+		}
+		page, err = (&PageModel{Db: tx}).Get(ctx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// RestoreWidget takes a widget out of the trash and puts it back at the
+// position it had, or at the end of the page if the page has fewer widgets
+// now. The widgets from that position on move down by one.
+func (m *TrashModel) RestoreWidget(ctx context.Context, id uuid.UUID) (*Widget, error) {
+	var widget *Widget
+
+	err := withTx(ctx, m.Db, func(tx dbtx) error {
+		trashed, err := (&TrashModel{Db: tx}).GetWidget(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err = lockPage(ctx, tx, trashed.PageId); err != nil {
+			return err
+		}
+		position, err := resolvePlacement(ctx, tx, trashed.PageId, Placement{Position: &trashed.Position}, id)
+		if err != nil {
+			return err
+		}
+		shiftQuery := `UPDATE widgets SET position = position + 1, version = version + 1
+			    WHERE page_id = $1 AND position >= $2 AND deleted_at IS NULL`
+
+		if _, err = tx.ExecContext(ctx, shiftQuery, trashed.PageId, position); err != nil {
+			return err
+		}
+		query := `UPDATE widgets SET deleted_at = NULL, position = $2, updated_at = NOW(), version = version + 1
+			    WHERE id = $1`
+
+		if _, err = tx.ExecContext(ctx, query, id, position); err != nil {
+			return err
+		}
+		widget, err = (&WidgetModel{Db: tx}).Get(ctx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return widget, nil
+}
+
+// Purge deletes for good everything that went to the trash before the given
+// time, and returns how many stores, pages and widgets that was. The rows
+// that reference them go through ON DELETE CASCADE.
+func (m *TrashModel) Purge(ctx context.Context, before time.Time) (int64, error) {
+	var purged int64
+
+	err := withTx(ctx, m.Db, func(tx dbtx) error {
+		for _, query := range []string{
+			`DELETE FROM stores WHERE deleted_at < $1`,
+			`DELETE FROM pages WHERE deleted_at < $1`,
+			`DELETE FROM widgets WHERE deleted_at < $1`,
+		} {
+			result, err := tx.ExecContext(ctx, query, before)
+			if err != nil {
+				return err
+			}
+			count, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+			purged += count
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
+
+// deletedWith returns the widgets of a trashed page that were deleted along
+// with it, ordered by position.
+func (m *TrashModel) deletedWith(ctx context.Context, page *Page) ([]*Widget, error) {
+	query := `SELECT id, page_id, type, position, config, created_at, updated_at, version, deleted_at FROM widgets
+		    WHERE page_id = $1 AND deleted_at = $2 ORDER BY position`
+
+	rows, err := m.Db.QueryContext(ctx, query, page.Id, page.DeletedAt)
+	if err != nil {
+		return nil, err
+	}
+	return scanTrashedWidgets(rows)
+}
+
+// scanTrashedPages reads every page with its deleted_at from rows and closes
+// them.
+func scanTrashedPages(rows *sql.Rows) ([]*Page, error) {
+	defer func() {
+		if err := rows.Close(); err != nil {
+			slog.Error("failed to close rows", "err", err)
+		}
+	}()
+	pages := []*Page{}
+
+	for rows.Next() {
+		var page Page
+
+		err := rows.Scan(
+			&page.Id, &page.StoreId,
+			&page.Name, &page.Route,
+			&page.IsHome,
+			&page.CreatedAt, &page.UpdatedAt,
+			&page.Version, &page.DeletedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, &page)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return pages, nil
+}
+
+// scanTrashedWidgets reads every widget with its deleted_at from rows and
+// closes them.
+func scanTrashedWidgets(rows *sql.Rows) ([]*Widget, error) {
+	defer func() {
+		if err := rows.Close(); err != nil {
+			slog.Error("failed to close rows", "err", err)
+		}
+	}()
+	widgets := []*Widget{}
+
+	for rows.Next() {
+		var configJSON []byte
+		var widget Widget
+
+		err := rows.Scan(
+			&widget.Id, &widget.PageId,
+			&widget.Type, &widget.Position,
+			&configJSON,
+			&widget.CreatedAt, &widget.UpdatedAt,
+			&widget.Version, &widget.DeletedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if configJSON != nil {
+			if err = json.Unmarshal(configJSON, &widget.Config); err != nil {
+				return nil, err
+			}
+		}
+		widgets = append(widgets, &widget)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return widgets, nil
+}
@@ -0,0 +1,73 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+)
+
+// dbtx is what the SQL models run their statements on: the connection pool,
+// or a transaction when the model is part of a unit of work.
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// withTx runs fn in a transaction on db and commits it if fn succeeds. If db
+// is already a transaction, fn joins it and committing is left to whoever
+// started it.
+func withTx(ctx context.Context, db dbtx, fn func(tx dbtx) error) error {
+	pool, ok := db.(*sql.DB)
+	if !ok {
+		return fn(db)
+	}
+	tx, err := pool.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// newModels returns the SQL models running on db.
+func newModels(db dbtx) Models {
+	return Models{
+		Stores:       &StoreModel{Db: db},
+		Pages:        &PageModel{Db: db},
+		Widgets:      &WidgetModel{Db: db},
+		WidgetTypes:  &WidgetTypeModel{Db: db},
+		Publications: &PublicationModel{Db: db},
+		PageVersions: &PageVersionModel{Db: db},
+		Trash:        &TrashModel{Db: db},
+		Audit:        &AuditModel{Db: db},
+		ApiKeys:      &ApiKeyModel{Db: db},
+		Users:        &UserModel{Db: db},
+		Sessions:     &SessionModel{Db: db},
+		Members:      &MemberModel{Db: db},
+		Invitations:  &InvitationModel{Db: db},
+		OAuthClients: &OAuthClientModel{Db: db},
+	}
+}
+
+// sqlTxRunner starts a unit of work on the pool. The models handed to fn
+// share the transaction, and InTx on them joins it instead of nesting.
+func sqlTxRunner(db *sql.DB) txRunner {
+	return func(ctx context.Context, fn func(Models) error) error {
+		return withTx(ctx, db, func(tx dbtx) error {
+			m := newModels(tx)
+			m.inTx = joinTx(m)
+			return fn(m)
+		})
+	}
+}
+
+// joinTx runs fn on models that are already inside a unit of work.
+func joinTx(m Models) txRunner {
+	return func(_ context.Context, fn func(Models) error) error {
+		return fn(m)
+	}
+}
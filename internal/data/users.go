@@ -0,0 +1,209 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// sessionScheme starts every session token, which tells it apart from an API
+// key.
+const sessionScheme = "ads_"
+
+// User is a member of staff, who logs in with their email and password and
+// works on the stores they are a member of.
+type User struct {
+	Id        uuid.UUID `json:"id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Password  password  `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	Version   int       `json:"version"`
+}
+
+// password is the bcrypt hash of a user's password. The plaintext is only
+// known when it is set.
+type password struct {
+	plaintext *string
+	hash      []byte
+}
+
+// passwordCost is the bcrypt cost of password hashes.
+const passwordCost = 12
+
+// noPassword is hashed once, at passwordCost, for MatchNoPassword to check
+// against.
+var noPassword = sync.OnceValues(func() ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte("no password"), passwordCost)
+})
+
+// Set hashes plaintext as the new password.
+func (p *password) Set(plaintext string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(plaintext), passwordCost)
+	if err != nil {
+		return err
+	}
+	p.plaintext = &plaintext
+	p.hash = hash
+	return nil
+}
+
+// Matches reports whether plaintext is the password.
+func (p *password) Matches(plaintext string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintext))
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// MatchNoPassword checks plaintext against a password no user has, for a
+// login with an unknown email to take as long as one with a wrong password, so
+// that its timing does not tell which emails are registered.
+func MatchNoPassword(plaintext string) error {
+	hash, err := noPassword()
+	if err != nil {
+		return err
+	}
+	err = bcrypt.CompareHashAndPassword(hash, []byte(plaintext))
+	if err != nil && !errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return err
+	}
+	return nil
+}
+
+// Session is what logging in opens. Its token is only known when it is
+// generated; what is kept is a hash of it.
+type Session struct {
+	Token     string    `json:"token,omitempty"`
+	Hash      []byte    `json:"-"`
+	UserId    uuid.UUID `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// GenerateSession returns a new session of the user userId that lasts for
+// ttl, with a random token.
+func GenerateSession(userId uuid.UUID, ttl time.Duration) (*Session, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	session := &Session{
+		Token:     sessionScheme + strings.ToLower(apiKeyEncoding.EncodeToString(secret)),
+		UserId:    userId,
+		ExpiresAt: time.Now().Add(ttl).UTC().Truncate(time.Second),
+	}
+	session.Hash = sessionHash(session.Token)
+	return session, nil
+}
+
+// IsSessionToken reports whether plaintext is shaped like a session token.
+func IsSessionToken(plaintext string) bool {
+	secret, ok := strings.CutPrefix(plaintext, sessionScheme)
+	return ok && secret != ""
+}
+
+func sessionHash(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
+
+type UserModel struct {
+	Db dbtx
+}
+
+func (m *UserModel) Insert(ctx context.Context, user *User) error {
+	query := `INSERT INTO users (id, name, email, password_hash)
+		    VALUES ($1, $2, $3, $4) RETURNING created_at, version`
+
+	user.Id = uuid.New()
+	args := []any{user.Id, user.Name, user.Email, user.Password.hash}
+
+	err := m.Db.QueryRowContext(ctx, query, args...).Scan(&user.CreatedAt, &user.Version)
+	if isUniqueViolation(err) {
+		return ErrDuplicateEmail
+	}
+	return err
+}
+
+func (m *UserModel) Get(ctx context.Context, id uuid.UUID) (*User, error) {
+	query := `SELECT id, name, email, password_hash, created_at, version FROM users WHERE id = $1`
+	return scanUser(m.Db.QueryRowContext(ctx, query, id))
+}
+
+func (m *UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	query := `SELECT id, name, email, password_hash, created_at, version FROM users WHERE email = $1`
+	return scanUser(m.Db.QueryRowContext(ctx, query, email))
+}
+
+// scanUser reads a user from row, with sql.ErrNoRows as ErrRecordNotFound.
+func scanUser(row *sql.Row) (*User, error) {
+	var user User
+	err := row.Scan(&user.Id, &user.Name, &user.Email, &user.Password.hash, &user.CreatedAt, &user.Version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+type SessionModel struct {
+	Db dbtx
+}
+
+func (m *SessionModel) Insert(ctx context.Context, session *Session) error {
+	query := `INSERT INTO sessions (hash, user_id, expires_at) VALUES ($1, $2, $3)`
+	_, err := m.Db.ExecContext(ctx, query, session.Hash, session.UserId, session.ExpiresAt)
+	return err
+}
+
+// GetUser returns the user of the session with the given token, if it has not
+// expired.
+func (m *SessionModel) GetUser(ctx context.Context, token string) (*User, error) {
+	query := `SELECT u.id, u.name, u.email, u.password_hash, u.created_at, u.version
+		    FROM users u JOIN sessions s ON s.user_id = u.id
+		    WHERE s.hash = $1 AND s.expires_at > NOW()`
+
+	return scanUser(m.Db.QueryRowContext(ctx, query, sessionHash(token)))
+}
+
+// Delete ends the session with the given token.
+func (m *SessionModel) Delete(ctx context.Context, token string) error {
+	query := `DELETE FROM sessions WHERE hash = $1`
+
+	result, err := m.Db.ExecContext(ctx, query, sessionHash(token))
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteExpired removes the sessions that expired before the given time and
+// returns how many there were.
+func (m *SessionModel) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	result, err := m.Db.ExecContext(ctx, `DELETE FROM sessions WHERE expires_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,111 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestSessions(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryModels()
+
+	user := &User{Name: "Ada", Email: "ada@example.com"}
+	if err := user.Password.Set("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Users.Insert(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Users.Insert(ctx, &User{Name: "Ada", Email: "ada@example.com"}); !errors.Is(err, ErrDuplicateEmail) {
+		t.Fatalf("expected ErrDuplicateEmail, got %v", err)
+	}
+	got, err := m.Users.GetByEmail(ctx, "ada@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := got.Password.Matches("correct horse"); err != nil || !ok {
+		t.Fatalf("expected the password to match, got %v, %v", ok, err)
+	}
+	if ok, _ := got.Password.Matches("wrong horse"); ok {
+		t.Fatal("expected another password not to match")
+	}
+	if err = MatchNoPassword("correct horse"); err != nil {
+		t.Fatalf("expected checking against no password to only cost time, got %v", err)
+	}
+
+	session, err := GenerateSession(user.Id, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSessionToken(session.Token) {
+		t.Fatalf("expected %q to be a session token", session.Token)
+	}
+	if err = m.Sessions.Insert(ctx, session); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = m.Sessions.GetUser(ctx, session.Token); err != nil || got.Id != user.Id {
+		t.Fatalf("expected the session to belong to %s, got %v, %v", user.Id, got, err)
+	}
+	expired, err := GenerateSession(user.Id, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Sessions.Insert(ctx, expired); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Sessions.GetUser(ctx, expired.Token); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("expected an expired session to be unknown, got %v", err)
+	}
+	if n, err := m.Sessions.DeleteExpired(ctx, time.Now()); err != nil || n != 1 {
+		t.Fatalf("expected 1 expired session to be deleted, got %d, %v", n, err)
+	}
+	if err = m.Sessions.Delete(ctx, session.Token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Sessions.GetUser(ctx, session.Token); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("expected a deleted session to be unknown, got %v", err)
+	}
+}
+
+func TestMemberOwners(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryModels()
+
+	store := &Store{Id: uuid.New(), Name: "Acme", Slug: "acme"}
+	if err := m.Stores.Insert(ctx, store); err != nil {
+		t.Fatal(err)
+	}
+	var ids []uuid.UUID
+	for i, role := range []string{RoleOwner, RoleEditor} {
+		user := &User{Name: role, Email: role + "@example.com"}
+		if err := m.Users.Insert(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Members.Insert(ctx, &Member{StoreId: store.Id, UserId: user.Id, Role: role}); err != nil {
+			t.Fatalf("member %d: %v", i, err)
+		}
+		ids = append(ids, user.Id)
+	}
+	owner, editor := ids[0], ids[1]
+
+	if err := m.Members.UpdateRole(ctx, store.Id, owner, RoleViewer); !errors.Is(err, ErrLastOwner) {
+		t.Fatalf("expected ErrLastOwner, got %v", err)
+	}
+	if err := m.Members.Delete(ctx, store.Id, owner); !errors.Is(err, ErrLastOwner) {
+		t.Fatalf("expected ErrLastOwner, got %v", err)
+	}
+	if err := m.Members.Delete(ctx, store.Id, editor); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Members.UpdateRole(ctx, store.Id, editor, RoleOwner); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("expected a removed member to be unknown, got %v", err)
+	}
+	roles, err := m.Members.GetRolesForUser(ctx, owner)
+	if err != nil || roles[store.Id] != RoleOwner {
+		t.Fatalf("expected to own acme, got %v, %v", roles, err)
+	}
+}
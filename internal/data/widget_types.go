@@ -0,0 +1,513 @@
+package data
+
+import (
+	"appdrop/internal/validator"
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// Field types understood by FieldSchema.Type.
+const (
+	FieldString  = "string"
+	FieldNumber  = "number"
+	FieldInteger = "integer"
+	FieldBoolean = "boolean"
+	FieldArray   = "array"
+	FieldObject  = "object"
+)
+
+// Formats understood by FieldSchema.Format. They only apply to string fields.
+const (
+	FormatURL   = "url"   // absolute http or https URL
+	FormatLink  = "link"  // absolute URL or an in-app path starting with "/"
+	FormatColor = "color" // #rgb, #rrggbb or #rrggbbaa
+)
+
+// FieldSchema describes the constraints on a single key of a widget config.
+// Min and Max bound the value of numbers, the length of strings and the
+// number of items in arrays.
+type FieldSchema struct {
+	Type     string   `json:"type"`
+	Required bool     `json:"required,omitempty"`
+	Enum     []string `json:"enum,omitempty"`
+	Min      *float64 `json:"min,omitempty"`
+	Max      *float64 `json:"max,omitempty"`
+	Format   string   `json:"format,omitempty"`
+}
+
+// ConfigSchema describes the config accepted by a widget type. Keys that are
+// not listed in Fields are rejected unless AllowAdditional is set.
+type ConfigSchema struct {
+	Fields          map[string]FieldSchema `json:"fields"`
+	AllowAdditional bool                   `json:"allow_additional,omitempty"`
+}
+
+// WidgetType is an entry in the widget type registry. Built-in types have no
+// StoreId and are available to every store; the others belong to one store.
+type WidgetType struct {
+	Id            uuid.UUID    `json:"id"`
+	StoreId       *uuid.UUID   `json:"store_id"`
+	Name          string       `json:"name"`
+	Label         string       `json:"label"`
+	Schema        ConfigSchema `json:"schema"`
+	MinAppVersion string       `json:"min_app_version,omitempty"`
+	MaxAppVersion string       `json:"max_app_version,omitempty"`
+	CreatedAt     time.Time    `json:"created_at"`
+	UpdatedAt     time.Time    `json:"updated_at"`
+}
+
+// BuiltIn reports whether the type is a global default.
+func (wt *WidgetType) BuiltIn() bool {
+	return wt.StoreId == nil
+}
+
+func bound(f float64) *float64 { return &f }
+
+// builtinWidgetTypes are the widget types every store can use. They are
+// written to the registry on startup by EnsureBuiltins.
+var builtinWidgetTypes = []*WidgetType{
+	{
+		Name: "banner", Label: "Banner",
+		Schema: ConfigSchema{Fields: map[string]FieldSchema{
+			"image_url":        {Type: FieldString, Required: true, Format: FormatURL},
+			"link":             {Type: FieldString, Format: FormatLink},
+			"alt_text":         {Type: FieldString, Max: bound(255)},
+			"title":            {Type: FieldString, Max: bound(120)},
+			"subtitle":         {Type: FieldString, Max: bound(255)},
+			"background_color": {Type: FieldString, Format: FormatColor},
+		}},
+	},
+	{
+		Name: "product_grid", Label: "Product grid",
+		Schema: ConfigSchema{Fields: map[string]FieldSchema{
+			"collection_id":    {Type: FieldString, Required: true, Min: bound(1), Max: bound(255)},
+			"title":            {Type: FieldString, Max: bound(120)},
+			"columns":          {Type: FieldInteger, Min: bound(1), Max: bound(4)},
+			"limit":            {Type: FieldInteger, Min: bound(1), Max: bound(50)},
+			"show_price":       {Type: FieldBoolean},
+			"show_add_to_cart": {Type: FieldBoolean},
+		}},
+	},
+	{
+		Name: "text", Label: "Text",
+		Schema: ConfigSchema{Fields: map[string]FieldSchema{
+			"content":     {Type: FieldString, Required: true, Min: bound(1), Max: bound(5000)},
+			"text_align":  {Type: FieldString, Enum: []string{"left", "center", "right", "justify"}},
+			"font_size":   {Type: FieldNumber, Min: bound(8), Max: bound(72)},
+			"font_weight": {Type: FieldString, Enum: []string{"normal", "bold"}},
+			"color":       {Type: FieldString, Format: FormatColor},
+		}},
+	},
+	{
+		Name: "image", Label: "Image",
+		Schema: ConfigSchema{Fields: map[string]FieldSchema{
+			"src":          {Type: FieldString, Required: true, Format: FormatURL},
+			"alt":          {Type: FieldString, Max: bound(255)},
+			"aspect_ratio": {Type: FieldString, Enum: []string{"1:1", "4:3", "3:4", "16:9", "9:16", "21:9"}},
+			"link":         {Type: FieldString, Format: FormatLink},
+		}},
+	},
+	{
+		Name: "spacer", Label: "Spacer",
+		Schema: ConfigSchema{Fields: map[string]FieldSchema{
+			"height": {Type: FieldInteger, Min: bound(0), Max: bound(500)},
+		}},
+	},
+}
+
+var (
+	widgetTypeNameRX = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+	appVersionRX     = regexp.MustCompile(`^\d+(\.\d+){0,2}$`)
+)
+
+// ValidateWidget checks the widget type and its config against wt, the
+// registry entry looked up for the widget's store. A nil wt means the type is
+// not registered. Config errors are keyed "config.<field>".
+func ValidateWidget(v *validator.Validator, widget *Widget, wt *WidgetType) {
+	if wt == nil {
+		v.AddError("type", fmt.Sprintf("%q is not a registered widget type", widget.Type))
+		return
+	}
+	wt.Schema.Validate(v, widget.Config)
+}
+
+// ValidateWidgetType checks a registry entry before it is stored.
+func ValidateWidgetType(v *validator.Validator, wt *WidgetType) {
+	v.Check(wt.Name != "", "name", "is required")
+	v.Check(len(wt.Name) <= 50, "name", "must be at most 50 characters long")
+	v.Check(validator.Matches(wt.Name, widgetTypeNameRX), "name",
+		"must start with a lowercase letter and contain only lowercase letters, digits and underscores")
+
+	v.Check(strings.TrimSpace(wt.Label) != "", "label", "is required")
+	v.Check(len(wt.Label) <= 255, "label", "must be at most 255 characters long")
+
+	for _, k := range []struct{ key, value string }{
+		{"min_app_version", wt.MinAppVersion},
+		{"max_app_version", wt.MaxAppVersion},
+	} {
+		if k.value != "" {
+			v.Check(ValidAppVersion(k.value), k.key, "must be a version such as 2.4.1")
+		}
+	}
+	if v.Valid() && wt.MinAppVersion != "" && wt.MaxAppVersion != "" {
+		v.Check(CompareAppVersions(wt.MinAppVersion, wt.MaxAppVersion) <= 0, "max_app_version",
+			"must not be lower than min_app_version")
+	}
+	for name, field := range wt.Schema.Fields {
+		key := "schema.fields." + name
+		v.Check(name != "", "schema.fields", "field names must not be empty")
+		v.Check(validator.PermittedValue(field.Type,
+			FieldString, FieldNumber, FieldInteger, FieldBoolean, FieldArray, FieldObject), key+".type",
+			"must be one of: string, number, integer, boolean, array, object")
+		if field.Format != "" {
+			v.Check(field.Type == FieldString, key+".format", "is only supported on string fields")
+			v.Check(validator.PermittedValue(field.Format, FormatURL, FormatLink, FormatColor), key+".format",
+				"must be one of: url, link, color")
+		}
+		if len(field.Enum) > 0 {
+			v.Check(field.Type == FieldString, key+".enum", "is only supported on string fields")
+		}
+		if field.Min != nil && field.Max != nil {
+			v.Check(*field.Min <= *field.Max, key+".max", "must not be lower than min")
+		}
+	}
+}
+
+// ValidAppVersion reports whether s is a dotted app version such as 2.4.1.
+func ValidAppVersion(s string) bool {
+	return validator.Matches(s, appVersionRX)
+}
+
+// CompareAppVersions compares two dotted app versions numerically, treating
+// missing components as zero. It returns -1, 0 or 1.
+func CompareAppVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < max(len(as), len(bs)); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			return cmp.Compare(x, y)
+		}
+	}
+	return 0
+}
+
+// Validate checks config against the schema and records every failing field
+// in v.
+func (s ConfigSchema) Validate(v *validator.Validator, config map[string]any) {
+	for name, field := range s.Fields {
+		value, present := config[name]
+		if !present || value == nil {
+			v.Check(!field.Required, "config."+name, "is required")
+			continue
+		}
+		field.validate(v, "config."+name, value)
+	}
+	if s.AllowAdditional {
+		return
+	}
+	for name := range config {
+		if _, known := s.Fields[name]; !known {
+			v.AddError("config."+name, "is not a supported field")
+		}
+	}
+}
+
+func (f FieldSchema) validate(v *validator.Validator, key string, value any) {
+	switch f.Type {
+	case FieldString:
+		s, ok := value.(string)
+		if !ok {
+			v.AddError(key, "must be a string")
+			return
+		}
+		f.checkBounds(v, key, float64(utf8.RuneCountInString(s)), "characters long")
+		if len(f.Enum) > 0 {
+			v.Check(validator.PermittedValue(s, f.Enum...), key, "must be one of: "+strings.Join(f.Enum, ", "))
+		}
+		f.checkFormat(v, key, s)
+	case FieldNumber, FieldInteger:
+		n, ok := value.(float64)
+		if !ok && f.Type == FieldInteger {
+			v.AddError(key, "must be an integer")
+			return
+		}
+		if !ok {
+			v.AddError(key, "must be a number")
+			return
+		}
+		if f.Type == FieldInteger && n != math.Trunc(n) {
+			v.AddError(key, "must be an integer")
+			return
+		}
+		f.checkBounds(v, key, n, "")
+	case FieldBoolean:
+		_, ok := value.(bool)
+		v.Check(ok, key, "must be a boolean")
+	case FieldArray:
+		items, ok := value.([]any)
+		if !ok {
+			v.AddError(key, "must be an array")
+			return
+		}
+		f.checkBounds(v, key, float64(len(items)), "items")
+	case FieldObject:
+		_, ok := value.(map[string]any)
+		v.Check(ok, key, "must be an object")
+	}
+}
+
+func (f FieldSchema) checkBounds(v *validator.Validator, key string, n float64, unit string) {
+	suffix := ""
+	if unit != "" {
+		suffix = " " + unit
+	}
+	if f.Min != nil {
+		v.Check(n >= *f.Min, key, fmt.Sprintf("must be at least %g%s", *f.Min, suffix))
+	}
+	if f.Max != nil {
+		v.Check(n <= *f.Max, key, fmt.Sprintf("must be at most %g%s", *f.Max, suffix))
+	}
+}
+
+func (f FieldSchema) checkFormat(v *validator.Validator, key, s string) {
+	switch f.Format {
+	case FormatURL:
+		v.Check(isAbsoluteURL(s), key, "must be an absolute http or https URL")
+	case FormatLink:
+		v.Check(isAbsoluteURL(s) || strings.HasPrefix(s, "/"), key, "must be an absolute URL or a path starting with /")
+	case FormatColor:
+		v.Check(validator.Matches(s, validator.HexColorRX), key, "must be a hex color such as #1a2b3c")
+	}
+}
+
+func isAbsoluteURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// registeredTypeClause restricts a widget statement to types that are in the
+// registry for the store of the widget's page. It expects the type name as
+// $3 and the page id as $2.
+const registeredTypeClause = `EXISTS (SELECT 1 FROM widget_types wt WHERE wt.name = $3
+		    AND (wt.store_id IS NULL OR wt.store_id = (SELECT store_id FROM pages WHERE id = $2)))`
+
+type WidgetTypeModel struct {
+	Db dbtx
+}
+
+const widgetTypeColumns = `id, store_id, name, label, schema, min_app_version, max_app_version,
+		    created_at, updated_at`
+
+func scanWidgetType(row interface{ Scan(...any) error }) (*WidgetType, error) {
+	var wt WidgetType
+	var storeId uuid.NullUUID
+	var schemaJSON []byte
+
+	err := row.Scan(
+		&wt.Id, &storeId,
+		&wt.Name, &wt.Label,
+		&schemaJSON,
+		&wt.MinAppVersion, &wt.MaxAppVersion,
+		&wt.CreatedAt, &wt.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if storeId.Valid {
+		wt.StoreId = &storeId.UUID
+	}
+	if err = json.Unmarshal(schemaJSON, &wt.Schema); err != nil {
+		return nil, err
+	}
+	return &wt, nil
+}
+
+// EnsureBuiltins writes the built-in widget types to the registry, replacing
+// the stored label and schema so the code stays the source of truth for them.
+func (m *WidgetTypeModel) EnsureBuiltins(ctx context.Context) error {
+	query := `INSERT INTO widget_types (id, store_id, name, label, schema) VALUES ($1, NULL, $2, $3, $4)
+		    ON CONFLICT (name) WHERE store_id IS NULL
+		    DO UPDATE SET label = EXCLUDED.label, schema = EXCLUDED.schema, updated_at = NOW()`
+
+	for _, wt := range builtinWidgetTypes {
+		schemaJSON, err := json.Marshal(wt.Schema)
+		if err != nil {
+			return err
+		}
+		_, err = m.Db.ExecContext(ctx, query, uuid.New(), wt.Name, wt.Label, schemaJSON)
+		if err != nil {
+			return fmt.Errorf("seeding widget type %q: %w", wt.Name, err)
+		}
+	}
+	return nil
+}
+
+// GetAllForStore returns the built-in types followed by the store's own types.
+func (m *WidgetTypeModel) GetAllForStore(ctx context.Context, storeId uuid.UUID) ([]*WidgetType, error) {
+	query := `SELECT ` + widgetTypeColumns + ` FROM widget_types
+		    WHERE store_id IS NULL OR store_id = $1 ORDER BY store_id NULLS FIRST, name`
+
+	rows, err := m.Db.QueryContext(ctx, query, storeId)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			slog.Error("failed to close rows", "err", err)
+		}
+	}()
+	var types []*WidgetType
+
+	for rows.Next() {
+		wt, err := scanWidgetType(rows)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, wt)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return types, nil
+}
+
+// Get returns a single widget type by id.
+func (m *WidgetTypeModel) Get(ctx context.Context, id uuid.UUID) (*WidgetType, error) {
+	query := `SELECT ` + widgetTypeColumns + ` FROM widget_types WHERE id = $1`
+
+	wt, err := scanWidgetType(m.Db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return wt, nil
+}
+
+// Lookup returns the type called name that is visible to the store. A type
+// defined by the store wins over a built-in of the same name.
+func (m *WidgetTypeModel) Lookup(ctx context.Context, storeId uuid.UUID, name string) (*WidgetType, error) {
+	query := `SELECT ` + widgetTypeColumns + ` FROM widget_types
+		    WHERE name = $1 AND (store_id IS NULL OR store_id = $2)
+		    ORDER BY store_id NULLS LAST LIMIT 1`
+
+	wt, err := scanWidgetType(m.Db.QueryRowContext(ctx, query, name, storeId))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return wt, nil
+}
+
+// Insert registers a store defined widget type. The name must not already be
+// used by a built-in type or another type of the same store.
+func (m *WidgetTypeModel) Insert(ctx context.Context, wt *WidgetType) error {
+	if wt.StoreId == nil {
+		return errors.New("storeId is required")
+	}
+	query := `INSERT INTO widget_types (id, store_id, name, label, schema, min_app_version, max_app_version)
+		    SELECT $1::uuid, $2::uuid, $3::varchar, $4::varchar, $5::jsonb, $6::varchar, $7::varchar
+		    WHERE NOT EXISTS (SELECT 1 FROM widget_types WHERE name = $3 AND (store_id IS NULL OR store_id = $2))
+		    RETURNING created_at, updated_at`
+
+	schemaJSON, err := json.Marshal(wt.Schema)
+	if err != nil {
+		return err
+	}
+	args := []any{
+		wt.Id, *wt.StoreId,
+		wt.Name, wt.Label,
+		schemaJSON,
+		wt.MinAppVersion, wt.MaxAppVersion,
+	}
+	err = m.Db.QueryRowContext(ctx, query, args...).Scan(&wt.CreatedAt, &wt.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrDuplicateWidgetType
+		case isUniqueViolation(err):
+			return ErrDuplicateWidgetType
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Update modifies the label, schema and supported app versions of a store
+// defined widget type. Names are immutable since widgets refer to them.
+func (m *WidgetTypeModel) Update(ctx context.Context, wt *WidgetType) error {
+	query := `UPDATE widget_types SET label = $1, schema = $2, min_app_version = $3, max_app_version = $4,
+		    updated_at = NOW() WHERE id = $5 AND store_id IS NOT NULL RETURNING updated_at`
+
+	schemaJSON, err := json.Marshal(wt.Schema)
+	if err != nil {
+		return err
+	}
+	args := []any{wt.Label, schemaJSON, wt.MinAppVersion, wt.MaxAppVersion, wt.Id}
+
+	err = m.Db.QueryRowContext(ctx, query, args...).Scan(&wt.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// Delete removes a store defined widget type that no live widget of the store
+// uses. Widgets of the type that are in the trash could never be restored, so
+// they are purged with it.
+func (m *WidgetTypeModel) Delete(ctx context.Context, id uuid.UUID) error {
+	query := `DELETE FROM widget_types wt WHERE wt.id = $1 AND wt.store_id IS NOT NULL
+		    AND NOT EXISTS (SELECT 1 FROM widgets w JOIN pages p ON p.id = w.page_id
+		                    WHERE p.store_id = wt.store_id AND w.type = wt.name AND w.deleted_at IS NULL)
+		  RETURNING store_id, name`
+
+	return withTx(ctx, m.Db, func(tx dbtx) error {
+		var storeId uuid.UUID
+		var name string
+
+		err := tx.QueryRowContext(ctx, query, id).Scan(&storeId, &name)
+		if errors.Is(err, sql.ErrNoRows) {
+			// Tell a missing type apart from one that is still in use.
+			if _, err = (&WidgetTypeModel{Db: tx}).Get(ctx, id); err != nil {
+				return err
+			}
+			return ErrWidgetTypeInUse
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM widgets w USING pages p
+			WHERE p.id = w.page_id AND p.store_id = $1 AND w.type = $2 AND w.deleted_at IS NOT NULL`, storeId, name)
+		return err
+	})
+}
@@ -0,0 +1,138 @@
+package data
+
+import (
+	"appdrop/internal/validator"
+	"testing"
+)
+
+func TestValidateWidget(t *testing.T) {
+	tests := []struct {
+		name   string
+		widget Widget
+		errors map[string]string
+	}{
+		{
+			name: "valid banner",
+			widget: Widget{Type: "banner", Config: map[string]any{
+				"image_url": "https://example.com/banner.jpg",
+				"link":      "/collections/new",
+				"alt_text":  "New Collection Banner",
+			}},
+		},
+		{
+			name:   "spacer without config",
+			widget: Widget{Type: "spacer"},
+		},
+		{
+			name:   "unknown type",
+			widget: Widget{Type: "carousel"},
+			errors: map[string]string{"type": `"carousel" is not a registered widget type`},
+		},
+		{
+			name:   "banner missing image",
+			widget: Widget{Type: "banner", Config: map[string]any{"link": "/sale"}},
+			errors: map[string]string{"config.image_url": "is required"},
+		},
+		{
+			name:   "spacer with string height",
+			widget: Widget{Type: "spacer", Config: map[string]any{"height": "20px"}},
+			errors: map[string]string{"config.height": "must be an integer"},
+		},
+		{
+			name: "every failing field is reported",
+			widget: Widget{Type: "text", Config: map[string]any{
+				"content":    "hello",
+				"text_align": "middle",
+				"font_size":  100.0,
+				"color":      "red",
+				"shadow":     true,
+			}},
+			errors: map[string]string{
+				"config.text_align": "must be one of: left, center, right, justify",
+				"config.font_size":  "must be at most 72",
+				"config.color":      "must be a hex color such as #1a2b3c",
+				"config.shadow":     "is not a supported field",
+			},
+		},
+		{
+			name:   "fractional integer",
+			widget: Widget{Type: "product_grid", Config: map[string]any{"collection_id": "summer", "columns": 2.5}},
+			errors: map[string]string{"config.columns": "must be an integer"},
+		},
+		{
+			name:   "relative image url",
+			widget: Widget{Type: "image", Config: map[string]any{"src": "/hero.jpg"}},
+			errors: map[string]string{"config.src": "must be an absolute http or https URL"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			ValidateWidget(v, &tt.widget, builtinWidgetType(tt.widget.Type))
+
+			if len(v.Errors) != len(tt.errors) {
+				t.Fatalf("expected errors %v, got %v", tt.errors, v.Errors)
+			}
+			for key, want := range tt.errors {
+				if got := v.Errors[key]; got != want {
+					t.Errorf("%s: expected %q, got %q", key, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateWidgetType(t *testing.T) {
+	wt := &WidgetType{
+		Name: "Countdown", Label: "Countdown",
+		Schema: ConfigSchema{Fields: map[string]FieldSchema{
+			"ends_at": {Type: "date"},
+			"color":   {Type: FieldInteger, Format: FormatColor},
+			"size":    {Type: FieldInteger, Min: bound(10), Max: bound(1)},
+		}},
+		MinAppVersion: "3.0", MaxAppVersion: "2.9.9",
+	}
+	v := validator.New()
+	ValidateWidgetType(v, wt)
+
+	for _, key := range []string{
+		"name",
+		"schema.fields.ends_at.type",
+		"schema.fields.color.format",
+		"schema.fields.size.max",
+	} {
+		if _, ok := v.Errors[key]; !ok {
+			t.Errorf("expected an error for %s, got %v", key, v.Errors)
+		}
+	}
+	// Version ordering is only checked once everything else is valid.
+	if _, ok := v.Errors["max_app_version"]; ok {
+		t.Errorf("unexpected max_app_version error: %v", v.Errors)
+	}
+}
+
+func TestCompareAppVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"1.2", "1.2.0", 0},
+		{"1.10", "1.9", 1},
+		{"2", "10.0.1", -1},
+	}
+	for _, tt := range tests {
+		if got := CompareAppVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareAppVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func builtinWidgetType(name string) *WidgetType {
+	for _, wt := range builtinWidgetTypes {
+		if wt.Name == name {
+			return wt
+		}
+	}
+	return nil
+}
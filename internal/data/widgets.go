@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"log/slog"
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
@@ -20,24 +21,28 @@ type Widget struct {
 	Config    map[string]any `json:"config,omitempty"`
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
+	Version   int            `json:"version"`
+	DeletedAt *time.Time     `json:"deleted_at,omitempty"`
 }
 
 type WidgetModel struct {
-	Db *sql.DB
+	Db dbtx
 }
 
 // GetForPage returns all widgets for a specific page, ordered by position
-func (m *WidgetModel) GetForPage(pageID uuid.UUID) ([]*Widget, error) {
-	query := `SELECT id, page_id, type, position, config, created_at, updated_at FROM widgets
-		    WHERE page_id = $1 ORDER BY position`
-
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+func (m *WidgetModel) GetForPage(ctx context.Context, pageID uuid.UUID) ([]*Widget, error) {
+	query := `SELECT id, page_id, type, position, config, created_at, updated_at, version FROM widgets
+		    WHERE page_id = $1 AND deleted_at IS NULL ORDER BY position`
 
 	rows, err := m.Db.QueryContext(ctx, query, pageID)
 	if err != nil {
 		return nil, err
 	}
+	return scanWidgets(rows)
+}
+
+// scanWidgets reads every widget from rows and closes them.
+func scanWidgets(rows *sql.Rows) ([]*Widget, error) {
 	defer func() {
 		if err := rows.Close(); err != nil {
 			slog.Error("Failed to close rows:", "err", err)
@@ -54,6 +59,7 @@ func (m *WidgetModel) GetForPage(pageID uuid.UUID) ([]*Widget, error) {
 			&widget.Type, &widget.Position,
 			&configJSON,
 			&widget.CreatedAt, &widget.UpdatedAt,
+			&widget.Version,
 		)
 		if err != nil {
 			return nil, err
@@ -66,53 +72,121 @@ func (m *WidgetModel) GetForPage(pageID uuid.UUID) ([]*Widget, error) {
 		}
 		widgets = append(widgets, &widget)
 	}
-	if err = rows.Err(); err != nil {
+	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 	return widgets, nil
 }
 
-// Insert creates a new widget
-func (m *WidgetModel) Insert(widget *Widget) error {
-	// Get the next position for this page
-	posQuery := `SELECT COALESCE(MAX(position), -1) + 1 FROM widgets WHERE page_id = $1`
+// Placement says where a new widget goes on its page: at Position, or right
+// before or after another widget of the page. At most one of them is set; the
+// zero value appends the widget. A position past the end also appends.
+type Placement struct {
+	Position *int
+	Before   *uuid.UUID
+	After    *uuid.UUID
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+// Insert creates a new widget at the end of its page.
+func (m *WidgetModel) Insert(ctx context.Context, widget *Widget) error {
+	return m.InsertAt(ctx, widget, Placement{})
+}
 
-	err := m.Db.QueryRowContext(ctx, posQuery, widget.PageId).Scan(&widget.Position)
-	if err != nil {
-		return err
-	}
+// InsertAt creates a new widget at the given place on its page, shifting the
+// widgets from there on down by one. The page row is locked while positions
+// are worked out, so concurrent writes to the same page queue up instead of
+// claiming the same position. An anchor widget that is not on the page is
+// reported as ErrForeignWidgets.
+func (m *WidgetModel) InsertAt(ctx context.Context, widget *Widget, at Placement) error {
 	// Marshal config to JSON
 	var configJSON []byte
+	var err error
+
 	if widget.Config != nil {
 		configJSON, err = json.Marshal(widget.Config)
 		if err != nil {
 			return err
 		}
 	}
-	query := `INSERT INTO widgets (id, page_id, type, position, config) VALUES ($1, $2, $3, $4, $5) 
-		    RETURNING created_at, updated_at`
+	// The type must be registered for the page's store; this replaces the old
+	// CHECK constraint on widgets.type.
+	query := `INSERT INTO widgets (id, page_id, type, position, config)
+		    SELECT $1::uuid, $2::uuid, $3::varchar, $4::int, $5::jsonb WHERE ` + registeredTypeClause + `
+		    RETURNING created_at, updated_at, version`
+
+	err = withTx(ctx, m.Db, func(tx dbtx) error {
+		if err := lockPage(ctx, tx, widget.PageId); err != nil {
+			return err
+		}
+		position, err := resolvePlacement(ctx, tx, widget.PageId, at, uuid.Nil)
+		if err != nil {
+			return err
+		}
+		// The unique position constraint is deferred, so shifting one row at
+		// a time is fine as long as the sequence is dense again at commit.
+		shiftQuery := `UPDATE widgets SET position = position + 1, version = version + 1
+			    WHERE page_id = $1 AND position >= $2 AND deleted_at IS NULL`
+
+		if _, err = tx.ExecContext(ctx, shiftQuery, widget.PageId, position); err != nil {
+			return err
+		}
+		widget.Position = position
+
+		args := []any{
+			widget.Id, widget.PageId,
+			widget.Type,
+			widget.Position, configJSON,
+		}
+		return tx.QueryRowContext(ctx, query, args...).Scan(&widget.CreatedAt, &widget.UpdatedAt, &widget.Version)
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUnknownWidgetType
+		}
+		return err
+	}
+	return nil
+}
 
-	args := []any{
-		widget.Id, widget.PageId,
-		widget.Type,
-		widget.Position, configJSON,
+// resolvePlacement returns the position a widget placed at lands on, between
+// 0 and the number of widgets on the page. The widget self is being moved and
+// counts neither towards the page nor as an anchor.
+func resolvePlacement(ctx context.Context, tx dbtx, pageId uuid.UUID, at Placement, self uuid.UUID) (int, error) {
+	var count int
+	err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM widgets WHERE page_id = $1 AND id <> $2 AND deleted_at IS NULL`,
+		pageId, self).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	anchor, offset := at.Before, 0
+	if at.After != nil {
+		anchor, offset = at.After, 1
+	}
+	switch {
+	case anchor != nil:
+		var position int
+		err := tx.QueryRowContext(ctx, `SELECT position FROM widgets WHERE id = $1 AND page_id = $2 AND id <> $3 AND deleted_at IS NULL`,
+			*anchor, pageId, self).Scan(&position)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return 0, ErrForeignWidgets
+			}
+			return 0, err
+		}
+		return position + offset, nil
+	case at.Position != nil:
+		return min(max(*at.Position, 0), count), nil
+	default:
+		return count, nil
 	}
-	err = m.Db.QueryRowContext(ctx, query, args...).Scan(&widget.CreatedAt, &widget.UpdatedAt)
-	return err
 }
 
 // Get returns a single widget by ID.
-func (m *WidgetModel) Get(id uuid.UUID) (*Widget, error) {
-	query := `SELECT id, page_id, type, position, config, created_at, updated_at FROM widgets 
-		    WHERE id = $1`
+func (m *WidgetModel) Get(ctx context.Context, id uuid.UUID) (*Widget, error) {
+	query := `SELECT id, page_id, type, position, config, created_at, updated_at, version FROM widgets
+		    WHERE id = $1 AND deleted_at IS NULL`
 	var widget Widget
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
 	var configJSON []byte
 
 	err := m.Db.QueryRowContext(ctx, query, id).Scan(
@@ -120,6 +194,7 @@ func (m *WidgetModel) Get(id uuid.UUID) (*Widget, error) {
 		&widget.Type, &widget.Position,
 		&configJSON,
 		&widget.CreatedAt, &widget.UpdatedAt,
+		&widget.Version,
 	)
 	if err != nil {
 		switch {
@@ -138,7 +213,7 @@ func (m *WidgetModel) Get(id uuid.UUID) (*Widget, error) {
 }
 
 // Update modifies an existing widget.
-func (m *WidgetModel) Update(widget *Widget) error {
+func (m *WidgetModel) Update(ctx context.Context, widget *Widget) error {
 	var configJSON []byte
 	var err error
 
@@ -148,19 +223,28 @@ func (m *WidgetModel) Update(widget *Widget) error {
 			return err
 		}
 	}
-	query := `UPDATE widgets SET type = $1, position = $2, config = $3, updated_at = NOW() 
-		    WHERE id = $4 RETURNING updated_at`
-
-	args := []any{widget.Type, widget.Position, configJSON, widget.Id}
+	query := `UPDATE widgets SET position = $1, type = $3, config = $4, updated_at = NOW(), version = version + 1
+		    WHERE id = $5 AND page_id = $2 AND version = $6 AND deleted_at IS NULL AND ` + registeredTypeClause + `
+		    RETURNING updated_at, version`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	args := []any{widget.Position, widget.PageId, widget.Type, configJSON, widget.Id, widget.Version}
 
-	err = m.Db.QueryRowContext(ctx, query, args...).Scan(&widget.UpdatedAt)
+	err = m.Db.QueryRowContext(ctx, query, args...).Scan(&widget.UpdatedAt, &widget.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return ErrRecordNotFound
+			// The widget is gone, was changed by someone else, or its new
+			// type is not registered.
+			current, err := m.Get(ctx, widget.Id)
+			switch {
+			case errors.Is(err, ErrRecordNotFound):
+				return ErrEditConflict
+			case err != nil:
+				return err
+			case current.Version != widget.Version || current.PageId != widget.PageId:
+				return ErrEditConflict
+			}
+			return ErrUnknownWidgetType
 		default:
 			return err
 		}
@@ -168,56 +252,224 @@ func (m *WidgetModel) Update(widget *Widget) error {
 	return nil
 }
 
-// Delete removes a widget.
-func (m *WidgetModel) Delete(id uuid.UUID) error {
-	query := `DELETE FROM widgets WHERE id = $1`
+// Delete moves a widget to the trash and closes the gap it leaves, so the
+// positions of the live widgets of the page stay 0..n-1. The widget keeps its
+// old position, which is where it goes back to when it is restored. version
+// must match the stored version, or ErrEditConflict is returned.
+func (m *WidgetModel) Delete(ctx context.Context, id uuid.UUID, version int) error {
+	return withTx(ctx, m.Db, func(tx dbtx) error {
+		var pageId uuid.UUID
+		err := tx.QueryRowContext(ctx, `SELECT page_id FROM widgets WHERE id = $1 AND deleted_at IS NULL`, id).Scan(&pageId)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrRecordNotFound
+			}
+			return err
+		}
+		if err = lockPage(ctx, tx, pageId); err != nil {
+			return err
+		}
+		var position int
+		query := `UPDATE widgets SET deleted_at = NOW() WHERE id = $1 AND version = $2 AND deleted_at IS NULL RETURNING position`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+		if err = tx.QueryRowContext(ctx, query, id, version).Scan(&position); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrEditConflict
+			}
+			return err
+		}
+		compactQuery := `UPDATE widgets SET position = position - 1, version = version + 1
+			    WHERE page_id = $1 AND position > $2 AND deleted_at IS NULL`
 
-	result, err := m.Db.ExecContext(ctx, query, id)
-	if err != nil {
+		_, err = tx.ExecContext(ctx, compactQuery, pageId, position)
 		return err
+	})
+}
+
+// Move takes the widget off its page and puts it at the given place on page
+// pageId, which may be the same page. Both pages are locked and re-packed, so
+// their positions stay 0..n-1. The target page must belong to the same store
+// as the widget, or ErrForeignPage is returned. widget.Version must match the
+// stored version, as in Update; on success widget holds the new page, position
+// and version.
+func (m *WidgetModel) Move(ctx context.Context, widget *Widget, pageId uuid.UUID, at Placement) error {
+	return withTx(ctx, m.Db, func(tx dbtx) error {
+		if err := lockPagesOfStore(ctx, tx, widget.PageId, pageId); err != nil {
+			return err
+		}
+		var from int
+		query := `SELECT position FROM widgets WHERE id = $1 AND page_id = $2 AND version = $3 AND deleted_at IS NULL
+			    FOR UPDATE`
+
+		err := tx.QueryRowContext(ctx, query, widget.Id, widget.PageId, widget.Version).Scan(&from)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrEditConflict
+			}
+			return err
+		}
+		// Close the gap on the old page first, so anchors on the same page
+		// are looked up at the positions they end up with.
+		compactQuery := `UPDATE widgets SET position = position - 1, version = version + 1
+			    WHERE page_id = $1 AND position > $2 AND deleted_at IS NULL`
+
+		if _, err = tx.ExecContext(ctx, compactQuery, widget.PageId, from); err != nil {
+			return err
+		}
+		position, err := resolvePlacement(ctx, tx, pageId, at, widget.Id)
+		if err != nil {
+			return err
+		}
+		shiftQuery := `UPDATE widgets SET position = position + 1, version = version + 1
+			    WHERE page_id = $1 AND position >= $2 AND id <> $3 AND deleted_at IS NULL`
+
+		if _, err = tx.ExecContext(ctx, shiftQuery, pageId, position, widget.Id); err != nil {
+			return err
+		}
+		moveQuery := `UPDATE widgets SET page_id = $1, position = $2, updated_at = NOW(), version = version + 1
+			    WHERE id = $3
+			    RETURNING updated_at, version`
+
+		err = tx.QueryRowContext(ctx, moveQuery, pageId, position, widget.Id).Scan(&widget.UpdatedAt, &widget.Version)
+		if err != nil {
+			return err
+		}
+		widget.PageId, widget.Position = pageId, position
+		return nil
+	})
+}
+
+// Ordering is a new order for the widgets of a page. WidgetIds lists widgets
+// in their new order; it must name every widget of the page unless Partial is
+// set, in which case the listed widgets swap places among themselves and the
+// others stay where they are. Moves are applied one after another, after
+// WidgetIds.
+type Ordering struct {
+	WidgetIds []uuid.UUID
+	Partial   bool
+	Moves     []Relocation
+}
+
+// Relocation moves one widget of the page to a new place. The anchor of a
+// Before or After placement must be another widget of the page.
+type Relocation struct {
+	WidgetId uuid.UUID
+	At       Placement
+}
+
+// apply returns the ids of the page, given in their current order, in the new
+// order.
+func (o Ordering) apply(ids []uuid.UUID) ([]uuid.UUID, error) {
+	onPage := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		onPage[id] = true
 	}
-	count, err := result.RowsAffected()
-	if err != nil {
-		return err
+	order := slices.Clone(ids)
+
+	if len(o.WidgetIds) > 0 {
+		listed := make(map[uuid.UUID]bool, len(o.WidgetIds))
+		for _, id := range o.WidgetIds {
+			switch {
+			case !onPage[id]:
+				return nil, ErrForeignWidgets
+			case listed[id]:
+				return nil, ErrDuplicateIds
+			}
+			listed[id] = true
+		}
+		if !o.Partial && len(listed) != len(ids) {
+			return nil, ErrPartialOrder
+		}
+		// The listed widgets fill the slots they held before, in their new
+		// order.
+		next := 0
+		for i, id := range order {
+			if listed[id] {
+				order[i] = o.WidgetIds[next]
+				next++
+			}
+		}
 	}
-	if count == 0 {
-		return ErrRecordNotFound
+	for _, move := range o.Moves {
+		from := slices.Index(order, move.WidgetId)
+		if from < 0 {
+			return nil, ErrForeignWidgets
+		}
+		order = slices.Delete(order, from, from+1)
+
+		to := len(order)
+		anchor, offset := move.At.Before, 0
+		if move.At.After != nil {
+			anchor, offset = move.At.After, 1
+		}
+		switch {
+		case anchor != nil:
+			i := slices.Index(order, *anchor)
+			if i < 0 {
+				return nil, ErrForeignWidgets
+			}
+			to = i + offset
+		case move.At.Position != nil:
+			to = min(max(*move.At.Position, 0), len(order))
+		}
+		order = slices.Insert(order, to, move.WidgetId)
 	}
-	return nil
+	return order, nil
 }
 
-// Reorder updates the positions of multiple widgets.
-func (m *WidgetModel) Reorder(pageID uuid.UUID, widgetIDs []uuid.UUID) error {
-	tx, err := m.Db.Begin()
-	if err != nil {
-		return err
-	}
-	defer func() { _ = tx.Rollback() }()
+// Reorder puts the widgets of the page in the order described by order and
+// returns them in that order. All positions are written in one statement, and
+// only widgets whose position changes get a new version.
+func (m *WidgetModel) Reorder(ctx context.Context, pageID uuid.UUID, order Ordering) ([]*Widget, error) {
+	var widgets []*Widget
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	err := withTx(ctx, m.Db, func(tx dbtx) error {
+		if err := lockPage(ctx, tx, pageID); err != nil {
+			return err
+		}
+		var ids []uuid.UUID
 
-	// Verify all widgets belong to this page
-	verifyQuery := `SELECT COUNT(*) FROM widgets WHERE page_id = $1 AND id = ANY($2)`
+		rows, err := tx.QueryContext(ctx, `SELECT id FROM widgets WHERE page_id = $1 AND deleted_at IS NULL ORDER BY position`, pageID)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id uuid.UUID
+			if err := rows.Scan(&id); err != nil {
+				_ = rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		if err = rows.Close(); err != nil {
+			return err
+		}
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		ids, err = order.apply(ids)
+		if err != nil {
+			return err
+		}
+		updateQuery := `UPDATE widgets w SET position = o.n - 1, version = w.version + 1
+			    FROM unnest($2::uuid[]) WITH ORDINALITY AS o(id, n)
+			    WHERE w.id = o.id AND w.page_id = $1 AND w.deleted_at IS NULL AND w.position <> o.n - 1`
 
-	var count int
-	err = tx.QueryRowContext(ctx, verifyQuery, pageID, pq.Array(widgetIDs)).Scan(&count)
-	if err != nil {
-		return err
-	}
-	if count != len(widgetIDs) {
-		return errors.New("some widgets do not belong to this page")
-	}
-	updateQuery := `UPDATE widgets SET position = $1 WHERE id = $2 AND page_id = $3`
+		if _, err = tx.ExecContext(ctx, updateQuery, pageID, pq.Array(ids)); err != nil {
+			return err
+		}
+		query := `SELECT id, page_id, type, position, config, created_at, updated_at, version FROM widgets
+			    WHERE page_id = $1 AND deleted_at IS NULL ORDER BY position`
 
-	for i, widgetID := range widgetIDs {
-		_, err = tx.ExecContext(ctx, updateQuery, i, widgetID, pageID)
+		rows, err = tx.QueryContext(ctx, query, pageID)
 		if err != nil {
 			return err
 		}
+		widgets, err = scanWidgets(rows)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-	return tx.Commit()
+	return widgets, nil
 }
@@ -0,0 +1,50 @@
+package data
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestOrderingApply(t *testing.T) {
+	a, b, c, d := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	page := []uuid.UUID{a, b, c, d}
+	at := func(position int) *int { return &position }
+
+	tests := []struct {
+		name    string
+		order   Ordering
+		want    []uuid.UUID
+		wantErr error
+	}{
+		{"full permutation", Ordering{WidgetIds: []uuid.UUID{d, c, b, a}}, []uuid.UUID{d, c, b, a}, nil},
+		{"strict rejects a partial list", Ordering{WidgetIds: []uuid.UUID{c, a}}, nil, ErrPartialOrder},
+		{"duplicate ids", Ordering{WidgetIds: []uuid.UUID{a, a, b, c}}, nil, ErrDuplicateIds},
+		{"foreign id", Ordering{WidgetIds: []uuid.UUID{a, b, c, uuid.New()}}, nil, ErrForeignWidgets},
+		{"partial swaps the listed widgets", Ordering{WidgetIds: []uuid.UUID{d, a}, Partial: true}, []uuid.UUID{d, b, c, a}, nil},
+		{"partial keeps the others in place", Ordering{WidgetIds: []uuid.UUID{c, b}, Partial: true}, []uuid.UUID{a, c, b, d}, nil},
+		{"move before", Ordering{Moves: []Relocation{{WidgetId: d, At: Placement{Before: &b}}}}, []uuid.UUID{a, d, b, c}, nil},
+		{"move after", Ordering{Moves: []Relocation{{WidgetId: a, At: Placement{After: &c}}}}, []uuid.UUID{b, c, a, d}, nil},
+		{"move to index", Ordering{Moves: []Relocation{{WidgetId: c, At: Placement{Position: at(0)}}}}, []uuid.UUID{c, a, b, d}, nil},
+		{"move past the end", Ordering{Moves: []Relocation{{WidgetId: a, At: Placement{Position: at(9)}}}}, []uuid.UUID{b, c, d, a}, nil},
+		{"moves in sequence", Ordering{Moves: []Relocation{
+			{WidgetId: d, At: Placement{Position: at(0)}},
+			{WidgetId: a, At: Placement{Before: &d}},
+		}}, []uuid.UUID{a, d, b, c}, nil},
+		{"anchor is the widget", Ordering{Moves: []Relocation{{WidgetId: a, At: Placement{Before: &a}}}}, nil, ErrForeignWidgets},
+		{"unknown widget", Ordering{Moves: []Relocation{{WidgetId: uuid.New(), At: Placement{After: &a}}}}, nil, ErrForeignWidgets},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.order.apply(page)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
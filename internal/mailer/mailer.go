@@ -0,0 +1,134 @@
+// Package mailer sends the emails of the API, such as store invitations.
+// SMTP delivers them; Log only logs them, for local development.
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// SMTP delivers messages through an SMTP server, upgrading the connection
+// with STARTTLS when the server offers it.
+type SMTP struct {
+	host     string
+	port     int
+	username string
+	password string
+	sender   string
+}
+
+// NewSMTP returns a mailer that sends as sender, an address such as
+// "AppDrop <no-reply@example.com>", through the server at host and port. With
+// a username, it authenticates with PLAIN, which needs TLS.
+func NewSMTP(host string, port int, username, password, sender string) *SMTP {
+	return &SMTP{host: host, port: port, username: username, password: password, sender: sender}
+}
+
+func (m *SMTP) Send(ctx context.Context, msg Message) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(m.host, strconv.Itoa(m.port)))
+	if err != nil {
+		return err
+	}
+	// The whole exchange shares the deadline of ctx, or a default one.
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(30 * time.Second)
+	}
+	if err = conn.SetDeadline(deadline); err != nil {
+		_ = conn.Close()
+		return err
+	}
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer func() { _ = c.Close() }()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err = c.StartTLS(&tls.Config{ServerName: m.host}); err != nil {
+			return err
+		}
+	}
+	if m.username != "" {
+		if err = c.Auth(smtp.PlainAuth("", m.username, m.password, m.host)); err != nil {
+			return err
+		}
+	}
+	from, err := mail.ParseAddress(m.sender)
+	if err != nil {
+		return fmt.Errorf("sender: %w", err)
+	}
+	if err = c.Mail(from.Address); err != nil {
+		return err
+	}
+	if err = c.Rcpt(msg.To); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(m.format(msg)); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// format returns msg as the headers and body of an email.
+func (m *SMTP) format(msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", headerValue(m.sender))
+	fmt.Fprintf(&b, "To: %s\r\n", headerValue(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", headerValue(msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// headerValue returns v without line breaks, which would start another header.
+func headerValue(v string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(v)
+}
+
+// Log logs messages instead of sending them, body included, which is meant
+// for local development only.
+type Log struct {
+	logger *slog.Logger
+}
+
+// NewLog returns a mailer that logs messages to logger.
+func NewLog(logger *slog.Logger) *Log {
+	return &Log{logger: logger}
+}
+
+func (m *Log) Send(_ context.Context, msg Message) error {
+	m.logger.Info("email not sent, logged instead", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+	return nil
+}
@@ -0,0 +1,30 @@
+package mailer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	m := NewSMTP("localhost", 25, "", "", "AppDrop <no-reply@example.com>")
+	raw := string(m.format(Message{
+		To:      "ada@example.com",
+		Subject: "Hello\r\nBcc: eve@example.com",
+		Body:    "line one\nline two\n",
+	}))
+	headers, body, ok := strings.Cut(raw, "\r\n\r\n")
+	if !ok {
+		t.Fatalf("expected headers and a body, got %q", raw)
+	}
+	for _, want := range []string{"From: AppDrop <no-reply@example.com>", "To: ada@example.com", "Subject: Hello  Bcc: eve@example.com"} {
+		if !strings.Contains(headers, want+"\r\n") {
+			t.Errorf("expected header %q in %q", want, headers)
+		}
+	}
+	if strings.Contains(headers, "\r\nBcc:") {
+		t.Error("expected the subject not to start another header")
+	}
+	if body != "line one\r\nline two\r\n" {
+		t.Errorf("expected CRLF line endings, got %q", body)
+	}
+}
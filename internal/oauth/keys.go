@@ -0,0 +1,190 @@
+package oauth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// The signing algorithms of a key set.
+const (
+	AlgHS256 = "HS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// ErrInvalidToken is returned for an access token that is malformed, signed
+// by an unknown key, issued by someone else or expired.
+var ErrInvalidToken = errors.New("invalid access token")
+
+// key is a signing key, known by its id. An HS256 key is a secret shared with
+// whoever verifies tokens; an EdDSA key is a private key whose public half is
+// published.
+type key struct {
+	id      string
+	secret  []byte
+	private ed25519.PrivateKey
+}
+
+// KeySet signs access tokens with its first key and verifies them with any of
+// its keys, which the kid header of a token names. Rotating keys is putting a
+// new one first and keeping the old ones until the tokens they signed expire.
+type KeySet struct {
+	alg    string
+	issuer string
+	keys   []key
+}
+
+// ParseKeySet returns the key set of the algorithm alg, HS256 or EdDSA, for
+// the tokens of issuer. spec lists the keys, first the one that signs, as
+// comma separated kid:key pairs where key is base64: a secret of at least 32
+// bytes for HS256, or the 32 byte seed of a private key for EdDSA.
+func ParseKeySet(alg, issuer, spec string) (*KeySet, error) {
+	s := &KeySet{alg: alg, issuer: issuer}
+	for pair := range strings.SplitSeq(spec, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("key %q must be a kid:key pair", pair)
+		}
+		for _, k := range s.keys {
+			if k.id == id {
+				return nil, fmt.Errorf("key id %q is used twice", id)
+			}
+		}
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		k, err := newKey(alg, id, raw)
+		if err != nil {
+			return nil, err
+		}
+		s.keys = append(s.keys, k)
+	}
+	return s, nil
+}
+
+// GenerateKeySet returns a key set with a single random key, which is only
+// good for as long as the process that generated it runs.
+func GenerateKeySet(alg, issuer string) (*KeySet, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	k, err := newKey(alg, uuid.NewString(), raw)
+	if err != nil {
+		return nil, err
+	}
+	return &KeySet{alg: alg, issuer: issuer, keys: []key{k}}, nil
+}
+
+func newKey(alg, id string, raw []byte) (key, error) {
+	switch alg {
+	case AlgHS256:
+		if len(raw) < 32 {
+			return key{}, fmt.Errorf("key %q must be at least 32 bytes for HS256", id)
+		}
+		return key{id: id, secret: raw}, nil
+	case AlgEdDSA:
+		if len(raw) != ed25519.SeedSize {
+			return key{}, fmt.Errorf("key %q must be a %d byte seed for EdDSA", id, ed25519.SeedSize)
+		}
+		return key{id: id, private: ed25519.NewKeyFromSeed(raw)}, nil
+	}
+	return key{}, fmt.Errorf("unsupported signing algorithm %q, must be HS256 or EdDSA", alg)
+}
+
+// Issue returns an access token for the client clientId, granted scope and
+// the stores storeIds, that expires after ttl.
+func (s *KeySet) Issue(clientId, scope string, storeIds []uuid.UUID, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Issuer:    s.issuer,
+			Subject:   clientId,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+		Scope:    scope,
+		StoreIds: storeIds,
+	}
+	signer := s.keys[0]
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(s.alg), claims)
+	token.Header["kid"] = signer.id
+
+	if s.alg == AlgEdDSA {
+		return token.SignedString(signer.private)
+	}
+	return token.SignedString(signer.secret)
+}
+
+// Verify returns the claims of the access token, or ErrInvalidToken.
+func (s *KeySet) Verify(token string) (*Claims, error) {
+	var claims Claims
+	_, err := jwt.ParseWithClaims(token, &claims, s.verificationKey,
+		jwt.WithValidMethods([]string{s.alg}),
+		jwt.WithIssuer(s.issuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	return &claims, nil
+}
+
+// verificationKey returns the key named by the kid header of token.
+func (s *KeySet) verificationKey(token *jwt.Token) (any, error) {
+	id, _ := token.Header["kid"].(string)
+	for _, k := range s.keys {
+		if k.id != id {
+			continue
+		}
+		if s.alg == AlgEdDSA {
+			return k.private.Public(), nil
+		}
+		return k.secret, nil
+	}
+	return nil, fmt.Errorf("unknown key id %q", id)
+}
+
+// JWK is a public key in the JSON Web Key format.
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+}
+
+// JWKS returns the public keys of the set, which verify the tokens it signs.
+// An HS256 set has none: its secrets must not be published.
+func (s *KeySet) JWKS() []JWK {
+	keys := []JWK{}
+	if s.alg != AlgEdDSA {
+		return keys
+	}
+	for _, k := range s.keys {
+		keys = append(keys, JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(k.private.Public().(ed25519.PublicKey)),
+			Kid: k.id,
+			Alg: AlgEdDSA,
+			Use: "sig",
+		})
+	}
+	return keys
+}
+
+// IsToken reports whether plaintext is shaped like a JSON Web Token.
+func IsToken(plaintext string) bool {
+	return strings.Count(plaintext, ".") == 2
+}
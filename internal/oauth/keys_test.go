@@ -0,0 +1,129 @@
+package oauth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// spec returns a key spec of random 32 byte keys named by ids.
+func spec(t *testing.T, ids ...string) string {
+	t.Helper()
+
+	var s string
+	for i, id := range ids {
+		raw := make([]byte, 32)
+		if _, err := rand.Read(raw); err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 {
+			s += ","
+		}
+		s += id + ":" + base64.StdEncoding.EncodeToString(raw)
+	}
+	return s
+}
+
+func TestIssueVerify(t *testing.T) {
+	storeId := uuid.New()
+
+	for _, alg := range []string{AlgHS256, AlgEdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			keys, err := ParseKeySet(alg, "appdrop", spec(t, "2024"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			token, err := keys.Issue("adc_ci", "pages:write stores:read", []uuid.UUID{storeId}, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			claims, err := keys.Verify(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.Subject != "adc_ci" || !claims.HasScope(ScopePagesWrite) || claims.HasScope(ScopePagesRead) {
+				t.Fatalf("unexpected claims %+v", claims)
+			}
+			if !claims.CanAccess(storeId) || claims.CanAccess(uuid.New()) {
+				t.Fatalf("expected the token to only access its store, got %v", claims.StoreIds)
+			}
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	old := spec(t, "old")
+	oldKeys, err := ParseKeySet(AlgEdDSA, "appdrop", old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := oldKeys.Issue("adc_ci", ScopeStoresRead, nil, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := ParseKeySet(AlgEdDSA, "appdrop", spec(t, "new")+","+old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rotated.Verify(token); err != nil {
+		t.Fatalf("expected a token of the old key to verify after rotation: %v", err)
+	}
+	if jwks := rotated.JWKS(); len(jwks) != 2 || jwks[0].Kid != "new" || jwks[1].Kid != "old" {
+		t.Fatalf("expected both keys to be published, new first, got %+v", jwks)
+	}
+	retired, err := ParseKeySet(AlgEdDSA, "appdrop", spec(t, "new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = retired.Verify(token); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected a token of a retired key to be invalid, got %v", err)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	keys, err := ParseKeySet(AlgHS256, "appdrop", spec(t, "k"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := keys.Issue("adc_ci", ScopeStoresRead, nil, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ParseKeySet(AlgHS256, "elsewhere", spec(t, "k"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign, err := other.Issue("adc_ci", ScopeStoresRead, nil, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, token := range map[string]string{"expired": expired, "foreign": foreign, "malformed": "a.b.c"} {
+		if _, err = keys.Verify(token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: expected ErrInvalidToken, got %v", name, err)
+		}
+	}
+	if jwks := keys.JWKS(); len(jwks) != 0 {
+		t.Fatalf("expected HS256 secrets not to be published, got %+v", jwks)
+	}
+}
+
+func TestParseKeySet(t *testing.T) {
+	short := "k:" + base64.StdEncoding.EncodeToString(make([]byte, 16))
+	for name, s := range map[string]string{
+		"short":     short,
+		"no kid":    "bm90IGEga2V5",
+		"duplicate": spec(t, "k", "k"),
+		"base64":    "k:not base64!",
+	} {
+		if _, err := ParseKeySet(AlgHS256, "appdrop", s); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if _, err := ParseKeySet("RS256", "appdrop", spec(t, "k")); err == nil {
+		t.Error("expected an unsupported algorithm to be rejected")
+	}
+}
@@ -0,0 +1,69 @@
+// Package oauth issues and verifies the access tokens of machine clients: JSON
+// Web Tokens signed with HS256 or EdDSA, which carry the scopes the client was
+// granted and the stores it can access.
+package oauth
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// The scopes a client can be granted. Each route of the API needs one of
+// them; the read scope of a resource does not come with its write scope, nor
+// the other way around.
+const (
+	ScopeStoresRead   = "stores:read"
+	ScopeStoresWrite  = "stores:write"
+	ScopePagesRead    = "pages:read"
+	ScopePagesWrite   = "pages:write"
+	ScopeWidgetsRead  = "widgets:read"
+	ScopeWidgetsWrite = "widgets:write"
+	ScopeMembersRead  = "members:read"
+	ScopeMembersWrite = "members:write"
+)
+
+// Scopes lists the scopes.
+var Scopes = []string{
+	ScopeStoresRead, ScopeStoresWrite,
+	ScopePagesRead, ScopePagesWrite,
+	ScopeWidgetsRead, ScopeWidgetsWrite,
+	ScopeMembersRead, ScopeMembersWrite,
+}
+
+// Claims are what an access token says about the client it was issued to.
+// The subject is the client id and Scope is space separated, as in the token
+// request. A token with no StoreIds can access every store.
+type Claims struct {
+	jwt.RegisteredClaims
+	Scope    string      `json:"scope"`
+	StoreIds []uuid.UUID `json:"store_ids,omitempty"`
+}
+
+// HasScope reports whether the token was granted scope.
+func (c *Claims) HasScope(scope string) bool {
+	return slices.Contains(strings.Fields(c.Scope), scope)
+}
+
+// HasScopes reports whether the token was granted every one of scopes, of
+// which there must be at least one.
+func (c *Claims) HasScopes(scopes []string) bool {
+	for _, scope := range scopes {
+		if !c.HasScope(scope) {
+			return false
+		}
+	}
+	return len(scopes) > 0
+}
+
+// Unrestricted reports whether the token can access every store.
+func (c *Claims) Unrestricted() bool {
+	return len(c.StoreIds) == 0
+}
+
+// CanAccess reports whether the token can access the store storeId.
+func (c *Claims) CanAccess(storeId uuid.UUID) bool {
+	return c.Unrestricted() || slices.Contains(c.StoreIds, storeId)
+}
@@ -0,0 +1,207 @@
+// Package routing parses the route patterns of pages and matches app paths
+// against them.
+//
+// A pattern is a sequence of slash separated segments. A segment is either
+// static text, a named parameter such as ":id" that matches exactly one path
+// segment, or a trailing wildcard such as "*rest" that matches one or more.
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MaxLength is the longest normalized pattern that fits the pages.route column.
+const MaxLength = 255
+
+// SegmentKind is the kind of a pattern segment. Kinds are ordered by
+// precedence: a static segment wins over a parameter, which wins over a
+// wildcard.
+type SegmentKind int
+
+const (
+	Static SegmentKind = iota
+	Param
+	Wildcard
+)
+
+// Segment is a single part of a pattern. Value holds the text of a static
+// segment or the name of a parameter or wildcard.
+type Segment struct {
+	Kind  SegmentKind
+	Value string
+}
+
+// Pattern is a parsed, normalized route pattern.
+type Pattern struct {
+	Segments []Segment
+}
+
+var (
+	staticRX = regexp.MustCompile(`^[A-Za-z0-9._~-]+$`)
+	nameRX   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// Parse normalizes raw and parses it into a Pattern. Surrounding whitespace is
+// trimmed, a missing leading slash is added and repeated or trailing slashes
+// are dropped, so "home/" and "/home" are the same route. Whitespace, query
+// strings and fragments are rejected.
+func Parse(raw string) (Pattern, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return Pattern{}, errors.New("must not be empty")
+	}
+	if strings.ContainsAny(raw, " \t\r\n") {
+		return Pattern{}, errors.New("must not contain whitespace")
+	}
+	if strings.ContainsAny(raw, "?#") {
+		return Pattern{}, errors.New("must not contain a query string or fragment")
+	}
+	var p Pattern
+	seen := make(map[string]bool)
+	parts := Split(raw)
+
+	for i, part := range parts {
+		var seg Segment
+		switch {
+		case strings.HasPrefix(part, ":"):
+			seg = Segment{Kind: Param, Value: part[1:]}
+			if !nameRX.MatchString(seg.Value) {
+				return Pattern{}, fmt.Errorf("parameter %q must be a name such as :id", part)
+			}
+		case strings.HasPrefix(part, "*"):
+			seg = Segment{Kind: Wildcard, Value: part[1:]}
+			if seg.Value == "" {
+				seg.Value = "rest"
+			}
+			if !nameRX.MatchString(seg.Value) {
+				return Pattern{}, fmt.Errorf("wildcard %q must be * or a name such as *rest", part)
+			}
+			if i != len(parts)-1 {
+				return Pattern{}, errors.New("a wildcard is only allowed as the last segment")
+			}
+		default:
+			seg = Segment{Kind: Static, Value: part}
+			if !staticRX.MatchString(part) {
+				return Pattern{}, fmt.Errorf("segment %q may only contain letters, digits and - . _ ~", part)
+			}
+		}
+		if seg.Kind != Static {
+			if seen[seg.Value] {
+				return Pattern{}, fmt.Errorf("parameter name %q is used twice", seg.Value)
+			}
+			seen[seg.Value] = true
+		}
+		p.Segments = append(p.Segments, seg)
+	}
+	if len(p.String()) > MaxLength {
+		return Pattern{}, fmt.Errorf("must not be more than %d characters long", MaxLength)
+	}
+	return p, nil
+}
+
+// Split returns the segments of a path, ignoring leading, trailing and
+// repeated slashes. The root path has no segments.
+func Split(path string) []string {
+	return strings.FieldsFunc(path, func(r rune) bool { return r == '/' })
+}
+
+// String returns the normalized form of the pattern.
+func (p Pattern) String() string {
+	if len(p.Segments) == 0 {
+		return "/"
+	}
+	var sb strings.Builder
+	for _, seg := range p.Segments {
+		sb.WriteByte('/')
+		switch seg.Kind {
+		case Param:
+			sb.WriteByte(':')
+		case Wildcard:
+			sb.WriteByte('*')
+		}
+		sb.WriteString(seg.Value)
+	}
+	return sb.String()
+}
+
+// shape is the pattern with parameter and wildcard names erased. Two patterns
+// of the same shape match exactly the same paths with the same precedence.
+func (p Pattern) shape() string {
+	var sb strings.Builder
+	for _, seg := range p.Segments {
+		sb.WriteByte('/')
+		switch seg.Kind {
+		case Static:
+			sb.WriteString(seg.Value)
+		case Param:
+			sb.WriteByte(':')
+		case Wildcard:
+			sb.WriteByte('*')
+		}
+	}
+	return sb.String()
+}
+
+// Conflicts reports whether p and q are ambiguous: some path would match both
+// and neither takes precedence, such as "/product/:id" and "/product/:sku".
+func (p Pattern) Conflicts(q Pattern) bool {
+	return p.shape() == q.shape()
+}
+
+// HasPrefix reports whether the leading static segments of p are prefix, so
+// that "/api/orders" has the prefix "/api" but "/apis" does not.
+func (p Pattern) HasPrefix(prefix string) bool {
+	parts := Split(prefix)
+	if len(parts) == 0 || len(parts) > len(p.Segments) {
+		return false
+	}
+	for i, part := range parts {
+		if p.Segments[i].Kind != Static || p.Segments[i].Value != part {
+			return false
+		}
+	}
+	return true
+}
+
+// Match reports whether path matches p and returns the values of its
+// parameters. A wildcard captures the remaining segments joined by slashes.
+func (p Pattern) Match(path string) (map[string]string, bool) {
+	parts := Split(path)
+	params := make(map[string]string)
+
+	for i, seg := range p.Segments {
+		if i >= len(parts) {
+			return nil, false
+		}
+		switch seg.Kind {
+		case Static:
+			if parts[i] != seg.Value {
+				return nil, false
+			}
+		case Param:
+			params[seg.Value] = parts[i]
+		case Wildcard:
+			params[seg.Value] = strings.Join(parts[i:], "/")
+			return params, true
+		}
+	}
+	if len(parts) != len(p.Segments) {
+		return nil, false
+	}
+	return params, true
+}
+
+// MoreSpecific reports whether p should win over q when both match the same
+// path. At the first segment where their kinds differ, the kind with the
+// higher precedence wins.
+func (p Pattern) MoreSpecific(q Pattern) bool {
+	for i := 0; i < min(len(p.Segments), len(q.Segments)); i++ {
+		if p.Segments[i].Kind != q.Segments[i].Kind {
+			return p.Segments[i].Kind < q.Segments[i].Kind
+		}
+	}
+	return false
+}
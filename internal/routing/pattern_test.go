@@ -0,0 +1,126 @@
+package routing
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+		err  bool
+	}{
+		{raw: "/", want: "/"},
+		{raw: "home/", want: "/home"},
+		{raw: " //collections//summer/ ", want: "/collections/summer"},
+		{raw: "/product/:id", want: "/product/:id"},
+		{raw: "/docs/*", want: "/docs/*rest"},
+		{raw: "/docs/*path", want: "/docs/*path"},
+		{raw: "", err: true},
+		{raw: "/new arrivals", err: true},
+		{raw: "/search?q=shoes", err: true},
+		{raw: "/product/:", err: true},
+		{raw: "/product/:1d", err: true},
+		{raw: "/a/:id/b/:id", err: true},
+		{raw: "/docs/*/edit", err: true},
+		{raw: "/sale%20now", err: true},
+	}
+	for _, tt := range tests {
+		p, err := Parse(tt.raw)
+		if tt.err {
+			if err == nil {
+				t.Errorf("Parse(%q): expected an error, got %q", tt.raw, p)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q): unexpected error %v", tt.raw, err)
+			continue
+		}
+		if got := p.String(); got != tt.want {
+			t.Errorf("Parse(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestPattern_Conflicts(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		conflict bool
+	}{
+		{"/product/:id", "/product/:sku", true},
+		{"/home", "home/", true},
+		{"/docs/*", "/docs/*path", true},
+		{"/product/:id", "/product/new", false},
+		{"/product/:id", "/product/:id/reviews", false},
+		{"/docs/:page", "/docs/*", false},
+	}
+	for _, tt := range tests {
+		a, b := mustParse(t, tt.a), mustParse(t, tt.b)
+		if got := a.Conflicts(b); got != tt.conflict {
+			t.Errorf("%q.Conflicts(%q) = %v, want %v", tt.a, tt.b, got, tt.conflict)
+		}
+	}
+}
+
+func TestPattern_Match(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		params        map[string]string
+		ok            bool
+	}{
+		{"/", "/", map[string]string{}, true},
+		{"/home", "/home/", map[string]string{}, true},
+		{"/product/:id", "/product/123", map[string]string{"id": "123"}, true},
+		{"/product/:id", "/product", nil, false},
+		{"/product/:id", "/product/123/reviews", nil, false},
+		{"/c/:slug/p/:id", "/c/shoes/p/9", map[string]string{"slug": "shoes", "id": "9"}, true},
+		{"/docs/*path", "/docs/a/b", map[string]string{"path": "a/b"}, true},
+		{"/docs/*path", "/docs", nil, false},
+		{"/sale", "/home", nil, false},
+	}
+	for _, tt := range tests {
+		params, ok := mustParse(t, tt.pattern).Match(tt.path)
+		if ok != tt.ok || !maps.Equal(params, tt.params) {
+			t.Errorf("%q.Match(%q) = %v, %v; want %v, %v", tt.pattern, tt.path, params, ok, tt.params, tt.ok)
+		}
+	}
+}
+
+func TestPattern_MoreSpecific(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"/product/new", "/product/:id", true},
+		{"/product/:id", "/product/new", false},
+		{"/product/:id", "/product/:sku", false},
+		{"/docs/:page", "/docs/*", true},
+	}
+	for _, tt := range tests {
+		if got := mustParse(t, tt.a).MoreSpecific(mustParse(t, tt.b)); got != tt.want {
+			t.Errorf("%q.MoreSpecific(%q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestPattern_HasPrefix(t *testing.T) {
+	p := mustParse(t, "/api/orders/:id")
+	if !p.HasPrefix("/api") || !p.HasPrefix("api/orders/") {
+		t.Errorf("expected %q to have the prefixes /api and /api/orders", p)
+	}
+	if p.HasPrefix("/ap") || p.HasPrefix("/api/orders/:id/items") {
+		t.Errorf("expected prefixes to match whole segments only")
+	}
+}
+
+func mustParse(t *testing.T, raw string) Pattern {
+	t.Helper()
+
+	p, err := Parse(raw)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", raw, err)
+	}
+	return p
+}
@@ -0,0 +1,52 @@
+package validator
+
+import (
+	"regexp"
+	"slices"
+)
+
+// HexColorRX matches #rgb, #rrggbb and #rrggbbaa colour codes.
+var HexColorRX = regexp.MustCompile(`^#(?:[0-9a-fA-F]{3}|[0-9a-fA-F]{6}|[0-9a-fA-F]{8})$`)
+
+// EmailRX matches email addresses, loosely: something, an @, and a domain.
+var EmailRX = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+// Validator collects field level validation errors keyed by field name.
+type Validator struct {
+	Errors map[string]string
+}
+
+// New returns a Validator with an empty error map.
+func New() *Validator {
+	return &Validator{Errors: make(map[string]string)}
+}
+
+// Valid reports whether no errors have been recorded.
+func (v *Validator) Valid() bool {
+	return len(v.Errors) == 0
+}
+
+// AddError records an error for key unless one is already present, so the
+// first failing check for a field is the one reported.
+func (v *Validator) AddError(key, message string) {
+	if _, exists := v.Errors[key]; !exists {
+		v.Errors[key] = message
+	}
+}
+
+// Check adds the error message for key if ok is false.
+func (v *Validator) Check(ok bool, key, message string) {
+	if !ok {
+		v.AddError(key, message)
+	}
+}
+
+// PermittedValue reports whether value is one of permittedValues.
+func PermittedValue[T comparable](value T, permittedValues ...T) bool {
+	return slices.Contains(permittedValues, value)
+}
+
+// Matches reports whether value matches the regular expression.
+func Matches(value string, rx *regexp.Regexp) bool {
+	return rx.MatchString(value)
+}
@@ -0,0 +1,6 @@
+-- widgets of store-defined types would fail the check; NOT VALID keeps them
+-- while still holding new and updated widgets to the built-in types
+ALTER TABLE widgets
+    ADD CONSTRAINT valid_widget_type CHECK ( type IN ('banner', 'product_grid', 'text', 'image', 'spacer')) NOT VALID;
+
+DROP TABLE IF EXISTS widget_types;
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS widget_types
+(
+    id              UUID PRIMARY KEY,
+    -- NULL store_id marks a built-in type available to every store; built-ins are seeded by the api on startup
+    store_id        UUID REFERENCES stores (id) ON DELETE CASCADE,
+    name            VARCHAR(50)                 NOT NULL,
+    label           VARCHAR(255)                NOT NULL,
+    schema          JSONB                       NOT NULL DEFAULT '{"fields": {}}',
+    min_app_version VARCHAR(32)                 NOT NULL DEFAULT '',
+    max_app_version VARCHAR(32)                 NOT NULL DEFAULT '',
+    created_at      TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at      TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX idx_widget_types_builtin_name ON widget_types (name) WHERE store_id IS NULL;
+
+CREATE UNIQUE INDEX idx_widget_types_store_name ON widget_types (store_id, name) WHERE store_id IS NOT NULL;
+
+-- widget types are now validated against the registry instead
+ALTER TABLE widgets
+    DROP CONSTRAINT IF EXISTS valid_widget_type;
@@ -0,0 +1,15 @@
+-- Every publish appends an immutable snapshot of the page and its ordered widgets; the highest revision is live.
+CREATE TABLE IF NOT EXISTS page_publications
+(
+    page_id      UUID                        NOT NULL REFERENCES pages (id) ON DELETE CASCADE,
+    revision     INTEGER                     NOT NULL,
+    store_id     UUID                        NOT NULL REFERENCES stores (id) ON DELETE CASCADE,
+    name         VARCHAR(255)                NOT NULL,
+    route        VARCHAR(255)                NOT NULL,
+    is_home      BOOLEAN                     NOT NULL,
+    widgets      JSONB                       NOT NULL DEFAULT '[]',
+    published_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (page_id, revision)
+);
+
+CREATE INDEX idx_page_publications_store_id ON page_publications (store_id);
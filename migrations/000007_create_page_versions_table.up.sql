@@ -0,0 +1,12 @@
+-- A numbered snapshot of the page and its widgets is recorded after every save.
+CREATE TABLE IF NOT EXISTS page_versions
+(
+    page_id    UUID                        NOT NULL REFERENCES pages (id) ON DELETE CASCADE,
+    version    INTEGER                     NOT NULL,
+    name       VARCHAR(255)                NOT NULL,
+    route      VARCHAR(255)                NOT NULL,
+    is_home    BOOLEAN                     NOT NULL,
+    widgets    JSONB                       NOT NULL DEFAULT '[]',
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (page_id, version)
+);
@@ -0,0 +1,3 @@
+ALTER TABLE widgets DROP COLUMN IF EXISTS version;
+ALTER TABLE pages DROP COLUMN IF EXISTS version;
+ALTER TABLE stores DROP COLUMN IF EXISTS version;
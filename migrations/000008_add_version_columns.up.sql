@@ -0,0 +1,4 @@
+-- Every row carries a version that is bumped on update, for optimistic locking.
+ALTER TABLE stores ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE pages ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE widgets ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,2 @@
+-- The original gaps are not recorded, so there is nothing to undo.
+SELECT 1;
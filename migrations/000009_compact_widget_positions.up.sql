@@ -0,0 +1,7 @@
+-- Widget positions are kept dense (0..n-1 per page) from now on; close the gaps left by earlier deletes.
+UPDATE widgets w
+SET position = ranked.rn - 1
+FROM (SELECT id, ROW_NUMBER() OVER (PARTITION BY page_id ORDER BY position, created_at) AS rn
+      FROM widgets) AS ranked
+WHERE w.id = ranked.id
+  AND w.position <> ranked.rn - 1;
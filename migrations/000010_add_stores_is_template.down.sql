@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_stores_is_template;
+
+ALTER TABLE stores DROP COLUMN IF EXISTS is_template;
@@ -0,0 +1,4 @@
+-- Template stores are offered as starting points for new stores.
+ALTER TABLE stores ADD COLUMN IF NOT EXISTS is_template BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_stores_is_template ON stores (is_template) WHERE is_template;
@@ -0,0 +1,25 @@
+-- The trash is emptied first: the old constraints do not allow its rows next to the live ones.
+DELETE FROM widgets WHERE deleted_at IS NOT NULL;
+DELETE FROM pages WHERE deleted_at IS NOT NULL;
+DELETE FROM stores WHERE deleted_at IS NOT NULL;
+
+DROP INDEX IF EXISTS idx_widgets_deleted_at;
+DROP INDEX IF EXISTS idx_pages_deleted_at;
+DROP INDEX IF EXISTS idx_stores_deleted_at;
+
+ALTER TABLE widgets DROP CONSTRAINT IF EXISTS unique_widgets_pos_per_page;
+ALTER TABLE widgets
+    ADD CONSTRAINT unique_widgets_pos_per_page UNIQUE (page_id, position) DEFERRABLE INITIALLY DEFERRED;
+
+DROP INDEX IF EXISTS idx_pages_is_home_per_app;
+CREATE UNIQUE INDEX idx_pages_is_home_per_app ON pages (store_id) WHERE is_home = TRUE;
+
+DROP INDEX IF EXISTS idx_pages_store_route;
+ALTER TABLE pages ADD CONSTRAINT pages_store_id_route_key UNIQUE (store_id, route);
+
+DROP INDEX IF EXISTS idx_stores_slug;
+ALTER TABLE stores ADD CONSTRAINT stores_slug_key UNIQUE (slug);
+
+ALTER TABLE widgets DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE pages DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE stores DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,26 @@
+-- Deleting a store, page or widget moves it to the trash by setting deleted_at; a background job purges it later.
+-- Rows deleted together (a page and its widgets, a store and its pages) share the same deleted_at, which is how
+-- restoring one brings the others back.
+ALTER TABLE stores ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE pages ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE widgets ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+
+-- Uniqueness only holds among rows that are not in the trash, so a slug or route can be reused after a delete.
+ALTER TABLE stores DROP CONSTRAINT IF EXISTS stores_slug_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_stores_slug ON stores (slug) WHERE deleted_at IS NULL;
+
+ALTER TABLE pages DROP CONSTRAINT IF EXISTS pages_store_id_route_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_pages_store_route ON pages (store_id, route) WHERE deleted_at IS NULL;
+
+DROP INDEX IF EXISTS idx_pages_is_home_per_app;
+CREATE UNIQUE INDEX idx_pages_is_home_per_app ON pages (store_id) WHERE is_home = TRUE AND deleted_at IS NULL;
+
+-- A partial unique index cannot be deferred, an exclusion constraint can.
+ALTER TABLE widgets DROP CONSTRAINT IF EXISTS unique_widgets_pos_per_page;
+ALTER TABLE widgets
+    ADD CONSTRAINT unique_widgets_pos_per_page EXCLUDE (page_id WITH =, position WITH =) WHERE (deleted_at IS NULL)
+        DEFERRABLE INITIALLY DEFERRED;
+
+CREATE INDEX IF NOT EXISTS idx_stores_deleted_at ON stores (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_pages_deleted_at ON pages (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_widgets_deleted_at ON widgets (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,18 @@
+-- One row per successful change made through the API, written in the same transaction as the change itself.
+-- store_id has no foreign key: the trail of a store, down to who deleted it, outlives its purge.
+CREATE TABLE IF NOT EXISTS audit_log
+(
+    id          BIGSERIAL PRIMARY KEY,
+    store_id    UUID                        NOT NULL,
+    actor       TEXT                        NOT NULL,
+    request_id  TEXT                        NOT NULL,
+    entity_type TEXT                        NOT NULL,
+    entity_id   UUID                        NOT NULL,
+    action      TEXT                        NOT NULL,
+    before      JSONB,
+    after       JSONB,
+    created_at  TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Entries are read per store, newest first, with the id as the cursor.
+CREATE INDEX idx_audit_log_store_id ON audit_log (store_id, id DESC);
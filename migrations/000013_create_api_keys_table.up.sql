@@ -0,0 +1,13 @@
+-- API keys authenticate requests. Only a hash of the secret is kept; the prefix finds the key without it.
+-- An empty store_ids gives access to every store.
+CREATE TABLE IF NOT EXISTS api_keys
+(
+    id          UUID PRIMARY KEY,
+    name        TEXT                        NOT NULL,
+    prefix      TEXT                        NOT NULL UNIQUE,
+    hash        BYTEA                       NOT NULL,
+    store_ids   UUID[]                      NOT NULL DEFAULT '{}',
+    expires_at  TIMESTAMP(0) WITH TIME ZONE,
+    revoked_at  TIMESTAMP(0) WITH TIME ZONE,
+    created_at  TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS store_members;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS users;
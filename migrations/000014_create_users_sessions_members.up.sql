@@ -0,0 +1,32 @@
+-- Staff log in as users, each with a role per store they work on.
+CREATE TABLE IF NOT EXISTS users
+(
+    id            UUID PRIMARY KEY,
+    name          TEXT                        NOT NULL,
+    email         TEXT                        NOT NULL UNIQUE,
+    password_hash BYTEA                       NOT NULL,
+    created_at    TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    version       INTEGER                     NOT NULL DEFAULT 1
+);
+
+-- Logging in opens a session; only a hash of its opaque token is kept.
+CREATE TABLE IF NOT EXISTS sessions
+(
+    hash       BYTEA PRIMARY KEY,
+    user_id    UUID                        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    expires_at TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_sessions_user_id ON sessions (user_id);
+
+CREATE TABLE IF NOT EXISTS store_members
+(
+    store_id   UUID                        NOT NULL REFERENCES stores (id) ON DELETE CASCADE,
+    user_id    UUID                        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role       TEXT                        NOT NULL CHECK ( role IN ('owner', 'editor', 'viewer') ),
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (store_id, user_id)
+);
+
+CREATE INDEX idx_store_members_user_id ON store_members (user_id);
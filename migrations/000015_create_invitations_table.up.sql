@@ -0,0 +1,18 @@
+-- An invitation adds whoever accepts it to a store. Only a hash of its token
+-- is kept, and it can be accepted once.
+CREATE TABLE IF NOT EXISTS invitations
+(
+    id          UUID PRIMARY KEY,
+    store_id    UUID                        NOT NULL REFERENCES stores (id) ON DELETE CASCADE,
+    email       TEXT                        NOT NULL,
+    role        TEXT                        NOT NULL CHECK ( role IN ('owner', 'editor', 'viewer') ),
+    hash        BYTEA                       NOT NULL UNIQUE,
+    invited_by  TEXT                        NOT NULL,
+    expires_at  TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    accepted_at TIMESTAMP(0) WITH TIME ZONE,
+    accepted_by UUID REFERENCES users (id) ON DELETE SET NULL,
+    revoked_at  TIMESTAMP(0) WITH TIME ZONE,
+    created_at  TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_invitations_store_id ON invitations (store_id, created_at DESC);
@@ -0,0 +1,13 @@
+-- Machine clients trade their id and secret for short-lived access tokens.
+-- Only a hash of the secret is kept.
+CREATE TABLE IF NOT EXISTS oauth_clients
+(
+    id          UUID PRIMARY KEY,
+    name        TEXT                        NOT NULL,
+    client_id   TEXT                        NOT NULL UNIQUE,
+    secret_hash BYTEA                       NOT NULL,
+    scopes      TEXT[]                      NOT NULL,
+    store_ids   UUID[]                      NOT NULL DEFAULT '{}',
+    revoked_at  TIMESTAMP(0) WITH TIME ZONE,
+    created_at  TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);